@@ -17,13 +17,43 @@ func main() {
 	host := flag.String("host", "0.0.0.0", "Server host")
 	port := flag.Int("port", 8080, "Server port")
 	apiKeys := flag.String("api-keys", "", "Comma-separated API keys (optional)")
+	apiKeysFile := flag.String("api-keys-file", "", "JSON file of API keys with metadata: [{key, name, rate_limit, disabled}] (optional)")
 	enableAuth := flag.Bool("enable-auth", false, "Enable API key authentication")
 	enableRateLimit := flag.Bool("enable-rate-limit", false, "Enable rate limiting")
 	rateLimit := flag.Int("rate-limit", 10, "Requests per second limit")
-	timeout := flag.Duration("timeout", 60*time.Second, "Request timeout")
+	rateLimitBurst := flag.Int("rate-limit-burst", 0, "Rate limit bucket size for short bursts (0 = same as rate)")
+	rateLimitByKey := flag.Bool("rate-limit-by-key", false, "Apply rate limit per API key / client IP instead of globally")
+	rateLimitPerKey := flag.Int("rate-limit-per-key", 0, "Requests per second limit per API key / client IP with -rate-limit-by-key (0 = same as -rate-limit)")
+	speechRateLimit := flag.Int("speech-rate-limit", 0, "Additional requests per second limit for the speech endpoints (0 = only the general limit)")
+	speechRateLimitBurst := flag.Int("speech-rate-limit-burst", 0, "Bucket size for the speech endpoint limit (0 = same as rate)")
+	timeout := flag.Duration("timeout", 60*time.Second, "Request timeout (overall deadline per synthesis call, including every long-text chunk)")
+	connectTimeout := flag.Duration("connect-timeout", 30*time.Second, "Upstream socket timeout per connection / single upstream call")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long shutdown waits for in-flight synthesis requests")
 	baseURL := flag.String("base-url", "https://www.openai.fm", "TTS service base URL")
 	proxyURL := flag.String("proxy", "", "Proxy URL (http, https, socks5)")
+	clientProfile := flag.String("client-profile", "", "Pin the upstream TLS client profile, e.g. chrome_133 (empty = random)")
+	userAgent := flag.String("user-agent", "", "Pin the upstream User-Agent (empty = random per request)")
 	autoCombine := flag.Bool("auto-combine", true, "Automatically combine API keys")
+	autoCombineThreshold := flag.Int("auto-combine-threshold", 0, "Input length that triggers auto-combine; max_length stays the chunk size (0 = max_length)")
+	enableMetrics := flag.Bool("enable-metrics", false, "Expose Prometheus metrics on /metrics")
+	maxConcurrentPerIP := flag.Int("max-concurrent-per-ip", 0, "Maximum concurrent requests per client IP (0 = unlimited)")
+	maxLongTextJobs := flag.Int("max-long-text-jobs", 0, "Maximum concurrent long-text (auto-combine) jobs server-wide (0 = unlimited)")
+	longTextQueueTimeout := flag.Duration("long-text-queue-timeout", 0, "How long excess long-text jobs wait for a slot before 503 (0 = reject immediately)")
+	allowedVoices := flag.String("allowed-voices", "", "Comma-separated voices allowed on this server (empty = all)")
+	audioChecksum := flag.Bool("audio-checksum", false, "Send the SHA-256 of streamed audio as an X-Audio-SHA256 trailer")
+	strictFormat := flag.Bool("strict-format", false, "Fail instead of falling back to wav when upstream cannot return the requested format, and when a long-text WAV chunk is not a valid RIFF/WAVE file")
+	formatDowngradeWarnings := flag.Bool("format-downgrade-warnings", false, "Add a warnings array to SSE/NDJSON done events when the requested format is downgraded (e.g. opus to wav)")
+	bufferResponseMaxBytes := flag.Int("buffer-response-max-bytes", 0, "Buffer short-text audio up to this size to send Content-Length and X-Audio-Size (0 = always stream)")
+	maxAudioDuration := flag.Duration("max-audio-duration", 0, "Reject requests whose estimated audio duration exceeds this (0 = unlimited)")
+	streamChunkSize := flag.Int("stream-chunk-size", 8*1024, "Audio bytes per SSE/NDJSON delta event")
+	longTextConcurrency := flag.Int("long-text-concurrency", 3, "Default and maximum chunks synthesized concurrently per long-text request (max 16)")
+	longTextChunkBufferSize := flag.Int("long-text-chunk-buffer-size", 32*1024, "Default and maximum per-chunk stream buffer size in bytes for long text (1KB-1MB)")
+	logFormat := flag.String("log-format", "text", "Log output format: text or json")
+	logLevel := flag.String("log-level", "info", "Minimum log level: debug, info, warn, error")
+	allowLogLevelHeader := flag.Bool("allow-log-level-header", false, "Allow the X-Log-Level request header to lower the log level for that authenticated request")
+	adminAPIKey := flag.String("admin-api-key", "", "Key required in the X-Admin-Key header for /admin/selftest (empty = endpoint disabled)")
+	ginMode := flag.String("gin-mode", "", "Gin mode: release, debug or test (empty = GIN_MODE env, else release)")
+	streamSniffSize := flag.Int("stream-sniff-size", 512, "Bytes of upstream audio inspected before committing a 200 response")
 
 	flag.Parse()
 
@@ -39,6 +69,12 @@ func main() {
 	if envKeys := strings.TrimSpace(os.Getenv("TTSFM_API_KEYS")); envKeys != "" {
 		*apiKeys = envKeys
 	}
+	if envKeysFile := strings.TrimSpace(os.Getenv("TTSFM_API_KEYS_FILE")); envKeysFile != "" {
+		*apiKeysFile = envKeysFile
+	}
+	if envAdminKey := strings.TrimSpace(os.Getenv("TTSFM_ADMIN_API_KEY")); envAdminKey != "" {
+		*adminAPIKey = envAdminKey
+	}
 	if strings.EqualFold(strings.TrimSpace(os.Getenv("TTSFM_ENABLE_AUTH")), "true") {
 		*enableAuth = true
 	}
@@ -50,6 +86,29 @@ func main() {
 			*rateLimit = r
 		}
 	}
+	if envBurst := strings.TrimSpace(os.Getenv("TTSFM_RATE_LIMIT_BURST")); envBurst != "" {
+		if b, err := strconv.Atoi(envBurst); err == nil && b > 0 {
+			*rateLimitBurst = b
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("TTSFM_RATE_LIMIT_BY_KEY")), "true") {
+		*rateLimitByKey = true
+	}
+	if envRate := strings.TrimSpace(os.Getenv("TTSFM_RATE_LIMIT_PER_KEY")); envRate != "" {
+		if r, err := strconv.Atoi(envRate); err == nil && r > 0 {
+			*rateLimitPerKey = r
+		}
+	}
+	if envRate := strings.TrimSpace(os.Getenv("TTSFM_SPEECH_RATE_LIMIT")); envRate != "" {
+		if r, err := strconv.Atoi(envRate); err == nil && r > 0 {
+			*speechRateLimit = r
+		}
+	}
+	if envBurst := strings.TrimSpace(os.Getenv("TTSFM_SPEECH_RATE_LIMIT_BURST")); envBurst != "" {
+		if b, err := strconv.Atoi(envBurst); err == nil && b > 0 {
+			*speechRateLimitBurst = b
+		}
+	}
 
 	if envBaseURL := strings.TrimSpace(os.Getenv("TTSFM_BASE_URL")); envBaseURL != "" {
 		*baseURL = envBaseURL
@@ -57,15 +116,105 @@ func main() {
 	if envProxy := strings.TrimSpace(os.Getenv("TTSFM_PROXY_URL")); envProxy != "" && strings.TrimSpace(*proxyURL) == "" {
 		*proxyURL = envProxy
 	}
+	if envProfile := strings.TrimSpace(os.Getenv("TTSFM_CLIENT_PROFILE")); envProfile != "" {
+		*clientProfile = envProfile
+	}
+	if envUA := strings.TrimSpace(os.Getenv("TTSFM_USER_AGENT")); envUA != "" {
+		*userAgent = envUA
+	}
 	if strings.EqualFold(strings.TrimSpace(os.Getenv("TTSFM_AUTO_COMBINE")), "true") {
 		*autoCombine = true
 	}
+	if envThreshold := strings.TrimSpace(os.Getenv("TTSFM_AUTO_COMBINE_THRESHOLD")); envThreshold != "" {
+		if n, err := strconv.Atoi(envThreshold); err == nil && n > 0 {
+			*autoCombineThreshold = n
+		}
+	}
+	if envDuration := strings.TrimSpace(os.Getenv("TTSFM_MAX_AUDIO_DURATION")); envDuration != "" {
+		if d, err := time.ParseDuration(envDuration); err == nil && d > 0 {
+			*maxAudioDuration = d
+		}
+	}
+	if envChunk := strings.TrimSpace(os.Getenv("TTSFM_STREAM_CHUNK_SIZE")); envChunk != "" {
+		if n, err := strconv.Atoi(envChunk); err == nil && n > 0 {
+			*streamChunkSize = n
+		}
+	}
+	if envConc := strings.TrimSpace(os.Getenv("TTSFM_LONG_TEXT_CONCURRENCY")); envConc != "" {
+		if n, err := strconv.Atoi(envConc); err == nil && n > 0 {
+			*longTextConcurrency = n
+		}
+	}
+	if envBuf := strings.TrimSpace(os.Getenv("TTSFM_LONG_TEXT_CHUNK_BUFFER_SIZE")); envBuf != "" {
+		if n, err := strconv.Atoi(envBuf); err == nil && n > 0 {
+			*longTextChunkBufferSize = n
+		}
+	}
+	if envSniff := strings.TrimSpace(os.Getenv("TTSFM_STREAM_SNIFF_SIZE")); envSniff != "" {
+		if n, err := strconv.Atoi(envSniff); err == nil && n > 0 {
+			*streamSniffSize = n
+		}
+	}
+	if envFormat := strings.TrimSpace(os.Getenv("TTSFM_LOG_FORMAT")); envFormat != "" {
+		*logFormat = envFormat
+	}
+	if envLevel := strings.TrimSpace(os.Getenv("TTSFM_LOG_LEVEL")); envLevel != "" {
+		*logLevel = envLevel
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("TTSFM_ALLOW_LOG_LEVEL_HEADER")), "true") {
+		*allowLogLevelHeader = true
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("TTSFM_AUDIO_CHECKSUM")), "true") {
+		*audioChecksum = true
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("TTSFM_STRICT_FORMAT")), "true") {
+		*strictFormat = true
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("TTSFM_FORMAT_DOWNGRADE_WARNINGS")), "true") {
+		*formatDowngradeWarnings = true
+	}
+	if envBuffer := strings.TrimSpace(os.Getenv("TTSFM_BUFFER_RESPONSE_MAX_BYTES")); envBuffer != "" {
+		if n, err := strconv.Atoi(envBuffer); err == nil && n > 0 {
+			*bufferResponseMaxBytes = n
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("TTSFM_ENABLE_METRICS")), "true") {
+		*enableMetrics = true
+	}
+	if envConc := strings.TrimSpace(os.Getenv("TTSFM_MAX_CONCURRENT_PER_IP")); envConc != "" {
+		if n, err := strconv.Atoi(envConc); err == nil && n > 0 {
+			*maxConcurrentPerIP = n
+		}
+	}
+	if envJobs := strings.TrimSpace(os.Getenv("TTSFM_MAX_LONG_TEXT_JOBS")); envJobs != "" {
+		if n, err := strconv.Atoi(envJobs); err == nil && n > 0 {
+			*maxLongTextJobs = n
+		}
+	}
+	if envQueue := strings.TrimSpace(os.Getenv("TTSFM_LONG_TEXT_QUEUE_TIMEOUT")); envQueue != "" {
+		if d, err := time.ParseDuration(envQueue); err == nil && d > 0 {
+			*longTextQueueTimeout = d
+		}
+	}
+	if envVoices := strings.TrimSpace(os.Getenv("TTSFM_ALLOWED_VOICES")); envVoices != "" {
+		*allowedVoices = envVoices
+	}
 	//TTSFM_TIMEOUT
 	if envTimeout := strings.TrimSpace(os.Getenv("TTSFM_TIMEOUT")); envTimeout != "" {
 		if eTimeout, err := time.ParseDuration(envTimeout); err == nil {
 			*timeout = eTimeout
 		}
 	}
+	if envConnect := strings.TrimSpace(os.Getenv("TTSFM_CONNECT_TIMEOUT")); envConnect != "" {
+		if d, err := time.ParseDuration(envConnect); err == nil && d > 0 {
+			*connectTimeout = d
+		}
+	}
+	if envDrain := strings.TrimSpace(os.Getenv("TTSFM_DRAIN_TIMEOUT")); envDrain != "" {
+		if d, err := time.ParseDuration(envDrain); err == nil && d > 0 {
+			*drainTimeout = d
+		}
+	}
 	var keys []string
 	if strings.TrimSpace(*apiKeys) != "" {
 		parts := strings.Split(*apiKeys, ",")
@@ -77,28 +226,83 @@ func main() {
 			}
 		}
 	}
+	var keyEntries []server.APIKey
+	if strings.TrimSpace(*apiKeysFile) != "" {
+		var err error
+		keyEntries, err = server.LoadAPIKeys(*apiKeysFile)
+		if err != nil {
+			log.Fatalf("Invalid API keys file: %v", err)
+		}
+	}
 
-	logger := &ttsfm.DefaultLogger{}
+	var voices []ttsfm.Voice
+	for _, v := range strings.Split(*allowedVoices, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			voices = append(voices, ttsfm.Voice(v))
+		}
+	}
+
+	level, err := ttsfm.ParseLogLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("Invalid log level: %v", err)
+	}
+	var logger ttsfm.Logger
+	switch strings.ToLower(strings.TrimSpace(*logFormat)) {
+	case "", "text":
+		logger = ttsfm.NewLevelLogger(&ttsfm.DefaultLogger{}, level)
+	case "json":
+		logger = ttsfm.NewJSONLogger(os.Stderr, level)
+	default:
+		log.Fatalf("Invalid log format %q (expected text or json)", *logFormat)
+	}
 
 	cfg := &server.ServerConfig{
 		Host:             *host,
 		Port:             *port,
 		APIKeys:          keys,
+		APIKeyEntries:    keyEntries,
 		EnableAPIKeyAuth: *enableAuth,
 
 		RequestTimeout:  *timeout,
 		ShutdownTimeout: 10 * time.Second,
+		DrainTimeout:    *drainTimeout,
 
-		EnableCORS:      true,
-		EnableRateLimit: *enableRateLimit,
-		RateLimitPerSec: *rateLimit,
-		AutoCombine:     *autoCombine,
-		Logger:          logger,
+		EnableCORS:                true,
+		EnableRateLimit:           *enableRateLimit,
+		RateLimitPerSec:           *rateLimit,
+		RateLimitBurst:            *rateLimitBurst,
+		RateLimitPerKey:           *rateLimitByKey,
+		RateLimitPerKeyPerSec:     *rateLimitPerKey,
+		SpeechRateLimitPerSec:     *speechRateLimit,
+		SpeechRateLimitBurst:      *speechRateLimitBurst,
+		EnableMetrics:             *enableMetrics,
+		MaxConcurrentPerIP:        *maxConcurrentPerIP,
+		MaxConcurrentLongTextJobs: *maxLongTextJobs,
+		LongTextJobQueueTimeout:   *longTextQueueTimeout,
+		AutoCombine:               *autoCombine,
+		AutoCombineThreshold:      *autoCombineThreshold,
+		AllowedVoices:             voices,
+		MaxAudioDuration:          *maxAudioDuration,
+		AudioChecksum:             *audioChecksum,
+		FormatDowngradeWarnings:   *formatDowngradeWarnings,
+		BufferResponseMaxBytes:    *bufferResponseMaxBytes,
+		StreamChunkSize:           *streamChunkSize,
+		LongTextConcurrency:       *longTextConcurrency,
+		LongTextChunkBufferSize:   *longTextChunkBufferSize,
+		StreamSniffSize:           *streamSniffSize,
+		AllowLogLevelHeader:       *allowLogLevelHeader,
+		AdminAPIKey:               strings.TrimSpace(*adminAPIKey),
+		GinMode:                   *ginMode,
+		Logger:                    logger,
 		TTSClientOptions: []ttsfm.ClientOption{
 			ttsfm.WithBaseURL(*baseURL),
 			ttsfm.WithTimeout(*timeout),
+			ttsfm.WithConnectTimeout(*connectTimeout),
 			ttsfm.WithMaxRetries(3),
 			ttsfm.WithProxyURL(*proxyURL),
+			ttsfm.WithClientProfile(*clientProfile),
+			ttsfm.WithUserAgent(*userAgent),
+			ttsfm.WithStrictFormat(*strictFormat),
 			ttsfm.WithLogger(logger),
 		},
 	}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// APIKey 单个 API key 及其元数据（多租户时区分调用方）
+type APIKey struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	// RateLimit 该 key 每秒请求数，>0 时覆盖按 key 限流的全局速率（需开启限流）
+	RateLimit int `json:"rate_limit"`
+	// Disabled 为 true 时该 key 被拒绝（403）；零值为启用，直接在 Go 代码中构造的条目无需额外设置
+	Disabled bool `json:"disabled"`
+}
+
+// UnmarshalJSON 兼容旧格式的 "enabled": false（等同于 "disabled": true）
+func (k *APIKey) UnmarshalJSON(data []byte) error {
+	type plain APIKey
+	var entry struct {
+		plain
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	*k = APIKey(entry.plain)
+	if entry.Enabled != nil && !*entry.Enabled {
+		k.Disabled = true
+	}
+	return nil
+}
+
+// LoadAPIKeys 从 JSON 文件读取 API key 列表，文件内容为
+// [{"key": "...", "name": "...", "rate_limit": 5, "disabled": false}, ...]
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read api keys file: %w", err)
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parse api keys file %s: %w", path, err)
+	}
+
+	seen := make(map[string]struct{}, len(keys))
+	for i := range keys {
+		keys[i].Key = strings.TrimSpace(keys[i].Key)
+		if keys[i].Key == "" {
+			return nil, fmt.Errorf("api keys file %s: entry %d has an empty key", path, i)
+		}
+		if keys[i].RateLimit < 0 {
+			return nil, fmt.Errorf("api keys file %s: entry %d has a negative rate_limit", path, i)
+		}
+		if _, dup := seen[keys[i].Key]; dup {
+			return nil, fmt.Errorf("api keys file %s: entry %d duplicates an earlier key", path, i)
+		}
+		seen[keys[i].Key] = struct{}{}
+	}
+	return keys, nil
+}
+
+// apiKeysFromStrings 将扁平 key 列表映射为默认元数据（无名称、不单独限流、启用）
+func apiKeysFromStrings(keys []string) []APIKey {
+	entries := make([]APIKey, 0, len(keys))
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			entries = append(entries, APIKey{Key: key})
+		}
+	}
+	return entries
+}
@@ -2,13 +2,18 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"ttsfm-go/ttsfm"
@@ -17,14 +22,82 @@ import (
 // SpeechRequest OpenAI 兼容的语音生成请求
 type SpeechRequest struct {
 	Model          string  `json:"model"`
-	Input          string  `json:"input"`
+	Input          string  `json:"input"` // 也接受字符串数组，见 UnmarshalJSON
 	Voice          string  `json:"voice"`
 	ResponseFormat string  `json:"response_format"`
 	Instructions   string  `json:"instructions"`
 	Speed          float64 `json:"speed"`
+	Vibe           string  `json:"vibe,omitempty"`
+	// Language 语言/地区提示（BCP-47，如 zh-CN），透传给上游
+	Language string `json:"language,omitempty"`
+	// SampleRate 输出采样率（Hz），透传给上游，0 表示使用上游默认值
+	SampleRate int `json:"sample_rate,omitempty"`
+	// StrictFormat 为 true 时上游无法返回所请求的格式即报错，而不是降级为 wav；省略时沿用服务器的客户端配置
+	StrictFormat *bool `json:"strict_format,omitempty"`
+	// Seed 随机种子，透传给上游并使请求可复现（是否生成相同音频取决于上游）
+	Seed *int64 `json:"seed,omitempty"`
 
 	AutoCombine *bool `json:"auto_combine,omitempty"`
 	MaxLength   int   `json:"max_length"`
+
+	// StreamFormat audio（默认，二进制音频）| sse | ndjson（base64 音频增量事件）
+	StreamFormat string `json:"stream_format,omitempty"`
+	// StreamChunkSize 每个增量事件携带的音频字节数（仅 sse/ndjson 生效）
+	StreamChunkSize int `json:"stream_chunk_size,omitempty"`
+
+	// StreamConcurrency 长文本同时合成的分段数，0 表示使用服务器默认值，大于服务器配置时按服务器配置
+	StreamConcurrency int `json:"stream_concurrency,omitempty"`
+	// ChunkBufferSize 长文本每个分段的流式拷贝缓冲区字节数，0 表示使用服务器默认值，大于服务器配置时按服务器配置
+	ChunkBufferSize int `json:"chunk_buffer_size,omitempty"`
+	// ChunkIndex 为 true 时长文本二进制输出在 X-Chunk-Index trailer 中附带每段的字节偏移索引（JSON），
+	// SSE 进度模式则在每个 chunk 事件中附带 offset；客户端可据此把时间近似映射到字节位置
+	ChunkIndex bool `json:"chunk_index,omitempty"`
+	// BudgetBytes / BudgetSeconds 输出音频的字节数 / 估算时长上限：字节预算截断在恰好 BudgetBytes 处；
+	// 时长按分段计，已输出分段的估算时长超出后取消剩余分段（单次合成的短文本不受时长预算影响）。
+	// 超出时二进制输出以 X-Budget-Exceeded trailer（缓冲响应为同名响应头）结束，SSE/NDJSON 与进度事件的
+	// 结束事件带 budget_exceeded 字段；0 表示不限制，省略时读取 X-Budget-Bytes / X-Budget-Seconds 请求头
+	BudgetBytes   int64   `json:"budget_bytes,omitempty"`
+	BudgetSeconds float64 `json:"budget_seconds,omitempty"`
+
+	// wrapWAV 来自查询参数 ?wrap=wav：response_format=pcm 时把裸 PCM 包装成 WAV 输出
+	wrapWAV bool
+
+	// ExtraBody OpenAI SDK extra_body 透传的厂商参数；仅接受字符串/数字/布尔值，原样写入上游表单
+	ExtraBody map[string]interface{} `json:"extra_body,omitempty"`
+}
+
+// UnmarshalJSON 允许 input 为字符串或字符串数组；数组中的空白元素被跳过，其余以空格拼接。
+// 全部为空时 Input 为空字符串，由入口统一返回 missing_input
+func (r *SpeechRequest) UnmarshalJSON(data []byte) error {
+	type plain SpeechRequest
+	aux := struct {
+		*plain
+		Input json.RawMessage `json:"input"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	r.Input = ""
+	if len(aux.Input) == 0 || string(aux.Input) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(aux.Input, &r.Input); err == nil {
+		return nil
+	}
+
+	var segments []string
+	if err := json.Unmarshal(aux.Input, &segments); err != nil {
+		return fmt.Errorf("input must be a string or an array of strings")
+	}
+	parts := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			parts = append(parts, segment)
+		}
+	}
+	r.Input = strings.Join(parts, " ")
+	return nil
 }
 
 // ErrorResponse 错误响应（OpenAI 风格）
@@ -52,6 +125,38 @@ type Handler struct {
 	logger             ttsfm.Logger
 	timeout            time.Duration
 	autoCombineDefault bool
+	// autoCombineThreshold 超过该长度才自动拼接，0 表示使用请求的 max_length
+	autoCombineThreshold    int
+	maxAudioDuration        time.Duration
+	audioChecksum           bool
+	formatDowngradeWarnings bool
+	bufferResponseMaxBytes  int
+	streamChunkSize         int
+	longTextConcurrency     int
+	longTextChunkBufferSize int
+	streamSniffSize         int
+	allowedVoices           []ttsfm.Voice
+	metrics                 *Metrics
+
+	// longTextJobs 全服务器长文本任务信号量，nil 表示不限制
+	longTextJobs         chan struct{}
+	longTextQueueTimeout time.Duration
+
+	// inflight/active 跟踪进行中的、会调用上游的请求，供优雅关闭时等待
+	inflight sync.WaitGroup
+	active   int64
+	// draining 开始优雅关闭后置 1，/readyz 据此返回 503 让负载均衡摘除本实例，新的上游请求被拒绝；
+	// drainMu 保证置位与 inflight.Add 互斥，drain 开始 Wait 后不会再有新的 Add
+	drainMu  sync.Mutex
+	draining int32
+	// shutdownCtx 在排空超时后取消，中止仍在进行的上游调用
+	shutdownCtx    context.Context
+	cancelInflight context.CancelFunc
+
+	// client 所有请求共用的上游客户端，首次使用时按 TTSClientOptions 创建；
+	// 共用同一个信号量，MaxConcurrent 才是整个服务器的上游并发上限
+	clientMu sync.Mutex
+	client   *ttsfm.TTSClient
 }
 
 // NewHandler 创建处理器
@@ -60,20 +165,181 @@ func NewHandler(cfg *ServerConfig) *Handler {
 		cfg.RequestTimeout = 60 * time.Second
 	}
 
+	shutdownCtx, cancelInflight := context.WithCancel(context.Background())
+
+	var longTextJobs chan struct{}
+	if cfg.MaxConcurrentLongTextJobs > 0 {
+		longTextJobs = make(chan struct{}, cfg.MaxConcurrentLongTextJobs)
+	}
+
 	return &Handler{
-		logger:             cfg.Logger,
-		timeout:            cfg.RequestTimeout,
-		autoCombineDefault: cfg.AutoCombine,
-		TTSClientOptions:   cfg.TTSClientOptions,
+		longTextJobs:            longTextJobs,
+		longTextQueueTimeout:    cfg.LongTextJobQueueTimeout,
+		shutdownCtx:             shutdownCtx,
+		cancelInflight:          cancelInflight,
+		logger:                  cfg.Logger,
+		timeout:                 cfg.RequestTimeout,
+		autoCombineDefault:      cfg.AutoCombine,
+		autoCombineThreshold:    cfg.AutoCombineThreshold,
+		maxAudioDuration:        cfg.MaxAudioDuration,
+		audioChecksum:           cfg.AudioChecksum,
+		formatDowngradeWarnings: cfg.FormatDowngradeWarnings,
+		bufferResponseMaxBytes:  cfg.BufferResponseMaxBytes,
+		streamChunkSize:         cfg.StreamChunkSize,
+		longTextConcurrency:     cfg.LongTextConcurrency,
+		longTextChunkBufferSize: cfg.LongTextChunkBufferSize,
+		streamSniffSize:         cfg.StreamSniffSize,
+		allowedVoices:           cfg.AllowedVoices,
+		TTSClientOptions:        cfg.TTSClientOptions,
 	}
 }
 
 // OpenAISpeech OpenAI 兼容的语音生成接口
 // POST /v1/audio/speech
 func (h *Handler) OpenAISpeech(c *gin.Context) {
-	var req SpeechRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.warn("Failed to parse request: %v", err)
+	req, voice, format, ok := h.bindSpeechRequest(c)
+	if !ok {
+		return
+	}
+
+	autoCombine := h.autoCombineDefault
+	if req.AutoCombine != nil {
+		autoCombine = *req.AutoCombine
+	}
+
+	h.metrics.observeSpeechRequest(voice, format)
+
+	h.info(c, "OpenAI API: Generating speech: text='%s...', voice=%s, format=%s, auto_combine=%v, max_length=%d",
+		ttsfm.TruncateString(req.Input, 50), req.Voice, req.ResponseFormat, autoCombine, req.MaxLength)
+
+	done, ok := h.beginWork(c)
+	if !ok {
+		return
+	}
+	defer done()
+
+	needsCombine, ok := h.checkSpeechLimits(c, req, autoCombine)
+	if !ok {
+		return
+	}
+	h.debug(c, "Speech request: chars=%d, needs_combine=%v, speed=%v, stream_format=%q",
+		utf8.RuneCountInString(req.Input), needsCombine, req.Speed, req.StreamFormat)
+
+	// 客户端断开或服务器强制关闭时都要中止上游调用
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	stop := context.AfterFunc(h.shutdownCtx, cancel)
+	defer stop()
+
+	if needsCombine {
+		release, err := h.acquireLongTextJob(ctx)
+		if err != nil {
+			h.handleError(c, err)
+			return
+		}
+		defer release()
+	}
+
+	// Accept: text/event-stream 时以 SSE 推送逐 chunk 进度（显式指定 stream_format 时以其为准）；
+	// 与二进制输出相同，只有需要拼接时才分片，否则整段作为一个 chunk 事件
+	if req.StreamFormat == "" && acceptsEventStream(c) {
+		h.handleProgressSSE(c, ctx, req, voice, format, needsCombine)
+		return
+	}
+
+	if needsCombine {
+		// 长文本：分片后按格式流式拼接输出，避免内存峰值并降低等待时间
+		h.handleLongTextStream(c, ctx, req, voice, format)
+		return
+	}
+
+	// 短文本使用流式处理
+	h.handleShortTextStream(c, ctx, req, voice, format, autoCombine)
+}
+
+// SpeechPlanChunk 分片计划中的单个分片
+type SpeechPlanChunk struct {
+	Index int    `json:"index"`
+	Text  string `json:"text"`
+	// Characters 分片字符（rune）数
+	Characters int `json:"characters"`
+	// EstimatedDuration 预估音频时长（秒）
+	EstimatedDuration float64 `json:"estimated_duration"`
+}
+
+// SpeechPlanResponse /v1/audio/speech/plan 的响应：请求将如何被分片，但不实际合成
+type SpeechPlanResponse struct {
+	Voice          string `json:"voice"`
+	ResponseFormat string `json:"response_format"`
+	MaxLength      int    `json:"max_length"`
+	// TextLength 清理后的文本字符数
+	TextLength        int               `json:"text_length"`
+	AutoCombine       bool              `json:"auto_combine"`
+	ChunkCount        int               `json:"chunk_count"`
+	EstimatedDuration float64           `json:"estimated_duration"`
+	Chunks            []SpeechPlanChunk `json:"chunks"`
+}
+
+// SpeechPlan 按与 /v1/audio/speech 相同的校验与分片规则返回分片计划，不调用上游。
+// 用于调试长文本时调整 max_length、查看切分位置
+// POST /v1/audio/speech/plan
+func (h *Handler) SpeechPlan(c *gin.Context) {
+	req, _, _, ok := h.bindSpeechRequest(c)
+	if !ok {
+		return
+	}
+
+	autoCombine := h.autoCombineDefault
+	if req.AutoCombine != nil {
+		autoCombine = *req.AutoCombine
+	}
+
+	needsCombine, ok := h.checkSpeechLimits(c, req, autoCombine)
+	if !ok {
+		return
+	}
+
+	cleanText, err := ttsfm.SanitizeText(req.Input)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// 阈值以内单次合成，与实际请求路径一致；长文本与客户端一样先修正过小的 max_length 再按词边界切分
+	chunks := []string{cleanText}
+	if needsCombine {
+		maxLength, _ := ttsfm.ClampChunkLength(req.MaxLength)
+		chunks = ttsfm.SplitTextByLength(cleanText, maxLength, true)
+	}
+
+	resp := SpeechPlanResponse{
+		Voice:          req.Voice,
+		ResponseFormat: req.ResponseFormat,
+		MaxLength:      req.MaxLength,
+		TextLength:     utf8.RuneCountInString(cleanText),
+		AutoCombine:    autoCombine,
+		ChunkCount:     len(chunks),
+		Chunks:         make([]SpeechPlanChunk, 0, len(chunks)),
+	}
+	for i, chunk := range chunks {
+		duration := estimateOutputDuration(chunk, req.Speed).Seconds()
+		resp.EstimatedDuration += duration
+		resp.Chunks = append(resp.Chunks, SpeechPlanChunk{
+			Index:             i,
+			Text:              chunk,
+			Characters:        utf8.RuneCountInString(chunk),
+			EstimatedDuration: duration,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// bindSpeechRequest 解析并校验语音请求（填充默认值），校验失败时已写出 400 响应并返回 ok=false
+func (h *Handler) bindSpeechRequest(c *gin.Context) (req *SpeechRequest, voice ttsfm.Voice, format ttsfm.AudioFormat, ok bool) {
+	req = &SpeechRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		h.warn(c, "Failed to parse request: %v", err)
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: ErrorDetail{
 				Message: "Invalid JSON data provided",
@@ -81,7 +347,7 @@ func (h *Handler) OpenAISpeech(c *gin.Context) {
 				Code:    "invalid_json",
 			},
 		})
-		return
+		return nil, "", "", false
 	}
 
 	if strings.TrimSpace(req.Voice) == "" {
@@ -94,11 +360,6 @@ func (h *Handler) OpenAISpeech(c *gin.Context) {
 		req.MaxLength = 2048
 	}
 
-	autoCombine := h.autoCombineDefault
-	if req.AutoCombine != nil {
-		autoCombine = *req.AutoCombine
-	}
-
 	if strings.TrimSpace(req.Input) == "" {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: ErrorDetail{
@@ -107,22 +368,22 @@ func (h *Handler) OpenAISpeech(c *gin.Context) {
 				Code:    "missing_input",
 			},
 		})
-		return
+		return nil, "", "", false
 	}
 
-	voice := ttsfm.Voice(req.Voice)
-	if !voice.IsValid() {
+	voice = ttsfm.Voice(req.Voice)
+	if !voice.IsValid() || !h.isVoiceAllowed(voice) {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: ErrorDetail{
-				Message: fmt.Sprintf("Invalid voice: %s. Must be one of: %v", req.Voice, ttsfm.ValidVoices),
+				Message: fmt.Sprintf("Invalid voice: %s. Must be one of: %v", req.Voice, h.availableVoices()),
 				Type:    "invalid_request_error",
 				Code:    "invalid_voice",
 			},
 		})
-		return
+		return nil, "", "", false
 	}
 
-	format := ttsfm.AudioFormat(req.ResponseFormat)
+	format = ttsfm.AudioFormat(req.ResponseFormat)
 	if !format.IsValid() {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: ErrorDetail{
@@ -131,50 +392,232 @@ func (h *Handler) OpenAISpeech(c *gin.Context) {
 				Code:    "invalid_format",
 			},
 		})
-		return
+		return nil, "", "", false
+	}
+
+	// speed=0 视为未设置；其余值（包括负数）必须落在允许范围内
+	if req.Speed != 0 && !ttsfm.IsValidSpeed(req.Speed) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid speed: %v. Must be between %v and %v", req.Speed, ttsfm.MinSpeed, ttsfm.MaxSpeed),
+				Type:    "invalid_request_error",
+				Code:    "invalid_speed",
+			},
+		})
+		return nil, "", "", false
 	}
 
-	h.info("OpenAI API: Generating speech: text='%s...', voice=%s, format=%s, auto_combine=%v, max_length=%d",
-		truncateString(req.Input, 50), req.Voice, req.ResponseFormat, autoCombine, req.MaxLength)
+	req.Language = strings.TrimSpace(req.Language)
+	if req.Language != "" && !ttsfm.IsValidLanguage(req.Language) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid language: %s. Expected a tag like 'en' or 'zh-CN'", req.Language),
+				Type:    "invalid_request_error",
+				Code:    "invalid_language",
+			},
+		})
+		return nil, "", "", false
+	}
 
-	ctx := c.Request.Context()
+	if req.SampleRate != 0 && !ttsfm.IsValidSampleRate(req.SampleRate) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid sample_rate: %d. Must be one of %v", req.SampleRate, ttsfm.ValidSampleRates),
+				Type:    "invalid_request_error",
+				Code:    "invalid_sample_rate",
+			},
+		})
+		return nil, "", "", false
+	}
 
-	textLength := len(req.Input)
+	if wrap := strings.ToLower(strings.TrimSpace(c.Query("wrap"))); wrap != "" {
+		if wrap != "wav" || format != ttsfm.FormatPCM {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: ErrorDetail{
+					Message: fmt.Sprintf("Invalid wrap: %s. Only wrap=wav with response_format=pcm is supported", wrap),
+					Type:    "invalid_request_error",
+					Code:    "invalid_wrap",
+				},
+			})
+			return nil, "", "", false
+		}
+		req.wrapWAV = true
+	}
 
-	if textLength > req.MaxLength && autoCombine {
-		// 长文本：分片后按格式流式拼接输出，避免内存峰值并降低等待时间
-		h.handleLongTextStream(c, ctx, &req, voice, format)
-		return
+	req.StreamFormat = normalizeStreamFormat(req.StreamFormat)
+	if !isValidStreamFormat(req.StreamFormat) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid stream_format: %s. Must be one of: [%s %s %s]",
+					req.StreamFormat, StreamFormatAudio, StreamFormatSSE, StreamFormatNDJSON),
+				Type: "invalid_request_error",
+				Code: "invalid_stream_format",
+			},
+		})
+		return nil, "", "", false
+	}
+
+	if req.StreamChunkSize < 0 || req.StreamChunkSize > maxStreamChunkSize {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid stream_chunk_size: %d. Must be between 1 and %d bytes",
+					req.StreamChunkSize, maxStreamChunkSize),
+				Type: "invalid_request_error",
+				Code: "invalid_stream_chunk_size",
+			},
+		})
+		return nil, "", "", false
+	}
+
+	if req.StreamConcurrency < 0 || req.StreamConcurrency > maxLongTextConcurrency {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid stream_concurrency: %d. Must be between 1 and %d",
+					req.StreamConcurrency, maxLongTextConcurrency),
+				Type: "invalid_request_error",
+				Code: "invalid_stream_concurrency",
+			},
+		})
+		return nil, "", "", false
+	}
+
+	if req.ChunkBufferSize < 0 || (req.ChunkBufferSize > 0 && req.ChunkBufferSize < minLongTextChunkBufferSize) ||
+		req.ChunkBufferSize > maxLongTextChunkBufferSize {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid chunk_buffer_size: %d. Must be between %d and %d bytes",
+					req.ChunkBufferSize, minLongTextChunkBufferSize, maxLongTextChunkBufferSize),
+				Type: "invalid_request_error",
+				Code: "invalid_chunk_buffer_size",
+			},
+		})
+		return nil, "", "", false
+	}
+
+	if err := resolveBudget(c, req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+				Code:    "invalid_budget",
+			},
+		})
+		return nil, "", "", false
+	}
+
+	if _, err := extraFormFields(req.ExtraBody); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+				Code:    "invalid_extra_body",
+			},
+		})
+		return nil, "", "", false
+	}
+	return req, voice, format, true
+}
+
+// checkSpeechLimits 校验预估时长与文本长度限制，返回是否需要分片拼接；超限时已写出 400 响应并返回 ok=false
+func (h *Handler) checkSpeechLimits(c *gin.Context, req *SpeechRequest, autoCombine bool) (needsCombine bool, ok bool) {
+	if h.maxAudioDuration > 0 {
+		if estimated := estimateOutputDuration(req.Input, req.Speed); estimated > h.maxAudioDuration {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: ErrorDetail{
+					Message: fmt.Sprintf(
+						"Estimated audio duration (%s) exceeds the maximum allowed duration of %s",
+						estimated.Round(time.Second), h.maxAudioDuration,
+					),
+					Type: "invalid_request_error",
+					Code: "audio_too_long",
+				},
+			})
+			return false, false
+		}
 	}
 
-	if textLength > req.MaxLength && !autoCombine {
+	// 按清理（去除 HTML 等）后的字符数判断长度，与实际发往上游的文本一致
+	textLength := sanitizedLength(req.Input)
+	// 超过阈值才分片拼接（分片大小仍为 max_length）；阈值以内即使超过 max_length 也单次合成
+	threshold := h.resolveAutoCombineThreshold(req.MaxLength)
+	needsCombine = textLength > threshold
+
+	if needsCombine && !autoCombine {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: ErrorDetail{
 				Message: fmt.Sprintf(
 					"Input text is too long (%d characters). Maximum allowed length is %d characters. "+
 						"Enable auto_combine to automatically split and combine long text.",
-					textLength, req.MaxLength,
+					textLength, threshold,
 				),
 				Type: "invalid_request_error",
 				Code: "text_too_long",
 			},
 		})
-		return
+		return false, false
 	}
 
-	// 短文本使用流式处理
-	h.handleShortTextStream(c, ctx, &req, voice, format, autoCombine)
+	return needsCombine, true
 }
 
-// handleShortTextStream 流式处理短文本
-func (h *Handler) handleShortTextStream(
-	c *gin.Context,
-	ctx context.Context,
-	req *SpeechRequest,
-	voice ttsfm.Voice,
-	format ttsfm.AudioFormat,
-	autoCombine bool,
-) {
+// estimateOutputDuration 按默认语速估算输出音频时长，speed>0 时按倍速折算
+func estimateOutputDuration(input string, speed float64) time.Duration {
+	seconds := ttsfm.EstimateAudioDuration(input, 0)
+	if speed > 0 {
+		seconds /= speed
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// sanitizedLength 返回 SanitizeText 清理后的字符（rune）数；清理失败时退回原始字符数
+func sanitizedLength(input string) int {
+	sanitized, err := ttsfm.SanitizeText(input)
+	if err != nil {
+		return utf8.RuneCountInString(input)
+	}
+	return utf8.RuneCountInString(sanitized)
+}
+
+// resolveAutoCombineThreshold 返回触发自动拼接的文本长度，未配置或小于 max_length 时等于 max_length
+func (h *Handler) resolveAutoCombineThreshold(maxLength int) int {
+	if h.autoCombineThreshold > maxLength {
+		return h.autoCombineThreshold
+	}
+	return maxLength
+}
+
+// acquireLongTextJob 占用一个长文本任务名额；已满时最多排队 longTextQueueTimeout，
+// 仍无名额则返回 BusyException（503）
+func (h *Handler) acquireLongTextJob(ctx context.Context) (func(), error) {
+	if h.longTextJobs == nil {
+		return func() {}, nil
+	}
+	release := func() { <-h.longTextJobs }
+
+	select {
+	case h.longTextJobs <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if h.longTextQueueTimeout <= 0 {
+		return nil, ttsfm.NewBusyException("Too many concurrent long-text jobs", time.Second)
+	}
+
+	timer := time.NewTimer(h.longTextQueueTimeout)
+	defer timer.Stop()
+	select {
+	case h.longTextJobs <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ttsfm.NewBusyException("Too many concurrent long-text jobs", h.longTextQueueTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// buildRequestOptions 将 SpeechRequest 转换为 ttsfm 请求选项（长文本每个 chunk 共用同一组选项）
+func buildRequestOptions(req *SpeechRequest, voice ttsfm.Voice, format ttsfm.AudioFormat) []ttsfm.RequestOption {
 	opts := []ttsfm.RequestOption{
 		ttsfm.WithVoice(voice),
 		ttsfm.WithFormat(format),
@@ -183,15 +626,75 @@ func (h *Handler) handleShortTextStream(
 	if strings.TrimSpace(req.Instructions) != "" {
 		opts = append(opts, ttsfm.WithInstructions(req.Instructions))
 	}
-	if req.Speed != 0 {
+	// 与 OpenAI 一致，speed=1.0 即默认语速，不向上游发送
+	if req.Speed != 0 && req.Speed != 1 {
 		opts = append(opts, ttsfm.WithSpeed(req.Speed))
 	}
-	client, err := ttsfm.NewTTSClient(h.TTSClientOptions...)
+	if strings.TrimSpace(req.Vibe) != "" {
+		opts = append(opts, ttsfm.WithVibe(req.Vibe))
+	}
+	if req.Language != "" {
+		opts = append(opts, ttsfm.WithLanguage(req.Language))
+	}
+	if req.SampleRate != 0 {
+		opts = append(opts, ttsfm.WithSampleRate(req.SampleRate))
+	}
+	if req.StrictFormat != nil {
+		opts = append(opts, ttsfm.WithStrictResponseFormat(*req.StrictFormat))
+	}
+	if req.Seed != nil {
+		opts = append(opts, ttsfm.WithSeed(*req.Seed))
+	}
+	// extra_body 已在入口校验过，这里只做转换
+	if fields, err := extraFormFields(req.ExtraBody); err == nil && len(fields) > 0 {
+		opts = append(opts, ttsfm.WithExtraFormFields(fields))
+	}
+	return opts
+}
+
+// extraFormFields 将 extra_body 转换为上游表单字段；null 被忽略，嵌套对象/数组视为错误
+func extraFormFields(extra map[string]interface{}) (map[string]string, error) {
+	if len(extra) == 0 {
+		return nil, nil
+	}
+
+	fields := make(map[string]string, len(extra))
+	for key, value := range extra {
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			fields[key] = v
+		case bool:
+			fields[key] = strconv.FormatBool(v)
+		case float64:
+			fields[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("Invalid extra_body.%s: only string, number and boolean values are supported", key)
+		}
+	}
+	return fields, nil
+}
+
+// handleShortTextStream 流式处理短文本
+func (h *Handler) handleShortTextStream(
+	c *gin.Context,
+	ctx context.Context,
+	req *SpeechRequest,
+	voice ttsfm.Voice,
+	format ttsfm.AudioFormat,
+	autoCombine bool,
+) {
+	// 长度已在入口按清理后的字符数校验过（阈值以内可能超过 max_length），这里不再按字节重复校验
+	opts := append(buildRequestOptions(req, voice, format), ttsfm.WithoutLengthValidation())
+	client, err := h.ttsClient()
 	if err != nil {
-		h.error("Failed to create TTS client: %v", err)
+		h.error(c, "Failed to create TTS client: %v", err)
 		return
 	}
-	defer client.Close()
 	// 获取流式响应
 	streamResp, err := client.GenerateSpeechStream(ctx, req.Input, opts...)
 	if err != nil {
@@ -200,26 +703,255 @@ func (h *Handler) handleShortTextStream(
 	}
 	defer streamResp.Close()
 
+	// 响应头一旦写出就无法再返回 JSON 错误，先确认上游给的确实是音频
+	body, err := sniffAudioStream(streamResp.Body, h.resolveStreamSniffSize())
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	streamResp.Body = body
+
+	if req.wrapWAV {
+		wrapped, err := wrapPCMResponse(streamResp, req.SampleRate, false)
+		if err != nil {
+			h.handleError(c, err)
+			return
+		}
+		streamResp = wrapped
+	}
+
+	if req.StreamFormat == StreamFormatSSE || req.StreamFormat == StreamFormatNDJSON {
+		setChunkCountHeaders(c, "1")
+		setAudioDurationHeaders(c, estimateOutputDuration(req.Input, req.Speed).Seconds(), true)
+		c.Header("X-Auto-Combine", fmt.Sprintf("%v", autoCombine))
+		c.Header("X-Powered-By", "TTSFM-OpenAI-Compatible")
+
+		var budgetExceeded atomic.Value
+		written, err := h.streamAudioDeltas(c, withBudgetReader(streamResp, req.BudgetBytes), format, req.StreamFormat,
+			h.resolveStreamChunkSize(req.StreamChunkSize), &budgetExceeded)
+		if err != nil {
+			h.error(c, "Error streaming audio deltas: %v (written %d bytes)", err, written)
+			return
+		}
+		if exceeded, _ := budgetExceeded.Load().(string); exceeded != "" {
+			h.warn(c, "Audio stream stopped: %s budget exceeded (written %d bytes)", exceeded, written)
+			return
+		}
+		h.info(c, "Successfully streamed %d bytes of %s audio as %s deltas", written, streamResp.Format, req.StreamFormat)
+		return
+	}
+
+	// 小音频先完整缓冲，以便给出 Content-Length / X-Audio-Size / X-Audio-Duration
+	if h.bufferResponseMaxBytes > 0 {
+		data, rest, err := bufferSmallAudio(streamResp.Body, h.bufferResponseMaxBytes)
+		if err != nil {
+			h.handleError(c, err)
+			return
+		}
+		if rest == nil {
+			if req.BudgetBytes > 0 && int64(len(data)) > req.BudgetBytes {
+				// 已知完整内容，截断后直接以普通响应头标记
+				data = data[:req.BudgetBytes]
+				c.Header(budgetExceededTrailer, "bytes")
+			}
+			h.writeBufferedAudio(c, req, streamResp, data, autoCombine)
+			return
+		}
+		streamResp.Body = rest
+	}
+
 	// 设置响应头
 	c.Header("Content-Type", streamResp.ContentType)
 	c.Header("Transfer-Encoding", "chunked")
 	c.Header("X-Audio-Format", string(streamResp.Format))
-	c.Header("X-Chunks-Combined", "1")
+	setChunkCountHeaders(c, "1")
+	// 音频未缓冲，只能按文本估算时长
+	setAudioDurationHeaders(c, estimateOutputDuration(req.Input, req.Speed).Seconds(), true)
 	c.Header("X-Auto-Combine", fmt.Sprintf("%v", autoCombine))
 	c.Header("X-Powered-By", "TTSFM-OpenAI-Compatible")
+	var trailers []string
+	if h.audioChecksum {
+		trailers = append(trailers, audioChecksumTrailer)
+	}
+	if req.BudgetBytes > 0 {
+		trailers = append(trailers, budgetExceededTrailer)
+	}
+	if len(trailers) > 0 {
+		c.Header("Trailer", strings.Join(trailers, ", "))
+	}
 
 	// 设置状态码
 	c.Status(http.StatusOK)
 
 	// 流式写入响应
-	written, err := io.Copy(c.Writer, streamResp.Body)
+	out, checksum := h.audioWriter(c)
+	if req.BudgetBytes > 0 {
+		out = &budgetWriter{w: out, limit: req.BudgetBytes}
+	}
+	written, err := io.Copy(out, streamResp.Body)
+	h.metrics.observeBytes(streamResp.Format, written)
+	if errors.Is(err, errBudgetExceeded) {
+		c.Writer.Header().Set(budgetExceededTrailer, "bytes")
+		h.warn(c, "Audio stream stopped: bytes budget exceeded (written %d bytes)", written)
+		return
+	}
 	if err != nil && !errors.Is(err, io.EOF) && err.Error() != "EOF" {
 		// 此时已经开始写入响应，无法返回 JSON 错误
-		h.error("Error streaming response: %v (written %d bytes)", err, written)
+		h.error(c, "Error streaming response: %v (written %d bytes)", err, written)
 		return
 	}
+	checksum()
+
+	h.info(c, "Successfully streamed %d bytes of %s audio", written, streamResp.Format)
+}
+
+// setChunkCountHeaders 写出本次响应拼接的上游分段数。
+// X-Chunk-Count 为规范字段，X-Chunks-Combined 是兼容旧客户端保留的同义字段，二者取值始终一致；
+// 短文本恒为 1，长文本为实际分段数（只有一段时同样为 1）。
+func setChunkCountHeaders(c *gin.Context, count string) {
+	c.Header("X-Chunk-Count", count)
+	c.Header("X-Chunks-Combined", count)
+}
+
+// setAudioDurationHeaders 写出音频时长（秒）；estimated 表示该值按文本估算而非由音频数据计算
+func setAudioDurationHeaders(c *gin.Context, seconds float64, estimated bool) {
+	c.Header("X-Audio-Duration-Seconds", strconv.FormatFloat(seconds, 'f', 3, 64))
+	c.Header("X-Audio-Duration-Estimated", strconv.FormatBool(estimated))
+}
+
+// writeBufferedAudio 一次性写出已完整缓冲的音频，附带大小、时长与校验和响应头；
+// 无法从音频数据计算时长时退回按文本估算
+func (h *Handler) writeBufferedAudio(c *gin.Context, req *SpeechRequest, streamResp *ttsfm.TTSStreamResponse, data []byte, autoCombine bool) {
+	c.Header("Content-Length", strconv.Itoa(len(data)))
+	c.Header("X-Audio-Format", string(streamResp.Format))
+	c.Header("X-Audio-Size", strconv.Itoa(len(data)))
+	if duration, err := ttsfm.GetAudioDuration(data, streamResp.Format); err == nil && duration > 0 {
+		c.Header("X-Audio-Duration", strconv.FormatFloat(duration, 'f', 3, 64))
+		setAudioDurationHeaders(c, duration, false)
+	} else {
+		setAudioDurationHeaders(c, estimateOutputDuration(req.Input, req.Speed).Seconds(), true)
+	}
+	setChunkCountHeaders(c, "1")
+	c.Header("X-Auto-Combine", fmt.Sprintf("%v", autoCombine))
+	c.Header("X-Powered-By", "TTSFM-OpenAI-Compatible")
+	if h.audioChecksum {
+		// 已知完整内容，直接作为普通响应头返回
+		c.Header(audioChecksumTrailer, sha256Hex(data))
+	}
+
+	c.Data(http.StatusOK, streamResp.ContentType, data)
+	h.metrics.observeBytes(streamResp.Format, int64(len(data)))
+
+	h.info(c, "Successfully sent %d bytes of buffered %s audio", len(data), streamResp.Format)
+}
+
+// chunkIndexTrailer 长文本二进制输出的分段索引 trailer（见 SpeechRequest.ChunkIndex）
+const chunkIndexTrailer = "X-Chunk-Index"
+
+// wavHeaderSize wrap=wav 包装 PCM 时写在音频前的标准 WAV 头长度
+const wavHeaderSize = 44
+
+// chunkIndexEntry 分段索引中的一项：第 Index 段从输出的第 Offset 字节开始，共 Bytes 字节，
+// EstimatedStartSeconds 为按文本估算的该段起始时间
+type chunkIndexEntry struct {
+	Index                 int     `json:"index"`
+	Offset                int64   `json:"offset"`
+	Bytes                 int64   `json:"bytes"`
+	EstimatedStartSeconds float64 `json:"estimated_start_seconds"`
+}
+
+// budgetExceededTrailer 二进制输出超出预算时写入的 trailer，值为超出的维度（bytes 或 seconds）
+const budgetExceededTrailer = "X-Budget-Exceeded"
+
+// errBudgetExceeded 输出超出请求预算，用于中止输出与剩余分段
+var errBudgetExceeded = errors.New("request budget exceeded")
+
+// resolveBudget 请求体未设置预算时从 X-Budget-Bytes / X-Budget-Seconds 请求头读取，并校验非负
+func resolveBudget(c *gin.Context, req *SpeechRequest) error {
+	if v := strings.TrimSpace(c.GetHeader("X-Budget-Bytes")); v != "" && req.BudgetBytes == 0 {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid X-Budget-Bytes header: %s", v)
+		}
+		req.BudgetBytes = n
+	}
+	if v := strings.TrimSpace(c.GetHeader("X-Budget-Seconds")); v != "" && req.BudgetSeconds == 0 {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("Invalid X-Budget-Seconds header: %s", v)
+		}
+		req.BudgetSeconds = f
+	}
+	if req.BudgetBytes < 0 {
+		return fmt.Errorf("Invalid budget_bytes: %d. Must be >= 0", req.BudgetBytes)
+	}
+	if req.BudgetSeconds < 0 {
+		return fmt.Errorf("Invalid budget_seconds: %v. Must be >= 0", req.BudgetSeconds)
+	}
+	return nil
+}
+
+// budgetWriter 最多写出 limit 字节：会越过 limit 的写入只写出剩余部分并返回 errBudgetExceeded
+type budgetWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (b *budgetWriter) Write(p []byte) (int, error) {
+	remaining := b.limit - b.written
+	if int64(len(p)) <= remaining {
+		n, err := b.w.Write(p)
+		b.written += int64(n)
+		return n, err
+	}
+	n, err := b.w.Write(p[:remaining])
+	b.written += int64(n)
+	if err == nil {
+		err = errBudgetExceeded
+	}
+	return n, err
+}
+
+// budgetReader 最多读出 limit 字节：读满后上游仍有数据时返回 errBudgetExceeded，用于 SSE/NDJSON 增量输出
+type budgetReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	if b.read >= b.limit {
+		// 恰好读满预算时再探测一个字节，区分正常结束与超出预算
+		var probe [1]byte
+		n, err := b.r.Read(probe[:])
+		if n > 0 {
+			return 0, errBudgetExceeded
+		}
+		return 0, err
+	}
+	if remaining := b.limit - b.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	return n, err
+}
 
-	h.info("Successfully streamed %d bytes of %s audio", written, streamResp.Format)
+// withBudgetReader 设置了 budget_bytes 时返回 body 被 budgetReader 包装的新响应，否则原样返回
+func withBudgetReader(streamResp *ttsfm.TTSStreamResponse, limit int64) *ttsfm.TTSStreamResponse {
+	if limit <= 0 {
+		return streamResp
+	}
+	return &ttsfm.TTSStreamResponse{
+		Body: struct {
+			io.Reader
+			io.Closer
+		}{&budgetReader{r: streamResp.Body, limit: limit}, streamResp.Body},
+		ContentType: streamResp.ContentType,
+		Format:      streamResp.Format,
+		Metadata:    streamResp.Metadata,
+	}
 }
 
 func (h *Handler) handleLongTextStream(
@@ -229,36 +961,57 @@ func (h *Handler) handleLongTextStream(
 	voice ttsfm.Voice,
 	format ttsfm.AudioFormat,
 ) {
-	h.info("Long text detected (%d chars), auto-combining enabled (streaming)", len(req.Input))
+	h.info(c, "Long text detected (%d chars), auto-combining enabled (streaming)", utf8.RuneCountInString(req.Input))
 
-	opts := []ttsfm.RequestOption{
-		ttsfm.WithVoice(voice),
-		ttsfm.WithFormat(format),
-		ttsfm.WithMaxLength(req.MaxLength),
-	}
-	if strings.TrimSpace(req.Instructions) != "" {
-		opts = append(opts, ttsfm.WithInstructions(req.Instructions))
-	}
-	if req.Speed != 0 {
-		opts = append(opts, ttsfm.WithSpeed(req.Speed))
-	}
+	opts := buildRequestOptions(req, voice, format)
 
-	client, err := ttsfm.NewTTSClient(h.TTSClientOptions...)
+	client, err := h.ttsClient()
 	if err != nil {
-		h.error("Failed to create TTS client: %v", err)
+		h.error(c, "Failed to create TTS client: %v", err)
 		return
 	}
-	defer client.Close()
+
+	streamConfig := h.longTextStreamConfig(req)
+
+	// 二进制输出时通过 trailer 汇报已完成的 chunk 数（以及可选的分段索引）；回调按序执行，只统计字节数，
+	// 不缓存 chunk 数据
+	var chunksCompleted int64
+	var indexMu sync.Mutex
+	var index []chunkIndexEntry
+	var estimatedStart float64
+	// budgetExceeded 超出的预算维度，由输出协程或进度回调写入
+	var budgetExceeded atomic.Value
+	binaryOutput := req.StreamFormat != StreamFormatSSE && req.StreamFormat != StreamFormatNDJSON
+	if binaryOutput || req.BudgetSeconds > 0 {
+		streamConfig.OnChunkProgress = func(chunk ttsfm.ChunkProgress) error {
+			atomic.AddInt64(&chunksCompleted, 1)
+			indexMu.Lock()
+			if req.ChunkIndex {
+				index = append(index, chunkIndexEntry{
+					Index:                 chunk.Index,
+					Offset:                chunk.Offset,
+					Bytes:                 chunk.Bytes,
+					EstimatedStartSeconds: estimatedStart,
+				})
+			}
+			estimatedStart += estimateOutputDuration(chunk.Text, req.Speed).Seconds()
+			overBudget := req.BudgetSeconds > 0 && estimatedStart > req.BudgetSeconds && chunk.Index < chunk.Total-1
+			indexMu.Unlock()
+			// 已输出的估算时长超出预算且仍有剩余分段时中止，剩余分段的上游请求随之取消
+			if overBudget {
+				budgetExceeded.Store("seconds")
+				return errBudgetExceeded
+			}
+			return nil
+		}
+	}
 
 	streamResp, err := client.GenerateSpeechLongTextStreamConcurrent(
 		ctx,
 		req.Input,
 		req.MaxLength,
 		true,
-		&ttsfm.LongTextStreamConfig{
-			MaxConcurrent:   3,
-			ChunkBufferSize: 32 * 1024,
-		},
+		streamConfig,
 		opts...,
 	)
 	if err != nil {
@@ -267,44 +1020,150 @@ func (h *Handler) handleLongTextStream(
 	}
 	defer streamResp.Close()
 
-	chunksTotal := streamResp.Metadata["chunks_total"]
-	if strings.TrimSpace(chunksTotal) == "" {
-		chunksTotal = "0"
+	// 包装为 WAV 时输出前多了 44 字节的 WAV 头，索引偏移需相应后移
+	var indexShift int64
+	if req.wrapWAV {
+		if streamResp.Format == ttsfm.FormatPCM {
+			indexShift = wavHeaderSize
+		}
+		wrapped, err := wrapPCMResponse(streamResp, req.SampleRate, true)
+		if err != nil {
+			h.handleError(c, err)
+			return
+		}
+		streamResp = wrapped
+	}
+
+	chunksTotal := strings.TrimSpace(streamResp.Metadata["chunks_total"])
+	if chunksTotal == "" {
+		chunksTotal = "1"
+	}
+
+	// 长文本边合成边输出，时长只能按文本估算
+	estimatedDuration := estimateOutputDuration(req.Input, req.Speed).Seconds()
+
+	if !binaryOutput {
+		setChunkCountHeaders(c, chunksTotal)
+		setAudioDurationHeaders(c, estimatedDuration, true)
+		c.Header("X-Original-Text-Length", strconv.Itoa(utf8.RuneCountInString(req.Input)))
+		c.Header("X-Auto-Combine", "true")
+		c.Header("X-Powered-By", "TTSFM-OpenAI-Compatible")
+
+		written, err := h.streamAudioDeltas(c, withBudgetReader(streamResp, req.BudgetBytes), format, req.StreamFormat,
+			h.resolveStreamChunkSize(req.StreamChunkSize), &budgetExceeded)
+		if err != nil {
+			h.error(c, "Error streaming long text audio deltas: %v (written %d bytes)", err, written)
+			return
+		}
+		if exceeded, _ := budgetExceeded.Load().(string); exceeded != "" {
+			h.warn(c, "Long text stream stopped: %s budget exceeded (written %d bytes)", exceeded, written)
+			return
+		}
+		h.info(c, "Successfully streamed %d bytes of %s audio as %s deltas (chunks=%s)", written, streamResp.Format, req.StreamFormat, chunksTotal)
+		return
 	}
 
 	c.Header("Content-Type", streamResp.ContentType)
 	c.Header("Transfer-Encoding", "chunked")
 	c.Header("X-Audio-Format", string(streamResp.Format))
-	c.Header("X-Chunks-Combined", chunksTotal)
-	c.Header("X-Original-Text-Length", strconv.Itoa(len(req.Input)))
+	setChunkCountHeaders(c, chunksTotal)
+	setAudioDurationHeaders(c, estimatedDuration, true)
+	c.Header("X-Original-Text-Length", strconv.Itoa(utf8.RuneCountInString(req.Input)))
 	c.Header("X-Auto-Combine", "true")
 	c.Header("X-Powered-By", "TTSFM-OpenAI-Compatible")
+	trailers := "X-Chunks-Completed, X-Total-Bytes"
+	if req.ChunkIndex {
+		trailers += ", " + chunkIndexTrailer
+	}
+	if h.audioChecksum {
+		trailers += ", " + audioChecksumTrailer
+	}
+	if req.BudgetBytes > 0 || req.BudgetSeconds > 0 {
+		trailers += ", " + budgetExceededTrailer
+	}
+	c.Header("Trailer", trailers)
 
 	c.Status(http.StatusOK)
 
-	written, err := io.Copy(c.Writer, streamResp.Body)
+	out, checksum := h.audioWriter(c)
+	if req.BudgetBytes > 0 {
+		// WAV 头同样计入预算
+		out = &budgetWriter{w: out, limit: req.BudgetBytes}
+	}
+	written, err := io.Copy(out, streamResp.Body)
+	if errors.Is(err, errBudgetExceeded) && budgetExceeded.Load() == nil {
+		budgetExceeded.Store("bytes")
+	}
+	h.metrics.observeBytes(streamResp.Format, written)
+
+	// 流结束（包括中途失败）后写入汇总 trailer，客户端可据此判断音频是否完整
+	c.Writer.Header().Set("X-Chunks-Completed", strconv.FormatInt(atomic.LoadInt64(&chunksCompleted), 10))
+	c.Writer.Header().Set("X-Total-Bytes", strconv.FormatInt(written, 10))
+	if req.ChunkIndex {
+		indexMu.Lock()
+		for i := range index {
+			index[i].Offset += indexShift
+		}
+		if encoded, err := json.Marshal(index); err == nil {
+			c.Writer.Header().Set(chunkIndexTrailer, string(encoded))
+		}
+		indexMu.Unlock()
+	}
+
+	if exceeded, _ := budgetExceeded.Load().(string); exceeded != "" {
+		// 提前关闭上游流，中止尚未完成的分段
+		_ = streamResp.Close()
+		c.Writer.Header().Set(budgetExceededTrailer, exceeded)
+		h.warn(c, "Long text stream stopped: %s budget exceeded (written %d bytes, chunks=%d/%s)",
+			exceeded, written, atomic.LoadInt64(&chunksCompleted), chunksTotal)
+		return
+	}
+
 	if err != nil && !errors.Is(err, io.EOF) && err.Error() != "EOF" {
-		h.error("Error streaming long text response: %v (written %d bytes)", err, written)
+		h.error(c, "Error streaming long text response: %v (written %d bytes)", err, written)
 		return
 	}
+	checksum()
 
-	h.info("Successfully streamed %d bytes of %s audio (chunks=%s)", written, streamResp.Format, chunksTotal)
+	h.info(c, "Successfully streamed %d bytes of %s audio (chunks=%s)", written, streamResp.Format, chunksTotal)
 }
 
 func (h *Handler) handleError(c *gin.Context, err error) {
-	h.error("Request error: %v", err)
+	h.error(c, "Request error: %v", err)
+	h.metrics.observeError(err)
+
+	// 长文本路径会给错误包上 chunk 序号，繁忙错误需要穿透包装识别
+	var busy *ttsfm.BusyException
+	if errors.As(err, &busy) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(busy.Wait.Seconds()))))
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: ErrorDetail{
+				Message: "Server is busy, please retry later",
+				Type:    "service_unavailable_error",
+				Code:    "server_busy",
+			},
+		})
+		return
+	}
 
-	switch e := err.(type) {
-	case *ttsfm.ValidationException:
+	var (
+		validation *ttsfm.ValidationException
+		auth       *ttsfm.AuthenticationException
+		rateLimit  *ttsfm.RateLimitException
+		network    *ttsfm.NetworkException
+		apiErr     *ttsfm.APIException
+	)
+	switch {
+	case errors.As(err, &validation):
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: ErrorDetail{
-				Message: e.Message,
+				Message: validation.Message,
 				Type:    "invalid_request_error",
 				Code:    "validation_error",
 			},
 		})
 
-	case *ttsfm.AuthenticationException:
+	case errors.As(err, &auth):
 		c.JSON(http.StatusUnauthorized, ErrorResponse{
 			Error: ErrorDetail{
 				Message: "Invalid API key",
@@ -313,7 +1172,7 @@ func (h *Handler) handleError(c *gin.Context, err error) {
 			},
 		})
 
-	case *ttsfm.RateLimitException:
+	case errors.As(err, &rateLimit):
 		c.JSON(http.StatusTooManyRequests, ErrorResponse{
 			Error: ErrorDetail{
 				Message: "Rate limit exceeded",
@@ -322,7 +1181,7 @@ func (h *Handler) handleError(c *gin.Context, err error) {
 			},
 		})
 
-	case *ttsfm.NetworkException:
+	case errors.As(err, &network):
 		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
 			Error: ErrorDetail{
 				Message: "TTS service is currently unavailable",
@@ -331,8 +1190,8 @@ func (h *Handler) handleError(c *gin.Context, err error) {
 			},
 		})
 
-	case *ttsfm.APIException:
-		statusCode := e.StatusCode
+	case errors.As(err, &apiErr):
+		statusCode := apiErr.StatusCode
 		if statusCode == 0 {
 			statusCode = http.StatusInternalServerError
 		}
@@ -355,19 +1214,260 @@ func (h *Handler) handleError(c *gin.Context, err error) {
 	}
 }
 
-// HealthCheck 健康检查接口
+// tryBeginWork 登记一个会调用上游的请求，返回的 done 在请求结束时调用；开始排空后返回 false
+func (h *Handler) tryBeginWork() (done func(), ok bool) {
+	h.drainMu.Lock()
+	defer h.drainMu.Unlock()
+	if atomic.LoadInt32(&h.draining) == 1 {
+		return nil, false
+	}
+	h.inflight.Add(1)
+	atomic.AddInt64(&h.active, 1)
+	return func() {
+		atomic.AddInt64(&h.active, -1)
+		h.inflight.Done()
+	}, true
+}
+
+// beginWork 同 tryBeginWork；排空期间直接返回 503
+func (h *Handler) beginWork(c *gin.Context) (done func(), ok bool) {
+	done, ok = h.tryBeginWork()
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: ErrorDetail{
+				Message: "Server is shutting down, please retry on another instance",
+				Type:    "service_unavailable_error",
+				Code:    "server_shutting_down",
+			},
+		})
+	}
+	return done, ok
+}
+
+// drain 停止接收新的上游请求，并等待进行中的请求结束，最多等待 timeout；返回超时时仍未结束的请求数
+func (h *Handler) drain(timeout time.Duration) int64 {
+	h.drainMu.Lock()
+	atomic.StoreInt32(&h.draining, 1)
+	h.drainMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.inflight.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return 0
+	case <-timer.C:
+		return atomic.LoadInt64(&h.active)
+	}
+}
+
+// abortInflight 取消所有进行中请求的上下文，使卡住的上游调用尽快返回
+func (h *Handler) abortInflight() {
+	h.cancelInflight()
+}
+
+// defaultHealthCheckTimeout 深度健康检查探测上游的超时
+const defaultHealthCheckTimeout = 5 * time.Second
+
+// HealthCheck 健康检查接口（?deep=1 时同时探测上游）
 func (h *Handler) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "healthy",
-		"service": "ttsfm",
-		"version": "1.0.0",
+	deep, _ := strconv.ParseBool(c.Query("deep"))
+	if !deep {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "healthy",
+			"service": "ttsfm",
+			"version": "1.0.0",
+		})
+		return
+	}
+
+	// ?deep=1 时额外探测上游，上游不可达返回 503，便于负载均衡区分"进程存活但上游故障"
+	upstream, err := h.probeUpstream(c)
+	status, code := "healthy", http.StatusOK
+	if err != nil {
+		status, code = "degraded", http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, gin.H{
+		"status":   status,
+		"service":  "ttsfm",
+		"version":  "1.0.0",
+		"upstream": upstream,
 	})
 }
 
+// errDraining 优雅关闭排空期间拒绝新的上游探测
+var errDraining = errors.New("server is draining")
+
+// probeUpstream 通过共享客户端 Ping 上游，返回可达性、耗时与错误信息
+func (h *Handler) probeUpstream(c *gin.Context) (gin.H, error) {
+	upstream := gin.H{"reachable": true}
+
+	done, ok := h.tryBeginWork()
+	if !ok {
+		upstream["reachable"] = false
+		upstream["error"] = errDraining.Error()
+		return upstream, errDraining
+	}
+	defer done()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), defaultHealthCheckTimeout)
+	defer cancel()
+	stop := context.AfterFunc(h.shutdownCtx, cancel)
+	defer stop()
+
+	start := time.Now()
+	client, err := h.ttsClient()
+	if err == nil {
+		err = client.Ping(ctx)
+	}
+	upstream["latency_ms"] = time.Since(start).Milliseconds()
+	if err != nil {
+		h.warn(c, "Upstream health check failed: %v", err)
+		upstream["reachable"] = false
+		upstream["error"] = err.Error()
+	}
+	return upstream, err
+}
+
+// Livez 存活检查：进程能处理请求即返回 200，不探测上游
+func (h *Handler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// Readyz 就绪检查：优雅关闭排空期间或上游不可达时返回 503
+func (h *Handler) Readyz(c *gin.Context) {
+	if atomic.LoadInt32(&h.draining) == 1 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+
+	upstream, err := h.probeUpstream(c)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "upstream": upstream})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "upstream": upstream})
+}
+
+// selfTestPhrase 自检合成文本的前缀
+const selfTestPhrase = "TTSFM self test"
+
+// selfTestInput 每次自检的合成文本附带时间戳，避免命中客户端响应缓存而测不到上游
+func selfTestInput() string {
+	return fmt.Sprintf("%s %d.", selfTestPhrase, time.Now().UnixNano())
+}
+
+// SelfTest 诊断接口：合成一句短文本并报告首字节耗时、总耗时与音频大小，音频本身不返回给客户端
+func (h *Handler) SelfTest(c *gin.Context) {
+	done, ok := h.beginWork(c)
+	if !ok {
+		return
+	}
+	defer done()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.timeout)
+	defer cancel()
+	stop := context.AfterFunc(h.shutdownCtx, cancel)
+	defer stop()
+
+	format := ttsfm.FormatMP3
+	report := gin.H{"ok": false, "format": string(format)}
+	fail := func(err error) {
+		h.warn(c, "Self test failed: %v", err)
+		report["error"] = err.Error()
+		c.JSON(http.StatusServiceUnavailable, report)
+	}
+
+	start := time.Now()
+	client, err := h.ttsClient()
+	if err != nil {
+		fail(err)
+		return
+	}
+	streamResp, err := client.GenerateSpeechStream(ctx, selfTestInput(), ttsfm.WithFormat(format))
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer streamResp.Close()
+
+	var (
+		data []byte
+		ttfb time.Duration
+		buf  = make([]byte, 32*1024)
+	)
+	for {
+		n, readErr := streamResp.Body.Read(buf)
+		if n > 0 {
+			if ttfb == 0 {
+				ttfb = time.Since(start)
+			}
+			data = append(data, buf[:n]...)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			fail(readErr)
+			return
+		}
+	}
+	total := time.Since(start)
+
+	report["ttfb_ms"] = ttfb.Milliseconds()
+	report["total_ms"] = total.Milliseconds()
+	report["bytes"] = len(data)
+	if streamResp.Format != "" {
+		report["format"] = string(streamResp.Format)
+	}
+	if err := ttsfm.ValidateAudioData(data, streamResp.Format); err != nil {
+		fail(err)
+		return
+	}
+
+	report["ok"] = true
+	c.JSON(http.StatusOK, report)
+}
+
+// isVoiceAllowed 检查语音是否在服务端允许列表内（未配置时全部允许）
+func (h *Handler) isVoiceAllowed(voice ttsfm.Voice) bool {
+	if len(h.allowedVoices) == 0 {
+		return true
+	}
+	for _, v := range h.allowedVoices {
+		if v == voice {
+			return true
+		}
+	}
+	return false
+}
+
+// availableVoices 返回当前可用的语音（有效且被允许）
+func (h *Handler) availableVoices() []ttsfm.Voice {
+	if len(h.allowedVoices) == 0 {
+		return ttsfm.ValidVoices
+	}
+	voices := make([]ttsfm.Voice, 0, len(h.allowedVoices))
+	for _, v := range ttsfm.ValidVoices {
+		if h.isVoiceAllowed(v) {
+			voices = append(voices, v)
+		}
+	}
+	return voices
+}
+
 // GetVoices 获取可用语音列表
 func (h *Handler) GetVoices(c *gin.Context) {
-	voices := make([]gin.H, len(ttsfm.ValidVoices))
-	for i, v := range ttsfm.ValidVoices {
+	available := h.availableVoices()
+	voices := make([]gin.H, len(available))
+	for i, v := range available {
 		voices[i] = gin.H{
 			"id":   string(v),
 			"name": string(v),
@@ -391,27 +1491,131 @@ func (h *Handler) GetFormats(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"formats": formats})
 }
 
-func truncateString(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
+// modelsCreatedAt /v1/models 返回的固定 created 时间戳
+const modelsCreatedAt int64 = 1735689600
+
+// ModelObject OpenAI 兼容的模型对象
+type ModelObject struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// ModelList OpenAI 兼容的模型列表
+type ModelList struct {
+	Object string        `json:"object"`
+	Data   []ModelObject `json:"data"`
+}
+
+func newModelObject(id string) ModelObject {
+	return ModelObject{
+		ID:      id,
+		Object:  "model",
+		Created: modelsCreatedAt,
+		OwnedBy: "ttsfm",
+	}
+}
+
+// GetModels 获取支持的模型列表
+// GET /v1/models
+func (h *Handler) GetModels(c *gin.Context) {
+	data := make([]ModelObject, len(ttsfm.SupportedModels))
+	for i, m := range ttsfm.SupportedModels {
+		data[i] = newModelObject(m)
+	}
+
+	c.JSON(http.StatusOK, ModelList{Object: "list", Data: data})
+}
+
+// GetModel 获取单个模型
+// GET /v1/models/:id
+func (h *Handler) GetModel(c *gin.Context) {
+	id := c.Param("id")
+	for _, m := range ttsfm.SupportedModels {
+		if m == id {
+			c.JSON(http.StatusOK, newModelObject(m))
+			return
+		}
+	}
+
+	c.JSON(http.StatusNotFound, ErrorResponse{
+		Error: ErrorDetail{
+			Message: fmt.Sprintf("The model '%s' does not exist", id),
+			Type:    "invalid_request_error",
+			Code:    "model_not_found",
+		},
+	})
+}
+
+// NotImplemented OpenAI 音频接口中本服务不支持的部分（转写/翻译），返回 OpenAI 格式的 501 错误，
+// 避免 SDK 探测时拿到没有 JSON 错误体的 404
+func (h *Handler) NotImplemented(c *gin.Context) {
+	c.JSON(http.StatusNotImplemented, ErrorResponse{
+		Error: ErrorDetail{
+			Message: fmt.Sprintf("%s is not supported: this is a text-to-speech only service (use POST /v1/audio/speech)", c.Request.URL.Path),
+			Type:    "invalid_request_error",
+			Code:    "not_implemented",
+		},
+	})
+}
+
+// ttsClient 返回共享的上游客户端，必要时创建；创建失败不缓存，下次请求重试
+func (h *Handler) ttsClient() (*ttsfm.TTSClient, error) {
+	h.clientMu.Lock()
+	defer h.clientMu.Unlock()
+
+	if h.client == nil {
+		client, err := ttsfm.NewTTSClient(h.TTSClientOptions...)
+		if err != nil {
+			return nil, err
+		}
+		h.client = client
+	}
+	return h.client, nil
+}
+
+// closeClient 关闭共享客户端（服务器停止时调用），之后的请求会重新创建
+func (h *Handler) closeClient() error {
+	h.clientMu.Lock()
+	client := h.client
+	h.client = nil
+	h.clientMu.Unlock()
+
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
+
+// loggerFor 返回按请求 ctx 中日志级别（X-Log-Level）调整后的日志器，未配置日志器时返回 nil
+func (h *Handler) loggerFor(c *gin.Context) ttsfm.Logger {
+	if h.logger == nil {
+		return nil
+	}
+	return ttsfm.LoggerForContext(c.Request.Context(), h.logger)
+}
+
+func (h *Handler) debug(c *gin.Context, msg string, args ...interface{}) {
+	if logger := h.loggerFor(c); logger != nil {
+		logger.Debug(msg, args...)
 	}
-	return s[:maxLen] + "..."
 }
 
-func (h *Handler) info(msg string, args ...interface{}) {
-	if h.logger != nil {
-		h.logger.Info(msg, args...)
+func (h *Handler) info(c *gin.Context, msg string, args ...interface{}) {
+	if logger := h.loggerFor(c); logger != nil {
+		logger.Info(msg, args...)
 	}
 }
 
-func (h *Handler) warn(msg string, args ...interface{}) {
-	if h.logger != nil {
-		h.logger.Warn(msg, args...)
+func (h *Handler) warn(c *gin.Context, msg string, args ...interface{}) {
+	if logger := h.loggerFor(c); logger != nil {
+		logger.Warn(msg, args...)
 	}
 }
 
-func (h *Handler) error(msg string, args ...interface{}) {
-	if h.logger != nil {
-		h.logger.Error(msg, args...)
+func (h *Handler) error(c *gin.Context, msg string, args ...interface{}) {
+	if logger := h.loggerFor(c); logger != nil {
+		logger.Error(msg, args...)
 	}
 }
@@ -2,10 +2,20 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -38,6 +48,15 @@ func newUpstreamTTS(t *testing.T, contentType string, cases map[string]upstreamC
 
 		input := r.FormValue("input")
 		c, ok := cases[input]
+		if !ok {
+			// 以 * 结尾的键按前缀匹配（如自检文本带时间戳）
+			for key, pc := range cases {
+				if prefix, wildcard := strings.CutSuffix(key, "*"); wildcard && strings.HasPrefix(input, prefix) {
+					c, ok = pc, true
+					break
+				}
+			}
+		}
 		if !ok {
 			http.Error(w, "unexpected input", http.StatusBadRequest)
 			return
@@ -57,6 +76,12 @@ func newUpstreamTTS(t *testing.T, contentType string, cases map[string]upstreamC
 func newTestEngine(t *testing.T, upstreamURL string) *gin.Engine {
 	t.Helper()
 
+	return newTestEngineWithConfig(t, upstreamURL, nil)
+}
+
+func newTestEngineWithConfig(t *testing.T, upstreamURL string, configure func(cfg *ServerConfig)) *gin.Engine {
+	t.Helper()
+
 	cfg := DefaultServerConfig()
 	cfg.Logger = &ttsfm.DefaultLogger{}
 	cfg.EnableCORS = false
@@ -70,6 +95,9 @@ func newTestEngine(t *testing.T, upstreamURL string) *gin.Engine {
 		ttsfm.WithMaxConcurrent(10),
 		ttsfm.WithLogger(cfg.Logger),
 	}
+	if configure != nil {
+		configure(cfg)
+	}
 
 	srv, err := NewServer(cfg)
 	if err != nil {
@@ -209,19 +237,19 @@ func TestOpenAISpeech_LongText_AutoCombine_Stream_MP3_OK(t *testing.T) {
 
 	// 故意让 chunk0 更慢，模拟并发下响应乱序
 	upstream, calls := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
-		"aaaaa.": {body: ch1, delay: 80 * time.Millisecond},
-		"bbbbb.": {body: ch2, delay: 0},
+		"This is chunk one.": {body: ch1, delay: 80 * time.Millisecond},
+		"This is chunk two.": {body: ch2, delay: 0},
 	})
 	defer upstream.Close()
 
 	engine := newTestEngine(t, upstream.URL)
 
 	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
-		"input":           "aaaaa. bbbbb.",
+		"input":           "This is chunk one. This is chunk two.",
 		"voice":           "alloy",
 		"response_format": "mp3",
 		"auto_combine":    true,
-		"max_length":      6,
+		"max_length":      20,
 	})
 
 	if w.Code != http.StatusOK {
@@ -243,6 +271,105 @@ func TestOpenAISpeech_LongText_AutoCombine_Stream_MP3_OK(t *testing.T) {
 	}
 }
 
+func TestOpenAISpeech_EstimatedDurationHeaders(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello":              {body: []byte("hello-audio")},
+		"This is chunk one.": {body: []byte("chunk1-")},
+		"This is chunk two.": {body: []byte("chunk2")},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	cases := []struct {
+		name         string
+		input        string
+		streamFormat string
+		speed        float64
+	}{
+		{name: "short binary", input: "hello"},
+		{name: "short sse", input: "hello", streamFormat: "sse"},
+		{name: "short binary with speed", input: "hello", speed: 2},
+		{name: "long binary", input: "This is chunk one. This is chunk two."},
+		{name: "long ndjson", input: "This is chunk one. This is chunk two.", streamFormat: "ndjson"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := map[string]any{
+				"input":        tc.input,
+				"voice":        "alloy",
+				"max_length":   20,
+				"auto_combine": true,
+			}
+			if tc.streamFormat != "" {
+				body["stream_format"] = tc.streamFormat
+			}
+			if tc.speed != 0 {
+				body["speed"] = tc.speed
+			}
+			w := doJSONPost(t, engine, "/v1/audio/speech", body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+			}
+			want := strconv.FormatFloat(estimateOutputDuration(tc.input, tc.speed).Seconds(), 'f', 3, 64)
+			if got := w.Header().Get("X-Audio-Duration-Seconds"); got != want {
+				t.Fatalf("expected X-Audio-Duration-Seconds %s, got %q", want, got)
+			}
+			if got := w.Header().Get("X-Audio-Duration-Estimated"); got != "true" {
+				t.Fatalf("expected streamed duration to be marked estimated, got %q", got)
+			}
+		})
+	}
+}
+
+func TestOpenAISpeech_ChunkCountHeaders(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello":              {body: []byte("hello-audio")},
+		"This is chunk one.": {body: []byte("chunk1-")},
+		"This is chunk two.": {body: []byte("chunk2")},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	cases := []struct {
+		name         string
+		input        string
+		streamFormat string
+		want         string
+	}{
+		{name: "short binary", input: "hello", want: "1"},
+		{name: "short sse", input: "hello", streamFormat: "sse", want: "1"},
+		{name: "long binary", input: "This is chunk one. This is chunk two.", want: "2"},
+		{name: "long ndjson", input: "This is chunk one. This is chunk two.", streamFormat: "ndjson", want: "2"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := map[string]any{
+				"input":        tc.input,
+				"voice":        "alloy",
+				"max_length":   20,
+				"auto_combine": true,
+			}
+			if tc.streamFormat != "" {
+				body["stream_format"] = tc.streamFormat
+			}
+			w := doJSONPost(t, engine, "/v1/audio/speech", body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("X-Chunk-Count"); got != tc.want {
+				t.Fatalf("expected X-Chunk-Count %s, got %q", tc.want, got)
+			}
+			if got := w.Header().Get("X-Chunks-Combined"); got != tc.want {
+				t.Fatalf("expected X-Chunks-Combined %s, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
 func TestOpenAISpeech_LongText_AutoCombine_Stream_WAV_OK(t *testing.T) {
 	pcm1 := []byte{0x01, 0x02, 0x03, 0x04}
 	pcm2 := []byte{0x05, 0x06}
@@ -251,19 +378,19 @@ func TestOpenAISpeech_LongText_AutoCombine_Stream_WAV_OK(t *testing.T) {
 
 	// 故意让 chunk0 更慢，模拟并发下响应乱序
 	upstream, calls := newUpstreamTTS(t, "audio/wav", map[string]upstreamCase{
-		"aaaaa.": {body: wav1, delay: 80 * time.Millisecond},
-		"bbbbb.": {body: wav2, delay: 0},
+		"This is chunk one.": {body: wav1, delay: 80 * time.Millisecond},
+		"This is chunk two.": {body: wav2, delay: 0},
 	})
 	defer upstream.Close()
 
 	engine := newTestEngine(t, upstream.URL)
 
 	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
-		"input":           "aaaaa. bbbbb.",
+		"input":           "This is chunk one. This is chunk two.",
 		"voice":           "alloy",
 		"response_format": "wav",
 		"auto_combine":    true,
-		"max_length":      6,
+		"max_length":      20,
 	})
 
 	if w.Code != http.StatusOK {
@@ -283,4 +410,2492 @@ func TestOpenAISpeech_LongText_AutoCombine_Stream_WAV_OK(t *testing.T) {
 	if atomic.LoadInt32(calls) != 2 {
 		t.Fatalf("expected upstream calls=2, got %d", atomic.LoadInt32(calls))
 	}
-}
\ No newline at end of file
+}
+
+func TestOpenAISpeech_LongText_Trailers(t *testing.T) {
+	ch1 := []byte("chunk1-")
+	ch2 := []byte("chunk2")
+
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"This is chunk one.": {body: ch1},
+		"This is chunk two.": {body: ch2},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":           "This is chunk one. This is chunk two.",
+		"voice":           "alloy",
+		"response_format": "mp3",
+		"auto_combine":    true,
+		"max_length":      20,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	resp := w.Result()
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+
+	if got := resp.Trailer.Get("X-Chunks-Completed"); got != "2" {
+		t.Fatalf("unexpected X-Chunks-Completed trailer: %q", got)
+	}
+	if got := resp.Trailer.Get("X-Total-Bytes"); got != strconv.Itoa(len(ch1)+len(ch2)) {
+		t.Fatalf("unexpected X-Total-Bytes trailer: %q", got)
+	}
+}
+
+func TestOpenAISpeech_LongText_ChunkIndexTrailer(t *testing.T) {
+	chunks := [][]byte{[]byte("chunk1-"), []byte("chunk2--"), []byte("chunk3")}
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"This is chunk one.":   {body: chunks[0], delay: 60 * time.Millisecond},
+		"This is chunk two.":   {body: chunks[1]},
+		"This is chunk three.": {body: chunks[2], delay: 30 * time.Millisecond},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":           "This is chunk one. This is chunk two. This is chunk three.",
+		"voice":           "alloy",
+		"response_format": "mp3",
+		"auto_combine":    true,
+		"max_length":      20,
+		"chunk_index":     true,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	resp := w.Result()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+
+	var index []chunkIndexEntry
+	if err := json.Unmarshal([]byte(resp.Trailer.Get("X-Chunk-Index")), &index); err != nil {
+		t.Fatalf("decode X-Chunk-Index trailer %q: %v", resp.Trailer.Get("X-Chunk-Index"), err)
+	}
+	if len(index) != len(chunks) {
+		t.Fatalf("expected one index entry per chunk, got %+v", index)
+	}
+	var offset int64
+	for i, entry := range index {
+		if entry.Index != i || entry.Offset != offset || entry.Bytes != int64(len(chunks[i])) {
+			t.Fatalf("entry %d: unexpected %+v (want offset %d, bytes %d)", i, entry, offset, len(chunks[i]))
+		}
+		if i > 0 && (entry.Offset <= index[i-1].Offset || entry.EstimatedStartSeconds <= index[i-1].EstimatedStartSeconds) {
+			t.Fatalf("entry %d: offsets and start times must increase, got %+v", i, index)
+		}
+		if got := body[entry.Offset : entry.Offset+entry.Bytes]; !bytes.Equal(got, chunks[i]) {
+			t.Fatalf("entry %d: offset points at %q, want %q", i, got, chunks[i])
+		}
+		offset += entry.Bytes
+	}
+	if offset != int64(len(body)) {
+		t.Fatalf("index covers %d bytes, body has %d", offset, len(body))
+	}
+
+	// 未请求时不附带索引
+	w = doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":        "This is chunk one. This is chunk two. This is chunk three.",
+		"voice":        "alloy",
+		"auto_combine": true,
+		"max_length":   20,
+	})
+	resp = w.Result()
+	defer resp.Body.Close()
+	_, _ = io.ReadAll(resp.Body)
+	if got := resp.Trailer.Get("X-Chunk-Index"); got != "" {
+		t.Fatalf("expected no chunk index without chunk_index, got %q", got)
+	}
+}
+
+func TestOpenAISpeech_AudioChecksumTrailer(t *testing.T) {
+	audio := bytes.Repeat([]byte("ID3-audio-"), 100)
+	ch1 := []byte("chunk1-")
+	ch2 := []byte("chunk2")
+
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello":              {body: audio},
+		"This is chunk one.": {body: ch1},
+		"This is chunk two.": {body: ch2},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.AudioChecksum = true
+	})
+
+	cases := map[string]map[string]any{
+		"short": {"input": "hello", "voice": "alloy", "response_format": "mp3"},
+		"long": {
+			"input":           "This is chunk one. This is chunk two.",
+			"voice":           "alloy",
+			"response_format": "mp3",
+			"auto_combine":    true,
+			"max_length":      20,
+		},
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := doJSONPost(t, engine, "/v1/audio/speech", body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+			}
+
+			resp := w.Result()
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+
+			sum := sha256.Sum256(got)
+			if trailer := resp.Trailer.Get("X-Audio-SHA256"); trailer != hex.EncodeToString(sum[:]) {
+				t.Fatalf("unexpected X-Audio-SHA256 trailer: %q", trailer)
+			}
+		})
+	}
+}
+
+func TestOpenAISpeech_AudioChecksumDisabled(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello": {body: []byte("ID3-audio")},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input": "hello", "voice": "alloy", "response_format": "mp3",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := w.Result().Trailer.Get("X-Audio-SHA256"); got != "" {
+		t.Fatalf("expected no checksum trailer, got %q", got)
+	}
+}
+
+func TestOpenAISpeech_BufferedSmallResponse(t *testing.T) {
+	small := makeWAV(bytes.Repeat([]byte{0, 0}, 24000), 24000, 1, 16)
+	large := bytes.Repeat([]byte("ID3-large-"), 6000)
+
+	upstream, _ := newUpstreamTTS(t, "audio/wav", map[string]upstreamCase{
+		"small": {body: small},
+		"large": {body: large},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.BufferResponseMaxBytes = len(small)
+	})
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input": "small", "voice": "alloy", "response_format": "wav",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if !bytes.Equal(w.Body.Bytes(), small) {
+		t.Fatalf("buffered body mismatch: got %d bytes", w.Body.Len())
+	}
+	size := strconv.Itoa(len(small))
+	if got := w.Header().Get("Content-Length"); got != size {
+		t.Fatalf("unexpected Content-Length: %q", got)
+	}
+	if got := w.Header().Get("X-Audio-Size"); got != size {
+		t.Fatalf("unexpected X-Audio-Size: %q", got)
+	}
+	if got := w.Header().Get("X-Audio-Duration"); got != "1.000" {
+		t.Fatalf("unexpected X-Audio-Duration: %q", got)
+	}
+	if got := w.Header().Get("X-Audio-Duration-Seconds"); got != "1.000" {
+		t.Fatalf("unexpected X-Audio-Duration-Seconds: %q", got)
+	}
+	if got := w.Header().Get("X-Audio-Duration-Estimated"); got != "false" {
+		t.Fatalf("buffered duration should be exact, got X-Audio-Duration-Estimated %q", got)
+	}
+	if got := w.Header().Get("Transfer-Encoding"); got != "" {
+		t.Fatalf("buffered response should not be chunked, got %q", got)
+	}
+
+	w = doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input": "large", "voice": "alloy", "response_format": "wav",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if !bytes.Equal(w.Body.Bytes(), large) {
+		t.Fatalf("streamed body mismatch: got %d bytes", w.Body.Len())
+	}
+	if got := w.Header().Get("X-Audio-Size"); got != "" {
+		t.Fatalf("streamed response should not carry X-Audio-Size, got %q", got)
+	}
+	if got := w.Header().Get("Transfer-Encoding"); got != "chunked" {
+		t.Fatalf("large response should stay chunked, got %q", got)
+	}
+}
+
+func parseDeltaEvents(t *testing.T, body []byte, sse bool) ([][]byte, map[string]any) {
+	t.Helper()
+
+	var deltas [][]byte
+	var done map[string]any
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if sse {
+			if !bytes.HasPrefix(line, []byte("data: ")) {
+				t.Fatalf("unexpected sse line: %q", line)
+			}
+			line = bytes.TrimPrefix(line, []byte("data: "))
+		}
+
+		var ev map[string]any
+		if err := json.Unmarshal(line, &ev); err != nil {
+			t.Fatalf("unmarshal event: %v (%q)", err, line)
+		}
+		switch ev["type"] {
+		case "speech.audio.delta":
+			raw, err := base64.StdEncoding.DecodeString(ev["audio"].(string))
+			if err != nil {
+				t.Fatalf("delta is not independently decodable: %v", err)
+			}
+			deltas = append(deltas, raw)
+		case "speech.audio.done":
+			done = ev
+		default:
+			t.Fatalf("unexpected event type: %v", ev["type"])
+		}
+	}
+	return deltas, done
+}
+
+func TestOpenAISpeech_StreamFormatSSE_DefaultChunkSize(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xAB, 0xCD, 0xEF}, 7000) // 21000 bytes
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello": {body: audio},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":         "hello",
+		"voice":         "alloy",
+		"stream_format": "sse",
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("unexpected content-type: %s", got)
+	}
+
+	deltas, done := parseDeltaEvents(t, w.Body.Bytes(), true)
+	wantSizes := []int{8192, 8192, 21000 - 2*8192}
+	if len(deltas) != len(wantSizes) {
+		t.Fatalf("expected %d deltas, got %d", len(wantSizes), len(deltas))
+	}
+	for i, d := range deltas {
+		if len(d) != wantSizes[i] {
+			t.Fatalf("delta %d: expected %d bytes, got %d", i, wantSizes[i], len(d))
+		}
+	}
+	if !bytes.Equal(bytes.Join(deltas, nil), audio) {
+		t.Fatalf("decoded deltas do not match upstream audio")
+	}
+	if done == nil || done["deltas"].(float64) != 3 || done["bytes"].(float64) != 21000 {
+		t.Fatalf("unexpected done event: %v", done)
+	}
+}
+
+func TestOpenAISpeech_StreamFormatNDJSON_CustomChunkSize(t *testing.T) {
+	audio := []byte("0123456789abcdefghij") // 20 bytes
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello": {body: audio},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":             "hello",
+		"voice":             "alloy",
+		"stream_format":     "ndjson",
+		"stream_chunk_size": 7,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Stream-Chunk-Size"); got != "7" {
+		t.Fatalf("unexpected X-Stream-Chunk-Size: %s", got)
+	}
+
+	deltas, done := parseDeltaEvents(t, w.Body.Bytes(), false)
+	wantSizes := []int{7, 7, 6}
+	if len(deltas) != len(wantSizes) {
+		t.Fatalf("expected %d deltas, got %d", len(wantSizes), len(deltas))
+	}
+	for i, d := range deltas {
+		if len(d) != wantSizes[i] {
+			t.Fatalf("delta %d: expected %d bytes, got %d", i, wantSizes[i], len(d))
+		}
+	}
+	if !bytes.Equal(bytes.Join(deltas, nil), audio) {
+		t.Fatalf("decoded deltas do not match upstream audio")
+	}
+	if done == nil {
+		t.Fatalf("missing done event")
+	}
+}
+
+func TestOpenAISpeech_FormatDowngradeWarning(t *testing.T) {
+	wav := makeWAV([]byte{1, 2, 3, 4}, 8000, 1, 16)
+	upstream, _ := newUpstreamTTS(t, "audio/wav", map[string]upstreamCase{
+		"hello": {body: wav},
+	})
+	defer upstream.Close()
+
+	request := map[string]any{
+		"input":           "hello",
+		"voice":           "alloy",
+		"response_format": "opus",
+		"stream_format":   "ndjson",
+	}
+
+	enabled := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.FormatDowngradeWarnings = true
+	})
+	w := doJSONPost(t, enabled, "/v1/audio/speech", request)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	deltas, done := parseDeltaEvents(t, w.Body.Bytes(), false)
+	if !bytes.Equal(bytes.Join(deltas, nil), wav) {
+		t.Fatalf("expected the downgraded wav audio to still stream")
+	}
+	if done == nil || done["format"] != "wav" {
+		t.Fatalf("unexpected done event: %v", done)
+	}
+	warnings, _ := done["warnings"].([]any)
+	if len(warnings) != 1 {
+		t.Fatalf("expected one warning, got %v", done["warnings"])
+	}
+	warning := warnings[0].(map[string]any)
+	if warning["code"] != "format_downgraded" || !strings.Contains(warning["message"].(string), "opus") {
+		t.Fatalf("unexpected warning: %v", warning)
+	}
+
+	// 默认关闭；格式一致时即使开启也不附带
+	w = doJSONPost(t, newTestEngine(t, upstream.URL), "/v1/audio/speech", request)
+	if _, done := parseDeltaEvents(t, w.Body.Bytes(), false); done == nil || done["warnings"] != nil {
+		t.Fatalf("expected no warnings when disabled, got %v", done)
+	}
+	request["response_format"] = "wav"
+	w = doJSONPost(t, enabled, "/v1/audio/speech", request)
+	if _, done := parseDeltaEvents(t, w.Body.Bytes(), false); done == nil || done["warnings"] != nil {
+		t.Fatalf("expected no warnings without a downgrade, got %v", done)
+	}
+}
+
+func TestOpenAISpeech_InvalidStreamFormat(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1") // 不会被调用
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":         "hello",
+		"stream_format": "websocket",
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d body=%s", w.Code, w.Body.String())
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"invalid_stream_format"`)) {
+		t.Fatalf("expected invalid_stream_format error, got body=%s", w.Body.String())
+	}
+}
+
+func TestOpenAISpeech_LongText_AutoCombine_Stream_OPUS_OK(t *testing.T) {
+	opus1 := makeTestOggOpus(0x01, []byte("p1"))
+	opus2 := makeTestOggOpus(0x02, []byte("p2"))
+
+	upstream, _ := newUpstreamTTS(t, "audio/opus", map[string]upstreamCase{
+		"This is chunk one.": {body: opus1, delay: 80 * time.Millisecond},
+		"This is chunk two.": {body: opus2},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":           "This is chunk one. This is chunk two.",
+		"voice":           "alloy",
+		"response_format": "opus",
+		"auto_combine":    true,
+		"max_length":      20,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := bytes.Count(w.Body.Bytes(), []byte("OpusHead")); got != 1 {
+		t.Fatalf("expected exactly one OpusHead, got %d", got)
+	}
+	if got := bytes.Count(w.Body.Bytes(), []byte("OggS")); got != 4 {
+		t.Fatalf("expected 4 ogg pages, got %d", got)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte("p1")) || !bytes.Contains(w.Body.Bytes(), []byte("p2")) {
+		t.Fatalf("missing audio packets in output")
+	}
+}
+
+// makeTestOggOpus 构造 OpusHead/OpusTags/单个音频页的最小 Ogg Opus（CRC 不校验，客户端会重算）
+func makeTestOggOpus(serial uint32, packet []byte) []byte {
+	page := func(headerType byte, granule uint64, seq uint32, body []byte) []byte {
+		p := make([]byte, 27, 28+len(body))
+		copy(p[0:4], "OggS")
+		p[5] = headerType
+		binary.LittleEndian.PutUint64(p[6:14], granule)
+		binary.LittleEndian.PutUint32(p[14:18], serial)
+		binary.LittleEndian.PutUint32(p[18:22], seq)
+		p[26] = 1
+		p = append(p, byte(len(body)))
+		return append(p, body...)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(page(0x02, 0, 0, []byte("OpusHead\x01\x01\x38\x01\x80\xbb\x00\x00\x00\x00\x00")))
+	buf.Write(page(0x00, 0, 1, []byte("OpusTags\x00\x00\x00\x00\x00\x00\x00\x00")))
+	buf.Write(page(0x04, 960, 2, packet))
+	return buf.Bytes()
+}
+
+func TestGetModels_OpenAIListShape(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1") // 不会被调用
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	var body struct {
+		Object string           `json:"object"`
+		Data   []map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if body.Object != "list" {
+		t.Fatalf("unexpected object: %q", body.Object)
+	}
+	if len(body.Data) != len(ttsfm.SupportedModels) {
+		t.Fatalf("expected %d models, got %d", len(ttsfm.SupportedModels), len(body.Data))
+	}
+
+	seen := map[string]bool{}
+	for _, m := range body.Data {
+		// openai-python 的 Model 要求 id/created/object/owned_by 四个字段
+		if _, ok := m["id"].(string); !ok {
+			t.Fatalf("model id must be a string: %v", m)
+		}
+		if m["object"] != "model" {
+			t.Fatalf("model object must be \"model\": %v", m)
+		}
+		if _, ok := m["created"].(float64); !ok {
+			t.Fatalf("model created must be an integer: %v", m)
+		}
+		if _, ok := m["owned_by"].(string); !ok {
+			t.Fatalf("model owned_by must be a string: %v", m)
+		}
+		seen[m["id"].(string)] = true
+	}
+	for _, id := range []string{"gpt-4o-mini-tts", "tts-1", "tts-1-hd"} {
+		if !seen[id] {
+			t.Fatalf("missing model %q", id)
+		}
+	}
+}
+
+func TestGetModel_ByID(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1") // 不会被调用
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models/tts-1", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"id":"tts-1"`)) {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/models/whisper-1", nil)
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d body=%s", w.Code, w.Body.String())
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"model_not_found"`)) {
+		t.Fatalf("expected model_not_found error, got body=%s", w.Body.String())
+	}
+}
+
+func TestAudioTranscriptions_NotImplemented(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1") // 不会被调用
+
+	for _, path := range []string{"/v1/audio/transcriptions", "/v1/audio/translations"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("file=x"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotImplemented {
+			t.Fatalf("%s: expected 501, got %d body=%s", path, w.Code, w.Body.String())
+		}
+		var resp ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: expected JSON error body, got %q", path, w.Body.String())
+		}
+		if resp.Error.Code != "not_implemented" || !strings.Contains(resp.Error.Message, "text-to-speech only") {
+			t.Fatalf("%s: unexpected error %+v", path, resp.Error)
+		}
+	}
+}
+
+func TestSpeechPlan(t *testing.T) {
+	upstream, calls := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	w := doJSONPost(t, engine, "/v1/audio/speech/plan", map[string]any{
+		"input":        "This is chunk one. This is <b>chunk</b> two.",
+		"voice":        "alloy",
+		"max_length":   20,
+		"auto_combine": true,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	var plan SpeechPlanResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("decode plan: %v body=%s", err, w.Body.String())
+	}
+	if plan.ChunkCount != 2 || len(plan.Chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %+v", plan)
+	}
+	if plan.Chunks[1].Text != "This is chunk two." || plan.Chunks[1].Characters != 18 {
+		t.Fatalf("unexpected sanitized chunk: %+v", plan.Chunks[1])
+	}
+	if plan.Chunks[0].EstimatedDuration <= 0 || plan.EstimatedDuration <= plan.Chunks[0].EstimatedDuration {
+		t.Fatalf("unexpected duration estimates: %+v", plan)
+	}
+	if plan.ResponseFormat != "mp3" {
+		t.Fatalf("expected default response_format mp3, got %q", plan.ResponseFormat)
+	}
+	if got := atomic.LoadInt32(calls); got != 0 {
+		t.Fatalf("expected no upstream calls, got %d", got)
+	}
+}
+
+func TestSpeechPlan_ShortTextIsSingleChunk(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1") // 不会被调用
+
+	w := doJSONPost(t, engine, "/v1/audio/speech/plan", map[string]any{
+		"input": "hello there",
+		"voice": "alloy",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var plan SpeechPlanResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("decode plan: %v", err)
+	}
+	if plan.ChunkCount != 1 || plan.Chunks[0].Text != "hello there" || plan.MaxLength != 2048 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestSpeechPlan_RejectsLikeSpeech(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1") // 不会被调用
+
+	cases := map[string]struct {
+		body map[string]any
+		code string
+	}{
+		"invalid voice":  {body: map[string]any{"input": "hello", "voice": "nope"}, code: "invalid_voice"},
+		"invalid format": {body: map[string]any{"input": "hello", "response_format": "midi"}, code: "invalid_format"},
+		"too long": {
+			body: map[string]any{"input": "This is chunk one. This is chunk two.", "max_length": 20, "auto_combine": false},
+			code: "text_too_long",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := doJSONPost(t, engine, "/v1/audio/speech/plan", tc.body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d body=%s", w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), `"`+tc.code+`"`) {
+				t.Fatalf("expected %s error, got body=%s", tc.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSpeechPlan_CountsCharactersNotBytes(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1") // 不会被调用
+
+	// 30 个汉字（90 字节）不超过 max_length=30，不应被拒绝或分段
+	input := strings.Repeat("你好", 15)
+	w := doJSONPost(t, engine, "/v1/audio/speech/plan", map[string]any{
+		"input": input, "voice": "alloy", "max_length": 30, "auto_combine": false,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var plan SpeechPlanResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("decode plan: %v", err)
+	}
+	if plan.ChunkCount != 1 || plan.TextLength != 30 {
+		t.Fatalf("expected a single 30-character chunk, got %+v", plan)
+	}
+
+	w = doJSONPost(t, engine, "/v1/audio/speech/plan", map[string]any{
+		"input": input + "好", "voice": "alloy", "max_length": 30, "auto_combine": false,
+	})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "(31 characters)") {
+		t.Fatalf("expected 31 characters to be rejected, got %d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestOpenAISpeech_LongTextStreamConcurrency(t *testing.T) {
+	var active, peak int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, "bad multipart", http.StatusBadRequest)
+			return
+		}
+		n := atomic.AddInt32(&active, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(40 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte(r.FormValue("input")))
+	}))
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+	input := "Chunk one is here. Chunk two is here. Chunk three here. Chunk four is here. Chunk five is here."
+
+	for _, tc := range []struct {
+		concurrency int
+		wantPeak    func(int32) bool
+	}{
+		{concurrency: 1, wantPeak: func(p int32) bool { return p == 1 }},
+		{concurrency: 4, wantPeak: func(p int32) bool { return p > 1 }},
+	} {
+		atomic.StoreInt32(&peak, 0)
+		w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+			"input":              input,
+			"voice":              "alloy",
+			"max_length":         20,
+			"stream_concurrency": tc.concurrency,
+			"chunk_buffer_size":  4096,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("concurrency %d: expected 200, got %d body=%s", tc.concurrency, w.Code, w.Body.String())
+		}
+		if got := atomic.LoadInt32(&peak); !tc.wantPeak(got) {
+			t.Fatalf("concurrency %d: unexpected peak upstream concurrency %d", tc.concurrency, got)
+		}
+	}
+}
+
+func TestOpenAISpeech_InvalidLongTextStreamParams(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1") // 不会被调用
+
+	cases := map[string]struct {
+		field string
+		value int
+		code  string
+	}{
+		"negative concurrency":  {field: "stream_concurrency", value: -1, code: "invalid_stream_concurrency"},
+		"concurrency above cap": {field: "stream_concurrency", value: maxLongTextConcurrency + 1, code: "invalid_stream_concurrency"},
+		"buffer too small":      {field: "chunk_buffer_size", value: 10, code: "invalid_chunk_buffer_size"},
+		"buffer above cap":      {field: "chunk_buffer_size", value: maxLongTextChunkBufferSize + 1, code: "invalid_chunk_buffer_size"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+				"input":  "hello",
+				tc.field: tc.value,
+			})
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d body=%s", w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), `"`+tc.code+`"`) {
+				t.Fatalf("expected %s error, got body=%s", tc.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLongTextStreamConfig_Defaults(t *testing.T) {
+	cases := []struct {
+		name            string
+		server, request [2]int
+		want            [2]int
+	}{
+		{name: "built-in defaults", want: [2]int{defaultLongTextConcurrency, defaultLongTextChunkBufferSize}},
+		{name: "server defaults", server: [2]int{6, 8192}, want: [2]int{6, 8192}},
+		{name: "request lowers server", server: [2]int{6, 8192}, request: [2]int{2, 2048}, want: [2]int{2, 2048}},
+		{name: "request capped by server", server: [2]int{2, 4096}, request: [2]int{8, 65536}, want: [2]int{2, 4096}},
+		{name: "request capped by built-in defaults", request: [2]int{maxLongTextConcurrency, maxLongTextChunkBufferSize}, want: [2]int{defaultLongTextConcurrency, defaultLongTextChunkBufferSize}},
+		{name: "server values clamped", server: [2]int{100, 10}, want: [2]int{maxLongTextConcurrency, minLongTextChunkBufferSize}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{longTextConcurrency: tc.server[0], longTextChunkBufferSize: tc.server[1]}
+			cfg := h.longTextStreamConfig(&SpeechRequest{StreamConcurrency: tc.request[0], ChunkBufferSize: tc.request[1]})
+			if got := [2]int{cfg.MaxConcurrent, cfg.ChunkBufferSize}; got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestOpenAISpeech_InvalidSpeed(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1") // 不会被调用
+
+	for _, speed := range []float64{10.0, 5.0, -1.0, 0.1, 4.01} {
+		w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+			"input": "hello",
+			"voice": "alloy",
+			"speed": speed,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("speed=%v: expected 400, got %d body=%s", speed, w.Code, w.Body.String())
+		}
+		if !bytes.Contains(w.Body.Bytes(), []byte(`"invalid_speed"`)) {
+			t.Fatalf("speed=%v: expected invalid_speed error, got body=%s", speed, w.Body.String())
+		}
+		if !bytes.Contains(w.Body.Bytes(), []byte("0.25")) || !bytes.Contains(w.Body.Bytes(), []byte("4")) {
+			t.Fatalf("speed=%v: expected range in message, got body=%s", speed, w.Body.String())
+		}
+	}
+}
+
+func TestOpenAISpeech_ZeroSpeedIsUnset(t *testing.T) {
+	type sentSpeed struct {
+		value   string
+		present bool
+	}
+	speeds := make(chan sentSpeed, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, "bad multipart", http.StatusBadRequest)
+			return
+		}
+		values, present := r.MultipartForm.Value["speed"]
+		sent := sentSpeed{present: present}
+		if present {
+			sent.value = values[0]
+		}
+		speeds <- sent
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("ID3audio"))
+	}))
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	// speed=0（未设置）与 speed=1.0（默认语速）都不应发送给上游
+	cases := []struct {
+		speed float64
+		want  sentSpeed
+	}{
+		{speed: 0},
+		{speed: 1.0},
+		{speed: 0.25, want: sentSpeed{value: "0.25", present: true}},
+		{speed: 4.0, want: sentSpeed{value: "4", present: true}},
+	}
+	for _, tc := range cases {
+		w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+			"input": "hello",
+			"voice": "alloy",
+			"speed": tc.speed,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("speed=%v: expected 200, got %d body=%s", tc.speed, w.Code, w.Body.String())
+		}
+		if got := <-speeds; got != tc.want {
+			t.Fatalf("speed=%v: expected upstream speed %+v, got %+v", tc.speed, tc.want, got)
+		}
+	}
+}
+
+type sseEvent struct {
+	name string
+	data map[string]any
+}
+
+func parseSSEEvents(t *testing.T, body []byte) []sseEvent {
+	t.Helper()
+
+	var events []sseEvent
+	for _, block := range bytes.Split(body, []byte("\n\n")) {
+		if len(bytes.TrimSpace(block)) == 0 {
+			continue
+		}
+		var ev sseEvent
+		for _, line := range bytes.Split(block, []byte("\n")) {
+			switch {
+			case bytes.HasPrefix(line, []byte("event: ")):
+				ev.name = string(bytes.TrimPrefix(line, []byte("event: ")))
+			case bytes.HasPrefix(line, []byte("data: ")):
+				if err := json.Unmarshal(bytes.TrimPrefix(line, []byte("data: ")), &ev.data); err != nil {
+					t.Fatalf("unmarshal sse data: %v", err)
+				}
+			}
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestOpenAISpeech_AcceptEventStream_ChunkProgress(t *testing.T) {
+	ch1 := []byte("chunk1-")
+	ch2 := []byte("chunk2")
+
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"This is chunk one.": {body: ch1, delay: 50 * time.Millisecond},
+		"This is chunk two.": {body: ch2},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	raw, _ := json.Marshal(map[string]any{
+		"input":      "This is chunk one. This is chunk two.",
+		"voice":      "alloy",
+		"max_length": 20,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("unexpected content-type: %s", got)
+	}
+
+	events := parseSSEEvents(t, w.Body.Bytes())
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %s", len(events), w.Body.String())
+	}
+
+	var audio []byte
+	for i, ev := range events[:2] {
+		if ev.name != "chunk" {
+			t.Fatalf("event %d: expected chunk, got %q", i, ev.name)
+		}
+		if int(ev.data["index"].(float64)) != i || int(ev.data["total"].(float64)) != 2 {
+			t.Fatalf("event %d: unexpected progress %v", i, ev.data)
+		}
+		data, err := base64.StdEncoding.DecodeString(ev.data["audio"].(string))
+		if err != nil {
+			t.Fatalf("event %d: decode audio: %v", i, err)
+		}
+		if int(ev.data["bytes"].(float64)) != len(data) {
+			t.Fatalf("event %d: bytes mismatch", i)
+		}
+		audio = append(audio, data...)
+	}
+	if !bytes.Equal(audio, append(append([]byte{}, ch1...), ch2...)) {
+		t.Fatalf("unexpected combined audio: %q", audio)
+	}
+
+	done := events[2]
+	if done.name != "done" || int(done.data["total"].(float64)) != 2 || int(done.data["bytes"].(float64)) != len(audio) {
+		t.Fatalf("unexpected done event: %+v", done)
+	}
+}
+
+func TestOpenAISpeech_AcceptEventStream_FollowsCombineDecision(t *testing.T) {
+	input := "This is chunk one. This is chunk two."
+	upstream, calls := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		input:                {body: []byte("whole")},
+		"This is chunk one.": {body: []byte("chunk1-")},
+		"This is chunk two.": {body: []byte("chunk2")},
+	})
+	defer upstream.Close()
+
+	post := func(engine *gin.Engine) []sseEvent {
+		t.Helper()
+		raw, _ := json.Marshal(map[string]any{"input": input, "voice": "alloy", "max_length": 20, "chunk_index": true})
+		req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "text/event-stream")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+		}
+		return parseSSEEvents(t, w.Body.Bytes())
+	}
+
+	// 阈值以内：即使超过 max_length 也只请求一次上游，只有一个 chunk 事件
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.AutoCombineThreshold = 100
+	})
+	events := post(engine)
+	if len(events) != 2 || events[0].name != "chunk" || int(events[0].data["total"].(float64)) != 1 {
+		t.Fatalf("expected a single chunk event, got %+v", events)
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Fatalf("expected 1 upstream call below the threshold, got %d", n)
+	}
+
+	// 超过阈值时分片，chunk_index 在每个 chunk 事件中附带字节偏移
+	events = post(newTestEngine(t, upstream.URL))
+	if len(events) != 3 {
+		t.Fatalf("expected 2 chunk events and done, got %+v", events)
+	}
+	for i, want := range []float64{0, float64(len("chunk1-"))} {
+		if got, ok := events[i].data["offset"].(float64); !ok || got != want {
+			t.Fatalf("chunk %d: expected offset %v, got %v", i, want, events[i].data["offset"])
+		}
+	}
+}
+
+func TestOpenAISpeech_NoAcceptEventStream_BinaryUnchanged(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello": {body: []byte("audio")},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": "hello"})
+	if w.Code != http.StatusOK || w.Body.String() != "audio" {
+		t.Fatalf("unexpected response: %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestOpenAISpeech_AllowedVoices(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello": {body: []byte("audio")},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.AllowedVoices = []ttsfm.Voice{ttsfm.VoiceNova, ttsfm.VoiceEcho}
+	})
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": "hello", "voice": "nova"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("allowed voice: expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	// alloy 是有效语音，但不在允许列表内
+	w = doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": "hello", "voice": "alloy"})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("disallowed voice: expected 400, got %d body=%s", w.Code, w.Body.String())
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"invalid_voice"`)) {
+		t.Fatalf("expected invalid_voice error, got body=%s", w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/voices", nil)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+
+	var body struct {
+		Voices []struct {
+			ID string `json:"id"`
+		} `json:"voices"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(body.Voices) != 2 {
+		t.Fatalf("expected 2 voices, got %v", body.Voices)
+	}
+	for _, v := range body.Voices {
+		if v.ID != "nova" && v.ID != "echo" {
+			t.Fatalf("unexpected voice listed: %s", v.ID)
+		}
+	}
+}
+
+func TestMetrics_SpeechRequestsAndErrors(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello": {body: []byte("audio")},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.EnableMetrics = true
+	})
+
+	if w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": "hello", "voice": "nova", "response_format": "mp3"}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	// stub 上游对未知输入返回 400 → ValidationException
+	if w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": "unknown", "voice": "nova"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 from /metrics, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`ttsfm_speech_requests_total{format="mp3",voice="nova"} 2`,
+		`ttsfm_errors_total{type="ValidationException"} 1`,
+		`ttsfm_request_duration_seconds_count{method="POST",route="/v1/audio/speech",status="2xx"} 1`,
+		`ttsfm_requests_in_flight 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics output missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetrics_DisabledByDefault(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1")
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 when metrics are disabled, got %d", w.Code)
+	}
+}
+
+func TestErrorMapping_WrappedExceptions(t *testing.T) {
+	cases := []struct {
+		err    error
+		typ    string
+		status int
+	}{
+		{ttsfm.NewValidationException("bad", "input", ""), "ValidationException", http.StatusBadRequest},
+		{fmt.Errorf("chunk 2: %w", ttsfm.NewValidationException("bad", "input", "")), "ValidationException", http.StatusBadRequest},
+		{fmt.Errorf("chunk 1: %w", ttsfm.NewRateLimitException("slow down", 1)), "RateLimitException", http.StatusTooManyRequests},
+		{fmt.Errorf("chunk 1: %w", ttsfm.NewAuthenticationException("denied")), "AuthenticationException", http.StatusUnauthorized},
+		{fmt.Errorf("chunk 3: %w", ttsfm.NewNetworkException("down", 2)), "NetworkException", http.StatusServiceUnavailable},
+		{fmt.Errorf("chunk 0: %w", ttsfm.NewAPIException("upstream", http.StatusBadGateway)), "APIException", http.StatusBadGateway},
+		{fmt.Errorf("plain"), "Unknown", http.StatusInternalServerError},
+	}
+
+	h := NewHandler(DefaultServerConfig())
+	for _, tc := range cases {
+		if got := exceptionType(tc.err); got != tc.typ {
+			t.Errorf("exceptionType(%v) = %s, want %s", tc.err, got, tc.typ)
+		}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/audio/speech", nil)
+		h.handleError(c, tc.err)
+		if w.Code != tc.status {
+			t.Errorf("handleError(%v) status = %d, want %d", tc.err, w.Code, tc.status)
+		}
+	}
+}
+
+func TestOpenAISpeech_ExtraBodyReachesUpstreamForm(t *testing.T) {
+	forms := make(chan map[string]string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, "bad multipart", http.StatusBadRequest)
+			return
+		}
+		form := map[string]string{}
+		for k, v := range r.MultipartForm.Value {
+			form[k] = v[0]
+		}
+		forms <- form
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input": "Hello there.",
+		"voice": "alloy",
+		"extra_body": map[string]any{
+			"style":  "whisper",
+			"pitch":  1.5,
+			"strict": true,
+			"voice":  "nova",
+			"ignore": nil,
+			// 本次未设置的内置字段同样不能通过 extra_body 注入
+			"speed":       9,
+			"seed":        7,
+			"Language":    "xx",
+			"sample_rate": 1,
+			"bitrate":     1,
+			"quality":     "ultra",
+		},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	form := <-forms
+	if form["style"] != "whisper" || form["pitch"] != "1.5" || form["strict"] != "true" {
+		t.Fatalf("extra fields missing from upstream form: %v", form)
+	}
+	if form["voice"] != "alloy" {
+		t.Fatalf("extra_body must not override built-in voice, got %q", form["voice"])
+	}
+	if _, ok := form["ignore"]; ok {
+		t.Fatalf("null extra value should not reach the form: %v", form)
+	}
+	for _, key := range []string{"speed", "seed", "language", "Language", "sample_rate", "bitrate", "quality"} {
+		if v, ok := form[key]; ok {
+			t.Fatalf("extra_body must not inject reserved field %s=%q", key, v)
+		}
+	}
+}
+
+func TestOpenAISpeech_ExtraBodyRejectsNestedValues(t *testing.T) {
+	engine := newTestEngine(t, "http://127.0.0.1:1") // 不会被调用
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":      "Hello there.",
+		"voice":      "alloy",
+		"extra_body": map[string]any{"nested": map[string]any{"a": 1}},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d body=%s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "invalid_extra_body") {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+}
+
+func TestOpenAISpeech_ShortText_JSONErrorWith200(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"Hello there.": {body: []byte(`{"error":{"message":"quota exceeded"}}`)},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input": "Hello there.",
+		"voice": "alloy",
+	})
+
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("expected JSON error body, got %q", w.Body.String())
+	}
+	if resp.Error.Code != "tts_error" {
+		t.Fatalf("unexpected error code: %+v", resp.Error)
+	}
+}
+
+func TestOpenAISpeech_ShortText_SniffKeepsFullBody(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x00}, 64)
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"Hello there.": {body: audio},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.StreamSniffSize = 16
+	})
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input": "Hello there.",
+		"voice": "alloy",
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if !bytes.Equal(w.Body.Bytes(), audio) {
+		t.Fatalf("expected sniffed bytes to be replayed, got %d bytes", w.Body.Len())
+	}
+}
+
+func TestMetrics_UpstreamLatencyRetriesAndBytes(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 第一次返回 503 触发一次重试
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio-bytes"))
+	}))
+	defer upstream.Close()
+
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.EnableMetrics = true
+		cfg.RequestTimeout = 10 * time.Second
+		cfg.TTSClientOptions = append(cfg.TTSClientOptions, ttsfm.WithMaxRetries(1), ttsfm.WithTimeout(10*time.Second))
+	})
+
+	if w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": "hello", "voice": "nova"}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`ttsfm_http_requests_total{code="200",method="POST",route="/v1/audio/speech"} 1`,
+		`ttsfm_upstream_duration_seconds_count{outcome="success"} 1`,
+		`ttsfm_upstream_retries_total 1`,
+		`ttsfm_audio_bytes_streamed_total{format="mp3"} 11`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics output missing %q:\n%s", want, body)
+		}
+	}
+}
+
+// startTestServer 在随机端口上启动完整的 Server（包括优雅关闭逻辑）
+func startTestServer(t *testing.T, upstreamURL string, configure func(cfg *ServerConfig)) (*Server, string) {
+	t.Helper()
+
+	cfg := DefaultServerConfig()
+	cfg.EnableCORS = false
+	cfg.TTSClientOptions = []ttsfm.ClientOption{
+		ttsfm.WithBaseURL(upstreamURL),
+		ttsfm.WithTimeout(10 * time.Second),
+		ttsfm.WithMaxRetries(0),
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv.httpServer = &http.Server{Handler: srv.engine}
+	go func() { _ = srv.httpServer.Serve(ln) }()
+
+	return srv, "http://" + ln.Addr().String()
+}
+
+func waitForActive(t *testing.T, h *Handler, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&h.active) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d active requests, got %d", want, atomic.LoadInt64(&h.active))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerStop_DrainsInFlightRequests(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello": {body: []byte("audio"), delay: 300 * time.Millisecond},
+	})
+	defer upstream.Close()
+
+	srv, base := startTestServer(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.DrainTimeout = 5 * time.Second
+	})
+
+	type result struct {
+		code int
+		body string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Post(base+"/v1/audio/speech", "application/json", strings.NewReader(`{"input":"hello","voice":"alloy"}`))
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		done <- result{code: resp.StatusCode, body: string(body), err: err}
+	}()
+
+	waitForActive(t, srv.handler, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Stop(ctx); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	r := <-done
+	if r.err != nil || r.code != http.StatusOK || r.body != "audio" {
+		t.Fatalf("expected in-flight request to complete, got code=%d body=%q err=%v", r.code, r.body, r.err)
+	}
+}
+
+func TestServerStop_AbortsAfterDrainTimeout(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello": {body: []byte("audio"), delay: 3 * time.Second},
+	})
+	defer upstream.Close()
+
+	srv, base := startTestServer(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.DrainTimeout = 100 * time.Millisecond
+	})
+
+	go func() {
+		resp, err := http.Post(base+"/v1/audio/speech", "application/json", strings.NewReader(`{"input":"hello","voice":"alloy"}`))
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+
+	waitForActive(t, srv.handler, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Stop(ctx); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected stuck upstream call to be aborted after the drain timeout, stop took %v", elapsed)
+	}
+	waitForActive(t, srv.handler, 0)
+}
+
+func TestOpenAISpeech_LongTextJobLimit(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"This is chunk one.": {body: []byte("chunk1-"), delay: 300 * time.Millisecond},
+		"This is chunk two.": {body: []byte("chunk2"), delay: 300 * time.Millisecond},
+		"hello":              {body: []byte("short")},
+	})
+	defer upstream.Close()
+
+	srv, base := startTestServer(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.AutoCombine = true
+		cfg.MaxConcurrentLongTextJobs = 1
+	})
+	defer func() { _ = srv.Stop(context.Background()) }()
+
+	longBody := `{"input":"This is chunk one. This is chunk two.","voice":"alloy","max_length":20}`
+	post := func(body string) (*http.Response, error) {
+		return http.Post(base+"/v1/audio/speech", "application/json", strings.NewReader(body))
+	}
+
+	done := make(chan int, 1)
+	go func() {
+		resp, err := post(longBody)
+		if err != nil {
+			done <- 0
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv.handler.longTextJobs) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("first long-text job never acquired a slot")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	resp, err := post(longBody)
+	if err != nil {
+		t.Fatalf("second request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while long-text slots are saturated, got %d body=%s", resp.StatusCode, body)
+	}
+	if !strings.Contains(string(body), "server_busy") || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected server_busy with Retry-After, got headers=%v body=%s", resp.Header, body)
+	}
+
+	// 短文本不占用长文本名额
+	resp, err = post(`{"input":"hello","voice":"alloy"}`)
+	if err != nil {
+		t.Fatalf("short request: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected short text to bypass the long-text limit, got %d", resp.StatusCode)
+	}
+
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("expected first long-text job to succeed, got %d", code)
+	}
+	if n := len(srv.handler.longTextJobs); n != 0 {
+		t.Fatalf("expected slot to be released, %d still held", n)
+	}
+}
+
+func TestOpenAISpeech_LongTextJobQueueing(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"This is chunk one.": {body: []byte("chunk1-"), delay: 100 * time.Millisecond},
+		"This is chunk two.": {body: []byte("chunk2"), delay: 100 * time.Millisecond},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.MaxConcurrentLongTextJobs = 1
+		cfg.LongTextJobQueueTimeout = 2 * time.Second
+	})
+
+	const n = 3
+	codes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+				"input":      "This is chunk one. This is chunk two.",
+				"voice":      "alloy",
+				"max_length": 20,
+			})
+			codes <- w.Code
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Fatalf("expected queued long-text jobs to succeed, got %d", code)
+		}
+	}
+}
+
+func TestOpenAISpeech_AutoCombineThreshold(t *testing.T) {
+	const input = "This is chunk one. This is chunk two."
+
+	upstream, calls := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		input:                {body: []byte("whole")},
+		"This is chunk one.": {body: []byte("chunk1-")},
+		"This is chunk two.": {body: []byte("chunk2")},
+	})
+	defer upstream.Close()
+
+	cases := []struct {
+		name        string
+		threshold   int
+		autoCombine bool
+		wantCode    int
+		wantBody    string
+		wantCalls   int32
+	}{
+		{name: "between max_length and threshold is single-shot", threshold: 100, autoCombine: true, wantCode: http.StatusOK, wantBody: "whole", wantCalls: 1},
+		{name: "single-shot does not require auto_combine", threshold: 100, autoCombine: false, wantCode: http.StatusOK, wantBody: "whole", wantCalls: 1},
+		{name: "above threshold combines with max_length chunks", threshold: 30, autoCombine: true, wantCode: http.StatusOK, wantBody: "chunk1-chunk2", wantCalls: 2},
+		{name: "above threshold without auto_combine is rejected", threshold: 30, autoCombine: false, wantCode: http.StatusBadRequest, wantCalls: 0},
+		{name: "threshold below max_length falls back to max_length", threshold: 5, autoCombine: true, wantCode: http.StatusOK, wantBody: "chunk1-chunk2", wantCalls: 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			atomic.StoreInt32(calls, 0)
+			engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+				cfg.AutoCombineThreshold = tc.threshold
+			})
+
+			w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+				"input":        input,
+				"voice":        "alloy",
+				"max_length":   20,
+				"auto_combine": tc.autoCombine,
+			})
+			if w.Code != tc.wantCode {
+				t.Fatalf("expected %d, got %d body=%s", tc.wantCode, w.Code, w.Body.String())
+			}
+			if tc.wantCode == http.StatusOK && w.Body.String() != tc.wantBody {
+				t.Fatalf("expected body %q, got %q", tc.wantBody, w.Body.String())
+			}
+			if got := atomic.LoadInt32(calls); got != tc.wantCalls {
+				t.Fatalf("expected %d upstream calls, got %d", tc.wantCalls, got)
+			}
+		})
+	}
+}
+
+func TestOpenAISpeech_LanguageHint(t *testing.T) {
+	forms := make(chan map[string]string, 2)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, "bad multipart", http.StatusBadRequest)
+			return
+		}
+		form := map[string]string{}
+		for k, v := range r.MultipartForm.Value {
+			form[k] = v[0]
+		}
+		forms <- form
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":    "你好",
+		"voice":    "alloy",
+		"language": "zh-CN",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if form := <-forms; form["language"] != "zh-CN" {
+		t.Fatalf("expected language=zh-CN in upstream form, got %v", form)
+	}
+
+	w = doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": "Hello", "voice": "alloy"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if form := <-forms; form["language"] != "" {
+		t.Fatalf("expected no language field when unset, got %q", form["language"])
+	}
+
+	for _, lang := range []string{"chinese", "zh_CN", "ZH-cn", "zh-CN-x"} {
+		w = doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": "Hello", "voice": "alloy", "language": lang})
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"invalid_language"`) {
+			t.Fatalf("language=%q: expected 400 invalid_language, got %d body=%s", lang, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestOpenAISpeech_LengthCheckUsesSanitizedText(t *testing.T) {
+	upstream, calls := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"Hello there.": {body: []byte("hello")},
+		"你好世界，今天天气很好。": {body: []byte("nihao")},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		// 原始 47 字节，去掉标签后只有 12 个字符
+		{name: "html markup", input: `<div class="x"><span>Hello there.</span></div>`, want: "hello"},
+		// 12 个字符但 36 字节
+		{name: "multibyte", input: "你好世界，今天天气很好。", want: "nihao"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+				"input":        tc.input,
+				"voice":        "alloy",
+				"auto_combine": false,
+				"max_length":   20,
+			})
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+			}
+			if w.Body.String() != tc.want {
+				t.Fatalf("expected body %q, got %q", tc.want, w.Body.String())
+			}
+		})
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Fatalf("expected single-shot requests only, got %d upstream calls", got)
+	}
+}
+
+func TestOpenAISpeech_MaxAudioDuration(t *testing.T) {
+	// 默认 150 词/分钟，含 10% 余量：10 个词约 4.4 秒，20 个词约 8.8 秒
+	short := "one two three four five six seven eight nine ten"
+	long := short + " " + short
+
+	upstream, calls := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		short: {body: []byte("short")},
+		long:  {body: []byte("long")},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.MaxAudioDuration = 5 * time.Second
+	})
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": short, "voice": "alloy"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected input under the cap to succeed, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": long, "voice": "alloy"})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"audio_too_long"`) {
+		t.Fatalf("expected 400 audio_too_long, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("rejected request must not reach upstream, got %d calls", got)
+	}
+
+	// 倍速播放缩短估算时长
+	w = doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": long, "voice": "alloy", "speed": 2.0})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected faster speech to fit under the cap, got %d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestHealthCheck_Deep(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	engine := newTestEngine(t, upstream.URL)
+
+	get := func(path string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON: %v body=%s", err, w.Body.String())
+		}
+		return w.Code, body
+	}
+
+	code, body := get("/health")
+	if code != http.StatusOK || body["upstream"] != nil {
+		t.Fatalf("shallow check should not probe upstream, got %d %v", code, body)
+	}
+
+	code, body = get("/health?deep=1")
+	if code != http.StatusOK || body["upstream"].(map[string]any)["reachable"] != true {
+		t.Fatalf("expected reachable upstream, got %d %v", code, body)
+	}
+
+	upstream.Close()
+	code, body = get("/health?deep=1")
+	if code != http.StatusServiceUnavailable || body["status"] != "degraded" {
+		t.Fatalf("expected 503 degraded, got %d %v", code, body)
+	}
+	if up := body["upstream"].(map[string]any); up["reachable"] != false || up["error"] == "" {
+		t.Fatalf("expected unreachable upstream with error, got %v", up)
+	}
+}
+
+func TestServerStop_TracksSelfTestAndRejectsNewWork(t *testing.T) {
+	mp3 := append([]byte("ID3"), make([]byte, 16)...)
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		selfTestPhrase + "*": {body: mp3, delay: 300 * time.Millisecond},
+	})
+	defer upstream.Close()
+
+	srv, base := startTestServer(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.DrainTimeout = 5 * time.Second
+		cfg.AdminAPIKey = "admin"
+	})
+	adminHeaders := map[string]string{"X-Admin-Key": "admin"}
+
+	// 进行中的自检同样计入排空等待
+	selfTest := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, base+"/admin/selftest", nil)
+		req.Header.Set("X-Admin-Key", "admin")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			selfTest <- 0
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		selfTest <- resp.StatusCode
+	}()
+	waitForActive(t, srv.handler, 1)
+
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		stopped <- srv.Stop(ctx)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&srv.handler.draining) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("server never entered the drain phase")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 排空开始后新的上游请求直接被拒绝，不再登记到 inflight
+	w := doJSONPost(t, srv.Engine(), "/v1/audio/speech", map[string]any{"input": "hello", "voice": "alloy"})
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "server_shutting_down") {
+		t.Fatalf("expected 503 server_shutting_down while draining, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doGet(srv.Engine(), "/admin/selftest", adminHeaders); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected self test to be rejected while draining, got %d", w.Code)
+	}
+
+	if code := <-selfTest; code != http.StatusOK {
+		t.Fatalf("expected in-flight self test to complete, got %d", code)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+}
+
+func TestLivezReadyz(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello": {body: []byte("audio"), delay: 500 * time.Millisecond},
+	})
+	defer upstream.Close()
+
+	srv, base := startTestServer(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.DrainTimeout = 5 * time.Second
+	})
+
+	get := func(path string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		srv.Engine().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON: %v body=%s", err, w.Body.String())
+		}
+		return w.Code, body
+	}
+
+	if code, body := get("/livez"); code != http.StatusOK {
+		t.Fatalf("expected /livez 200, got %d %v", code, body)
+	}
+	if code, body := get("/readyz"); code != http.StatusOK || body["status"] != "ready" {
+		t.Fatalf("expected /readyz 200 ready, got %d %v", code, body)
+	}
+
+	// 保持一个进行中的请求，使 Stop 停留在排空阶段
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Post(base+"/v1/audio/speech", "application/json", strings.NewReader(`{"input":"hello","voice":"alloy"}`))
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	waitForActive(t, srv.handler, 1)
+
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		stopped <- srv.Stop(ctx)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&srv.handler.draining) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("server never entered the drain phase")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if code, body := get("/readyz"); code != http.StatusServiceUnavailable || body["status"] != "draining" {
+		t.Fatalf("expected /readyz 503 draining, got %d %v", code, body)
+	}
+	if code, body := get("/livez"); code != http.StatusOK {
+		t.Fatalf("expected /livez to stay 200 while draining, got %d %v", code, body)
+	}
+
+	<-done
+	if err := <-stopped; err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+}
+
+func TestReadyz_UpstreamUnreachable(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	engine := newTestEngine(t, upstream.URL)
+	upstream.Close()
+
+	w := doGet(engine, "/readyz", nil)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"unavailable"`) {
+		t.Fatalf("expected /readyz 503 when upstream is unreachable, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doGet(engine, "/livez", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected /livez 200 regardless of upstream, got %d", w.Code)
+	}
+}
+
+func TestOpenAISpeech_PCMWrapWAV(t *testing.T) {
+	pcm := []byte{0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04, 0x00}
+	upstream, _ := newUpstreamTTS(t, "audio/pcm", map[string]upstreamCase{
+		"hello": {body: pcm},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+	body := map[string]any{"input": "hello", "voice": "alloy", "response_format": "pcm"}
+
+	w := doJSONPost(t, engine, "/v1/audio/speech?wrap=wav", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "audio/wav" {
+		t.Fatalf("expected audio/wav, got %q", got)
+	}
+	if got := w.Header().Get("X-Audio-Format"); got != "wav" {
+		t.Fatalf("expected X-Audio-Format wav, got %q", got)
+	}
+
+	wav := w.Body.Bytes()
+	if len(wav) != 44+len(pcm) || string(wav[0:4]) != "RIFF" || string(wav[8:12]) != "WAVE" {
+		t.Fatalf("expected a 44-byte WAV header followed by PCM, got %d bytes %q", len(wav), wav[:min(12, len(wav))])
+	}
+	if got := binary.LittleEndian.Uint32(wav[24:28]); got != 24000 {
+		t.Fatalf("expected 24kHz sample rate, got %d", got)
+	}
+	if got := binary.LittleEndian.Uint32(wav[40:44]); got != uint32(len(pcm)) {
+		t.Fatalf("expected data size %d, got %d", len(pcm), got)
+	}
+	if !bytes.Equal(wav[44:], pcm) {
+		t.Fatalf("PCM payload altered: %v", wav[44:])
+	}
+
+	// 不带 wrap 时原样输出裸 PCM
+	w = doJSONPost(t, engine, "/v1/audio/speech", body)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), pcm) {
+		t.Fatalf("expected raw PCM without wrap, got %d %v", w.Code, w.Body.Bytes())
+	}
+
+	// wrap 只支持 pcm
+	w = doJSONPost(t, engine, "/v1/audio/speech?wrap=wav", map[string]any{"input": "hello", "voice": "alloy", "response_format": "mp3"})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"invalid_wrap"`) {
+		t.Fatalf("expected 400 invalid_wrap, got %d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestOpenAISpeech_LongText_PCMWrapWAVStreams(t *testing.T) {
+	chunks := [][]byte{{0x01, 0x00, 0x02, 0x00}, {0x03, 0x00, 0x04, 0x00}}
+	upstream, _ := newUpstreamTTS(t, "audio/pcm", map[string]upstreamCase{
+		"This is chunk one.": {body: chunks[0]},
+		"This is chunk two.": {body: chunks[1]},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+	w := doJSONPost(t, engine, "/v1/audio/speech?wrap=wav", map[string]any{
+		"input":           "This is chunk one. This is chunk two.",
+		"voice":           "alloy",
+		"response_format": "pcm",
+		"auto_combine":    true,
+		"max_length":      20,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	// 长文本不预先读入全部 PCM：WAV 头的长度字段为未知（0xFFFFFFFF），PCM 原样跟在后面
+	wav := w.Body.Bytes()
+	if len(wav) < 44 || string(wav[0:4]) != "RIFF" || string(wav[36:40]) != "data" {
+		t.Fatalf("expected a WAV header, got %q", wav[:min(44, len(wav))])
+	}
+	if riff, data := binary.LittleEndian.Uint32(wav[4:8]), binary.LittleEndian.Uint32(wav[40:44]); riff != 0xFFFFFFFF || data != 0xFFFFFFFF {
+		t.Fatalf("expected unknown RIFF/data sizes for a streamed WAV, got %d/%d", riff, data)
+	}
+	if want := bytes.Join(chunks, nil); !bytes.Equal(wav[44:], want) {
+		t.Fatalf("expected PCM %v after the header, got %v", want, wav[44:])
+	}
+}
+
+func TestOpenAISpeech_AcceptEventStream_PCMWrapWAV(t *testing.T) {
+	chunks := [][]byte{{0x01, 0x00, 0x02, 0x00}, {0x03, 0x00, 0x04, 0x00}}
+	upstream, _ := newUpstreamTTS(t, "audio/pcm", map[string]upstreamCase{
+		"This is chunk one.": {body: chunks[0]},
+		"This is chunk two.": {body: chunks[1]},
+	})
+	defer upstream.Close()
+
+	raw, _ := json.Marshal(map[string]any{
+		"input":           "This is chunk one. This is chunk two.",
+		"voice":           "alloy",
+		"response_format": "pcm",
+		"max_length":      20,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech?wrap=wav", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	newTestEngine(t, upstream.URL).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Audio-Format"); got != "wav" {
+		t.Fatalf("expected X-Audio-Format=wav, got %q", got)
+	}
+
+	events := parseSSEEvents(t, w.Body.Bytes())
+	if len(events) != 3 {
+		t.Fatalf("expected 2 chunk events and done, got %s", w.Body.String())
+	}
+	var audio []byte
+	for _, ev := range events[:2] {
+		data, err := base64.StdEncoding.DecodeString(ev.data["audio"].(string))
+		if err != nil {
+			t.Fatalf("decode audio: %v", err)
+		}
+		audio = append(audio, data...)
+	}
+	if len(audio) < 44 || string(audio[0:4]) != "RIFF" || binary.LittleEndian.Uint32(audio[40:44]) != 0xFFFFFFFF {
+		t.Fatalf("expected the first chunk to start with a streaming WAV header, got %v", audio)
+	}
+	if want := bytes.Join(chunks, nil); !bytes.Equal(audio[44:], want) {
+		t.Fatalf("expected PCM %v after the header, got %v", want, audio[44:])
+	}
+	if done := events[2]; done.data["format"] != "wav" || int(done.data["bytes"].(float64)) != len(audio) {
+		t.Fatalf("unexpected done event: %+v", done.data)
+	}
+}
+
+func TestOpenAISpeech_InputArray(t *testing.T) {
+	upstream, calls := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"First part. Second part.": {body: []byte("joined")},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+
+	for _, input := range []any{[]string{}, []string{"", "   ", "\n"}} {
+		w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": input, "voice": "alloy"})
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"missing_input"`) {
+			t.Fatalf("input=%q: expected 400 missing_input, got %d body=%s", input, w.Code, w.Body.String())
+		}
+	}
+	if got := atomic.LoadInt32(calls); got != 0 {
+		t.Fatalf("empty input must not reach upstream, got %d calls", got)
+	}
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input": []string{"First part.", "  ", "", " Second part. "},
+		"voice": "alloy",
+	})
+	if w.Code != http.StatusOK || w.Body.String() != "joined" {
+		t.Fatalf("expected non-empty segments to be joined, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": []any{"ok", 1}, "voice": "alloy"})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"invalid_json"`) {
+		t.Fatalf("expected non-string elements to be rejected, got %d body=%s", w.Code, w.Body.String())
+	}
+}
+
+// debugRecorder 记录实际输出的 Debug 日志（client 日志可能来自其他 goroutine）
+type debugRecorder struct {
+	mu     sync.Mutex
+	debugs []string
+}
+
+func (r *debugRecorder) Info(msg string, args ...interface{})  {}
+func (r *debugRecorder) Warn(msg string, args ...interface{})  {}
+func (r *debugRecorder) Error(msg string, args ...interface{}) {}
+func (r *debugRecorder) Debug(msg string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.debugs = append(r.debugs, fmt.Sprintf(msg, args...))
+}
+
+func (r *debugRecorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	debugs := r.debugs
+	r.debugs = nil
+	return debugs
+}
+
+func TestOpenAISpeech_LogLevelHeader(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/wav", map[string]upstreamCase{
+		"hello": {body: makeWAV([]byte{1, 2, 3, 4}, 8000, 1, 16)},
+	})
+	defer upstream.Close()
+
+	newEngine := func(allow bool) (*gin.Engine, *debugRecorder) {
+		rec := &debugRecorder{}
+		logger := ttsfm.NewLevelLogger(rec, ttsfm.LevelInfo)
+		engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+			cfg.AllowLogLevelHeader = allow
+			cfg.Logger = logger
+			cfg.TTSClientOptions = append(cfg.TTSClientOptions, ttsfm.WithLogger(logger))
+		})
+		return engine, rec
+	}
+	post := func(engine *gin.Engine, level string) {
+		t.Helper()
+		raw, _ := json.Marshal(map[string]any{"input": "hello", "voice": "alloy", "response_format": "opus"})
+		req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		if level != "" {
+			req.Header.Set("X-Log-Level", level)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+		}
+	}
+
+	engine, rec := newEngine(true)
+	post(engine, "")
+	if debugs := rec.take(); len(debugs) != 0 {
+		t.Fatalf("expected no debug logs without the header, got %q", debugs)
+	}
+
+	post(engine, "debug")
+	debugs := strings.Join(rec.take(), "\n")
+	if !strings.Contains(debugs, "Speech request:") {
+		t.Fatalf("expected handler debug log, got %q", debugs)
+	}
+	if !strings.Contains(debugs, "returning WAV format") {
+		t.Fatalf("expected client debug log, got %q", debugs)
+	}
+
+	post(engine, "")
+	if debugs := rec.take(); len(debugs) != 0 {
+		t.Fatalf("expected the override to stay scoped to one request, got %q", debugs)
+	}
+
+	// 未开启配置时忽略该请求头
+	engine, rec = newEngine(false)
+	post(engine, "debug")
+	if debugs := rec.take(); len(debugs) != 0 {
+		t.Fatalf("expected header to be ignored when not allowed, got %q", debugs)
+	}
+}
+
+func TestOpenAISpeech_LogLevelHeaderRequiresAuth(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/wav", map[string]upstreamCase{
+		"hello": {body: makeWAV([]byte{1, 2, 3, 4}, 8000, 1, 16)},
+	})
+	defer upstream.Close()
+
+	// 访问日志为 Info 级别，日志器配置为 Warn：只有请求头生效时才会输出
+	var buf bytes.Buffer
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.AllowLogLevelHeader = true
+		cfg.EnableAPIKeyAuth = true
+		cfg.APIKeys = []string{"secret"}
+		cfg.Logger = ttsfm.NewJSONLogger(&buf, ttsfm.LevelWarn)
+	})
+	post := func(apiKey string) int {
+		raw, _ := json.Marshal(map[string]any{"input": "hello", "voice": "alloy", "response_format": "wav"})
+		req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Log-Level", "info")
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := post(""); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without API key, got %d", code)
+	}
+	if strings.Contains(buf.String(), `"level":"info"`) {
+		t.Fatalf("expected the header to be ignored for an unauthenticated request, got %q", buf.String())
+	}
+
+	if code := post("secret"); code != http.StatusOK {
+		t.Fatalf("expected 200 with API key, got %d", code)
+	}
+	if !strings.Contains(buf.String(), `"level":"info"`) {
+		t.Fatalf("expected info logs for an authenticated request, got %q", buf.String())
+	}
+}
+
+func TestOpenAISpeech_MaxConcurrentIsServerWide(t *testing.T) {
+	var inflight, peak, calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+		// 在写出响应头之前结束计数：客户端收到响应头即释放并发槽位
+		atomic.AddInt32(&inflight, -1)
+
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ID3audio"))
+	}))
+	defer upstream.Close()
+
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		cfg.TTSClientOptions = append(cfg.TTSClientOptions, ttsfm.WithMaxConcurrent(1))
+	})
+
+	const requests = 5
+	codes := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{"input": "hello", "voice": "alloy"})
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != requests {
+		t.Fatalf("expected %d upstream calls, got %d", requests, got)
+	}
+	if got := atomic.LoadInt32(&peak); got != 1 {
+		t.Fatalf("expected at most one in-flight upstream synthesis, saw %d", got)
+	}
+}
+
+func TestOpenAISpeech_StrictFormat(t *testing.T) {
+	upstream, _ := newUpstreamTTS(t, "audio/wav", map[string]upstreamCase{
+		"hello": {body: makeWAV([]byte{1, 2, 3, 4}, 8000, 1, 16)},
+	})
+	defer upstream.Close()
+	engine := newTestEngine(t, upstream.URL)
+
+	request := map[string]any{"input": "hello", "voice": "alloy", "response_format": "opus"}
+	w := doJSONPost(t, engine, "/v1/audio/speech", request)
+	if w.Code != http.StatusOK || w.Header().Get("X-Audio-Format") != "wav" {
+		t.Fatalf("expected lenient wav fallback, got %d format=%q", w.Code, w.Header().Get("X-Audio-Format"))
+	}
+
+	request["strict_format"] = true
+	w = doJSONPost(t, engine, "/v1/audio/speech", request)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 in strict mode, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Error.Code != "validation_error" || !strings.Contains(resp.Error.Message, "opus") {
+		t.Fatalf("unexpected error: %+v", resp.Error)
+	}
+}
+
+func TestOpenAISpeech_SeedForwarded(t *testing.T) {
+	seeds := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, "bad multipart", http.StatusBadRequest)
+			return
+		}
+		seeds <- r.FormValue("seed")
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("ID3audio"))
+	}))
+	defer upstream.Close()
+
+	w := doJSONPost(t, newTestEngine(t, upstream.URL), "/v1/audio/speech", map[string]any{
+		"input": "hello", "voice": "alloy", "seed": 1234,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := <-seeds; got != "1234" {
+		t.Fatalf("expected seed 1234 upstream, got %q", got)
+	}
+}
+
+func TestAdminSelfTest(t *testing.T) {
+	mp3 := append([]byte("ID3"), make([]byte, 64)...)
+	upstream, calls := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		selfTestPhrase + "*": {body: mp3},
+	})
+	defer upstream.Close()
+
+	adminHeaders := map[string]string{"X-Admin-Key": "admin"}
+	withAdminKey := func(cfg *ServerConfig) { cfg.AdminAPIKey = "admin" }
+
+	// 开启响应缓存时每次自检仍会调用上游
+	engine := newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		withAdminKey(cfg)
+		cfg.TTSClientOptions = append(cfg.TTSClientOptions, ttsfm.WithCache(10, time.Minute))
+	})
+	w := doGet(engine, "/admin/selftest", adminHeaders)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Fatalf("expected JSON report, got content-type %q", got)
+	}
+
+	var report struct {
+		OK      bool   `json:"ok"`
+		TTFBMs  *int64 `json:"ttfb_ms"`
+		TotalMs *int64 `json:"total_ms"`
+		Bytes   int    `json:"bytes"`
+		Format  string `json:"format"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if !report.OK || report.Bytes != len(mp3) || report.Format != "mp3" {
+		t.Fatalf("unexpected report: %s", w.Body.String())
+	}
+	if report.TTFBMs == nil || report.TotalMs == nil || *report.TTFBMs > *report.TotalMs {
+		t.Fatalf("expected ttfb_ms <= total_ms, got %s", w.Body.String())
+	}
+	if w := doGet(engine, "/admin/selftest", adminHeaders); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if atomic.LoadInt32(calls) != 2 {
+		t.Fatalf("expected every self test to reach upstream, got %d calls", atomic.LoadInt32(calls))
+	}
+
+	// 上游返回无效音频时报告失败
+	bad, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		selfTestPhrase + "*": {body: []byte("not audio")},
+	})
+	defer bad.Close()
+	w = doGet(newTestEngineWithConfig(t, bad.URL, withAdminKey), "/admin/selftest", adminHeaders)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"ok":false`) {
+		t.Fatalf("expected 503 with ok=false for invalid audio, got %d %s", w.Code, w.Body.String())
+	}
+
+	// 未配置管理员 key 时接口不存在，key 错误时拒绝
+	if w := doGet(newTestEngine(t, upstream.URL), "/admin/selftest", adminHeaders); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without an admin key configured, got %d", w.Code)
+	}
+	for _, headers := range []map[string]string{nil, {"X-Admin-Key": "wrong"}} {
+		if w := doGet(engine, "/admin/selftest", headers); w.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 for admin headers %v, got %d", headers, w.Code)
+		}
+	}
+
+	// 开启认证时还需要 API key
+	engine = newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		withAdminKey(cfg)
+		cfg.EnableAPIKeyAuth = true
+		cfg.APIKeys = []string{"secret"}
+	})
+	if w := doGet(engine, "/admin/selftest", adminHeaders); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without api key, got %d", w.Code)
+	}
+	if w := doGet(engine, "/admin/selftest", map[string]string{"Authorization": "Bearer secret", "X-Admin-Key": "admin"}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 with api key, got %d %s", w.Code, w.Body.String())
+	}
+
+	// 与合成接口共用合成限流
+	engine = newTestEngineWithConfig(t, upstream.URL, func(cfg *ServerConfig) {
+		withAdminKey(cfg)
+		cfg.EnableRateLimit = true
+		cfg.RateLimitPerSec = 100
+		cfg.SpeechRateLimitPerSec = 1
+		cfg.SpeechRateLimitBurst = 1
+	})
+	if w := doGet(engine, "/admin/selftest", adminHeaders); w.Code != http.StatusOK {
+		t.Fatalf("expected first self test to pass the speech limiter, got %d", w.Code)
+	}
+	if w := doGet(engine, "/admin/selftest", adminHeaders); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected second self test to be rate limited, got %d", w.Code)
+	}
+}
+
+func TestOpenAISpeech_BudgetAppliesToEveryOutputMode(t *testing.T) {
+	chunks := [][]byte{[]byte("chunk1-"), []byte("chunk2--"), []byte("chunk3")}
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"hello":                {body: []byte("short audio")},
+		"This is chunk one.":   {body: chunks[0]},
+		"This is chunk two.":   {body: chunks[1], delay: 30 * time.Millisecond},
+		"This is chunk three.": {body: chunks[2], delay: 200 * time.Millisecond},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+	longInput := "This is chunk one. This is chunk two. This is chunk three."
+	post := func(body map[string]any, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	// 短文本二进制输出同样截断在预算处
+	w := post(map[string]any{"input": "hello", "voice": "alloy", "budget_bytes": 5}, "")
+	if w.Body.String() != "short" || w.Result().Trailer.Get("X-Budget-Exceeded") != "bytes" {
+		t.Fatalf("expected short audio truncated to 5 bytes with trailer, got %q trailer=%q",
+			w.Body.String(), w.Result().Trailer.Get("X-Budget-Exceeded"))
+	}
+
+	// Accept: text/event-stream 的进度事件
+	events := parseSSEEvents(t, post(map[string]any{
+		"input": longInput, "voice": "alloy", "max_length": 20, "budget_bytes": 5,
+	}, "text/event-stream").Body.Bytes())
+	done := events[len(events)-1]
+	if done.name != "done" || done.data["budget_exceeded"] != "bytes" || int(done.data["bytes"].(float64)) != 5 {
+		t.Fatalf("expected done event with bytes budget exceeded after 5 bytes, got %+v", events)
+	}
+	audio, _ := base64.StdEncoding.DecodeString(events[0].data["audio"].(string))
+	if len(events) != 2 || !bytes.Equal(audio, chunks[0][:5]) {
+		t.Fatalf("expected a single truncated chunk event, got %+v", events)
+	}
+
+	// stream_format=sse 的增量事件：字节与时长预算
+	for _, tc := range []struct {
+		budget map[string]any
+		want   string
+		bytes  int
+	}{
+		{budget: map[string]any{"budget_bytes": 5}, want: "bytes", bytes: 5},
+		{budget: map[string]any{"budget_seconds": 0.1}, want: "seconds", bytes: len(chunks[0])},
+	} {
+		body := map[string]any{"input": longInput, "voice": "alloy", "max_length": 20, "stream_format": "sse"}
+		for k, v := range tc.budget {
+			body[k] = v
+		}
+		events := parseSSEEvents(t, post(body, "").Body.Bytes())
+		done := events[len(events)-1]
+		if done.data["type"] != "speech.audio.done" || done.data["budget_exceeded"] != tc.want || int(done.data["bytes"].(float64)) != tc.bytes {
+			t.Fatalf("budget %v: unexpected done event %+v", tc.budget, done.data)
+		}
+	}
+}
+
+func TestOpenAISpeech_LongText_BudgetExceeded(t *testing.T) {
+	chunks := [][]byte{[]byte("chunk1-"), []byte("chunk2--"), []byte("chunk3")}
+	upstream, _ := newUpstreamTTS(t, "audio/mpeg", map[string]upstreamCase{
+		"This is chunk one.":   {body: chunks[0]},
+		"This is chunk two.":   {body: chunks[1], delay: 30 * time.Millisecond},
+		"This is chunk three.": {body: chunks[2], delay: 200 * time.Millisecond},
+	})
+	defer upstream.Close()
+
+	engine := newTestEngine(t, upstream.URL)
+	input := "This is chunk one. This is chunk two. This is chunk three."
+	full := len(chunks[0]) + len(chunks[1]) + len(chunks[2])
+
+	w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":        input,
+		"voice":        "alloy",
+		"auto_combine": true,
+		"max_length":   20,
+		"budget_bytes": 5,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	resp := w.Result()
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(body, chunks[0][:5]) {
+		t.Fatalf("expected the stream to stop at exactly 5 of %d bytes, got %q", full, body)
+	}
+	if got := resp.Trailer.Get("X-Total-Bytes"); got != "5" {
+		t.Fatalf("expected X-Total-Bytes=5, got %q", got)
+	}
+	if got := resp.Trailer.Get("X-Budget-Exceeded"); got != "bytes" {
+		t.Fatalf("expected X-Budget-Exceeded=bytes, got %q", got)
+	}
+	if got := resp.Trailer.Get("X-Chunks-Completed"); got == "3" {
+		t.Fatalf("expected remaining chunks to be cancelled, got X-Chunks-Completed=%s", got)
+	}
+
+	// 预算也可通过请求头设置：估算时长在第一段后即超出
+	raw, _ := json.Marshal(map[string]any{"input": input, "voice": "alloy", "auto_combine": true, "max_length": 20})
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Budget-Seconds", "0.1")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	resp = w.Result()
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	if !bytes.Equal(body, chunks[0]) {
+		t.Fatalf("expected only the first chunk, got %q", body)
+	}
+	if got := resp.Trailer.Get("X-Budget-Exceeded"); got != "seconds" {
+		t.Fatalf("expected X-Budget-Exceeded=seconds, got %q", got)
+	}
+
+	// 未超出预算时 trailer 为空、音频完整
+	w = doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input":        input,
+		"voice":        "alloy",
+		"auto_combine": true,
+		"max_length":   20,
+		"budget_bytes": 1 << 20,
+	})
+	resp = w.Result()
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	if len(body) != full || resp.Trailer.Get("X-Budget-Exceeded") != "" {
+		t.Fatalf("expected full audio without overage, got %d bytes trailer=%q", len(body), resp.Trailer.Get("X-Budget-Exceeded"))
+	}
+
+	if w := doJSONPost(t, engine, "/v1/audio/speech", map[string]any{
+		"input": "hi", "voice": "alloy", "budget_bytes": -1,
+	}); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_budget") {
+		t.Fatalf("expected 400 invalid_budget, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"ttsfm-go/ttsfm"
+)
+
+// Metrics Prometheus 指标（使用独立 registry，避免与宿主进程的全局 registry 冲突）
+type Metrics struct {
+	registry *prometheus.Registry
+
+	speechRequests  *prometheus.CounterVec
+	httpRequests    *prometheus.CounterVec
+	requestLatency  *prometheus.HistogramVec
+	upstreamErrors  *prometheus.CounterVec
+	upstreamLatency *prometheus.HistogramVec
+	upstreamRetries prometheus.Counter
+	bytesStreamed   *prometheus.CounterVec
+	inFlight        prometheus.Gauge
+}
+
+// NewMetrics 创建并注册指标
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		speechRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ttsfm",
+			Name:      "speech_requests_total",
+			Help:      "Total number of speech requests by voice and format.",
+		}, []string{"voice", "format"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ttsfm",
+			Name:      "http_requests_total",
+			Help:      "Total number of HTTP requests by route and status code.",
+		}, []string{"method", "route", "code"}),
+		requestLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "ttsfm",
+			Name:      "request_duration_seconds",
+			Help:      "End-to-end request latency in seconds, including streaming.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, []string{"method", "route", "status"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ttsfm",
+			Name:      "errors_total",
+			Help:      "Total number of failed speech generations by exception type.",
+		}, []string{"type"}),
+		upstreamLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "ttsfm",
+			Name:      "upstream_duration_seconds",
+			Help:      "Upstream synthesis latency until response headers, including retries.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"outcome"}),
+		upstreamRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ttsfm",
+			Name:      "upstream_retries_total",
+			Help:      "Total number of upstream request retries.",
+		}),
+		bytesStreamed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ttsfm",
+			Name:      "audio_bytes_streamed_total",
+			Help:      "Total number of audio bytes written to clients by format.",
+		}, []string{"format"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "ttsfm",
+			Name:      "requests_in_flight",
+			Help:      "Number of requests currently being served.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.speechRequests,
+		m.httpRequests,
+		m.requestLatency,
+		m.upstreamErrors,
+		m.upstreamLatency,
+		m.upstreamRetries,
+		m.bytesStreamed,
+		m.inFlight,
+	)
+	return m
+}
+
+// Middleware 统计进行中的请求数与端到端延迟
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := c.Writer.Status()
+		m.httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(status)).Inc()
+		m.requestLatency.
+			WithLabelValues(c.Request.Method, route, statusLabel(status)).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler 返回 /metrics 处理器
+func (m *Metrics) Handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return gin.WrapH(h)
+}
+
+// observeSpeechRequest 记录一次语音生成请求
+func (m *Metrics) observeSpeechRequest(voice ttsfm.Voice, format ttsfm.AudioFormat) {
+	if m == nil {
+		return
+	}
+	m.speechRequests.WithLabelValues(string(voice), string(format)).Inc()
+}
+
+// observeError 按 errors.go 中的异常类型记录失败
+func (m *Metrics) observeError(err error) {
+	if m == nil {
+		return
+	}
+	m.upstreamErrors.WithLabelValues(exceptionType(err)).Inc()
+}
+
+// observeUpstream 作为 ttsfm.WithUpstreamObserver 回调，记录上游延迟与重试次数
+func (m *Metrics) observeUpstream(stats ttsfm.UpstreamStats) {
+	if m == nil {
+		return
+	}
+	outcome := "success"
+	if stats.Err != nil {
+		outcome = "error"
+	}
+	m.upstreamLatency.WithLabelValues(outcome).Observe(stats.Duration.Seconds())
+	if stats.Attempts > 1 {
+		m.upstreamRetries.Add(float64(stats.Attempts - 1))
+	}
+}
+
+// observeBytes 记录写给客户端的音频字节数
+func (m *Metrics) observeBytes(format ttsfm.AudioFormat, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.bytesStreamed.WithLabelValues(string(format)).Add(float64(n))
+}
+
+// exceptionType 返回与 errors.go 对应的异常类型名
+func exceptionType(err error) string {
+	// 长文本等路径会用 fmt.Errorf("...: %w") 包装异常，需要穿透包装识别；子类型先于其嵌入的父类型判断
+	var (
+		validation *ttsfm.ValidationException
+		auth       *ttsfm.AuthenticationException
+		rateLimit  *ttsfm.RateLimitException
+		network    *ttsfm.NetworkException
+		busy       *ttsfm.BusyException
+		api        *ttsfm.APIException
+		base       *ttsfm.TTSException
+	)
+	switch {
+	case errors.As(err, &validation):
+		return "ValidationException"
+	case errors.As(err, &auth):
+		return "AuthenticationException"
+	case errors.As(err, &rateLimit):
+		return "RateLimitException"
+	case errors.As(err, &network):
+		return "NetworkException"
+	case errors.As(err, &busy):
+		return "BusyException"
+	case errors.As(err, &api):
+		return "APIException"
+	case errors.As(err, &base):
+		return "TTSException"
+	default:
+		return "Unknown"
+	}
+}
+
+func statusLabel(status int) string {
+	switch {
+	case status >= 500:
+		return "5xx"
+	case status >= 400:
+		return "4xx"
+	case status >= 300:
+		return "3xx"
+	default:
+		return "2xx"
+	}
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"crypto/subtle"
+	"math"
 	"net/http"
 	"strings"
 	"sync"
@@ -10,16 +12,41 @@ import (
 	"ttsfm-go/ttsfm"
 )
 
+// ContextKeyAPIKey 认证通过后保存在 gin.Context 中的 API key
+const ContextKeyAPIKey = "ttsfm.api_key"
+
+// ContextKeyAPIKeyName 认证通过后保存在 gin.Context 中的 key 名称（APIKey.Name，可能为空）
+const ContextKeyAPIKeyName = "ttsfm.api_key_name"
+
+// ContextKeyAPIKeyRateLimit 认证的 key 配置了 rate_limit 时保存在 gin.Context 中的每秒请求数
+const ContextKeyAPIKeyRateLimit = "ttsfm.api_key_rate_limit"
+
 // APIKeyConfig API 密钥配置
 type APIKeyConfig struct {
 	Enabled bool
 	Keys    []string
+	// Entries 带元数据的 key（如由 LoadAPIKeys 读取）；Keys 中的 key 以默认元数据并入，同一 key 以 Entries 为准
+	Entries []APIKey
 }
 
 // APIKeyMiddleware API 密钥验证中间件
 func APIKeyMiddleware(config *APIKeyConfig) gin.HandlerFunc {
+	var lookup map[string]APIKey
+	if config != nil {
+		lookup = make(map[string]APIKey, len(config.Keys)+len(config.Entries))
+		for _, entry := range apiKeysFromStrings(config.Keys) {
+			lookup[entry.Key] = entry
+		}
+		for _, entry := range config.Entries {
+			if key := strings.TrimSpace(entry.Key); key != "" {
+				entry.Key = key
+				lookup[key] = entry
+			}
+		}
+	}
+
 	return func(c *gin.Context) {
-		if config == nil || !config.Enabled || len(config.Keys) == 0 {
+		if config == nil || !config.Enabled || len(lookup) == 0 {
 			c.Next()
 			return
 		}
@@ -45,14 +72,7 @@ func APIKeyMiddleware(config *APIKeyConfig) gin.HandlerFunc {
 			return
 		}
 
-		valid := false
-		for _, key := range config.Keys {
-			if apiKey == strings.TrimSpace(key) && apiKey != "" {
-				valid = true
-				break
-			}
-		}
-
+		entry, valid := lookup[apiKey]
 		if !valid {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": gin.H{
@@ -65,6 +85,57 @@ func APIKeyMiddleware(config *APIKeyConfig) gin.HandlerFunc {
 			return
 		}
 
+		if entry.Disabled {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": gin.H{
+					"message": "API key is disabled",
+					"type":    "authentication_error",
+					"code":    "api_key_disabled",
+				},
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set(ContextKeyAPIKey, apiKey)
+		c.Set(ContextKeyAPIKeyName, entry.Name)
+		if entry.RateLimit > 0 {
+			c.Set(ContextKeyAPIKeyRateLimit, entry.RateLimit)
+		}
+		c.Next()
+	}
+}
+
+// AdminKeyMiddleware 管理接口验证中间件：X-Admin-Key 请求头须与 adminKey 一致，否则返回 403
+func AdminKeyMiddleware(adminKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided := strings.TrimSpace(c.GetHeader("X-Admin-Key"))
+		if provided == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(adminKey)) != 1 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": gin.H{
+					"message": "Admin key is required",
+					"type":    "authentication_error",
+					"code":    "invalid_admin_key",
+				},
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequestLogLevelMiddleware 读取请求头 X-Log-Level（debug/info/warn/error），
+// 将该级别写入请求 ctx，使本请求的处理器日志与客户端（含重试）日志按此级别输出；
+// 级别只能比日志器已配置的更详细（见 ttsfm.LevelOverrider），无法解析的值被忽略。
+// 只应在配置允许时注册，并放在 APIKeyMiddleware 之后
+func RequestLogLevelMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value := strings.TrimSpace(c.GetHeader("X-Log-Level")); value != "" {
+			if level, err := ttsfm.ParseLogLevel(value); err == nil {
+				c.Request = c.Request.WithContext(ttsfm.ContextWithLogLevel(c.Request.Context(), level))
+			}
+		}
 		c.Next()
 	}
 }
@@ -86,7 +157,7 @@ func LoggingMiddleware(logger ttsfm.Logger) gin.HandlerFunc {
 		}
 
 		if logger != nil {
-			logger.Info("[%s] %s %s %d %v",
+			ttsfm.LoggerForContext(c.Request.Context(), logger).Info("[%s] %s %s %d %v",
 				c.Request.Method,
 				path,
 				c.ClientIP(),
@@ -102,8 +173,8 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-API-Key")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, X-Audio-Format, X-Audio-Size, X-Chunks-Combined, X-Auto-Combine, X-Powered-By")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-API-Key, X-Log-Level, X-Budget-Bytes, X-Budget-Seconds")
+		c.Header("Access-Control-Expose-Headers", "Content-Length, X-Audio-Format, X-Audio-Size, X-Audio-Duration, X-Audio-Duration-Seconds, X-Audio-Duration-Estimated, X-Chunk-Count, X-Chunks-Combined, X-Auto-Combine, X-Budget-Exceeded, X-Powered-By")
 
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -137,9 +208,14 @@ func RecoveryMiddleware(logger ttsfm.Logger) gin.HandlerFunc {
 	}
 }
 
-// RateLimitMiddleware 简单的速率限制中间件（进程内）
+// RateLimitMiddleware 简单的速率限制中间件（进程内），桶容量等于速率
 func RateLimitMiddleware(requestsPerSecond int) gin.HandlerFunc {
-	limiter := newRateLimiter(requestsPerSecond)
+	return RateLimitMiddlewareWithBurst(requestsPerSecond, 0)
+}
+
+// RateLimitMiddlewareWithBurst 与 RateLimitMiddleware 相同，但可单独指定桶容量；burst <= 0 时等于速率
+func RateLimitMiddlewareWithBurst(requestsPerSecond, burst int) gin.HandlerFunc {
+	limiter := newRateLimiter(requestsPerSecond, burst)
 
 	return func(c *gin.Context) {
 		if !limiter.allow() {
@@ -158,51 +234,264 @@ func RateLimitMiddleware(requestsPerSecond int) gin.HandlerFunc {
 	}
 }
 
+// ConcurrencyPerIPMiddleware 限制单个客户端 IP 同时进行中的请求数；
+// 计数在 handler 返回（包括流式响应写完）后释放。
+func ConcurrencyPerIPMiddleware(maxPerIP int) gin.HandlerFunc {
+	limiter := newConcurrencyLimiter(maxPerIP)
+
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if !limiter.acquire(ip) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": gin.H{
+					"message": "Too many concurrent requests from this client",
+					"type":    "rate_limit_error",
+					"code":    "too_many_concurrent_requests",
+				},
+			})
+			c.Abort()
+			return
+		}
+		defer limiter.release(ip)
+
+		c.Next()
+	}
+}
+
+type concurrencyLimiter struct {
+	mu     sync.Mutex
+	active map[string]int
+	max    int
+}
+
+func newConcurrencyLimiter(max int) *concurrencyLimiter {
+	if max <= 0 {
+		max = 1
+	}
+	return &concurrencyLimiter{
+		active: make(map[string]int),
+		max:    max,
+	}
+}
+
+func (l *concurrencyLimiter) acquire(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[key] >= l.max {
+		return false
+	}
+	l.active[key]++
+	return true
+}
+
+func (l *concurrencyLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[key] <= 1 {
+		delete(l.active, key)
+		return
+	}
+	l.active[key]--
+}
+
+func (l *concurrencyLimiter) inFlight(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active[key]
+}
+
+const defaultRateLimitIdleTTL = 10 * time.Minute
+
+// RateLimitConfig 按 key 限流配置
+type RateLimitConfig struct {
+	// RequestsPerSecond 每个 key 的速率
+	RequestsPerSecond int
+	// Burst 每个 key 的桶容量，<=0 时等于 RequestsPerSecond
+	Burst int
+	// IdleTTL key 超过该时长未访问时回收其令牌桶（默认 10 分钟）
+	IdleTTL time.Duration
+	// EvictionInterval >0 时后台定期回收空闲令牌桶（否则只在访问时顺带回收）
+	EvictionInterval time.Duration
+	// Done 关闭后停止后台回收协程
+	Done <-chan struct{}
+	// IgnoreKeyRateLimits 为 true 时不使用 key 自身的 rate_limit，所有 key 统一按 RequestsPerSecond（用于路由级限流）
+	IgnoreKeyRateLimits bool
+}
+
+// PerKeyRateLimitMiddleware 按认证的 API key 分别限流（未认证时按客户端 IP）。
+// 需要挂在 APIKeyMiddleware 之后才能读取到 key；key 配置了 rate_limit 时以其为该 key 的速率。
+func PerKeyRateLimitMiddleware(config *RateLimitConfig) gin.HandlerFunc {
+	if config == nil {
+		config = &RateLimitConfig{}
+	}
+	limiter := newKeyedRateLimiter(config.RequestsPerSecond, config.Burst, config.IdleTTL)
+	if config.EvictionInterval > 0 {
+		go limiter.runEviction(config.EvictionInterval, config.Done)
+	}
+
+	return func(c *gin.Context) {
+		rate := 0
+		if !config.IgnoreKeyRateLimits {
+			rate = c.GetInt(ContextKeyAPIKeyRateLimit)
+		}
+		if !limiter.allowRate(rateLimitKey(c), rate) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": gin.H{
+					"message": "Too many requests, please slow down",
+					"type":    "rate_limit_error",
+					"code":    "rate_limit_exceeded",
+				},
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimitKey 优先使用认证的 API key，否则回退到客户端 IP
+func rateLimitKey(c *gin.Context) string {
+	if key := c.GetString(ContextKeyAPIKey); key != "" {
+		return "key:" + key
+	}
+	return "ip:" + c.ClientIP()
+}
+
+type keyedRateLimiterEntry struct {
+	limiter  *rateLimiter
+	lastSeen time.Time
+}
+
+// keyedRateLimiter 每个 key 一个令牌桶，按需创建，空闲的桶在访问时顺带回收
+type keyedRateLimiter struct {
+	mu        sync.Mutex
+	limiters  map[string]*keyedRateLimiterEntry
+	rate      int
+	burst     int
+	idleTTL   time.Duration
+	lastSweep time.Time
+}
+
+func newKeyedRateLimiter(requestsPerSecond, burst int, idleTTL time.Duration) *keyedRateLimiter {
+	if idleTTL <= 0 {
+		idleTTL = defaultRateLimitIdleTTL
+	}
+	return &keyedRateLimiter{
+		limiters:  make(map[string]*keyedRateLimiterEntry),
+		rate:      requestsPerSecond,
+		burst:     burst,
+		idleTTL:   idleTTL,
+		lastSweep: time.Now(),
+	}
+}
+
+func (k *keyedRateLimiter) allow(key string) bool {
+	return k.allowRate(key, 0)
+}
+
+// allowRate 与 allow 相同，但 rate>0 时该 key 的桶按 rate 补充（首次创建时确定）
+func (k *keyedRateLimiter) allowRate(key string, rate int) bool {
+	if rate <= 0 {
+		rate = k.rate
+	}
+	now := time.Now()
+
+	k.mu.Lock()
+	if now.Sub(k.lastSweep) >= k.idleTTL {
+		k.sweepLocked(now)
+	}
+	entry, ok := k.limiters[key]
+	if !ok {
+		entry = &keyedRateLimiterEntry{limiter: newRateLimiter(rate, k.burst)}
+		k.limiters[key] = entry
+	}
+	entry.lastSeen = now
+	limiter := entry.limiter
+	k.mu.Unlock()
+
+	return limiter.allow()
+}
+
+// sweepLocked 删除空闲超过 idleTTL 的桶（调用方需持有锁）
+func (k *keyedRateLimiter) sweepLocked(now time.Time) {
+	for key, entry := range k.limiters {
+		if now.Sub(entry.lastSeen) >= k.idleTTL {
+			delete(k.limiters, key)
+		}
+	}
+	k.lastSweep = now
+}
+
+// runEviction 定期回收空闲令牌桶，直到 done 关闭
+func (k *keyedRateLimiter) runEviction(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			k.mu.Lock()
+			k.sweepLocked(now)
+			k.mu.Unlock()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (k *keyedRateLimiter) size() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.limiters)
+}
+
+// rateLimiter 令牌桶：按 refillRate 持续补充（保留小数部分），最多积累 maxTokens 个
 type rateLimiter struct {
 	mu         sync.Mutex
-	tokens     int
-	maxTokens  int
+	tokens     float64
+	maxTokens  float64
 	refillRate int
 	lastRefill time.Time
 }
 
-func newRateLimiter(requestsPerSecond int) *rateLimiter {
+func newRateLimiter(requestsPerSecond, burst int) *rateLimiter {
 	if requestsPerSecond <= 0 {
 		requestsPerSecond = 10
 	}
+	if burst <= 0 {
+		burst = requestsPerSecond
+	}
 	now := time.Now()
 	return &rateLimiter{
-		tokens:     requestsPerSecond,
-		maxTokens:  requestsPerSecond,
+		tokens:     float64(burst),
+		maxTokens:  float64(burst),
 		refillRate: requestsPerSecond,
 		lastRefill: now,
 	}
 }
 
 func (r *rateLimiter) allow() bool {
+	return r.allowAt(time.Now())
+}
+
+// allowAt 以 now 作为当前时间尝试取一个令牌（便于测试以固定节奏驱动）
+func (r *rateLimiter) allowAt(now time.Time) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	now := time.Now()
 	elapsed := now.Sub(r.lastRefill)
-
-	tokensToAdd := int(elapsed.Seconds()) * r.refillRate
-	if tokensToAdd > 0 {
-		r.tokens = minInt(r.maxTokens, r.tokens+tokensToAdd)
+	if elapsed > 0 {
+		r.tokens = math.Min(r.maxTokens, r.tokens+elapsed.Seconds()*float64(r.refillRate))
 		r.lastRefill = now
 	}
 
-	if r.tokens > 0 {
+	if r.tokens >= 1 {
 		r.tokens--
 		return true
 	}
 
 	return false
 }
-
-func minInt(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
\ No newline at end of file
@@ -0,0 +1,618 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"ttsfm-go/ttsfm"
+)
+
+func newMiddlewareTestEngine(middlewares ...gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(middlewares...)
+	engine.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+	return engine
+}
+
+func doGet(r http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestPerKeyRateLimit_IsolatesKeys(t *testing.T) {
+	engine := newMiddlewareTestEngine(
+		APIKeyMiddleware(&APIKeyConfig{Enabled: true, Keys: []string{"key-a", "key-b"}}),
+		PerKeyRateLimitMiddleware(&RateLimitConfig{RequestsPerSecond: 2}),
+	)
+
+	keyA := map[string]string{"Authorization": "Bearer key-a"}
+	keyB := map[string]string{"Authorization": "Bearer key-b"}
+
+	for i := 0; i < 2; i++ {
+		if w := doGet(engine, "/ping", keyA); w.Code != http.StatusOK {
+			t.Fatalf("key-a request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+	if w := doGet(engine, "/ping", keyA); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("key-a: expected 429 after exhausting bucket, got %d", w.Code)
+	}
+
+	// key-b 不受 key-a 影响
+	if w := doGet(engine, "/ping", keyB); w.Code != http.StatusOK {
+		t.Fatalf("key-b: expected 200, got %d", w.Code)
+	}
+}
+
+func TestPerKeyRateLimit_FallsBackToClientIP(t *testing.T) {
+	engine := newMiddlewareTestEngine(PerKeyRateLimitMiddleware(&RateLimitConfig{RequestsPerSecond: 1}))
+
+	ipA := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+	ipB := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.RemoteAddr = "10.0.0.2:1234"
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := ipA(); w.Code != http.StatusOK {
+		t.Fatalf("ip a: expected 200, got %d", w.Code)
+	}
+	if w := ipA(); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("ip a: expected 429, got %d", w.Code)
+	}
+	if w := ipB(); w.Code != http.StatusOK {
+		t.Fatalf("ip b: expected 200, got %d", w.Code)
+	}
+}
+
+func TestKeyedRateLimiter_EvictsIdleBuckets(t *testing.T) {
+	limiter := newKeyedRateLimiter(5, 0, 20*time.Millisecond)
+
+	limiter.allow("a")
+	limiter.allow("b")
+	if got := limiter.size(); got != 2 {
+		t.Fatalf("expected 2 buckets, got %d", got)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	limiter.allow("c")
+
+	if got := limiter.size(); got != 1 {
+		t.Fatalf("expected idle buckets to be evicted, got %d buckets", got)
+	}
+}
+
+func TestConcurrencyPerIP_RejectsExcess(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(ConcurrencyPerIPMiddleware(2))
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	engine.GET("/stream", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = request()
+		}(i)
+	}
+	<-started
+	<-started
+
+	if w := request(); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 for request over the cap, got %d", w.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	for i, w := range results {
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+
+	// 请求结束后计数释放，可以再次进入
+	if w := request(); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 after slots were released, got %d", w.Code)
+	}
+}
+
+func TestConcurrencyLimiter_ReleaseCleansUp(t *testing.T) {
+	l := newConcurrencyLimiter(1)
+	if !l.acquire("a") {
+		t.Fatal("expected first acquire to succeed")
+	}
+	if l.acquire("a") {
+		t.Fatal("expected second acquire to fail")
+	}
+	l.release("a")
+	if got := l.inFlight("a"); got != 0 {
+		t.Fatalf("expected 0 in flight, got %d", got)
+	}
+}
+
+func TestKeyedRateLimiter_PeriodicEviction(t *testing.T) {
+	limiter := newKeyedRateLimiter(5, 0, 10*time.Millisecond)
+	done := make(chan struct{})
+	defer close(done)
+	go limiter.runEviction(5*time.Millisecond, done)
+
+	limiter.allow("a")
+	limiter.allow("b")
+
+	deadline := time.Now().Add(time.Second)
+	for limiter.size() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected idle buckets to be evicted in background, still have %d", limiter.size())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAPIKeyMiddleware_StashesKey(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(APIKeyMiddleware(&APIKeyConfig{Enabled: true, Keys: []string{"secret"}}))
+	engine.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(ContextKeyAPIKey)) })
+
+	w := doGet(engine, "/ping", map[string]string{"X-API-Key": "secret"})
+	if w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Fatalf("expected stashed api key, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return path
+	}
+
+	keys, err := LoadAPIKeys(write("keys.json", `[
+		{"key": " tenant-a ", "name": "Tenant A", "rate_limit": 5},
+		{"key": "tenant-b", "name": "Tenant B", "disabled": true},
+		{"key": "tenant-c", "name": "Tenant C", "enabled": false}
+	]`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := []APIKey{
+		{Key: "tenant-a", Name: "Tenant A", RateLimit: 5},
+		{Key: "tenant-b", Name: "Tenant B", Disabled: true},
+		{Key: "tenant-c", Name: "Tenant C", Disabled: true},
+	}
+	if len(keys) != len(want) || keys[0] != want[0] || keys[1] != want[1] || keys[2] != want[2] {
+		t.Fatalf("expected %+v, got %+v", want, keys)
+	}
+
+	for name, content := range map[string]string{
+		"empty-key.json": `[{"name": "no key"}]`,
+		"dup.json":       `[{"key": "a"}, {"key": "a"}]`,
+		"negative.json":  `[{"key": "a", "rate_limit": -1}]`,
+		"invalid.json":   `{"key": "a"}`,
+	} {
+		if _, err := LoadAPIKeys(write(name, content)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+	if _, err := LoadAPIKeys(filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestAPIKeyMiddleware_EntryMetadata(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(APIKeyMiddleware(&APIKeyConfig{
+		Enabled: true,
+		Keys:    []string{"flat"},
+		Entries: []APIKey{
+			{Key: "tenant-a", Name: "Tenant A", RateLimit: 5},
+			{Key: "tenant-b", Name: "Tenant B", Disabled: true},
+		},
+	}))
+	engine.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, "%s|%d", c.GetString(ContextKeyAPIKeyName), c.GetInt(ContextKeyAPIKeyRateLimit))
+	})
+
+	if w := doGet(engine, "/ping", map[string]string{"X-API-Key": "tenant-a"}); w.Code != http.StatusOK || w.Body.String() != "Tenant A|5" {
+		t.Fatalf("expected tenant metadata, got %d %q", w.Code, w.Body.String())
+	}
+	if w := doGet(engine, "/ping", map[string]string{"X-API-Key": "flat"}); w.Code != http.StatusOK || w.Body.String() != "|0" {
+		t.Fatalf("expected flat key with default metadata, got %d %q", w.Code, w.Body.String())
+	}
+	if w := doGet(engine, "/ping", map[string]string{"X-API-Key": "tenant-b"}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for disabled key, got %d", w.Code)
+	}
+	if w := doGet(engine, "/ping", map[string]string{"X-API-Key": "unknown"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for unknown key, got %d", w.Code)
+	}
+}
+
+func TestServer_RateLimitFromAPIKeyEntries(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.EnableCORS = false
+	cfg.EnableRateLimit = true
+	cfg.RateLimitPerSec = 100
+	cfg.EnableAPIKeyAuth = true
+	cfg.APIKeyEntries = []APIKey{
+		{Key: "slow", Name: "Slow", RateLimit: 1},
+		{Key: "fast", Name: "Fast"},
+	}
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer func() { _ = srv.Stop(context.Background()) }()
+	engine := srv.Engine()
+
+	slow := map[string]string{"Authorization": "Bearer slow"}
+	fast := map[string]string{"Authorization": "Bearer fast"}
+
+	if w := doGet(engine, "/v1/voices", slow); w.Code != http.StatusOK {
+		t.Fatalf("slow: expected 200, got %d", w.Code)
+	}
+	if w := doGet(engine, "/v1/voices", slow); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("slow: expected 429 from its own rate_limit, got %d", w.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if w := doGet(engine, "/v1/voices", fast); w.Code != http.StatusOK {
+			t.Fatalf("fast request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+}
+
+func TestServer_RateLimitPerKey(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.EnableCORS = false
+	cfg.EnableRateLimit = true
+	cfg.RateLimitPerKey = true
+	cfg.RateLimitPerKeyPerSec = 1
+	cfg.EnableAPIKeyAuth = true
+	cfg.APIKeys = []string{"key-a", "key-b"}
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer func() { _ = srv.Stop(context.Background()) }()
+	engine := srv.Engine()
+
+	keyA := map[string]string{"Authorization": "Bearer key-a"}
+	keyB := map[string]string{"Authorization": "Bearer key-b"}
+
+	if w := doGet(engine, "/v1/voices", keyA); w.Code != http.StatusOK {
+		t.Fatalf("key-a: expected 200, got %d", w.Code)
+	}
+	if w := doGet(engine, "/v1/voices", keyA); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("key-a: expected 429, got %d", w.Code)
+	}
+	if w := doGet(engine, "/v1/voices", keyB); w.Code != http.StatusOK {
+		t.Fatalf("key-b: expected 200, got %d", w.Code)
+	}
+	// /health 不在认证分组内，不受按 key 限流影响
+	if w := doGet(engine, "/health", nil); w.Code != http.StatusOK {
+		t.Fatalf("health: expected 200, got %d", w.Code)
+	}
+}
+
+func TestServer_RateLimitPerKeyRequiresOptIn(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.EnableCORS = false
+	cfg.EnableRateLimit = true
+	cfg.RateLimitPerSec = 1
+	cfg.RateLimitPerKeyPerSec = 5
+	cfg.EnableAPIKeyAuth = true
+	cfg.APIKeys = []string{"key-a", "key-b"}
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer func() { _ = srv.Stop(context.Background()) }()
+	engine := srv.Engine()
+
+	// 未开启 RateLimitPerKey 时仍为全局令牌桶，不同 key 共享额度
+	if w := doGet(engine, "/v1/voices", map[string]string{"Authorization": "Bearer key-a"}); w.Code != http.StatusOK {
+		t.Fatalf("key-a: expected 200, got %d", w.Code)
+	}
+	if w := doGet(engine, "/v1/voices", map[string]string{"Authorization": "Bearer key-b"}); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("key-b: expected 429 from the shared limiter, got %d", w.Code)
+	}
+}
+
+func TestServer_SpeechRateLimitIsStricter(t *testing.T) {
+	for _, perKey := range []bool{false, true} {
+		cfg := DefaultServerConfig()
+		cfg.EnableCORS = false
+		cfg.EnableRateLimit = true
+		cfg.RateLimitPerSec = 100
+		cfg.RateLimitPerKey = perKey
+		cfg.SpeechRateLimitPerSec = 1
+
+		srv, err := NewServer(cfg)
+		if err != nil {
+			t.Fatalf("new server: %v", err)
+		}
+		engine := srv.Engine()
+
+		// 请求体缺少 input：通过限流后由处理器返回 400，不会调用上游
+		speech := map[string]any{"voice": "alloy"}
+		if w := doJSONPost(t, engine, "/v1/audio/speech", speech); w.Code != http.StatusBadRequest {
+			t.Fatalf("perKey=%v: first speech request should pass the limiter, got %d", perKey, w.Code)
+		}
+		if w := doJSONPost(t, engine, "/v1/audio/speech", speech); w.Code != http.StatusTooManyRequests {
+			t.Fatalf("perKey=%v: expected speech endpoint to be limited, got %d", perKey, w.Code)
+		}
+		// 兼容入口共用合成接口的令牌桶
+		if w := doJSONPost(t, engine, "/api/speech", speech); w.Code != http.StatusTooManyRequests {
+			t.Fatalf("perKey=%v: expected /api/speech to share the speech bucket, got %d", perKey, w.Code)
+		}
+		for i := 0; i < 5; i++ {
+			if w := doGet(engine, "/v1/voices", nil); w.Code != http.StatusOK {
+				t.Fatalf("perKey=%v: voices request %d: expected 200, got %d", perKey, i, w.Code)
+			}
+		}
+		_ = srv.Stop(context.Background())
+	}
+}
+
+func TestRateLimiter_BurstAboveRate(t *testing.T) {
+	limiter := newRateLimiter(1, 5)
+
+	for i := 0; i < 5; i++ {
+		if !limiter.allow() {
+			t.Fatalf("request %d within burst should be allowed", i)
+		}
+	}
+	if limiter.allow() {
+		t.Fatal("request beyond burst should be rejected")
+	}
+}
+
+func TestRateLimiter_BurstThenSustainedRate(t *testing.T) {
+	limiter := newRateLimiter(2, 5)
+	start := limiter.lastRefill
+
+	for i := 0; i < 5; i++ {
+		if !limiter.allowAt(start) {
+			t.Fatalf("request %d within burst should be allowed immediately", i)
+		}
+	}
+	if limiter.allowAt(start) {
+		t.Fatal("request beyond burst should be rejected")
+	}
+
+	// 突发耗尽后按持续速率放行：每 100ms 请求一次，3 秒内只放行 2*3 个
+	allowed := 0
+	for at := 100 * time.Millisecond; at <= 3*time.Second; at += 100 * time.Millisecond {
+		if limiter.allowAt(start.Add(at)) {
+			allowed++
+		}
+	}
+	if allowed != 6 {
+		t.Fatalf("expected 6 requests at the sustained rate, got %d", allowed)
+	}
+
+	// 同样经由 RateLimitMiddlewareWithBurst 生效
+	engine := newMiddlewareTestEngine(RateLimitMiddlewareWithBurst(1, 3))
+	for i := 0; i < 3; i++ {
+		if w := doGet(engine, "/ping", nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d within burst: expected 200, got %d", i, w.Code)
+		}
+	}
+	if w := doGet(engine, "/ping", nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request beyond burst: expected 429, got %d", w.Code)
+	}
+
+	// 原有的 RateLimitMiddleware 保持单参数签名，桶容量等于速率
+	engine = newMiddlewareTestEngine(RateLimitMiddleware(2))
+	for i := 0; i < 2; i++ {
+		if w := doGet(engine, "/ping", nil); w.Code != http.StatusOK {
+			t.Fatalf("RateLimitMiddleware request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+	if w := doGet(engine, "/ping", nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("RateLimitMiddleware beyond rate: expected 429, got %d", w.Code)
+	}
+}
+
+func TestRateLimiter_FractionalRefill(t *testing.T) {
+	limiter := newRateLimiter(10, 1)
+
+	if !limiter.allow() {
+		t.Fatal("first request should be allowed")
+	}
+	if limiter.allow() {
+		t.Fatal("second immediate request should be rejected")
+	}
+
+	// 10 rps 下 150ms 可补充 1.5 个令牌，不能因为不足一秒而丢弃
+	time.Sleep(150 * time.Millisecond)
+	if !limiter.allow() {
+		t.Fatal("request after 150ms at 10 rps should be allowed")
+	}
+}
+
+func TestRateLimiter_SteadySubSecondCadence(t *testing.T) {
+	cases := []struct {
+		rate, burst int
+		interval    time.Duration
+	}{
+		{rate: 5, burst: 1, interval: 50 * time.Millisecond},
+		{rate: 10, burst: 1, interval: 20 * time.Millisecond},
+		// 间隔不整除 1/rate 时，小数部分靠桶内余量跨请求累积（burst 默认等于 rate）
+		{rate: 10, interval: 30 * time.Millisecond},
+		{rate: 3, interval: 70 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		limiter := newRateLimiter(tc.rate, tc.burst)
+		start := limiter.lastRefill
+		burst := int(limiter.maxTokens)
+
+		// 以固定的亚秒间隔持续请求 10 秒：放行数应为 rate*10 加上初始的突发令牌
+		const window = 10 * time.Second
+		allowed := 0
+		for at := time.Duration(0); at < window; at += tc.interval {
+			if limiter.allowAt(start.Add(at)) {
+				allowed++
+			}
+		}
+
+		want := tc.rate*int(window/time.Second) + burst
+		if allowed < want-1 || allowed > want {
+			t.Fatalf("rate %d burst %d every %v: expected about %d allowed, got %d",
+				tc.rate, burst, tc.interval, want, allowed)
+		}
+	}
+}
+
+func TestServer_OptionsWithoutCORS(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.EnableCORS = false
+	cfg.EnableAPIKeyAuth = true
+	cfg.APIKeys = []string{"secret"}
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer func() { _ = srv.Stop(context.Background()) }()
+
+	req := httptest.NewRequest(http.MethodOptions, "/v1/audio/speech", nil)
+	w := httptest.NewRecorder()
+	srv.Engine().ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if got := w.Header().Get("Allow"); got != "POST, OPTIONS" {
+		t.Fatalf("unexpected Allow header: %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodOptions, "/v1/unknown", nil)
+	w = httptest.NewRecorder()
+	srv.Engine().ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown route: expected 404, got %d", w.Code)
+	}
+}
+
+func TestNewServer_RejectsEmptyAllowedVoices(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.AllowedVoices = []ttsfm.Voice{}
+	if _, err := NewServer(cfg); err == nil || !strings.Contains(err.Error(), "allowed voices") {
+		t.Fatalf("expected configuration error for empty allowed voices, got %v", err)
+	}
+
+	cfg = DefaultServerConfig()
+	cfg.AllowedVoices = []ttsfm.Voice{"robot"}
+	if _, err := NewServer(cfg); err == nil {
+		t.Fatal("expected configuration error when no allowed voice is valid")
+	}
+
+	cfg = DefaultServerConfig()
+	cfg.AllowedVoices = []ttsfm.Voice{"robot", ttsfm.VoiceNova}
+	if _, err := NewServer(cfg); err != nil {
+		t.Fatalf("expected at least one valid voice to be accepted, got %v", err)
+	}
+
+	if _, err := NewServer(DefaultServerConfig()); err != nil {
+		t.Fatalf("expected nil allowed voices to mean unrestricted, got %v", err)
+	}
+}
+
+func TestNewServer_GinMode(t *testing.T) {
+	defer gin.SetMode(gin.ReleaseMode)
+
+	cfg := DefaultServerConfig()
+	cfg.GinMode = gin.DebugMode
+	if _, err := NewServer(cfg); err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	if gin.Mode() != gin.DebugMode {
+		t.Fatalf("expected gin mode %q, got %q", gin.DebugMode, gin.Mode())
+	}
+
+	t.Setenv(gin.EnvGinMode, gin.TestMode)
+	if _, err := NewServer(DefaultServerConfig()); err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	if gin.Mode() != gin.TestMode {
+		t.Fatalf("expected GIN_MODE to apply, got %q", gin.Mode())
+	}
+
+	t.Setenv(gin.EnvGinMode, "")
+	if _, err := NewServer(DefaultServerConfig()); err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	if gin.Mode() != gin.ReleaseMode {
+		t.Fatalf("expected release mode by default, got %q", gin.Mode())
+	}
+
+	cfg = DefaultServerConfig()
+	cfg.GinMode = "verbose"
+	if _, err := NewServer(cfg); err == nil {
+		t.Fatal("expected error for unknown gin mode")
+	}
+}
+
+func TestCORSMiddleware_AllowsBudgetHeaders(t *testing.T) {
+	engine := newMiddlewareTestEngine(CORSMiddleware())
+	req := httptest.NewRequest(http.MethodOptions, "/ping", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Headers", "x-budget-bytes, x-budget-seconds")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 preflight, got %d", w.Code)
+	}
+	allowed := w.Header().Get("Access-Control-Allow-Headers")
+	for _, header := range []string{"X-Budget-Bytes", "X-Budget-Seconds"} {
+		if !strings.Contains(allowed, header) {
+			t.Fatalf("expected %s in Access-Control-Allow-Headers, got %q", header, allowed)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -13,20 +15,82 @@ import (
 	"ttsfm-go/ttsfm"
 )
 
+const defaultDrainTimeout = 30 * time.Second
+
 // ServerConfig 服务器配置
 type ServerConfig struct {
 	Host             string
 	Port             int
 	APIKeys          []string
 	EnableAPIKeyAuth bool
+	// APIKeyEntries 带名称、单独限流与启用状态的 key（通常由 LoadAPIKeys 读取），与 APIKeys 合并使用；
+	// 任一 key 配置了 rate_limit 时，开启限流即按 key 限流
+	APIKeyEntries []APIKey
 
 	RequestTimeout  time.Duration
 	ShutdownTimeout time.Duration
-
-	EnableCORS       bool
-	EnableRateLimit  bool
-	RateLimitPerSec  int
-	AutoCombine      bool
+	// DrainTimeout 关闭时等待进行中的合成请求（尤其是长文本流）结束的时长，超时后中止上游调用再关闭连接
+	DrainTimeout time.Duration
+
+	EnableCORS      bool
+	EnableRateLimit bool
+	RateLimitPerSec int
+	// RateLimitBurst 令牌桶容量（允许的瞬时突发请求数），<=0 时等于速率
+	RateLimitBurst int
+	// RateLimitPerKey 按 API key（未认证时按客户端 IP）分别限流，替代全局令牌桶；
+	// 每个 key 的速率取 RateLimitPerKeyPerSec，未设置时取 RateLimitPerSec
+	RateLimitPerKey bool
+	// RateLimitPerKeyPerSec 按 key 限流时每个 key 的速率，<=0 时等于 RateLimitPerSec；本身不开启按 key 限流
+	RateLimitPerKeyPerSec int
+	// RateLimitIdleTTL 按 key 限流时，空闲令牌桶的回收时间（默认 10 分钟）
+	RateLimitIdleTTL time.Duration
+	// SpeechRateLimitPerSec >0（且开启限流）时，合成接口（/v1/audio/speech、/api/speech）在通用限流之外
+	// 再经过一个独立令牌桶，使昂贵的合成请求比元数据接口限得更严；按 key 限流时同样按 key 分桶
+	SpeechRateLimitPerSec int
+	// SpeechRateLimitBurst 合成接口令牌桶容量，<=0 时等于 SpeechRateLimitPerSec
+	SpeechRateLimitBurst int
+	// EnableMetrics 启用 Prometheus 指标与 GET /metrics
+	EnableMetrics bool
+	// MaxConcurrentPerIP >0 时限制单个客户端 IP 同时进行中的请求数
+	MaxConcurrentPerIP int
+	// MaxConcurrentLongTextJobs >0 时限制全服务器同时进行的长文本（自动拼接）任务数，
+	// 每个任务内部还会并发多个上游请求
+	MaxConcurrentLongTextJobs int
+	// LongTextJobQueueTimeout 长文本任务已满时的排队等待时长，<=0 时直接返回 503
+	LongTextJobQueueTimeout time.Duration
+	AutoCombine             bool
+	// AutoCombineThreshold 输入超过该长度才自动拼接（以 max_length 为分片大小）；
+	// <=0 或小于 max_length 时以 max_length 为阈值
+	AutoCombineThreshold int
+	// MaxAudioDuration >0 时按输入文本估算输出时长，超过则直接拒绝（400），不调用上游
+	MaxAudioDuration time.Duration
+	// AudioChecksum 二进制音频响应结束后以 X-Audio-SHA256 trailer 返回音频的 SHA-256
+	AudioChecksum bool
+	// FormatDowngradeWarnings 实际输出格式与请求不同（如 opus 降级为 wav）时，
+	// 在 stream_format=sse/ndjson 的结束事件中附带 warnings 数组；二进制响应仍只通过 X-Audio-Format 体现
+	FormatDowngradeWarnings bool
+	// BufferResponseMaxBytes >0 时，短文本二进制响应不超过该字节数则先完整缓冲再返回，
+	// 从而带上 Content-Length、X-Audio-Size 与 X-Audio-Duration；更大的音频仍分块流式输出
+	BufferResponseMaxBytes int
+	// AllowedVoices 非空时只允许使用其中的语音（/v1/voices 也只列出这些）；nil 表示不限制，
+	// 非 nil 但不含任何有效语音时 NewServer 返回配置错误
+	AllowedVoices []ttsfm.Voice
+	// StreamChunkSize stream_format=sse/ndjson 时每个增量事件的音频字节数（默认 8KB）
+	StreamChunkSize int
+	// LongTextConcurrency 长文本默认同时合成的分段数（默认 3，上限 16），同时也是请求 stream_concurrency 的上限
+	LongTextConcurrency int
+	// LongTextChunkBufferSize 长文本每个分段的默认流式缓冲区字节数（默认 32KB，范围 1KB-1MB），同时也是请求 chunk_buffer_size 的上限
+	LongTextChunkBufferSize int
+	// StreamSniffSize 短文本流式响应在写出响应头前预读并校验的字节数（默认 512）
+	StreamSniffSize int
+	// AllowLogLevelHeader 允许已认证的请求通过 X-Log-Level 头（如 debug）临时调低本请求的日志级别，
+	// 覆盖处理器及其上游调用（含重试）的日志，便于排查单个请求而不必重启；不能高于已配置的级别
+	AllowLogLevelHeader bool
+	// AdminAPIKey 非空时注册 GET /admin/selftest，请求须在 X-Admin-Key 头携带该值（否则 403）；
+	// 为空时不注册该接口（404）。自检会真实调用上游，并与合成接口共用合成限流
+	AdminAPIKey string
+	// GinMode gin 运行模式（release/debug/test），为空时读取 GIN_MODE 环境变量，仍为空则为 release
+	GinMode          string
 	Logger           ttsfm.Logger
 	TTSClientOptions []ttsfm.ClientOption
 }
@@ -38,9 +102,12 @@ func DefaultServerConfig() *ServerConfig {
 		Port:            8080,
 		RequestTimeout:  60 * time.Second,
 		ShutdownTimeout: 10 * time.Second,
+		DrainTimeout:    defaultDrainTimeout,
 		EnableCORS:      true,
 		EnableRateLimit: false,
 		RateLimitPerSec: 10,
+		StreamChunkSize: defaultStreamChunkSize,
+		StreamSniffSize: defaultStreamSniffSize,
 		Logger:          &ttsfm.DefaultLogger{},
 	}
 }
@@ -51,9 +118,13 @@ type Server struct {
 	engine *gin.Engine
 
 	httpServer *http.Server
-	//ttsClient  *ttsfm.TTSClient
-	handler *Handler
-	logger  ttsfm.Logger
+	handler    *Handler
+	metrics    *Metrics
+	logger     ttsfm.Logger
+
+	// done 在服务器停止时关闭，用于结束后台协程
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
 // NewServer 创建服务器
@@ -70,8 +141,18 @@ func NewServer(config *ServerConfig) (*Server, error) {
 	if config.ShutdownTimeout <= 0 {
 		config.ShutdownTimeout = 10 * time.Second
 	}
+	if config.DrainTimeout <= 0 {
+		config.DrainTimeout = defaultDrainTimeout
+	}
 
-	gin.SetMode(gin.ReleaseMode)
+	mode, err := resolveGinMode(config.GinMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateAllowedVoicesAndFormats(config.AllowedVoices); err != nil {
+		return nil, err
+	}
+	gin.SetMode(mode)
 	engine := gin.New()
 
 	srv := &Server{
@@ -79,6 +160,16 @@ func NewServer(config *ServerConfig) (*Server, error) {
 		engine:  engine,
 		handler: NewHandler(config),
 		logger:  config.Logger,
+		done:    make(chan struct{}),
+	}
+	if config.EnableMetrics {
+		srv.metrics = NewMetrics()
+		srv.handler.metrics = srv.metrics
+		// 复制一份选项，避免修改调用方传入的切片
+		srv.handler.TTSClientOptions = append(
+			append([]ttsfm.ClientOption(nil), config.TTSClientOptions...),
+			ttsfm.WithUpstreamObserver(srv.metrics.observeUpstream),
+		)
 	}
 
 	srv.setupMiddleware()
@@ -87,44 +178,192 @@ func NewServer(config *ServerConfig) (*Server, error) {
 	return srv, nil
 }
 
+// resolveGinMode 确定 gin 运行模式；gin.SetMode 遇到未知模式会 panic，这里提前校验
+func resolveGinMode(mode string) (string, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		mode = strings.ToLower(strings.TrimSpace(os.Getenv(gin.EnvGinMode)))
+	}
+	switch mode {
+	case "":
+		return gin.ReleaseMode, nil
+	case gin.ReleaseMode, gin.DebugMode, gin.TestMode:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid gin mode %q (expected release, debug or test)", mode)
+	}
+}
+
+// validateAllowedVoicesAndFormats 确保实际可用的语音与格式非空，否则所有合成请求都会被拒绝；
+// AllowedVoices 为 nil 表示不限制，显式传入空列表或列表中没有任何有效语音视为配置错误
+func validateAllowedVoicesAndFormats(allowed []ttsfm.Voice) error {
+	if len(ttsfm.ValidFormats) == 0 {
+		return fmt.Errorf("invalid configuration: no audio formats are available")
+	}
+	if len(ttsfm.ValidVoices) == 0 {
+		return fmt.Errorf("invalid configuration: no voices are available")
+	}
+	if allowed == nil {
+		return nil
+	}
+	for _, v := range allowed {
+		if v.IsValid() {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid configuration: allowed voices %v leave no usable voice (valid voices: %v)", allowed, ttsfm.ValidVoices)
+}
+
 func (s *Server) setupMiddleware() {
 	s.engine.Use(RecoveryMiddleware(s.logger))
 	s.engine.Use(LoggingMiddleware(s.logger))
+	if s.metrics != nil {
+		s.engine.Use(s.metrics.Middleware())
+	}
 
 	if s.config.EnableCORS {
 		s.engine.Use(CORSMiddleware())
 	}
-	if s.config.EnableRateLimit {
-		s.engine.Use(RateLimitMiddleware(s.config.RateLimitPerSec))
+	if s.config.EnableRateLimit && !s.perKeyRateLimit() {
+		s.engine.Use(RateLimitMiddlewareWithBurst(s.config.RateLimitPerSec, s.config.RateLimitBurst))
+	}
+	if s.config.MaxConcurrentPerIP > 0 {
+		s.engine.Use(ConcurrencyPerIPMiddleware(s.config.MaxConcurrentPerIP))
 	}
 }
 
 func (s *Server) setupRoutes() {
 	s.engine.GET("/health", s.handler.HealthCheck)
 	s.engine.GET("/", s.handler.HealthCheck)
+	s.engine.GET("/livez", s.handler.Livez)
+	s.engine.GET("/readyz", s.handler.Readyz)
+	if s.metrics != nil {
+		s.engine.GET("/metrics", s.metrics.Handler())
+	}
 
 	api := s.engine.Group("")
 
-	if s.config.EnableAPIKeyAuth && len(s.config.APIKeys) > 0 {
+	if s.config.EnableAPIKeyAuth && (len(s.config.APIKeys) > 0 || len(s.config.APIKeyEntries) > 0) {
 		api.Use(APIKeyMiddleware(&APIKeyConfig{
 			Enabled: true,
 			Keys:    s.config.APIKeys,
+			Entries: s.config.APIKeyEntries,
 		}))
 	}
 
+	// 日志级别请求头在认证之后生效，未认证的请求不能打开调试日志
+	if s.config.AllowLogLevelHeader {
+		api.Use(RequestLogLevelMiddleware())
+	}
+
+	// 按 key 限流需要在认证之后执行，才能拿到认证的 API key；
+	// 仅由 key 条目的 rate_limit 启用时，其余 key 使用全局速率
+	if s.config.EnableRateLimit && s.perKeyRateLimit() {
+		rate := s.config.RateLimitPerKeyPerSec
+		if rate <= 0 {
+			rate = s.config.RateLimitPerSec
+		}
+		idleTTL := s.config.RateLimitIdleTTL
+		if idleTTL <= 0 {
+			idleTTL = defaultRateLimitIdleTTL
+		}
+		api.Use(PerKeyRateLimitMiddleware(&RateLimitConfig{
+			RequestsPerSecond: rate,
+			Burst:             s.config.RateLimitBurst,
+			IdleTTL:           idleTTL,
+			EvictionInterval:  idleTTL,
+			Done:              s.done,
+		}))
+	}
+
+	speech := s.speechRateLimit()
+
 	v1 := api.Group("/v1")
 	{
 		audio := v1.Group("/audio")
 		{
-			audio.POST("/speech", s.handler.OpenAISpeech)
+			audio.POST("/speech", append(speech, s.handler.OpenAISpeech)...)
+			audio.POST("/speech/plan", s.handler.SpeechPlan)
+			audio.POST("/transcriptions", s.handler.NotImplemented)
+			audio.POST("/translations", s.handler.NotImplemented)
 		}
 
 		v1.GET("/voices", s.handler.GetVoices)
 		v1.GET("/formats", s.handler.GetFormats)
+		v1.GET("/models", s.handler.GetModels)
+		v1.GET("/models/:id", s.handler.GetModel)
 	}
 
 	// 兼容入口（非 OpenAI 标准，但方便自用）
-	api.POST("/api/speech", s.handler.OpenAISpeech)
+	api.POST("/api/speech", append(speech, s.handler.OpenAISpeech)...)
+
+	// 诊断接口：在 API 认证之外还需要管理员 key，并与合成接口共用合成限流
+	if s.config.AdminAPIKey != "" {
+		selfTest := append([]gin.HandlerFunc{AdminKeyMiddleware(s.config.AdminAPIKey)}, speech...)
+		api.GET("/admin/selftest", append(selfTest, s.handler.SelfTest)...)
+	}
+
+	s.setupOptionsRoutes()
+}
+
+// setupOptionsRoutes 为所有已注册路由补充 OPTIONS（返回 204 与 Allow），
+// 未启用 CORS 时预检请求不会落到 404；注册在认证之外，预检不需要携带 API key
+func (s *Server) setupOptionsRoutes() {
+	methods := make(map[string][]string)
+	var paths []string
+	for _, r := range s.engine.Routes() {
+		if _, ok := methods[r.Path]; !ok {
+			paths = append(paths, r.Path)
+		}
+		methods[r.Path] = append(methods[r.Path], r.Method)
+	}
+
+	for _, path := range paths {
+		allow := strings.Join(append(methods[path], http.MethodOptions), ", ")
+		s.engine.OPTIONS(path, func(c *gin.Context) {
+			c.Header("Allow", allow)
+			c.Status(http.StatusNoContent)
+		})
+	}
+}
+
+// speechRateLimit 合成路由专用的限流中间件（未配置时为空）；两个合成入口共用同一组令牌桶
+func (s *Server) speechRateLimit() []gin.HandlerFunc {
+	if !s.config.EnableRateLimit || s.config.SpeechRateLimitPerSec <= 0 {
+		return nil
+	}
+	if !s.perKeyRateLimit() {
+		return []gin.HandlerFunc{RateLimitMiddlewareWithBurst(s.config.SpeechRateLimitPerSec, s.config.SpeechRateLimitBurst)}
+	}
+	idleTTL := s.config.RateLimitIdleTTL
+	if idleTTL <= 0 {
+		idleTTL = defaultRateLimitIdleTTL
+	}
+	return []gin.HandlerFunc{PerKeyRateLimitMiddleware(&RateLimitConfig{
+		RequestsPerSecond:   s.config.SpeechRateLimitPerSec,
+		Burst:               s.config.SpeechRateLimitBurst,
+		IdleTTL:             idleTTL,
+		EvictionInterval:    idleTTL,
+		Done:                s.done,
+		IgnoreKeyRateLimits: true,
+	})}
+}
+
+func (s *Server) perKeyRateLimit() bool {
+	if s.config.RateLimitPerKey {
+		return true
+	}
+	for _, entry := range s.config.APIKeyEntries {
+		if entry.RateLimit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// closeDone 通知后台协程退出
+func (s *Server) closeDone() {
+	s.stopOnce.Do(func() { close(s.done) })
 }
 
 // Start 启动服务器（阻塞）
@@ -160,35 +399,48 @@ func (s *Server) StartWithGracefulShutdown() error {
 
 	s.logger.Info("Shutting down server...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DrainTimeout+s.config.ShutdownTimeout)
 	defer cancel()
 
-	if err := s.httpServer.Shutdown(ctx); err != nil {
+	defer s.closeDone()
+	if err := s.shutdown(ctx); err != nil {
 		s.logger.Error("Server forced to shutdown: %v", err)
 		return err
 	}
 
-	//if s.ttsClient != nil {
-	//	if err := s.ttsClient.Close(); err != nil {
-	//		s.logger.Error("Failed to close TTS client: %v", err)
-	//	}
-	//}
+	if err := s.handler.closeClient(); err != nil {
+		s.logger.Error("Failed to close TTS client: %v", err)
+	}
 
 	s.logger.Info("Server stopped")
 	return nil
 }
 
+// shutdown 停止接收新连接，等待进行中的合成请求最多 DrainTimeout，
+// 超时后取消它们的上下文，再等待连接关闭直到 ctx 结束
+func (s *Server) shutdown(ctx context.Context) error {
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.httpServer.Shutdown(ctx)
+	}()
+
+	if active := s.handler.drain(s.config.DrainTimeout); active > 0 {
+		s.logger.Warn("Drain timeout (%v) expired with %d requests still active, aborting them", s.config.DrainTimeout, active)
+	}
+	s.handler.abortInflight()
+
+	return <-shutdownErr
+}
+
 // Stop 外部触发停止
 func (s *Server) Stop(ctx context.Context) error {
+	defer s.closeDone()
 	if s.httpServer != nil {
-		if err := s.httpServer.Shutdown(ctx); err != nil {
+		if err := s.shutdown(ctx); err != nil {
 			return err
 		}
 	}
-	//if s.ttsClient != nil {
-	//	return s.ttsClient.Close()
-	//}
-	return nil
+	return s.handler.closeClient()
 }
 
 // Engine 返回 Gin 引擎（测试用）
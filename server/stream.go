@@ -0,0 +1,564 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"ttsfm-go/ttsfm"
+)
+
+const (
+	// StreamFormatAudio 默认：直接输出二进制音频流
+	StreamFormatAudio = "audio"
+	// StreamFormatSSE 以 Server-Sent Events 输出 base64 音频增量
+	StreamFormatSSE = "sse"
+	// StreamFormatNDJSON 以换行分隔 JSON 输出 base64 音频增量
+	StreamFormatNDJSON = "ndjson"
+)
+
+const (
+	defaultStreamChunkSize = 8 * 1024
+	maxStreamChunkSize     = 1024 * 1024
+
+	defaultStreamSniffSize = 512
+
+	defaultLongTextConcurrency     = 3
+	maxLongTextConcurrency         = 16
+	defaultLongTextChunkBufferSize = 32 * 1024
+	minLongTextChunkBufferSize     = 1024
+	maxLongTextChunkBufferSize     = 1024 * 1024
+	// maxUpstreamErrorBodySize 读取伪装成 200 的 JSON 错误体的上限
+	maxUpstreamErrorBodySize = 64 * 1024
+)
+
+// isValidStreamFormat 检查 stream_format 是否受支持（空值视为 audio）
+func isValidStreamFormat(f string) bool {
+	switch f {
+	case "", StreamFormatAudio, StreamFormatSSE, StreamFormatNDJSON:
+		return true
+	}
+	return false
+}
+
+// audioDeltaEvent 单个音频增量事件（与 OpenAI speech.audio.delta 对齐）
+type audioDeltaEvent struct {
+	Type  string `json:"type"`
+	Audio string `json:"audio"`
+}
+
+// audioDoneEvent 流结束事件
+type audioDoneEvent struct {
+	Type     string          `json:"type"`
+	Format   string          `json:"format"`
+	Bytes    int64           `json:"bytes"`
+	Deltas   int             `json:"deltas"`
+	Warnings []streamWarning `json:"warnings,omitempty"`
+	// BudgetExceeded 输出因预算提前结束时为超出的维度（bytes 或 seconds）
+	BudgetExceeded string `json:"budget_exceeded,omitempty"`
+}
+
+// streamWarning JSON 事件流中的非致命提示（音频仍正常输出）
+type streamWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// formatWarnings 开启 FormatDowngradeWarnings 且实际输出格式与请求不同时返回 format_downgraded 提示，
+// 供无法读取 X-Audio-Format 响应头的客户端感知降级（介于静默降级与直接拒绝之间）
+func (h *Handler) formatWarnings(requested, actual ttsfm.AudioFormat) []streamWarning {
+	if !h.formatDowngradeWarnings || requested == actual {
+		return nil
+	}
+	return []streamWarning{{
+		Code:    "format_downgraded",
+		Message: fmt.Sprintf("Requested response_format %s is not available upstream; audio is %s", requested, actual),
+	}}
+}
+
+// forEachAudioDelta 按 chunkSize 字节切分 r，并对每段调用 emit。
+// 除最后一段外，每段都恰好为 chunkSize 字节；每段单独做 base64 编码（含 padding），
+// 因此每个事件都可以独立解码，不存在跨事件的 base64 分组。
+func forEachAudioDelta(r io.Reader, chunkSize int, emit func(delta string) error) (int64, int, error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultStreamChunkSize
+	}
+
+	buf := make([]byte, chunkSize)
+	var total int64
+	var count int
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if emitErr := emit(base64.StdEncoding.EncodeToString(buf[:n])); emitErr != nil {
+				return total, count, emitErr
+			}
+			total += int64(n)
+			count++
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return total, count, nil
+			}
+			return total, count, err
+		}
+	}
+}
+
+// streamAudioDeltas 将音频流以 SSE/NDJSON 的 base64 增量事件写给客户端。
+// 读取因预算中止（errBudgetExceeded，或 budget 中已记录超出的维度）时仍正常写出结束事件，
+// 超出的维度记录在 budget 中并带在结束事件里；budget 为 nil 时不处理预算
+func (h *Handler) streamAudioDeltas(
+	c *gin.Context,
+	streamResp *ttsfm.TTSStreamResponse,
+	requested ttsfm.AudioFormat,
+	streamFormat string,
+	chunkSize int,
+	budget *atomic.Value,
+) (int64, error) {
+	writeEvent := func(v any) error {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if streamFormat == StreamFormatSSE {
+			_, err = fmt.Fprintf(c.Writer, "data: %s\n\n", raw)
+		} else {
+			_, err = fmt.Fprintf(c.Writer, "%s\n", raw)
+		}
+		if err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	if streamFormat == StreamFormatSSE {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Header("X-Audio-Format", string(streamResp.Format))
+	c.Header("X-Stream-Chunk-Size", fmt.Sprintf("%d", chunkSize))
+	c.Status(http.StatusOK)
+
+	written, deltas, err := forEachAudioDelta(streamResp.Body, chunkSize, func(delta string) error {
+		return writeEvent(audioDeltaEvent{Type: "speech.audio.delta", Audio: delta})
+	})
+	h.metrics.observeBytes(streamResp.Format, written)
+	var exceeded string
+	if err != nil && budget != nil {
+		if errors.Is(err, errBudgetExceeded) && budget.Load() == nil {
+			budget.Store("bytes")
+		}
+		if exceeded, _ = budget.Load().(string); exceeded != "" {
+			// 提前关闭上游流，中止尚未完成的分段
+			_ = streamResp.Close()
+			err = nil
+		}
+	}
+	if err != nil {
+		return written, err
+	}
+
+	return written, writeEvent(audioDoneEvent{
+		Type:           "speech.audio.done",
+		Format:         string(streamResp.Format),
+		Bytes:          written,
+		Deltas:         deltas,
+		Warnings:       h.formatWarnings(requested, streamResp.Format),
+		BudgetExceeded: exceeded,
+	})
+}
+
+// sniffAudioStream 在提交响应头之前预读上游响应的前 sniffSize 字节：
+// 若是 JSON 错误载荷（上游以 200 返回错误）或空响应，则返回对应异常，调用方仍可输出 JSON 错误；
+// 否则返回一个包含已预读数据的新 body。
+func sniffAudioStream(body io.ReadCloser, sniffSize int) (io.ReadCloser, error) {
+	if sniffSize <= 0 {
+		sniffSize = defaultStreamSniffSize
+	}
+
+	br := bufio.NewReaderSize(body, sniffSize)
+	head, err := br.Peek(sniffSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, ttsfm.NewNetworkException(fmt.Sprintf("Failed to read upstream audio: %v", err), 0)
+	}
+
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	if len(trimmed) == 0 {
+		return nil, ttsfm.NewAPIException("Upstream returned an empty audio stream", http.StatusBadGateway)
+	}
+
+	if trimmed[0] == '{' || trimmed[0] == '[' {
+		raw, _ := io.ReadAll(io.LimitReader(br, maxUpstreamErrorBodySize))
+		var errorData map[string]interface{}
+		_ = json.Unmarshal(raw, &errorData)
+		return nil, ttsfm.CreateExceptionFromResponse(
+			http.StatusBadGateway,
+			errorData,
+			"Upstream returned a JSON payload instead of audio",
+		)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, body}, nil
+}
+
+// bufferSmallAudio 最多读取 maxBytes+1 字节：音频不超过 maxBytes 时返回完整数据且 rest 为 nil（body 已关闭）；
+// 否则返回包含已读数据的 rest，调用方继续分块流式输出
+func bufferSmallAudio(body io.ReadCloser, maxBytes int) ([]byte, io.ReadCloser, error) {
+	data, err := io.ReadAll(io.LimitReader(body, int64(maxBytes)+1))
+	if err != nil {
+		return nil, nil, ttsfm.NewNetworkException(fmt.Sprintf("Failed to read upstream audio: %v", err), 0)
+	}
+	if len(data) <= maxBytes {
+		_ = body.Close()
+		return data, nil, nil
+	}
+	return nil, struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), body), body}, nil
+}
+
+// resolveStreamSniffSize 计算短文本流式响应提交前的预读字节数
+func (h *Handler) resolveStreamSniffSize() int {
+	if h.streamSniffSize > 0 {
+		return h.streamSniffSize
+	}
+	return defaultStreamSniffSize
+}
+
+// resolveStreamChunkSize 计算本次请求使用的增量大小
+func (h *Handler) resolveStreamChunkSize(requested int) int {
+	if requested > 0 {
+		return requested
+	}
+	if h.streamChunkSize > 0 {
+		return h.streamChunkSize
+	}
+	return defaultStreamChunkSize
+}
+
+// longTextStreamConfig 长文本并发数与分段缓冲区大小：服务器配置（未配置时为内置默认值）作为上限，
+// 请求参数只能在其之下调小；服务器配置超出范围时截断到允许区间（请求参数已在入口校验）
+func (h *Handler) longTextStreamConfig(req *SpeechRequest) *ttsfm.LongTextStreamConfig {
+	// 服务端配置（或默认值）作为上限，请求只能在其之下调小
+	concurrency := h.longTextConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultLongTextConcurrency
+	}
+	if concurrency > maxLongTextConcurrency {
+		concurrency = maxLongTextConcurrency
+	}
+	if req.StreamConcurrency > 0 && req.StreamConcurrency < concurrency {
+		concurrency = req.StreamConcurrency
+	}
+
+	bufSize := h.longTextChunkBufferSize
+	if bufSize <= 0 {
+		bufSize = defaultLongTextChunkBufferSize
+	}
+	if bufSize > maxLongTextChunkBufferSize {
+		bufSize = maxLongTextChunkBufferSize
+	}
+	if req.ChunkBufferSize > 0 && req.ChunkBufferSize < bufSize {
+		bufSize = req.ChunkBufferSize
+	}
+	if bufSize < minLongTextChunkBufferSize {
+		bufSize = minLongTextChunkBufferSize
+	}
+
+	return &ttsfm.LongTextStreamConfig{
+		MaxConcurrent:   concurrency,
+		ChunkBufferSize: bufSize,
+	}
+}
+
+// audioChecksumTrailer 完整音频的 SHA-256（十六进制）：流式响应仅在成功结束时以 trailer 写入，缓冲响应直接作为响应头
+const audioChecksumTrailer = "X-Audio-SHA256"
+
+// audioWriter 返回写出二进制音频用的 writer；启用校验和时同时写入 SHA-256（不额外缓冲），
+// 返回的 done 在流成功结束后调用，把校验和写入 trailer
+func (h *Handler) audioWriter(c *gin.Context) (io.Writer, func()) {
+	if !h.audioChecksum {
+		return c.Writer, func() {}
+	}
+	hasher := sha256.New()
+	return io.MultiWriter(c.Writer, hasher), func() {
+		c.Writer.Header().Set(audioChecksumTrailer, hex.EncodeToString(hasher.Sum(nil)))
+	}
+}
+
+// sha256Hex 计算完整音频的 SHA-256（十六进制）
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// maxWrappedPCMBytes 短文本包装 WAV 时整体读入的 PCM 上限（单次上游响应远小于该值）
+const maxWrappedPCMBytes = 64 << 20
+
+// pcmWAVHeader 返回包装裸 PCM 用的 WAV 头参数，sampleRate 为 0 时使用 ttsfm.DefaultPCMHeader 的默认值
+func pcmWAVHeader(sampleRate int) *ttsfm.WAVHeader {
+	header := ttsfm.DefaultPCMHeader()
+	if sampleRate > 0 {
+		header.SampleRate = uint32(sampleRate)
+		header.ByteRate = header.SampleRate * uint32(header.BlockAlign)
+	}
+	return header
+}
+
+// wrapPCMResponse 将裸 PCM 响应包装成 WAV，上游已经返回 WAV 时原样返回 streamResp。
+// streaming 为 false 时整体读入（最多 maxWrappedPCMBytes）以写出准确的数据长度；
+// 为 true 时（长文本）写出长度未知的 WAV 头后直接透传 PCM 流，不在内存中累积音频。
+// 长文本响应在返回前已由后台 goroutine 读取，因此不修改 streamResp，而是返回新的响应。
+// sampleRate 为请求的采样率，0 时使用 ttsfm.DefaultPCMHeader 的默认值
+func wrapPCMResponse(streamResp *ttsfm.TTSStreamResponse, sampleRate int, streaming bool) (*ttsfm.TTSStreamResponse, error) {
+	if streamResp.Format != ttsfm.FormatPCM {
+		return streamResp, nil
+	}
+
+	header := pcmWAVHeader(sampleRate)
+
+	var body io.ReadCloser
+	if streaming {
+		wavHeader, err := ttsfm.StreamingWAVHeader(header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build WAV header: %w", err)
+		}
+		body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(wavHeader), streamResp.Body), streamResp.Body}
+	} else {
+		pcm, err := io.ReadAll(io.LimitReader(streamResp.Body, maxWrappedPCMBytes+1))
+		_ = streamResp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PCM audio: %w", err)
+		}
+		if len(pcm) > maxWrappedPCMBytes {
+			return nil, fmt.Errorf("PCM audio exceeds %d bytes and cannot be wrapped as WAV", maxWrappedPCMBytes)
+		}
+		wav, err := ttsfm.WrapPCMAsWAV(pcm, header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap PCM audio as WAV: %w", err)
+		}
+		body = io.NopCloser(bytes.NewReader(wav))
+	}
+
+	return &ttsfm.TTSStreamResponse{
+		Body:        body,
+		ContentType: ttsfm.GetContentType(ttsfm.FormatWAV),
+		Format:      ttsfm.FormatWAV,
+		Metadata:    streamResp.Metadata,
+	}, nil
+}
+
+func normalizeStreamFormat(f string) string {
+	return strings.ToLower(strings.TrimSpace(f))
+}
+
+// acceptsEventStream 客户端是否通过 Accept 头请求 SSE
+func acceptsEventStream(c *gin.Context) bool {
+	return strings.Contains(strings.ToLower(c.GetHeader("Accept")), "text/event-stream")
+}
+
+// chunkProgressEvent SSE 进度模式下每个 chunk 完成时的事件
+type chunkProgressEvent struct {
+	Index int `json:"index"`
+	Total int `json:"total"`
+	Bytes int `json:"bytes"`
+	// Offset 该 chunk 在完整音频中的字节偏移，仅在请求 chunk_index 时返回
+	Offset *int64 `json:"offset,omitempty"`
+	Audio  string `json:"audio"`
+}
+
+// progressDoneEvent SSE 进度模式的结束事件
+type progressDoneEvent struct {
+	Total    int             `json:"total"`
+	Bytes    int64           `json:"bytes"`
+	Format   string          `json:"format"`
+	Warnings []streamWarning `json:"warnings,omitempty"`
+	// BudgetExceeded 因预算提前结束时为超出的维度（bytes 或 seconds），剩余分段不再下发
+	BudgetExceeded string `json:"budget_exceeded,omitempty"`
+}
+
+func writeSSEEvent(w gin.ResponseWriter, event string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, raw); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// handleProgressSSE 以 SSE 推送合成进度：每个 chunk 完成时发送 chunk 事件
+// （data 中 audio 为该 chunk 的 base64 音频，按 index 顺序解码拼接即为完整文件），最后发送 done 事件。
+// needsCombine 为 false 时不分片，整段文本只产生一个 chunk 事件
+func (h *Handler) handleProgressSSE(
+	c *gin.Context,
+	ctx context.Context,
+	req *SpeechRequest,
+	voice ttsfm.Voice,
+	format ttsfm.AudioFormat,
+	needsCombine bool,
+) {
+	opts := buildRequestOptions(req, voice, format)
+
+	client, err := h.ttsClient()
+	if err != nil {
+		h.error(c, "Failed to create TTS client: %v", err)
+		return
+	}
+
+	// 回调在输出协程中执行，必须等响应头写出后才能写事件；
+	// wavHeader 在 ready 关闭前设置（?wrap=wav 且上游返回裸 PCM 时），拼在第一个 chunk 之前
+	ready := make(chan struct{})
+	var (
+		total          int64
+		wavHeader      []byte
+		estimatedEnd   float64
+		budgetExceeded string
+	)
+	onChunk := func(r ttsfm.ChunkResult) error {
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		data := r.Data
+		if r.Index == 0 && wavHeader != nil {
+			data = append(append([]byte(nil), wavHeader...), data...)
+		}
+		// 与二进制输出相同：字节预算截断在恰好 budget_bytes 处，时长预算在超出后取消剩余分段
+		if req.BudgetBytes > 0 && total+int64(len(data)) > req.BudgetBytes {
+			data = data[:req.BudgetBytes-total]
+			budgetExceeded = "bytes"
+			if len(data) == 0 {
+				return errBudgetExceeded
+			}
+		}
+		estimatedEnd += estimateOutputDuration(r.Text, req.Speed).Seconds()
+		if budgetExceeded == "" && req.BudgetSeconds > 0 && estimatedEnd > req.BudgetSeconds && r.Index < r.Total-1 {
+			budgetExceeded = "seconds"
+		}
+		event := chunkProgressEvent{
+			Index: r.Index,
+			Total: r.Total,
+			Bytes: len(data),
+			Audio: base64.StdEncoding.EncodeToString(data),
+		}
+		if req.ChunkIndex {
+			offset := total
+			event.Offset = &offset
+		}
+		total += int64(len(data))
+		if err := writeSSEEvent(c.Writer, "chunk", event); err != nil {
+			return err
+		}
+		if budgetExceeded != "" {
+			return errBudgetExceeded
+		}
+		return nil
+	}
+
+	streamConfig := h.longTextStreamConfig(req)
+	streamConfig.OnChunk = onChunk
+
+	// 不需要拼接时以整段长度作为分片大小，只请求一次上游（不占用长文本任务槽位）
+	splitLength := req.MaxLength
+	if !needsCombine {
+		splitLength = max(splitLength, utf8.RuneCountInString(req.Input))
+	}
+
+	streamResp, err := client.GenerateSpeechLongTextStreamConcurrent(
+		ctx,
+		req.Input,
+		splitLength,
+		true,
+		streamConfig,
+		opts...,
+	)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	defer streamResp.Close()
+
+	chunksTotal, _ := strconv.Atoi(streamResp.Metadata["chunks_total"])
+
+	// 拼接后的音频是一个长度未知的流式 WAV，与二进制长文本输出一致
+	outFormat := streamResp.Format
+	if req.wrapWAV && outFormat == ttsfm.FormatPCM {
+		wavHeader, err = ttsfm.StreamingWAVHeader(pcmWAVHeader(req.SampleRate))
+		if err != nil {
+			h.handleError(c, fmt.Errorf("failed to build WAV header: %w", err))
+			return
+		}
+		outFormat = ttsfm.FormatWAV
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Audio-Format", string(outFormat))
+	c.Header("X-Powered-By", "TTSFM-OpenAI-Compatible")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	close(ready)
+
+	// 音频已经在 chunk 事件里下发，这里只需驱动输出流直到结束；
+	// 输出流关闭前所有回调都已执行完，之后才能安全地写 done 事件
+	_, err = io.Copy(io.Discard, streamResp.Body)
+	h.metrics.observeBytes(outFormat, total)
+	if budgetExceeded != "" {
+		// 提前关闭上游流，中止尚未完成的分段
+		_ = streamResp.Close()
+		h.warn(c, "Progress stream stopped: %s budget exceeded (sent %d bytes)", budgetExceeded, total)
+		err = nil
+	}
+	if err != nil {
+		h.error(c, "Error streaming progress events: %v", err)
+		_ = writeSSEEvent(c.Writer, "error", ErrorDetail{
+			Message: "Text-to-speech generation failed",
+			Type:    "api_error",
+			Code:    "tts_error",
+		})
+		return
+	}
+
+	if err := writeSSEEvent(c.Writer, "done", progressDoneEvent{
+		Total:          chunksTotal,
+		Bytes:          total,
+		Format:         string(outFormat),
+		Warnings:       h.formatWarnings(format, streamResp.Format),
+		BudgetExceeded: budgetExceeded,
+	}); err != nil {
+		h.error(c, "Error writing done event: %v", err)
+		return
+	}
+
+	h.info(c, "Successfully streamed %d bytes of %s audio as progress events (chunks=%d)", total, outFormat, chunksTotal)
+}
@@ -7,10 +7,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"time"
 )
 
 var wavRiffHeader = [12]byte{'R', 'I', 'F', 'F', 0, 0, 0, 0, 'W', 'A', 'V', 'E'}
 
+// maxWAVSkipChunkSize 流式解析时允许跳过的非 data chunk 的最大字节数。
+// LIST/fact 等元数据 chunk 通常只有几十字节，声明更大的视为损坏或恶意数据，
+// 避免为跳过一个声称有数 GB 的 chunk 而一直读取上游；data chunk 本身只受实际输入长度约束
+const maxWAVSkipChunkSize = 16 << 20
+
 func copyNBuffer(dst io.Writer, src io.Reader, n int64, buf []byte) (int64, error) {
 	if n <= 0 {
 		return 0, nil
@@ -66,6 +73,13 @@ func CopyMP3Stream(w io.Writer, r io.Reader, skipID3 bool) (int64, error) {
 
 // CopyMP3StreamWithBuffer 与 CopyMP3Stream 类似，但允许显式指定拷贝缓冲区大小（buf）。
 func CopyMP3StreamWithBuffer(w io.Writer, r io.Reader, skipID3 bool, buf []byte) (int64, error) {
+	return CopyMP3StreamTrimmed(w, r, skipID3, 0, buf)
+}
+
+// CopyMP3StreamTrimmed 与 CopyMP3StreamWithBuffer 相同，但（跳过 ID3 之后）再丢弃开头 trimFrames 个 MP3 帧，
+// 用于去掉后续分段的编码器起始填充（priming），减轻拼接处的咔哒声/空隙。
+// 遇到无法识别的帧头时停止丢弃，剩余数据原样输出。
+func CopyMP3StreamTrimmed(w io.Writer, r io.Reader, skipID3 bool, trimFrames int, buf []byte) (int64, error) {
 	if len(buf) == 0 {
 		return 0, fmt.Errorf("buffer size must be > 0")
 	}
@@ -76,9 +90,90 @@ func CopyMP3StreamWithBuffer(w io.Writer, r io.Reader, skipID3 bool, buf []byte)
 			return 0, err
 		}
 	}
+	if err := discardMP3Frames(br, trimFrames); err != nil {
+		return 0, err
+	}
 	return io.CopyBuffer(w, br, buf)
 }
 
+// discardMP3Frames 丢弃开头最多 n 个完整的 MP3 帧
+func discardMP3Frames(br *bufio.Reader, n int) error {
+	for i := 0; i < n; i++ {
+		header, err := br.Peek(4)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		size, ok := mp3FrameLength(header)
+		if !ok {
+			return nil
+		}
+		if _, err := br.Discard(size); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	// mp3Bitrates 码率表（kbps），下标依次为 MPEG1 Layer I/II/III、MPEG2/2.5 Layer I、MPEG2/2.5 Layer II/III
+	mp3Bitrates = [5][15]int{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	// mp3SampleRates 采样率表，下标为帧头中的版本字段（0=MPEG2.5，2=MPEG2，3=MPEG1）
+	mp3SampleRates = [4][3]int{
+		{11025, 12000, 8000},
+		{},
+		{22050, 24000, 16000},
+		{44100, 48000, 32000},
+	}
+)
+
+// mp3FrameLength 解析 MPEG 音频帧头，返回整帧字节数；不是合法帧头（或为 free format）时 ok=false
+func mp3FrameLength(header []byte) (int, bool) {
+	if len(header) < 4 || header[0] != 0xFF || header[1]&0xE0 != 0xE0 {
+		return 0, false
+	}
+	version := int(header[1]>>3) & 0x03
+	layer := int(header[1]>>1) & 0x03 // 3=Layer I，2=Layer II，1=Layer III
+	bitrateIndex := int(header[2] >> 4)
+	sampleRateIndex := int(header[2]>>2) & 0x03
+	padding := int(header[2]>>1) & 0x01
+	if version == 1 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return 0, false
+	}
+
+	var table int
+	switch {
+	case version == 3:
+		table = 3 - layer
+	case layer == 3:
+		table = 3
+	default:
+		table = 4
+	}
+	bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+	sampleRate := mp3SampleRates[version][sampleRateIndex]
+
+	switch {
+	case layer == 3:
+		return (12*bitrate/sampleRate + padding) * 4, true
+	case layer == 1 && version != 3:
+		return 72*bitrate/sampleRate + padding, true
+	default:
+		return 144*bitrate/sampleRate + padding, true
+	}
+}
+
 func discardID3v2(br *bufio.Reader) error {
 	header, err := br.Peek(10)
 	if err != nil {
@@ -147,6 +242,9 @@ func CopyWAVDataStream(w io.Writer, r io.Reader) (int64, error) {
 		}
 
 		// 丢弃其他 chunk
+		if chunkSize > maxWAVSkipChunkSize {
+			return written, fmt.Errorf("wav %q chunk too large to skip (%d bytes)", chunkID, chunkSize)
+		}
 		if _, err := io.CopyN(io.Discard, br, int64(chunkSize)); err != nil {
 			if errors.Is(err, io.EOF) {
 				return written, nil
@@ -161,6 +259,13 @@ func CopyWAVDataStream(w io.Writer, r io.Reader) (int64, error) {
 
 // CopyWAVDataStreamWithBuffer 与 CopyWAVDataStream 类似，但允许显式指定拷贝缓冲区大小（buf）。
 func CopyWAVDataStreamWithBuffer(w io.Writer, r io.Reader, buf []byte) (int64, error) {
+	return copyWAVDataMatching(w, r, buf, nil, false)
+}
+
+// copyWAVDataMatching 同 CopyWAVDataStreamWithBuffer；expected 非 nil 时在写出任何数据前
+// 校验 fmt chunk 与其一致（采样率/声道/位深），不一致时返回错误而不是拼出错速的音频。
+// strict 为 true 时输入不是 RIFF/WAVE 直接返回 ErrNotWAV，而不是按裸数据写出
+func copyWAVDataMatching(w io.Writer, r io.Reader, buf []byte, expected *WAVHeader, strict bool) (int64, error) {
 	if len(buf) == 0 {
 		return 0, fmt.Errorf("buffer size must be > 0")
 	}
@@ -174,6 +279,9 @@ func CopyWAVDataStreamWithBuffer(w io.Writer, r io.Reader, buf []byte) (int64, e
 	if header[0] != wavRiffHeader[0] || header[1] != wavRiffHeader[1] || header[2] != wavRiffHeader[2] ||
 		header[3] != wavRiffHeader[3] || header[8] != wavRiffHeader[8] || header[9] != wavRiffHeader[9] ||
 		header[10] != wavRiffHeader[10] || header[11] != wavRiffHeader[11] {
+		if strict {
+			return 0, ErrNotWAV
+		}
 		// 不是 WAV，按裸数据写回（把已经读出的 12 字节也写回）
 		n1, err := w.Write(header[:])
 		if err != nil {
@@ -204,6 +312,34 @@ func CopyWAVDataStreamWithBuffer(w io.Writer, r io.Reader, buf []byte) (int64, e
 			return written, nil
 		}
 
+		if chunkID == "fmt " && expected != nil {
+			if chunkSize < 16 || chunkSize > 1024 {
+				return written, fmt.Errorf("invalid wav fmt chunk size %d", chunkSize)
+			}
+			fmtChunk := make([]byte, chunkSize)
+			if _, err := io.ReadFull(br, fmtChunk); err != nil {
+				return written, err
+			}
+			header := &WAVHeader{
+				AudioFormat:   binary.LittleEndian.Uint16(fmtChunk[0:2]),
+				NumChannels:   binary.LittleEndian.Uint16(fmtChunk[2:4]),
+				SampleRate:    binary.LittleEndian.Uint32(fmtChunk[4:8]),
+				ByteRate:      binary.LittleEndian.Uint32(fmtChunk[8:12]),
+				BlockAlign:    binary.LittleEndian.Uint16(fmtChunk[12:14]),
+				BitsPerSample: binary.LittleEndian.Uint16(fmtChunk[14:16]),
+			}
+			if err := expected.sameFormat(header); err != nil {
+				return written, err
+			}
+			if chunkSize%2 != 0 {
+				_, _ = br.ReadByte()
+			}
+			continue
+		}
+
+		if chunkSize > maxWAVSkipChunkSize {
+			return written, fmt.Errorf("wav %q chunk too large to skip (%d bytes)", chunkID, chunkSize)
+		}
 		_, err := copyNBuffer(io.Discard, br, int64(chunkSize), buf)
 		if err != nil && !errors.Is(err, io.EOF) {
 			return written, err
@@ -214,8 +350,221 @@ func CopyWAVDataStreamWithBuffer(w io.Writer, r io.Reader, buf []byte) (int64, e
 	}
 }
 
+// ogg 页头固定部分长度（不含 segment table）
+const oggPageHeaderSize = 27
+
+const (
+	oggFlagBOS = 0x02
+	oggFlagEOS = 0x04
+)
+
+var errNotOgg = errors.New("not an ogg stream")
+
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = (r << 1) ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// oggCRC 计算 Ogg 页校验和（多项式 0x04c11db7，非反射，初值 0）
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = (crc << 8) ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// readOggPage 读取一个完整的 Ogg 页（页头 + segment table + 数据）
+func readOggPage(br *bufio.Reader) ([]byte, error) {
+	var header [oggPageHeaderSize]byte
+	n, err := io.ReadFull(br, header[:])
+	if err != nil {
+		if n == 0 && errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	if string(header[0:4]) != "OggS" {
+		return nil, errNotOgg
+	}
+
+	segCount := int(header[26])
+	page := make([]byte, oggPageHeaderSize+segCount, oggPageHeaderSize+segCount+segCount*255)
+	copy(page, header[:])
+	if _, err := io.ReadFull(br, page[oggPageHeaderSize:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	bodySize := 0
+	for _, lace := range page[oggPageHeaderSize:] {
+		bodySize += int(lace)
+	}
+	page = page[:oggPageHeaderSize+segCount+bodySize]
+	if _, err := io.ReadFull(br, page[oggPageHeaderSize+segCount:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return page, nil
+}
+
+// oggPageBody 返回页数据部分
+func oggPageBody(page []byte) []byte {
+	return page[oggPageHeaderSize+int(page[26]):]
+}
+
+// oggPageCompletesPacket 页内最后一个 lacing 值 < 255 表示有包在本页结束
+func oggPageCompletesPacket(page []byte) bool {
+	segCount := int(page[26])
+	return segCount > 0 && page[oggPageHeaderSize+segCount-1] < 255
+}
+
+func setOggPageCRC(page []byte) {
+	binary.LittleEndian.PutUint32(page[22:26], 0)
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC(page))
+}
+
+// OggOpusStreamState 跨 chunk 拼接 Ogg Opus 时的状态。
+// 零值即可使用；同一条输出流的所有 chunk 必须共用同一个 state，并在最后调用 Flush。
+type OggOpusStreamState struct {
+	started     bool
+	serial      uint32
+	nextSeq     uint32
+	lastGranule int64
+	// pending 暂存已改写但尚未写出的最后一页，只有整条流的最后一页才保留 EOS 标记
+	pending []byte
+}
+
+// CopyOggOpusDataStream 解析 r 中的 Ogg 页并写入 w，使多个独立的 Ogg Opus 文件拼接为一条连续流：
+// - 第一个 chunk 保留 OpusHead/OpusTags 标识页
+// - 之后的 chunk 跳过 OpusHead/OpusTags 页
+// - 所有页统一使用第一个 chunk 的 serial，并按顺序重新编号 page sequence
+// - granule position 在前面 chunk 的基础上累加，保证单调递增
+// - 重新计算每页 CRC；EOS 只保留在 Flush 写出的最后一页上
+//
+// 如果 r 不是 Ogg 流，则按裸数据写回。返回写入 w 的字节数。
+func CopyOggOpusDataStream(w io.Writer, r io.Reader, state *OggOpusStreamState) (int64, error) {
+	if state == nil {
+		return 0, fmt.Errorf("ogg opus stream state is nil")
+	}
+
+	br := bufio.NewReader(r)
+
+	var written int64
+	magic, err := br.Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	if string(magic) != "OggS" {
+		// 不是 Ogg，先写出暂存页再按裸数据写回
+		n, err := state.writePending(w, false)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		n, err = io.Copy(w, br)
+		return written + n, err
+	}
+
+	firstChunk := !state.started
+	granuleBase := state.lastGranule
+	skipHeaders := !firstChunk
+	inTags := false
+
+	for {
+		page, err := readOggPage(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return written, nil
+			}
+			return written, err
+		}
+
+		if skipHeaders {
+			body := oggPageBody(page)
+			switch {
+			case page[5]&oggFlagBOS != 0 && bytes.HasPrefix(body, []byte("OpusHead")):
+				continue
+			case bytes.HasPrefix(body, []byte("OpusTags")) || inTags:
+				// OpusTags 可能跨多页，直到包结束为止
+				inTags = !oggPageCompletesPacket(page)
+				if !inTags {
+					skipHeaders = false
+				}
+				continue
+			default:
+				skipHeaders = false
+			}
+		}
+
+		if !state.started {
+			state.serial = binary.LittleEndian.Uint32(page[14:18])
+			state.started = true
+		}
+
+		granule := int64(binary.LittleEndian.Uint64(page[6:14]))
+		if granule != -1 {
+			if !firstChunk {
+				granule += granuleBase
+			}
+			binary.LittleEndian.PutUint64(page[6:14], uint64(granule))
+			state.lastGranule = granule
+		}
+
+		page[5] &^= oggFlagEOS
+		if !(firstChunk && state.nextSeq == 0) {
+			page[5] &^= oggFlagBOS
+		}
+		binary.LittleEndian.PutUint32(page[14:18], state.serial)
+		binary.LittleEndian.PutUint32(page[18:22], state.nextSeq)
+		state.nextSeq++
+
+		n, err := state.writePending(w, false)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		state.pending = page
+	}
+}
+
+// Flush 写出暂存的最后一页并打上 EOS 标记
+func (s *OggOpusStreamState) Flush(w io.Writer) (int64, error) {
+	return s.writePending(w, true)
+}
+
+func (s *OggOpusStreamState) writePending(w io.Writer, eos bool) (int64, error) {
+	if s.pending == nil {
+		return 0, nil
+	}
+	page := s.pending
+	s.pending = nil
+	if eos {
+		page[5] |= oggFlagEOS
+	}
+	setOggPageCRC(page)
+	n, err := w.Write(page)
+	return int64(n), err
+}
+
 // CombineAudioChunks 合并多个音频块
 func CombineAudioChunks(chunks [][]byte, format AudioFormat) ([]byte, error) {
+	return CombineAudioChunksWithCrossfade(chunks, format, 0)
+}
+
+// CombineAudioChunksWithCrossfade 与 CombineAudioChunks 相同，但 WAV（16 位 PCM）分段之间
+// 用 crossfade 时长做线性交叉淡化：前一段末尾与后一段开头重叠混合，消除拼接处的咔哒声，
+// 总时长相应缩短。其他格式或 crossfade<=0 时按原方式拼接
+func CombineAudioChunksWithCrossfade(chunks [][]byte, format AudioFormat, crossfade time.Duration) ([]byte, error) {
 	if len(chunks) == 0 {
 		return nil, fmt.Errorf("no audio chunks to combine")
 	}
@@ -227,7 +576,7 @@ func CombineAudioChunks(chunks [][]byte, format AudioFormat) ([]byte, error) {
 	case FormatMP3:
 		return combineMP3Chunks(chunks)
 	case FormatWAV:
-		return combineWAVChunks(chunks)
+		return combineWAVChunks(chunks, crossfade)
 	case FormatOPUS, FormatAAC, FormatFLAC, FormatPCM:
 		return combineRawChunks(chunks)
 	default:
@@ -273,36 +622,113 @@ func skipID3Tag(data []byte) []byte {
 	return data
 }
 
-// combineWAVChunks 合并 WAV 音频块（需重建 WAV 头并更新数据长度）
-func combineWAVChunks(chunks [][]byte) ([]byte, error) {
+// combineWAVChunks 合并 WAV 音频块（需重建 WAV 头并更新数据长度），crossfade>0 时相邻分段交叉淡化
+func combineWAVChunks(chunks [][]byte, crossfade time.Duration) ([]byte, error) {
 	firstHeader, err := parseWAVHeader(chunks[0])
 	if err != nil {
 		// 不是标准 WAV（或返回格式不一致）时退回到原始拼接
 		return combineRawChunks(chunks)
 	}
 
-	var audioData bytes.Buffer
-	for _, chunk := range chunks {
+	fadeFrames := 0
+	if crossfade > 0 && firstHeader.AudioFormat == 1 && firstHeader.BitsPerSample == 16 {
+		fadeFrames = int(crossfade * time.Duration(firstHeader.SampleRate) / time.Second)
+	}
+
+	var audioData []byte
+	appendData := func(data []byte) {
+		audioData = appendCrossfadedPCM16(audioData, data, fadeFrames, int(firstHeader.BlockAlign))
+	}
+	for i, chunk := range chunks {
+		// 采样率/声道/位深不一致时直接拼接会得到错速或噪声，宁可报错
+		if i > 0 && looksLikeWAV(chunk) {
+			header, err := parseWAVHeader(chunk)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse wav header of chunk %d: %w", i, err)
+			}
+			if err := firstHeader.sameFormat(header); err != nil {
+				return nil, fmt.Errorf("chunk %d: %w", i, err)
+			}
+		}
+
 		data, err := extractWAVData(chunk)
 		if err != nil {
 			// 如果 chunk 看起来像 WAV 但提取失败，直接返回错误避免输出不可播放文件
 			if looksLikeWAV(chunk) {
-				return nil, fmt.Errorf("failed to extract wav data: %w", err)
+				return nil, fmt.Errorf("failed to extract wav data from chunk %d: %w", i, err)
+			}
+			// 只接受与首段格式对齐的裸 PCM（极少数服务可能返回裸 PCM），其他容器一律报错，
+			// 避免把 MP3/Ogg 等数据写进 WAV data 段造成静默损坏
+			if !looksLikeRawPCM(chunk, firstHeader) {
+				return nil, fmt.Errorf("chunk %d is neither WAV nor raw PCM matching the first chunk", i)
 			}
-			// 否则当作纯音频数据拼接（极少数服务可能返回裸 PCM）
-			_, _ = audioData.Write(chunk)
+			appendData(chunk)
 			continue
 		}
-		_, _ = audioData.Write(data)
+		appendData(data)
 	}
 
-	return buildWAVFile(firstHeader, audioData.Bytes())
+	return buildWAVFile(firstHeader, audioData)
+}
+
+// appendCrossfadedPCM16 把 next 追加到 dst：两者各取至多 frames 帧重叠，按线性权重混合 16 位小端样本。
+// frames<=0 或任一侧为空时直接拼接
+func appendCrossfadedPCM16(dst, next []byte, frames, blockAlign int) []byte {
+	if blockAlign <= 0 {
+		return append(dst, next...)
+	}
+	frames = min(frames, len(dst)/blockAlign, len(next)/blockAlign)
+	if frames <= 0 {
+		return append(dst, next...)
+	}
+
+	overlap := frames * blockAlign
+	tail := dst[len(dst)-overlap:]
+	for f := 0; f < frames; f++ {
+		weight := float64(f+1) / float64(frames+1)
+		for off := f * blockAlign; off+1 < (f+1)*blockAlign; off += 2 {
+			a := float64(int16(binary.LittleEndian.Uint16(tail[off:])))
+			b := float64(int16(binary.LittleEndian.Uint16(next[off:])))
+			mixed := math.Round(a*(1-weight) + b*weight)
+			binary.LittleEndian.PutUint16(tail[off:], uint16(int16(max(math.MinInt16, min(math.MaxInt16, mixed)))))
+		}
+	}
+	return append(dst, next[overlap:]...)
 }
 
 func looksLikeWAV(data []byte) bool {
 	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE"
 }
 
+// hasContainerSignature 检查数据是否以已知音频容器/帧头开头
+func hasContainerSignature(data []byte) bool {
+	if len(data) >= 4 {
+		switch string(data[0:4]) {
+		case "RIFF", "OggS", "fLaC":
+			return true
+		}
+	}
+	if len(data) >= 3 && string(data[0:3]) == "ID3" {
+		return true
+	}
+	// MP3 帧同步 / AAC ADTS
+	if len(data) >= 2 && data[0] == 0xFF && (data[1]&0xE0) == 0xE0 {
+		return true
+	}
+	return false
+}
+
+// looksLikeRawPCM 判断数据是否为与 header 格式一致的裸 PCM（无容器头且按 block 对齐）
+func looksLikeRawPCM(data []byte, header *WAVHeader) bool {
+	if len(data) == 0 || header == nil || header.BlockAlign == 0 {
+		return false
+	}
+	if hasContainerSignature(data) {
+		return false
+	}
+	return len(data)%int(header.BlockAlign) == 0
+}
+
 // WAVHeader WAV 文件头信息
 type WAVHeader struct {
 	AudioFormat   uint16
@@ -313,6 +739,59 @@ type WAVHeader struct {
 	BitsPerSample uint16
 }
 
+// sameFormat 检查两个 WAV 头的采样格式是否一致（可安全拼接 PCM 数据）
+func (h *WAVHeader) sameFormat(other *WAVHeader) error {
+	if h.AudioFormat != other.AudioFormat || h.NumChannels != other.NumChannels ||
+		h.SampleRate != other.SampleRate || h.BitsPerSample != other.BitsPerSample {
+		return fmt.Errorf(
+			"wav format mismatch: expected %d Hz/%d ch/%d bit (format %d), got %d Hz/%d ch/%d bit (format %d)",
+			h.SampleRate, h.NumChannels, h.BitsPerSample, h.AudioFormat,
+			other.SampleRate, other.NumChannels, other.BitsPerSample, other.AudioFormat,
+		)
+	}
+	return nil
+}
+
+// validate 检查 WAV 头各字段是否自洽，避免写出无法播放的文件
+func (h *WAVHeader) validate() error {
+	if h.NumChannels == 0 || h.SampleRate == 0 || h.BitsPerSample == 0 {
+		return fmt.Errorf("invalid wav header: %d Hz/%d ch/%d bit", h.SampleRate, h.NumChannels, h.BitsPerSample)
+	}
+	if h.AudioFormat == 1 {
+		blockAlign := uint32(h.NumChannels) * uint32(h.BitsPerSample) / 8
+		if uint32(h.BlockAlign) != blockAlign || h.ByteRate != h.SampleRate*blockAlign {
+			return fmt.Errorf(
+				"inconsistent wav header: block_align=%d byte_rate=%d for %d Hz/%d ch/%d bit",
+				h.BlockAlign, h.ByteRate, h.SampleRate, h.NumChannels, h.BitsPerSample,
+			)
+		}
+	}
+	return nil
+}
+
+// wavHeaderPeekSize 流式场景下为解析首段 WAV 头预读的最大字节数（fmt 前可能还有 LIST 等 chunk）
+const wavHeaderPeekSize = 1024
+
+// peekWAVHeader 在不消费数据的情况下解析 br 开头的 WAV 头；不是 WAV 或头不完整时返回 nil
+func peekWAVHeader(br *bufio.Reader) *WAVHeader {
+	head, _ := br.Peek(wavHeaderPeekSize)
+	header, err := parseWAVHeader(head)
+	if err != nil {
+		return nil
+	}
+	return header
+}
+
+// nextWAVChunkOffset 返回 offset 处的 chunk（8 字节头 + chunkSize + 对齐填充）之后的偏移。
+// 以 int64 计算，避免 32 位平台上 chunkSize 转 int 溢出；越过 limit 时返回 ok=false
+func nextWAVChunkOffset(offset int, chunkSize uint32, limit int) (int, bool) {
+	next := int64(offset) + 8 + int64(chunkSize) + int64(chunkSize%2)
+	if next > int64(limit) {
+		return limit, false
+	}
+	return int(next), true
+}
+
 // parseWAVHeader 解析 WAV 文件头
 func parseWAVHeader(data []byte) (*WAVHeader, error) {
 	if len(data) < 44 {
@@ -329,7 +808,7 @@ func parseWAVHeader(data []byte) (*WAVHeader, error) {
 		chunkSize := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
 
 		if chunkID == "fmt " {
-			if offset+8+int(chunkSize) > len(data) {
+			if int64(chunkSize) > int64(len(data)-offset-8) {
 				return nil, fmt.Errorf("fmt chunk extends beyond file")
 			}
 			if chunkSize < 16 {
@@ -347,10 +826,11 @@ func parseWAVHeader(data []byte) (*WAVHeader, error) {
 			}, nil
 		}
 
-		offset += 8 + int(chunkSize)
-		if chunkSize%2 != 0 {
-			offset++
+		next, ok := nextWAVChunkOffset(offset, chunkSize, len(data))
+		if !ok {
+			break
 		}
+		offset = next
 	}
 
 	return nil, fmt.Errorf("fmt chunk not found")
@@ -371,25 +851,92 @@ func extractWAVData(data []byte) ([]byte, error) {
 		chunkSize := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
 
 		if chunkID == "data" {
+			// 流式 WAV 常把 data 大小写成 0xFFFFFFFF，超出部分按实际长度截断
 			dataStart := offset + 8
-			dataEnd := dataStart + int(chunkSize)
-			if dataEnd > len(data) {
-				dataEnd = len(data)
+			dataEnd := len(data)
+			if int64(chunkSize) < int64(dataEnd-dataStart) {
+				dataEnd = dataStart + int(chunkSize)
 			}
 			return data[dataStart:dataEnd], nil
 		}
 
-		offset += 8 + int(chunkSize)
-		if chunkSize%2 != 0 {
-			offset++
+		next, ok := nextWAVChunkOffset(offset, chunkSize, len(data))
+		if !ok {
+			break
 		}
+		offset = next
 	}
 
 	return nil, fmt.Errorf("data chunk not found")
 }
 
+// trimWAVLeading 去掉 WAV 开头 n 字节的 PCM 数据（按 block 对齐），返回重新封装的 WAV
+func trimWAVLeading(data []byte, n int) ([]byte, error) {
+	header, err := parseWAVHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	pcm, err := extractWAVData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if align := int(header.BlockAlign); align > 1 {
+		n -= n % align
+	}
+	if n > len(pcm) {
+		n = len(pcm)
+	}
+	return buildWAVFile(header, pcm[n:])
+}
+
+// DefaultPCMHeader 上游裸 PCM 的默认参数：24kHz、单声道、16-bit little-endian
+func DefaultPCMHeader() *WAVHeader {
+	return &WAVHeader{
+		AudioFormat:   1,
+		NumChannels:   1,
+		SampleRate:    24000,
+		ByteRate:      24000 * 2,
+		BlockAlign:    2,
+		BitsPerSample: 16,
+	}
+}
+
+// WrapPCMAsWAV 为裸 PCM 数据加上最小的 WAV 头；header 为 nil 时使用 DefaultPCMHeader
+func WrapPCMAsWAV(pcm []byte, header *WAVHeader) ([]byte, error) {
+	if header == nil {
+		header = DefaultPCMHeader()
+	}
+	return buildWAVFile(header, pcm)
+}
+
+// unknownWAVSize 流式 WAV 头中未知长度字段的取值
+const unknownWAVSize = 0xFFFFFFFF
+
+// StreamingWAVHeader 生成数据长度未知的 44 字节 WAV 头（RIFF 与 data 长度均为 0xFFFFFFFF），
+// 用于边合成边输出的 PCM 流；header 为 nil 时使用 DefaultPCMHeader
+func StreamingWAVHeader(header *WAVHeader) ([]byte, error) {
+	if header == nil {
+		header = DefaultPCMHeader()
+	}
+	wav, err := buildWAVFile(header, nil)
+	if err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(wav[4:8], unknownWAVSize)
+	binary.LittleEndian.PutUint32(wav[40:44], unknownWAVSize)
+	return wav, nil
+}
+
 // buildWAVFile 构建 WAV 文件
 func buildWAVFile(header *WAVHeader, audioData []byte) ([]byte, error) {
+	if header == nil {
+		return nil, fmt.Errorf("wav header is nil")
+	}
+	if err := header.validate(); err != nil {
+		return nil, err
+	}
+
 	var buf bytes.Buffer
 
 	dataSize := uint32(len(audioData))
@@ -544,4 +1091,4 @@ func NewAudioReader(r io.Reader, format AudioFormat) *AudioReader {
 // ReadAll 读取所有音频数据
 func (ar *AudioReader) ReadAll() ([]byte, error) {
 	return io.ReadAll(ar.reader)
-}
\ No newline at end of file
+}
@@ -0,0 +1,445 @@
+package ttsfm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeOggPage(headerType byte, granule int64, serial, seq uint32, packets ...[]byte) []byte {
+	var lacing []byte
+	var body []byte
+	for _, p := range packets {
+		n := len(p)
+		for n >= 255 {
+			lacing = append(lacing, 255)
+			n -= 255
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, p...)
+	}
+
+	page := make([]byte, oggPageHeaderSize, oggPageHeaderSize+len(lacing)+len(body))
+	copy(page[0:4], "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:18], serial)
+	binary.LittleEndian.PutUint32(page[18:22], seq)
+	page[26] = byte(len(lacing))
+	page = append(page, lacing...)
+	page = append(page, body...)
+	setOggPageCRC(page)
+	return page
+}
+
+// makeOggOpus 构造一个最小的 Ogg Opus 文件：OpusHead + OpusTags + 若干音频页
+func makeOggOpus(serial uint32, audioPackets ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(makeOggPage(oggFlagBOS, 0, serial, 0, append([]byte("OpusHead"), 1, 1, 0x38, 0x01, 0x80, 0xBB, 0, 0, 0, 0, 0)))
+	buf.Write(makeOggPage(0, 0, serial, 1, append([]byte("OpusTags"), 0, 0, 0, 0, 0, 0, 0, 0)))
+
+	granule := int64(0)
+	for i, p := range audioPackets {
+		granule += 960
+		flags := byte(0)
+		if i == len(audioPackets)-1 {
+			flags = oggFlagEOS
+		}
+		buf.Write(makeOggPage(flags, granule, serial, uint32(i+2), p))
+	}
+	return buf.Bytes()
+}
+
+func readAllOggPages(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+
+	br := bufio.NewReader(bytes.NewReader(data))
+	var pages [][]byte
+	for {
+		page, err := readOggPage(br)
+		if errors.Is(err, io.EOF) {
+			return pages
+		}
+		if err != nil {
+			t.Fatalf("read page %d: %v", len(pages), err)
+		}
+		pages = append(pages, page)
+	}
+}
+
+func TestCopyOggOpusDataStream_ConcatenatesIntoSingleStream(t *testing.T) {
+	first := makeOggOpus(0x1111, []byte("a1"), []byte("a2"))
+	second := makeOggOpus(0x2222, []byte("b1"), []byte("b2"), []byte("b3"))
+
+	var out bytes.Buffer
+	state := &OggOpusStreamState{}
+	if _, err := CopyOggOpusDataStream(&out, bytes.NewReader(first), state); err != nil {
+		t.Fatalf("copy first: %v", err)
+	}
+	if _, err := CopyOggOpusDataStream(&out, bytes.NewReader(second), state); err != nil {
+		t.Fatalf("copy second: %v", err)
+	}
+	if _, err := state.Flush(&out); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	pages := readAllOggPages(t, out.Bytes())
+	// 2 个标识页 + 2 + 3 个音频页
+	if len(pages) != 7 {
+		t.Fatalf("expected 7 pages, got %d", len(pages))
+	}
+
+	var lastGranule int64
+	for i, page := range pages {
+		if serial := binary.LittleEndian.Uint32(page[14:18]); serial != 0x1111 {
+			t.Fatalf("page %d: unexpected serial %x", i, serial)
+		}
+		if seq := binary.LittleEndian.Uint32(page[18:22]); seq != uint32(i) {
+			t.Fatalf("page %d: unexpected sequence %d", i, seq)
+		}
+
+		crc := binary.LittleEndian.Uint32(page[22:26])
+		check := append([]byte{}, page...)
+		binary.LittleEndian.PutUint32(check[22:26], 0)
+		if oggCRC(check) != crc {
+			t.Fatalf("page %d: bad crc", i)
+		}
+
+		if bos := page[5]&oggFlagBOS != 0; bos != (i == 0) {
+			t.Fatalf("page %d: unexpected BOS=%v", i, bos)
+		}
+		if eos := page[5]&oggFlagEOS != 0; eos != (i == len(pages)-1) {
+			t.Fatalf("page %d: unexpected EOS=%v", i, eos)
+		}
+
+		granule := int64(binary.LittleEndian.Uint64(page[6:14]))
+		if granule < lastGranule {
+			t.Fatalf("page %d: granule went backwards (%d < %d)", i, granule, lastGranule)
+		}
+		lastGranule = granule
+	}
+
+	if got := bytes.Count(out.Bytes(), []byte("OpusHead")); got != 1 {
+		t.Fatalf("expected exactly one OpusHead, got %d", got)
+	}
+	if got := bytes.Count(out.Bytes(), []byte("OpusTags")); got != 1 {
+		t.Fatalf("expected exactly one OpusTags, got %d", got)
+	}
+	if lastGranule != 5*960 {
+		t.Fatalf("unexpected final granule: %d", lastGranule)
+	}
+}
+
+func TestCopyOggOpusDataStream_NonOggPassthrough(t *testing.T) {
+	var out bytes.Buffer
+	state := &OggOpusStreamState{}
+	n, err := CopyOggOpusDataStream(&out, bytes.NewReader([]byte("raw-bytes")), state)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if n != int64(len("raw-bytes")) || out.String() != "raw-bytes" {
+		t.Fatalf("unexpected passthrough output: %q", out.String())
+	}
+}
+
+func testWAV(t *testing.T, pcm []byte) []byte {
+	t.Helper()
+
+	data, err := buildWAVFile(&WAVHeader{
+		AudioFormat:   1,
+		NumChannels:   1,
+		SampleRate:    8000,
+		ByteRate:      16000,
+		BlockAlign:    2,
+		BitsPerSample: 16,
+	}, pcm)
+	if err != nil {
+		t.Fatalf("build wav: %v", err)
+	}
+	return data
+}
+
+func TestCombineWAVChunks_RejectsMP3Chunk(t *testing.T) {
+	wav := testWAV(t, []byte{1, 2, 3, 4})
+	mp3 := append([]byte{0xFF, 0xFB, 0x90, 0x64}, bytes.Repeat([]byte{0}, 60)...)
+
+	if _, err := CombineAudioChunks([][]byte{wav, mp3}, FormatWAV); err == nil {
+		t.Fatal("expected error when combining WAV with an MP3 chunk")
+	}
+
+	id3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x00"), bytes.Repeat([]byte{0}, 60)...)
+	if _, err := CombineAudioChunks([][]byte{wav, id3}, FormatWAV); err == nil {
+		t.Fatal("expected error when combining WAV with an ID3-tagged chunk")
+	}
+}
+
+func TestCombineWAVChunks_AcceptsMatchingRawPCM(t *testing.T) {
+	wav := testWAV(t, []byte{1, 2, 3, 4})
+
+	combined, err := CombineAudioChunks([][]byte{wav, {5, 6, 7, 8}}, FormatWAV)
+	if err != nil {
+		t.Fatalf("combine: %v", err)
+	}
+	data, err := extractWAVData(combined)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if !bytes.Equal(data, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Fatalf("unexpected combined data: %v", data)
+	}
+
+	// 与 block 对齐不一致的裸数据不能当作 PCM
+	if _, err := CombineAudioChunks([][]byte{wav, {5, 6, 7}}, FormatWAV); err == nil {
+		t.Fatal("expected error for raw data not aligned to block size")
+	}
+}
+
+func TestCombineWAVChunks_RejectsFormatMismatch(t *testing.T) {
+	first := testWAV(t, []byte{1, 2, 3, 4})
+	second, err := buildWAVFile(&WAVHeader{
+		AudioFormat:   1,
+		NumChannels:   1,
+		SampleRate:    16000,
+		ByteRate:      32000,
+		BlockAlign:    2,
+		BitsPerSample: 16,
+	}, []byte{5, 6, 7, 8})
+	if err != nil {
+		t.Fatalf("build wav: %v", err)
+	}
+
+	_, err = CombineAudioChunks([][]byte{first, second}, FormatWAV)
+	if err == nil || !strings.Contains(err.Error(), "wav format mismatch") {
+		t.Fatalf("expected wav format mismatch error, got %v", err)
+	}
+}
+
+// mp3Frame 构造一个 MPEG1 Layer III 128kbps/44.1kHz 帧（417 字节），负载填充 fill
+func mp3Frame(fill byte) []byte {
+	frame := bytes.Repeat([]byte{fill}, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return frame
+}
+
+func TestMP3FrameLength(t *testing.T) {
+	cases := []struct {
+		header []byte
+		want   int
+		ok     bool
+	}{
+		{[]byte{0xFF, 0xFB, 0x90, 0x00}, 417, true}, // MPEG1 L3 128k 44.1k
+		{[]byte{0xFF, 0xFB, 0x92, 0x00}, 418, true}, // 同上，带 padding
+		{[]byte{0xFF, 0xF3, 0x84, 0x00}, 192, true}, // MPEG2 L3 64k 24k
+		{[]byte{0xFF, 0xFD, 0x80, 0x00}, 417, true}, // MPEG1 L2 128k 44.1k
+		{[]byte{0xFF, 0xFB, 0x00, 0x00}, 0, false},  // free format
+		{[]byte{0xFF, 0xFB, 0x9C, 0x00}, 0, false},  // 保留采样率
+		{[]byte("ID3\x04"), 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := mp3FrameLength(tc.header)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("mp3FrameLength(% x) = %d, %v; want %d, %v", tc.header, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestCopyMP3StreamTrimmed_DropsLeadingFrames(t *testing.T) {
+	id3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x05"), 1, 2, 3, 4, 5)
+	stream := append(append(append(append([]byte{}, id3...), mp3Frame(1)...), mp3Frame(2)...), mp3Frame(3)...)
+
+	var out bytes.Buffer
+	if _, err := CopyMP3StreamTrimmed(&out, bytes.NewReader(stream), true, 2, make([]byte, 64)); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), mp3Frame(3)) {
+		t.Fatalf("expected only the third frame, got %d bytes", out.Len())
+	}
+
+	// 默认 0 不裁剪
+	out.Reset()
+	if _, err := CopyMP3StreamWithBuffer(&out, bytes.NewReader(stream), true, make([]byte, 64)); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if out.Len() != 3*417 {
+		t.Fatalf("expected all frames without trimming, got %d bytes", out.Len())
+	}
+
+	// 非帧数据处停止裁剪并原样输出
+	garbage := append(mp3Frame(1), []byte("not an mp3 frame")...)
+	out.Reset()
+	if _, err := CopyMP3StreamTrimmed(&out, bytes.NewReader(garbage), false, 5, make([]byte, 64)); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if out.String() != "not an mp3 frame" {
+		t.Fatalf("expected trimming to stop at unrecognised data, got %q", out.String())
+	}
+}
+
+func TestCombineAudioChunksWithCrossfade_WAV(t *testing.T) {
+	pcm := func(value int16, samples int) []byte {
+		data := make([]byte, samples*2)
+		for i := 0; i < samples; i++ {
+			binary.LittleEndian.PutUint16(data[i*2:], uint16(value))
+		}
+		return data
+	}
+	first := testWAV(t, pcm(1000, 16))
+	second := testWAV(t, pcm(-1000, 16))
+
+	// 8000 Hz 下 0.5ms 为 4 帧
+	combined, err := CombineAudioChunksWithCrossfade([][]byte{first, second}, FormatWAV, 500*time.Microsecond)
+	if err != nil {
+		t.Fatalf("combine: %v", err)
+	}
+	data, err := extractWAVData(combined)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if len(data) != (32-4)*2 {
+		t.Fatalf("expected the 4-frame overlap to shorten the data to %d bytes, got %d", (32-4)*2, len(data))
+	}
+
+	sample := func(i int) int16 { return int16(binary.LittleEndian.Uint16(data[i*2:])) }
+	if sample(0) != 1000 || sample(11) != 1000 || sample(16) != -1000 || sample(27) != -1000 {
+		t.Fatalf("expected untouched samples outside the overlap, got %d %d %d %d", sample(0), sample(11), sample(16), sample(27))
+	}
+	for i := 12; i < 16; i++ {
+		if s := sample(i); s >= sample(i-1) || s <= -1000 {
+			t.Fatalf("sample %d: expected a monotonic fade from 1000 to -1000, got %d after %d", i, s, sample(i-1))
+		}
+	}
+
+	// 默认不交叉淡化，按原样拼接
+	plain, err := CombineAudioChunks([][]byte{first, second}, FormatWAV)
+	if err != nil {
+		t.Fatalf("combine: %v", err)
+	}
+	if data, _ := extractWAVData(plain); len(data) != 64 {
+		t.Fatalf("expected plain concatenation without crossfade, got %d bytes", len(data))
+	}
+}
+
+func TestBuildWAVFile_RejectsInconsistentHeader(t *testing.T) {
+	_, err := buildWAVFile(&WAVHeader{
+		AudioFormat:   1,
+		NumChannels:   2,
+		SampleRate:    24000,
+		ByteRate:      48000,
+		BlockAlign:    2,
+		BitsPerSample: 16,
+	}, []byte{1, 2, 3, 4})
+	if err == nil {
+		t.Fatal("expected error for inconsistent block align / byte rate")
+	}
+}
+
+// craftedWAV 构造一个 RIFF/WAVE 头后跟任意 chunk 的 WAV 片段
+func craftedWAV(chunks ...[]byte) []byte {
+	data := append([]byte{}, wavRiffHeader[:]...)
+	for _, c := range chunks {
+		data = append(data, c...)
+	}
+	return data
+}
+
+func wavChunk(id string, size uint32, payload []byte) []byte {
+	chunk := make([]byte, 8, 8+len(payload))
+	copy(chunk, id)
+	binary.LittleEndian.PutUint32(chunk[4:8], size)
+	return append(chunk, payload...)
+}
+
+// endlessReader 永不结束的输入，模拟持续发送数据的恶意上游
+type endlessReader struct{ read int64 }
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestWAVParsers_RejectHugeChunkSizes(t *testing.T) {
+	fmtChunk := wavChunk("fmt ", 16, []byte{1, 0, 1, 0, 0x40, 0x1f, 0, 0, 0x80, 0x3e, 0, 0, 2, 0, 16, 0})
+	data := craftedWAV(
+		wavChunk("LIST", 0xFFFFFFF0, make([]byte, 8)),
+		fmtChunk,
+		wavChunk("data", 4, []byte{1, 2, 3, 4}),
+		make([]byte, 16),
+	)
+
+	if _, err := parseWAVHeader(data); err == nil {
+		t.Fatalf("expected parseWAVHeader to fail when a chunk size points past the buffer")
+	}
+	if _, err := extractWAVData(data); err == nil {
+		t.Fatalf("expected extractWAVData to fail when a chunk size points past the buffer")
+	}
+
+	// fmt 之后声明超大的 data chunk：按实际长度截断
+	truncated := craftedWAV(fmtChunk, wavChunk("data", 0xFFFFFFFF, bytes.Repeat([]byte{7}, 24)))
+	pcm, err := extractWAVData(truncated)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if len(pcm) != 24 {
+		t.Fatalf("expected data to be truncated to 24 bytes, got %d", len(pcm))
+	}
+}
+
+func TestCopyWAVDataStream_RejectsHugeSkippedChunk(t *testing.T) {
+	head := craftedWAV(wavChunk("LIST", 0xFFFFFFF0, nil))
+
+	for name, copyFn := range map[string]func(io.Writer, io.Reader) (int64, error){
+		"CopyWAVDataStream": CopyWAVDataStream,
+		"CopyWAVDataStreamWithBuffer": func(w io.Writer, r io.Reader) (int64, error) {
+			return CopyWAVDataStreamWithBuffer(w, r, make([]byte, 4096))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			upstream := &endlessReader{}
+			_, err := copyFn(io.Discard, io.MultiReader(bytes.NewReader(head), upstream))
+			if err == nil || !strings.Contains(err.Error(), "too large") {
+				t.Fatalf("expected oversized chunk error, got %v", err)
+			}
+			if upstream.read > 1<<20 {
+				t.Fatalf("expected no large reads from upstream, read %d bytes", upstream.read)
+			}
+		})
+	}
+}
+
+func FuzzWAVChunkParsing(f *testing.F) {
+	valid, err := buildWAVFile(DefaultPCMHeader(), []byte{1, 2, 3, 4, 5, 6})
+	if err != nil {
+		f.Fatalf("build wav: %v", err)
+	}
+	f.Add(valid)
+	f.Add(valid[:30])
+	f.Add(craftedWAV(wavChunk("LIST", 0xFFFFFFFF, nil)))
+	f.Add(craftedWAV(wavChunk("fmt ", 0x7FFFFFFF, make([]byte, 16))))
+	f.Add(craftedWAV(wavChunk("JUNK", 3, []byte{0, 0, 0}), wavChunk("data", 0xFFFFFFFF, make([]byte, 40))))
+
+	expected := DefaultPCMHeader()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if header, err := parseWAVHeader(data); err == nil && header == nil {
+			t.Fatalf("parseWAVHeader returned nil header without error")
+		}
+		if pcm, err := extractWAVData(data); err == nil && len(pcm) > len(data) {
+			t.Fatalf("extractWAVData returned %d bytes from %d byte input", len(pcm), len(data))
+		}
+		_, _ = GetAudioDuration(data, FormatWAV)
+		_ = peekWAVHeader(bufio.NewReaderSize(bytes.NewReader(data), wavHeaderPeekSize))
+
+		n, _ := CopyWAVDataStream(io.Discard, bytes.NewReader(data))
+		if n > int64(len(data)) {
+			t.Fatalf("CopyWAVDataStream wrote %d bytes from %d byte input", n, len(data))
+		}
+		n, _ = copyWAVDataMatching(io.Discard, bytes.NewReader(data), make([]byte, 64), expected, false)
+		if n > int64(len(data)) {
+			t.Fatalf("copyWAVDataMatching wrote %d bytes from %d byte input", n, len(data))
+		}
+	})
+}
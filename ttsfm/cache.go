@@ -0,0 +1,215 @@
+package ttsfm
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cachedAudio 缓存的一次完整上游响应
+type cachedAudio struct {
+	data        []byte
+	contentType string
+	format      AudioFormat
+	metadata    map[string]string
+	expiresAt   time.Time
+}
+
+// streamResponse 基于缓存数据构造一个新的流式响应（每次调用返回独立的 reader）
+func (a *cachedAudio) streamResponse(hit bool) *TTSStreamResponse {
+	metadata := make(map[string]string, len(a.metadata)+1)
+	for k, v := range a.metadata {
+		metadata[k] = v
+	}
+	if hit {
+		metadata["cache"] = "hit"
+	} else {
+		metadata["cache"] = "miss"
+	}
+
+	return &TTSStreamResponse{
+		Body:        io.NopCloser(bytes.NewReader(a.data)),
+		ContentType: a.contentType,
+		Format:      a.format,
+		Metadata:    metadata,
+	}
+}
+
+type cacheEntry struct {
+	key   string
+	audio *cachedAudio
+}
+
+// cacheCall 同一 key 正在进行中的上游请求，后来者等待其结果（single-flight）
+type cacheCall struct {
+	done  chan struct{}
+	audio *cachedAudio
+	err   error
+}
+
+// defaultCacheFetchTimeout 未设置 ClientConfig.Timeout 时，合并请求共享的上游调用的超时
+const defaultCacheFetchTimeout = 2 * time.Minute
+
+// responseCache 进程内 LRU 响应缓存，带 TTL，并合并并发的相同请求
+type responseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	// fetchTimeout 共享上游调用的超时，该调用不受任何单个调用方 ctx 的取消影响
+	fetchTimeout time.Duration
+	ll           *list.List
+	items        map[string]*list.Element
+	inflight     map[string]*cacheCall
+}
+
+func newResponseCache(maxEntries int, ttl, fetchTimeout time.Duration) *responseCache {
+	if fetchTimeout <= 0 {
+		fetchTimeout = defaultCacheFetchTimeout
+	}
+	return &responseCache{
+		maxEntries:   maxEntries,
+		ttl:          ttl,
+		fetchTimeout: fetchTimeout,
+		ll:           list.New(),
+		items:        make(map[string]*list.Element),
+		inflight:     make(map[string]*cacheCall),
+	}
+}
+
+// do 命中时直接返回缓存；否则同一 key 只执行一次 fetch，其余调用方共享结果。
+// 返回值 hit 表示结果是否来自已有缓存或其他调用方的请求。
+//
+// fetch 在独立的上下文中执行（保留 ctx 的值，但不继承其取消，超时为 fetchTimeout），
+// 发起请求的调用方断开不会让合并进来的其他调用方一起失败；
+// 每个调用方只按自己的 ctx 放弃等待，放弃后共享的请求仍会完成并写入缓存
+func (c *responseCache) do(
+	ctx context.Context,
+	key string,
+	fetch func(context.Context) (*cachedAudio, error),
+) (*cachedAudio, bool, error) {
+	c.mu.Lock()
+	if audio, ok := c.getLocked(key, time.Now()); ok {
+		c.mu.Unlock()
+		return audio, true, nil
+	}
+	call, shared := c.inflight[key]
+	if !shared {
+		call = &cacheCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		go c.run(ctx, key, call, fetch)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.audio, shared && call.err == nil, call.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// run 执行共享的 fetch，并把结果写入缓存后唤醒所有等待方
+func (c *responseCache) run(
+	ctx context.Context,
+	key string,
+	call *cacheCall,
+	fetch func(context.Context) (*cachedAudio, error),
+) {
+	fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.fetchTimeout)
+	defer cancel()
+
+	call.audio, call.err = fetch(fetchCtx)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if call.err == nil {
+		c.addLocked(key, call.audio)
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+func (c *responseCache) getLocked(key string, now time.Time) (*cachedAudio, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.audio.expiresAt.IsZero() && now.After(entry.audio.expiresAt) {
+		c.removeLocked(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.audio, true
+}
+
+func (c *responseCache) addLocked(key string, audio *cachedAudio) {
+	if c.ttl > 0 {
+		audio.expiresAt = time.Now().Add(c.ttl)
+	}
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheEntry).audio = audio
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, audio: audio})
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeLocked(c.ll.Back())
+	}
+}
+
+func (c *responseCache) removeLocked(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*cacheEntry).key)
+}
+
+func (c *responseCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// requestCacheKey 对归一化后的请求参数求哈希；vibe/prompt 使用最终发往上游的值，
+// strict 为生效的严格格式模式（宽松模式缓存的回退格式不能命中严格请求）
+func requestCacheKey(request *TTSRequest, vibe, prompt string, strict bool) string {
+	h := sha256.New()
+	write := func(s string) {
+		_, _ = h.Write([]byte(strconv.Itoa(len(s))))
+		_, _ = h.Write([]byte{':'})
+		_, _ = h.Write([]byte(s))
+	}
+
+	write(request.Input)
+	write(string(request.Voice))
+	write(string(request.ResponseFormat))
+	write(prompt)
+	write(strconv.FormatFloat(request.Speed, 'f', -1, 64))
+	write(vibe)
+	write(request.Language)
+	write(strconv.Itoa(request.SampleRate))
+	write(strconv.Itoa(request.Bitrate))
+	write(request.Quality)
+	if request.Seed != nil {
+		write("seed=" + strconv.FormatInt(*request.Seed, 10))
+	}
+	write(strconv.FormatBool(strict))
+
+	keys := make([]string, 0, len(request.ExtraFormFields))
+	for k := range request.ExtraFormFields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		write(k)
+		write(request.ExtraFormFields[k])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,246 @@
+package ttsfm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func readStream(t *testing.T, resp *TTSStreamResponse) string {
+	t.Helper()
+	defer resp.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(data)
+}
+
+func TestWithCache_HitAvoidsUpstream(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte("audio:" + input) })
+	client := newStubClient(t, upstream.URL, WithCache(10, time.Minute))
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.GenerateSpeechStream(context.Background(), "Hello there.", WithVoice(VoiceNova))
+		if err != nil {
+			t.Fatalf("generate %d: %v", i, err)
+		}
+		wantCache := "hit"
+		if i == 0 {
+			wantCache = "miss"
+		}
+		if got := resp.Metadata["cache"]; got != wantCache {
+			t.Fatalf("request %d: expected cache=%s, got %q", i, wantCache, got)
+		}
+		if got := readStream(t, resp); got != "audio:Hello there." {
+			t.Fatalf("request %d: unexpected body %q", i, got)
+		}
+	}
+	if n := len(rec.all()); n != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", n)
+	}
+
+	// 任一参数不同都不能命中
+	resp, err := client.GenerateSpeechStream(context.Background(), "Hello there.", WithVoice(VoiceNova), WithSpeed(1.5))
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	_ = readStream(t, resp)
+	if n := len(rec.all()); n != 2 {
+		t.Fatalf("expected a different speed to miss the cache, got %d upstream requests", n)
+	}
+}
+
+func TestRequestCacheKey_CoversAllSynthesisParameters(t *testing.T) {
+	base := func() *TTSRequest {
+		return &TTSRequest{Input: "Hello.", Voice: VoiceAlloy, ResponseFormat: FormatWAV}
+	}
+	baseKey := requestCacheKey(base(), "vibe", "prompt", false)
+
+	variants := map[string]func() string{
+		"input": func() string { r := base(); r.Input = "Bye."; return requestCacheKey(r, "vibe", "prompt", false) },
+		"voice": func() string { r := base(); r.Voice = VoiceNova; return requestCacheKey(r, "vibe", "prompt", false) },
+		"format": func() string {
+			r := base()
+			r.ResponseFormat = FormatMP3
+			return requestCacheKey(r, "vibe", "prompt", false)
+		},
+		"instructions": func() string { return requestCacheKey(base(), "vibe", "other prompt", false) },
+		"vibe":         func() string { return requestCacheKey(base(), "other vibe", "prompt", false) },
+		"speed":        func() string { r := base(); r.Speed = 1.25; return requestCacheKey(r, "vibe", "prompt", false) },
+		"sample_rate":  func() string { r := base(); r.SampleRate = 16000; return requestCacheKey(r, "vibe", "prompt", false) },
+		"seed":         func() string { r := base(); WithSeed(42)(r); return requestCacheKey(r, "vibe", "prompt", false) },
+		"strict":       func() string { return requestCacheKey(base(), "vibe", "prompt", true) },
+	}
+	for name, key := range variants {
+		if key() == baseKey {
+			t.Errorf("changing %s must change the cache key", name)
+		}
+	}
+}
+
+func TestWithCache_InstructionsDoNotCollide(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte("audio:" + input) })
+	client := newStubClient(t, upstream.URL, WithCache(10, time.Minute))
+
+	for _, instructions := range []string{"Speak cheerfully.", "Speak slowly.", "Speak cheerfully."} {
+		resp, err := client.GenerateSpeechStream(context.Background(), "Hello there.", WithInstructions(instructions))
+		if err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+		_ = readStream(t, resp)
+	}
+
+	forms := rec.all()
+	if len(forms) != 2 {
+		t.Fatalf("expected 2 upstream requests (one per distinct instructions), got %d", len(forms))
+	}
+	if forms[0]["prompt"] == forms[1]["prompt"] {
+		t.Fatalf("expected distinct prompts upstream, got %q twice", forms[0]["prompt"])
+	}
+}
+
+func TestWithCache_StrictRequestDoesNotHitLenientFallback(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/wav", func(input string) []byte { return testWAV(t, []byte{1, 2, 3, 4}) })
+	client := newStubClient(t, upstream.URL, WithCache(10, time.Minute))
+
+	resp, err := client.GenerateSpeech(context.Background(), "Hello.", WithFormat(FormatOPUS))
+	if err != nil {
+		t.Fatalf("lenient generate: %v", err)
+	}
+	if resp.Format != FormatWAV {
+		t.Fatalf("expected lenient fallback to wav, got %s", resp.Format)
+	}
+
+	_, err = client.GenerateSpeech(context.Background(), "Hello.", WithFormat(FormatOPUS), WithStrictResponseFormat(true))
+	var ve *ValidationException
+	if !errors.As(err, &ve) || ve.Field != "response_format" {
+		t.Fatalf("expected response_format ValidationException for strict request, got %v", err)
+	}
+	if n := len(rec.all()); n != 2 {
+		t.Fatalf("expected the strict request to bypass the lenient cache entry, got %d upstream requests", n)
+	}
+}
+
+func TestWithCache_CoalescesConcurrentRequests(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+
+	client := newStubClient(t, upstream.URL, WithCache(10, time.Minute))
+
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			resp, err := client.GenerateSpeech(context.Background(), "Same text.")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(resp.AudioData) != "audio" {
+				errs <- io.ErrUnexpectedEOF
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent request failed: %v", err)
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected concurrent identical requests to hit upstream once, got %d", got)
+	}
+}
+
+func TestWithCache_CoalescedCallersUseTheirOwnContext(t *testing.T) {
+	started := make(chan struct{}, 4)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(150 * time.Millisecond)
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+
+	client := newStubClient(t, upstream.URL, WithCache(10, time.Minute))
+
+	// 发起请求的调用方断开后，合并进来的调用方仍拿到结果
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := client.GenerateSpeech(leaderCtx, "Same text.")
+		leaderErr <- err
+	}()
+	<-started
+
+	followerErr := make(chan error, 1)
+	go func() {
+		resp, err := client.GenerateSpeech(context.Background(), "Same text.")
+		if err == nil && string(resp.AudioData) != "audio" {
+			err = io.ErrUnexpectedEOF
+		}
+		followerErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancelLeader()
+
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the leader to observe its own cancellation, got %v", err)
+	}
+	if err := <-followerErr; err != nil {
+		t.Fatalf("follower must not inherit the leader's cancellation: %v", err)
+	}
+
+	// 等待方可以按自己的截止时间提前放弃
+	go func() { _, _ = client.GenerateSpeech(context.Background(), "Other text.") }()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.GenerateSpeech(ctx, "Other text."); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected follower deadline to apply, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("follower waited %v for the shared request instead of giving up", elapsed)
+	}
+}
+
+func TestResponseCache_TTLAndEviction(t *testing.T) {
+	fetch := func(data string) func(context.Context) (*cachedAudio, error) {
+		return func(context.Context) (*cachedAudio, error) { return &cachedAudio{data: []byte(data)}, nil }
+	}
+
+	ctx := context.Background()
+	cache := newResponseCache(2, 20*time.Millisecond, 0)
+	_, _, _ = cache.do(ctx, "a", fetch("a"))
+	_, _, _ = cache.do(ctx, "b", fetch("b"))
+	_, _, _ = cache.do(ctx, "c", fetch("c"))
+	if got := cache.len(); got != 2 {
+		t.Fatalf("expected LRU to keep 2 entries, got %d", got)
+	}
+	if _, hit, _ := cache.do(ctx, "a", fetch("a2")); hit {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	audio, hit, _ := cache.do(ctx, "a", fetch("a3"))
+	if hit || string(audio.data) != "a3" {
+		t.Fatalf("expected expired entry to be refetched, got hit=%v data=%q", hit, audio.data)
+	}
+}
@@ -1,15 +1,19 @@
 package ttsfm
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"math/rand"
 	"mime/multipart"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -38,30 +42,101 @@ func (l *DefaultLogger) Debug(msg string, args ...interface{}) { log.Printf("[DE
 
 // ClientConfig 客户端配置
 type ClientConfig struct {
-	BaseURL       string
-	APIKey        string
+	BaseURL string
+	APIKey  string
+	// Timeout 每次 GenerateSpeech* 调用的整体截止时间（含重试、长文本的全部分段及读取流式响应体），
+	// 通过 context.WithTimeout 施加，<=0 表示只受调用方 ctx 约束
 	Timeout       time.Duration
 	MaxRetries    int
 	VerifySSL     bool
 	MaxConcurrent int
 	ProxyURL      string
 	Logger        Logger
+	// ConnectTimeout tls-client 的套接字超时（默认 30s）：限制建立连接，
+	// 同时也是单次上游 HTTP 调用的超时；长文本的每个分段各自计时，不受分段数量影响
+	ConnectTimeout time.Duration
+	// DefaultVibe 请求未指定 vibe 时使用的默认值
+	DefaultVibe string
+	// RecordSourceText 是否在 TTSResponse.SourceText 中保留每段的原始文本（批量/长文本时会额外占用内存）
+	RecordSourceText bool
+	// ClientProfile 固定使用的 TLS 指纹（profiles.MappedTLSClients 中的名称），为空时随机选择
+	ClientProfile string
+	// UserAgent 固定的 User-Agent，为空时每次请求随机选择
+	UserAgent string
+	// CacheSize >0 时启用进程内响应缓存，最多保留的条目数
+	CacheSize int
+	// CacheTTL 缓存条目的有效期，<=0 表示不过期（仅按 LRU 淘汰）
+	CacheTTL time.Duration
+	// StaticHostMapping 主机名到固定 IP 的映射，命中的主机跳过 DNS 解析（不能与代理同时使用）
+	StaticHostMapping map[string][]string
+	// UpstreamObserver 每次上游调用（含全部重试）结束后回调，用于指标采集
+	UpstreamObserver func(UpstreamStats)
+	// RetryCallback 每次重试等待前回调：attempt 为第几次重试（从 1 开始），err 为触发重试的错误
+	RetryCallback func(attempt int, err error, delay time.Duration)
+	// RequestSigner 每次发送上游请求前（请求头已设置）调用，可用于附加 HMAC 等签名头；
+	// body 为完整请求体，重试时会对新构建的请求重新调用
+	RequestSigner func(req *http.Request, body []byte) error
+	// ContextOverlap >0 时非流式长文本每段前附带上一段末尾的若干句作为上下文（见 WithContextOverlap）
+	ContextOverlap int
+	// StripMarkdown 为 true 时在清理与分段之前先去除 Markdown 格式（见 StripMarkdown）
+	StripMarkdown bool
+	// StrictFormat 严格校验上游音频格式：上游返回的格式与请求不同且无法转码时返回 ValidationException，
+	// 而不是降级输出（如请求 flac 却得到 wav）；拼接 WAV 时任一分段不是合法的 RIFF/WAVE 即报错（ErrNotWAV），
+	// 而不是把非 WAV 字节当作 PCM 拼进结果。可被请求的 WithStrictResponseFormat 覆盖
+	StrictFormat bool
+	// TextPreprocessor 在 Markdown 去除、SanitizeText 与分段之前对输入做自定义处理（见 WithTextPreprocessor）
+	TextPreprocessor func(string) (string, error)
+	// MultipartBoundary 每次请求生成上游表单的 multipart boundary，为 nil 时使用标准库的随机 boundary
+	MultipartBoundary func() string
+	// RetryBackoffBase / RetryBackoffMax 重试退避的基础延迟与上限（秒），第 n 次重试等待 base * 2^(n-1)
+	RetryBackoffBase float64
+	RetryBackoffMax  float64
+	// RetryJitter 重试退避的抖动比例（见 ExponentialBackoffWithJitter），0 表示不加抖动
+	RetryJitter float64
+	// RetryableStatus 判断非 200 状态码是否重试，为 nil 时使用 DefaultRetryableStatus
+	RetryableStatus func(status int) bool
+}
+
+// UpstreamStats 一次上游调用的统计信息
+type UpstreamStats struct {
+	// Attempts 实际发出的请求次数（重试次数 = Attempts - 1）
+	Attempts int
+	// Duration 从首次发出请求到拿到响应头（或最终失败）的耗时
+	Duration time.Duration
+	// Err 最终错误，成功时为 nil
+	Err error
 }
 
 // DefaultClientConfig 默认配置
 func DefaultClientConfig() *ClientConfig {
 	return &ClientConfig{
-		BaseURL:       "https://www.openai.fm",
-		Timeout:       30 * time.Second,
-		MaxRetries:    3,
-		VerifySSL:     true,
-		MaxConcurrent: 10,
-		Logger:        &DefaultLogger{},
+		BaseURL:        "https://www.openai.fm",
+		ConnectTimeout: defaultConnectTimeout,
+		MaxRetries:     3,
+		VerifySSL:      true,
+		MaxConcurrent:  10,
+		Logger:         &DefaultLogger{},
+		DefaultVibe:    DefaultVibe,
+
+		RetryBackoffBase: defaultRetryBackoffBase,
+		RetryBackoffMax:  defaultRetryBackoffMax,
+		RetryJitter:      DefaultRetryJitter,
 	}
 }
 
+// defaultConnectTimeout tls-client 套接字超时的默认值
+const defaultConnectTimeout = 30 * time.Second
+
+// 重试退避默认的基础延迟与上限（秒）
+const (
+	defaultRetryBackoffBase = 1.0
+	defaultRetryBackoffMax  = 60.0
+)
+
 const defaultLongTextStreamMaxConcurrent = 3
 const defaultLongTextStreamChunkBufferSize = 32 * 1024
+const defaultLongTextStreamAcquireTimeout = 10 * time.Second
+const defaultStreamFuncBufferSize = 32 * 1024
 
 // LongTextStreamConfig 长文本流式配置
 type LongTextStreamConfig struct {
@@ -69,6 +144,43 @@ type LongTextStreamConfig struct {
 	MaxConcurrent int
 	// ChunkBufferSize 单个 chunk 的预读/拷贝缓冲大小（默认 32KB）
 	ChunkBufferSize int
+	// AcquireTimeout 每个 chunk 等待全局并发槽位的上限（默认 10s），超时返回 BusyException 而不是一直阻塞
+	AcquireTimeout time.Duration
+	// RawConcat 调试用：所有格式都按原样拼接上游返回的字节，不跳过 ID3/WAV 头、不改写 Ogg 页
+	RawConcat bool
+	// TrimLeadingFrames MP3 输出时丢弃第 2 段起每段开头的帧数，用于去掉编码器起始填充、减轻拼接处的咔哒声；
+	// 默认 0 不裁剪（每帧约 24ms，通常 1-2 帧即可）
+	TrimLeadingFrames int
+	// OnChunk 每个 chunk 按序写入输出流后调用（在输出协程中同步执行）；返回错误会中止整个流。
+	// 设置后每个 chunk 的数据会额外缓存一份用于回调。
+	OnChunk func(ChunkResult) error
+	// OnChunkProgress 与 OnChunk 时机相同，但只汇报序号、文本与字节数，不缓存 chunk 数据；
+	// 只有单个 chunk 时仍直接透传上游流，并在读到结尾时回调。返回错误会中止整个流
+	OnChunkProgress func(ChunkProgress) error
+}
+
+// ChunkProgress 长文本中单个 chunk 的写出进度（不含音频数据）
+type ChunkProgress struct {
+	Index int
+	Total int
+	// Text 该 chunk 的源文本，可用于估算时长
+	Text string
+	// Bytes 该 chunk 实际写入输出流的字节数
+	Bytes int64
+	// Offset 该 chunk 在输出流中的起始字节偏移
+	Offset int64
+}
+
+// ChunkResult 长文本中单个 chunk 的输出结果
+type ChunkResult struct {
+	Index int
+	Total int
+	// Text 该 chunk 的源文本
+	Text string
+	// Data 该 chunk 实际写入输出流的字节（chunk>0 已去掉重复的容器头），按序拼接即为完整音频
+	Data []byte
+	// Offset 该 chunk 在输出流中的起始字节偏移，可用于构建分段索引以近似定位
+	Offset int64
 }
 
 // DefaultLongTextStreamConfig 默认配置
@@ -76,6 +188,7 @@ func DefaultLongTextStreamConfig() *LongTextStreamConfig {
 	return &LongTextStreamConfig{
 		MaxConcurrent:   defaultLongTextStreamMaxConcurrent,
 		ChunkBufferSize: defaultLongTextStreamChunkBufferSize,
+		AcquireTimeout:  defaultLongTextStreamAcquireTimeout,
 	}
 }
 
@@ -103,12 +216,60 @@ func (r *TTSStreamResponse) WriteTo(w io.Writer) (int64, error) {
 	return io.Copy(w, r.Body)
 }
 
+// SaveToFile 将流直接写入文件（不在内存中缓冲整段音频），扩展名按 Format 修正，
+// 返回最终路径与写入的字节数。ctx 取消时中断写入；失败时删除不完整的文件。
+func (r *TTSStreamResponse) SaveToFile(ctx context.Context, filename string) (string, int64, error) {
+	if r.Body == nil {
+		return "", 0, fmt.Errorf("stream response has no body")
+	}
+
+	finalFilename, err := resolveAudioFilename(filename, r.Format)
+	if err != nil {
+		return "", 0, err
+	}
+
+	file, err := os.Create(finalFilename)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	// ctx 取消时关闭 body 以打断阻塞中的读取
+	stop := context.AfterFunc(ctx, func() { _ = r.Body.Close() })
+	written, err := io.Copy(file, r.Body)
+	stop()
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(finalFilename)
+		return "", written, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return finalFilename, written, nil
+}
+
 // TTSClient TTS 客户端
 type TTSClient struct {
 	config     *ClientConfig
 	httpClient tls_client.HttpClient
 	semaphore  chan struct{}
 	logger     Logger
+	cache      *responseCache
+
+	// proxyMu 保护 httpClient 的传输层：SetProxy/ClearProxy 会替换 Transport，
+	// 发起请求时持读锁，避免与进行中的请求竞争
+	proxyMu sync.RWMutex
+
+	// rootCtx 在 Shutdown 时取消，中止所有进行中的上游请求
+	rootCtx    context.Context
+	rootCancel context.CancelFunc
+	// inflight 统计已发起且响应体尚未关闭的上游请求；shutdown 置位后不再登记新请求
+	inflight     sync.WaitGroup
+	inflightMu   sync.Mutex
+	shuttingDown bool
 }
 
 // NewTTSClient 创建新的 TTS 客户端
@@ -126,6 +287,16 @@ func NewTTSClient(opts ...ClientOption) (*TTSClient, error) {
 			config.BaseURL,
 		)
 	}
+	// 提前校验一次，固定 boundary 写错时在创建客户端时就报错
+	if config.MultipartBoundary != nil {
+		if boundary := config.MultipartBoundary(); !IsValidMultipartBoundary(boundary) {
+			return nil, NewValidationException(
+				fmt.Sprintf("Invalid multipart boundary: %q", boundary),
+				"multipart_boundary",
+				boundary,
+			)
+		}
+	}
 
 	if config.MaxConcurrent <= 0 {
 		config.MaxConcurrent = 10
@@ -133,27 +304,30 @@ func NewTTSClient(opts ...ClientOption) (*TTSClient, error) {
 	if config.Logger == nil {
 		config.Logger = &DefaultLogger{}
 	}
-	if config.Timeout <= 0 {
-		config.Timeout = 30 * time.Second
+	if config.ConnectTimeout <= 0 {
+		config.ConnectTimeout = defaultConnectTimeout
 	}
-
-	timeoutSeconds := int(math.Ceil(config.Timeout.Seconds()))
-	if timeoutSeconds <= 0 {
-		timeoutSeconds = 1
+	if config.RetryBackoffBase <= 0 {
+		config.RetryBackoffBase = defaultRetryBackoffBase
+	}
+	if config.RetryBackoffMax <= 0 {
+		config.RetryBackoffMax = defaultRetryBackoffMax
+	}
+	if config.RetryJitter < 0 {
+		config.RetryJitter = 0
 	}
+
+	connectTimeoutMillis := int(math.Ceil(float64(config.ConnectTimeout) / float64(time.Millisecond)))
+	// 同一客户端的所有并发请求共享这个 jar；tls-client 的 cookieJar 内部以 RWMutex 保护读写，
+	// 可以安全并发使用，这里不再额外加锁（换用其他 jar 实现时需同样保证并发安全）
 	jar := tls_client.NewCookieJar()
 
-	clientProfileList := []profiles.ClientProfile{
-		profiles.Safari_IOS_18_0,
-		profiles.Chrome_133,
-		profiles.Safari_IOS_17_0,
-		profiles.Chrome_131,
-		profiles.Firefox_135,
-		profiles.Safari_Ipad_15_6,
+	profile, err := resolveClientProfile(config.ClientProfile)
+	if err != nil {
+		return nil, err
 	}
-	profile := clientProfileList[rand.Intn(len(clientProfileList))]
 	tlsOptions := []tls_client.HttpClientOption{
-		tls_client.WithTimeoutSeconds(timeoutSeconds),
+		tls_client.WithTimeoutMilliseconds(connectTimeoutMillis),
 		tls_client.WithClientProfile(profile),
 		tls_client.WithNotFollowRedirects(),
 		tls_client.WithCookieJar(jar),
@@ -168,6 +342,21 @@ func NewTTSClient(opts ...ClientOption) (*TTSClient, error) {
 		tlsOptions = append(tlsOptions, tls_client.WithProxyUrl(strings.TrimSpace(config.ProxyURL)))
 	}
 
+	hostMapping, err := normalizeStaticHostMapping(config.StaticHostMapping)
+	if err != nil {
+		return nil, err
+	}
+	if len(hostMapping) > 0 {
+		if strings.TrimSpace(config.ProxyURL) != "" {
+			return nil, NewValidationException(
+				"Static host mapping cannot be combined with a proxy",
+				"static_host_mapping",
+				config.ProxyURL,
+			)
+		}
+		tlsOptions = append(tlsOptions, tls_client.WithProxyDialerFactory(staticHostDialerFactory(hostMapping)))
+	}
+
 	httpClient, err := tls_client.NewHttpClient(tls_client.NewNoopLogger(), tlsOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create tls client: %w", err)
@@ -179,12 +368,42 @@ func NewTTSClient(opts ...ClientOption) (*TTSClient, error) {
 		semaphore:  make(chan struct{}, config.MaxConcurrent),
 		logger:     config.Logger,
 	}
+	client.rootCtx, client.rootCancel = context.WithCancel(context.Background())
+	if config.CacheSize > 0 {
+		client.cache = newResponseCache(config.CacheSize, config.CacheTTL, config.Timeout)
+	}
 
 	client.logger.Info("Initialized TTS client with base URL: %s", config.BaseURL)
 
 	return client, nil
 }
 
+// resolveClientProfile 按名称查找 TLS 指纹；名称为空时从常用指纹中随机选择
+func resolveClientProfile(name string) (profiles.ClientProfile, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		clientProfileList := []profiles.ClientProfile{
+			profiles.Safari_IOS_18_0,
+			profiles.Chrome_133,
+			profiles.Safari_IOS_17_0,
+			profiles.Chrome_131,
+			profiles.Firefox_135,
+			profiles.Safari_Ipad_15_6,
+		}
+		return clientProfileList[rand.Intn(len(clientProfileList))], nil
+	}
+
+	profile, ok := profiles.MappedTLSClients[name]
+	if !ok {
+		return profiles.ClientProfile{}, NewValidationException(
+			fmt.Sprintf("Unknown client profile: %s", name),
+			"client_profile",
+			name,
+		)
+	}
+	return profile, nil
+}
+
 // ClientOption 客户端选项函数类型
 type ClientOption func(*ClientConfig)
 
@@ -202,13 +421,22 @@ func WithAPIKey(key string) ClientOption {
 	}
 }
 
-// WithTimeout 设置超时
+// WithTimeout 设置每次 GenerateSpeech* 调用的整体截止时间（见 ClientConfig.Timeout），
+// 与 WithConnectTimeout 的套接字超时相互独立：长文本任务需要较长的整体时间，
+// 但单个分段的连接/请求仍按 ConnectTimeout 快速失败并重试
 func WithTimeout(timeout time.Duration) ClientOption {
 	return func(c *ClientConfig) {
 		c.Timeout = timeout
 	}
 }
 
+// WithConnectTimeout 设置 tls-client 的套接字超时（默认 30s），作用于每次上游连接与单次 HTTP 调用
+func WithConnectTimeout(timeout time.Duration) ClientOption {
+	return func(c *ClientConfig) {
+		c.ConnectTimeout = timeout
+	}
+}
+
 // WithMaxRetries 设置最大重试次数
 func WithMaxRetries(retries int) ClientOption {
 	return func(c *ClientConfig) {
@@ -216,6 +444,29 @@ func WithMaxRetries(retries int) ClientOption {
 	}
 }
 
+// WithRetryBackoff 设置重试退避的基础延迟与上限（秒，默认 1 与 60），<=0 时使用默认值
+func WithRetryBackoff(base, max float64) ClientOption {
+	return func(c *ClientConfig) {
+		c.RetryBackoffBase = base
+		c.RetryBackoffMax = max
+	}
+}
+
+// WithRetryJitter 设置重试退避的抖动比例（默认 0.3，即附加 10%~30%），0 关闭抖动以得到确定的延迟
+func WithRetryJitter(fraction float64) ClientOption {
+	return func(c *ClientConfig) {
+		c.RetryJitter = fraction
+	}
+}
+
+// WithRetryableStatus 自定义哪些上游状态码需要重试（如重试 408/425、对 409 快速失败）；
+// 不重试的状态码仍会读取响应体并返回对应的类型化异常
+func WithRetryableStatus(retryable func(status int) bool) ClientOption {
+	return func(c *ClientConfig) {
+		c.RetryableStatus = retryable
+	}
+}
+
 // WithMaxConcurrent 设置最大并发数
 func WithMaxConcurrent(concurrent int) ClientOption {
 	return func(c *ClientConfig) {
@@ -237,14 +488,133 @@ func WithLogger(logger Logger) ClientOption {
 	}
 }
 
-// SetProxy 动态设置代理
+// WithDefaultVibe 设置默认 vibe（请求级 WithVibe 优先）
+func WithDefaultVibe(vibe string) ClientOption {
+	return func(c *ClientConfig) {
+		c.DefaultVibe = vibe
+	}
+}
+
+// WithSourceText 在批量/长文本响应中保留每段的原始文本（用于日志、调试、字幕生成）
+func WithSourceText(enabled bool) ClientOption {
+	return func(c *ClientConfig) {
+		c.RecordSourceText = enabled
+	}
+}
+
+// WithMarkdownStripping 输入按 Markdown 处理：去掉标题/强调/代码等标记后再朗读
+func WithMarkdownStripping(enabled bool) ClientOption {
+	return func(c *ClientConfig) {
+		c.StripMarkdown = enabled
+	}
+}
+
+// WithStrictFormat 启用严格格式模式（见 ClientConfig.StrictFormat）
+func WithStrictFormat(enabled bool) ClientOption {
+	return func(c *ClientConfig) {
+		c.StrictFormat = enabled
+	}
+}
+
+// WithTextPreprocessor 设置输入文本的预处理函数（如展开缩写、数字转读法），
+// 在 GenerateSpeechStream 与各长文本方法中先于 SanitizeText 与 SplitTextByLength 执行；
+// 返回错误时请求以 ValidationException 失败（Cause 为原始错误）
+func WithTextPreprocessor(fn func(string) (string, error)) ClientOption {
+	return func(c *ClientConfig) {
+		c.TextPreprocessor = fn
+	}
+}
+
+// WithMultipartBoundary 固定上游表单的 multipart boundary（须符合 RFC 2046：1-70 个合法字符）。
+// 固定值可被输入文本预知，主要用于测试；字段内容包含该 boundary 时请求会以 ValidationException 拒绝。
+// 需要模拟浏览器时请使用 WithMultipartBoundaryGenerator(WebKitFormBoundary)
+func WithMultipartBoundary(boundary string) ClientOption {
+	return func(c *ClientConfig) {
+		c.MultipartBoundary = func() string { return boundary }
+	}
+}
+
+// WithMultipartBoundaryGenerator 每次请求调用 gen 生成 boundary，例如 WebKitFormBoundary
+func WithMultipartBoundaryGenerator(gen func() string) ClientOption {
+	return func(c *ClientConfig) {
+		c.MultipartBoundary = gen
+	}
+}
+
+// WithClientProfile 固定 TLS 指纹（如 "chrome_133"、"firefox_135"），便于复现上游拒绝的请求
+func WithClientProfile(name string) ClientOption {
+	return func(c *ClientConfig) {
+		c.ClientProfile = name
+	}
+}
+
+// WithUserAgent 固定所有请求的 User-Agent
+func WithUserAgent(ua string) ClientOption {
+	return func(c *ClientConfig) {
+		c.UserAgent = ua
+	}
+}
+
+// WithCache 启用响应缓存：相同参数的请求直接返回缓存音频，并发的相同请求只触发一次上游调用。
+// size 为最大条目数，ttl<=0 表示不过期。启用后每个响应会先完整读入内存再返回。
+func WithCache(size int, ttl time.Duration) ClientOption {
+	return func(c *ClientConfig) {
+		c.CacheSize = size
+		c.CacheTTL = ttl
+	}
+}
+
+// WithStaticHostMapping 将 host 固定解析到给定 IP（按顺序尝试），减少 DNS 查询；
+// 多次调用可映射多个主机。与代理互斥。
+func WithStaticHostMapping(host string, ips ...string) ClientOption {
+	return func(c *ClientConfig) {
+		if c.StaticHostMapping == nil {
+			c.StaticHostMapping = make(map[string][]string)
+		}
+		c.StaticHostMapping[host] = append([]string(nil), ips...)
+	}
+}
+
+// WithUpstreamObserver 设置上游调用统计回调（在请求协程中同步执行，应尽快返回）
+func WithUpstreamObserver(observe func(UpstreamStats)) ClientOption {
+	return func(c *ClientConfig) {
+		c.UpstreamObserver = observe
+	}
+}
+
+// WithRetryCallback 设置重试回调（在请求协程中同步执行），可用于自定义指标/告警
+func WithRetryCallback(callback func(attempt int, err error, delay time.Duration)) ClientOption {
+	return func(c *ClientConfig) {
+		c.RetryCallback = callback
+	}
+}
+
+// WithRequestSigner 设置请求签名函数，用于需要自定义鉴权（如 HMAC 签名）的上游；
+// 返回错误时请求不会发出，也不会重试
+func WithRequestSigner(signer func(req *http.Request, body []byte) error) ClientOption {
+	return func(c *ClientConfig) {
+		c.RequestSigner = signer
+	}
+}
+
+// SetProxy 动态设置代理，可与进行中的请求并发调用：会等待正在发出的请求拿到响应头后再切换，
+// 之后发出的请求（包括重试）使用新代理
 func (c *TTSClient) SetProxy(proxyURL string) error {
+	c.proxyMu.Lock()
+	defer c.proxyMu.Unlock()
 	return c.httpClient.SetProxy(strings.TrimSpace(proxyURL))
 }
 
-// ClearProxy 清除代理
+// ClearProxy 清除代理（并发安全，同 SetProxy）
 func (c *TTSClient) ClearProxy() error {
-	return c.httpClient.SetProxy("")
+	return c.SetProxy("")
+}
+
+// doHTTP 在代理读锁下发出请求，防止 SetProxy 在请求选择传输层时替换它
+func (c *TTSClient) doHTTP(req *http.Request) (*http.Response, error) {
+	c.proxyMu.RLock()
+	defer c.proxyMu.RUnlock()
+	return c.httpClient.Do(req)
 }
 
 // GenerateSpeech 生成语音（保留原有方法以保持兼容性）
@@ -270,9 +640,26 @@ func (c *TTSClient) GenerateSpeech(ctx context.Context, text string, opts ...Req
 	}, nil
 }
 
+// sanitizeInput 按客户端配置预处理输入（自定义预处理、可选去除 Markdown）后再执行 SanitizeText
+func (c *TTSClient) sanitizeInput(text string) (string, error) {
+	if preprocess := c.config.TextPreprocessor; preprocess != nil {
+		processed, err := preprocess(text)
+		if err != nil {
+			validationErr := NewValidationException("Text preprocessor failed", "input", TruncateString(text, 50))
+			validationErr.Cause = err
+			return "", validationErr
+		}
+		text = processed
+	}
+	if c.config.StripMarkdown {
+		text = StripMarkdown(text)
+	}
+	return SanitizeText(text)
+}
+
 // GenerateSpeechStream 生成语音并返回流式响应
 func (c *TTSClient) GenerateSpeechStream(ctx context.Context, text string, opts ...RequestOption) (*TTSStreamResponse, error) {
-	sanitizedText, err := SanitizeText(text)
+	sanitizedText, err := c.sanitizeInput(text)
 	if err != nil {
 		return nil, err
 	}
@@ -282,7 +669,88 @@ func (c *TTSClient) GenerateSpeechStream(ctx context.Context, text string, opts
 		return nil, err
 	}
 
-	return c.makeStreamRequest(ctx, request)
+	return c.GenerateSpeechFromRequestStream(ctx, request)
+}
+
+// Ping 通过当前代理与 TLS 指纹向 BaseURL 发送 HEAD 请求，确认上游可达（用于就绪检查）。
+// 连接失败或上游返回 5xx 时返回 NetworkException；不占用并发名额。
+func (c *TTSClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodHead, c.config.BaseURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+
+	var headers map[string]string
+	if ua := strings.TrimSpace(c.config.UserAgent); ua != "" {
+		headers = GetRealisticHeadersWithUserAgent(ua)
+	} else {
+		headers = GetRealisticHeaders()
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.doHTTP(req)
+	if err != nil {
+		return NewNetworkException(fmt.Sprintf("Upstream unreachable: %v", err), 0)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return NewNetworkException(fmt.Sprintf("Upstream returned status %d", resp.StatusCode), 0)
+	}
+	return nil
+}
+
+// GenerateSpeechToFile 生成语音并直接流式写入文件，不在内存中缓冲整段音频。
+// 扩展名按实际返回的格式修正（同 TTSResponse.SaveToFile），返回最终路径；
+// 写入中途失败时删除不完整的文件。
+func (c *TTSClient) GenerateSpeechToFile(ctx context.Context, text, filename string, opts ...RequestOption) (string, error) {
+	streamResp, err := c.GenerateSpeechStream(ctx, text, opts...)
+	if err != nil {
+		return "", err
+	}
+	defer streamResp.Close()
+
+	finalFilename, _, err := streamResp.SaveToFile(ctx, filename)
+	return finalFilename, err
+}
+
+// GenerateSpeechStreamFunc 生成语音并按缓冲区大小分段回调 onChunk（适合逐段处理音频的管线）。
+// onChunk 收到的切片在回调返回后会被复用，需要保留时请自行拷贝；
+// onChunk 返回错误时立即取消上游请求并返回该错误。
+func (c *TTSClient) GenerateSpeechStreamFunc(
+	ctx context.Context,
+	text string,
+	onChunk func([]byte) error,
+	opts ...RequestOption,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	streamResp, err := c.GenerateSpeechStream(ctx, text, opts...)
+	if err != nil {
+		return err
+	}
+	defer streamResp.Close()
+
+	buf := make([]byte, defaultStreamFuncBufferSize)
+	for {
+		n, readErr := streamResp.Body.Read(buf)
+		if n > 0 {
+			if err := onChunk(buf[:n]); err != nil {
+				cancel()
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read audio data: %w", readErr)
+		}
+	}
 }
 
 // GenerateSpeechLongText 处理长文本生成语音
@@ -293,16 +761,26 @@ func (c *TTSClient) GenerateSpeechLongText(
 	preserveWords bool,
 	opts ...RequestOption,
 ) ([]*TTSResponse, error) {
-	cleanText, err := SanitizeText(text)
+	ctx, cancel := c.withDeadline(ctx)
+	defer cancel()
+
+	cleanText, err := c.sanitizeInput(text)
 	if err != nil {
 		return nil, err
 	}
 
+	maxLength = c.clampChunkLength(maxLength)
 	chunks := SplitTextByLength(cleanText, maxLength, preserveWords)
 	if len(chunks) == 0 {
 		return nil, fmt.Errorf("no valid text chunks found after processing")
 	}
 
+	if overlap := c.config.ContextOverlap; overlap > 0 && preserveWords && len(chunks) > 1 {
+		// 为上下文句子预留长度，保证 "上下文 + 本段" 仍不超过 maxLength
+		chunks = SplitTextByLength(cleanText, overlapSplitLength(maxLength), preserveWords)
+		return c.generateLongTextWithOverlap(ctx, chunks, maxLength, overlap, opts)
+	}
+
 	requests := make([]*TTSRequest, len(chunks))
 	for i, chunk := range chunks {
 		req, err := NewTTSRequest(chunk, append(opts, WithoutLengthValidation())...)
@@ -325,12 +803,22 @@ func (c *TTSClient) GenerateSpeechLongTextStream(
 	maxLength int,
 	preserveWords bool,
 	opts ...RequestOption,
-) (*TTSStreamResponse, error) {
-	cleanText, err := SanitizeText(text)
+) (result *TTSStreamResponse, err error) {
+	ctx, cancel := c.withDeadline(ctx)
+	defer func() {
+		if err != nil {
+			cancel()
+			return
+		}
+		result = deadlineStream(result, cancel)
+	}()
+
+	cleanText, err := c.sanitizeInput(text)
 	if err != nil {
 		return nil, err
 	}
 
+	maxLength = c.clampChunkLength(maxLength)
 	chunks := SplitTextByLength(cleanText, maxLength, preserveWords)
 	if len(chunks) == 0 {
 		return nil, fmt.Errorf("no valid text chunks found after processing")
@@ -362,15 +850,39 @@ func (c *TTSClient) GenerateSpeechLongTextStream(
 	go func() {
 		defer pipeWriter.Close()
 
+		// Ogg Opus 需要跨 chunk 重写页序号/granule，chunk 0 也要经过同一个 state
+		var opusState *OggOpusStreamState
+		if out.Format == FormatOPUS {
+			opusState = &OggOpusStreamState{}
+		}
+
 		writeErr := func() error {
-			// chunk 0：完整写入（包含容器头/ID3）
-			_, err := io.Copy(pipeWriter, firstResp.Body)
+			// chunk 0：完整写入（包含容器头/ID3）；WAV 时取出基准头，后续 chunk 的采样格式必须与之一致
+			var firstBody io.Reader = firstResp.Body
+			var wavHeader *WAVHeader
+			if out.Format == FormatWAV {
+				br := bufio.NewReaderSize(firstResp.Body, wavHeaderPeekSize)
+				wavHeader = peekWAVHeader(br)
+				if wavHeader == nil && c.strictFormat(firstReq) {
+					_ = firstResp.Close()
+					return fmt.Errorf("chunk 0: %w", ErrNotWAV)
+				}
+				firstBody = br
+			}
+
+			var err error
+			if opusState != nil {
+				_, err = CopyOggOpusDataStream(pipeWriter, firstBody, opusState)
+			} else {
+				_, err = io.Copy(pipeWriter, firstBody)
+			}
 			_ = firstResp.Close()
 			if err != nil {
 				return err
 			}
 
 			// chunk >= 1：根据格式做“跳头/跳标签”处理
+			var wavBuf []byte
 			for i := 1; i < len(chunks); i++ {
 				req, err := NewTTSRequest(chunks[i], append(opts, WithoutLengthValidation())...)
 				if err != nil {
@@ -387,7 +899,12 @@ func (c *TTSClient) GenerateSpeechLongTextStream(
 				case FormatMP3:
 					_, copyErr = CopyMP3Stream(pipeWriter, sr.Body, true)
 				case FormatWAV:
-					_, copyErr = CopyWAVDataStream(pipeWriter, sr.Body)
+					if wavBuf == nil {
+						wavBuf = make([]byte, defaultLongTextStreamChunkBufferSize)
+					}
+					_, copyErr = copyWAVDataMatching(pipeWriter, sr.Body, wavBuf, wavHeader, c.strictFormat(firstReq))
+				case FormatOPUS:
+					_, copyErr = CopyOggOpusDataStream(pipeWriter, sr.Body, opusState)
 				default:
 					_, copyErr = io.Copy(pipeWriter, sr.Body)
 				}
@@ -398,6 +915,12 @@ func (c *TTSClient) GenerateSpeechLongTextStream(
 				}
 			}
 
+			if opusState != nil {
+				if _, err := opusState.Flush(pipeWriter); err != nil {
+					return err
+				}
+			}
+
 			return nil
 		}()
 
@@ -409,6 +932,40 @@ func (c *TTSClient) GenerateSpeechLongTextStream(
 	return out, nil
 }
 
+// countingWriter 统计写出的字节数，用于不缓存数据的进度回调
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// progressReadCloser 单 chunk 透传时统计读出的字节数，读到结尾时触发一次 OnChunkProgress；
+// 回调返回错误时以该错误代替 io.EOF
+type progressReadCloser struct {
+	io.ReadCloser
+	text  string
+	onEOF func(ChunkProgress) error
+	n     int64
+	done  bool
+}
+
+func (p *progressReadCloser) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.n += int64(n)
+	if err == io.EOF && !p.done {
+		p.done = true
+		if cbErr := p.onEOF(ChunkProgress{Index: 0, Total: 1, Text: p.text, Bytes: p.n}); cbErr != nil {
+			return n, cbErr
+		}
+	}
+	return n, err
+}
+
 // GenerateSpeechLongTextStreamConcurrent 并发流式处理长文本（按序输出，流式零 ReadAll，小缓存）
 //
 // - 为单个长文本请求限制并发数（默认 3）
@@ -421,7 +978,16 @@ func (c *TTSClient) GenerateSpeechLongTextStreamConcurrent(
 	preserveWords bool,
 	config *LongTextStreamConfig,
 	opts ...RequestOption,
-) (*TTSStreamResponse, error) {
+) (result *TTSStreamResponse, err error) {
+	ctx, cancelDeadline := c.withDeadline(ctx)
+	defer func() {
+		if err != nil {
+			cancelDeadline()
+			return
+		}
+		result = deadlineStream(result, cancelDeadline)
+	}()
+
 	if config == nil {
 		config = DefaultLongTextStreamConfig()
 	}
@@ -431,22 +997,45 @@ func (c *TTSClient) GenerateSpeechLongTextStreamConcurrent(
 		bufSize = defaultLongTextStreamChunkBufferSize
 	}
 
-	cleanText, err := SanitizeText(text)
+	acquireTimeout := config.AcquireTimeout
+	if acquireTimeout <= 0 {
+		acquireTimeout = defaultLongTextStreamAcquireTimeout
+	}
+
+	cleanText, err := c.sanitizeInput(text)
 	if err != nil {
 		return nil, err
 	}
 
+	maxLength = c.clampChunkLength(maxLength)
 	chunks := SplitTextByLength(cleanText, maxLength, preserveWords)
 	if len(chunks) == 0 {
 		return nil, fmt.Errorf("no valid text chunks found after processing")
 	}
 
-	if len(chunks) == 1 {
+	// 单个 chunk 直接返回上游流；设置了 OnChunk 时仍走下面的通用路径以触发回调
+	if len(chunks) == 1 && config.OnChunk == nil {
 		req, err := NewTTSRequest(chunks[0], append(opts, WithoutLengthValidation())...)
 		if err != nil {
 			return nil, err
 		}
-		return c.GenerateSpeechFromRequestStream(ctx, req)
+		streamResp, err := c.makeStreamRequestWithAcquireTimeout(ctx, req, acquireTimeout)
+		if err != nil {
+			return nil, err
+		}
+		// 与多段路径保持一致，调用方可以统一读取 chunks_total
+		if streamResp.Metadata == nil {
+			streamResp.Metadata = make(map[string]string)
+		}
+		streamResp.Metadata["chunks_total"] = "1"
+		if config.OnChunkProgress != nil {
+			streamResp.Body = &progressReadCloser{
+				ReadCloser: streamResp.Body,
+				text:       chunks[0],
+				onEOF:      config.OnChunkProgress,
+			}
+		}
+		return streamResp, nil
 	}
 
 	maxConc := config.MaxConcurrent
@@ -487,7 +1076,7 @@ func (c *TTSClient) GenerateSpeechLongTextStreamConcurrent(
 		return nil, fmt.Errorf("failed to create request for chunk 0: %w", err)
 	}
 
-	firstResp, err := c.GenerateSpeechFromRequestStream(ctx, firstReq)
+	firstResp, err := c.makeStreamRequestWithAcquireTimeout(ctx, firstReq, acquireTimeout)
 	if err != nil {
 		cancel()
 		for i := 1; i < len(chunks); i++ {
@@ -511,6 +1100,24 @@ func (c *TTSClient) GenerateSpeechLongTextStreamConcurrent(
 	var bufPool sync.Pool
 	bufPool.New = func() any { return make([]byte, bufSize) }
 
+	// WAV：后续 chunk 只拼接 PCM 数据，必须与 chunk0 的采样格式一致，先从 chunk0 取出基准头
+	var firstBody io.Reader = firstResp.Body
+	var wavHeader *WAVHeader
+	if firstResp.Format == FormatWAV && !config.RawConcat {
+		br := bufio.NewReaderSize(firstResp.Body, wavHeaderPeekSize)
+		firstBody = br
+		wavHeader = peekWAVHeader(br)
+		if wavHeader == nil && c.strictFormat(firstReq) {
+			_ = firstResp.Close()
+			_ = outReader.Close()
+			cancel()
+			for i := 1; i < len(chunks); i++ {
+				_ = pipes[i].r.Close()
+			}
+			return nil, fmt.Errorf("chunk 0: %w", ErrNotWAV)
+		}
+	}
+
 	jobs := make(chan int)
 	var wg sync.WaitGroup
 
@@ -545,7 +1152,7 @@ func (c *TTSClient) GenerateSpeechLongTextStreamConcurrent(
 					return
 				}
 
-				sr, err := c.GenerateSpeechFromRequestStream(ctx, req)
+				sr, err := c.makeStreamRequestWithAcquireTimeout(ctx, req, acquireTimeout)
 				if err != nil {
 					_ = pw.CloseWithError(fmt.Errorf("chunk %d: %w", idx, err))
 					cancel()
@@ -556,11 +1163,19 @@ func (c *TTSClient) GenerateSpeechLongTextStreamConcurrent(
 
 				var copyErr error
 				// 使用实际返回的格式，而不是 out.Format
-				switch sr.Format {
-				case FormatMP3:
-					_, copyErr = CopyMP3StreamWithBuffer(pw, sr.Body, true, buf)
-				case FormatWAV:
-					_, copyErr = CopyWAVDataStreamWithBuffer(pw, sr.Body, buf)
+				switch {
+				case config.RawConcat:
+					_, copyErr = io.CopyBuffer(pw, sr.Body, buf)
+				case c.strictFormat(firstReq) && out.Format == FormatWAV:
+					// 严格模式按字节校验，不信任分段自己声明的格式
+					_, copyErr = copyWAVDataMatching(pw, sr.Body, buf, wavHeader, true)
+				case sr.Format == FormatMP3:
+					_, copyErr = CopyMP3StreamTrimmed(pw, sr.Body, true, config.TrimLeadingFrames, buf)
+				case sr.Format == FormatWAV:
+					_, copyErr = copyWAVDataMatching(pw, sr.Body, buf, wavHeader, false)
+				case sr.Format == FormatOPUS:
+					// Ogg 页的序号/granule 依赖前序 chunk，由输出协程按序改写，这里原样转发
+					_, copyErr = io.CopyBuffer(pw, sr.Body, buf)
 				default:
 					_, copyErr = io.CopyBuffer(pw, sr.Body, buf)
 				}
@@ -611,8 +1226,61 @@ func (c *TTSClient) GenerateSpeechLongTextStreamConcurrent(
 		buf := bufPool.Get().([]byte)
 		defer bufPool.Put(buf)
 
+		var opusState *OggOpusStreamState
+		if out.Format == FormatOPUS && !config.RawConcat {
+			opusState = &OggOpusStreamState{}
+		}
+		var offset int64
+		copyChunk := func(r io.Reader, idx int) error {
+			counter := &countingWriter{w: outWriter}
+			var dst io.Writer = counter
+			var chunkBuf *bytes.Buffer
+			if config.OnChunk != nil {
+				chunkBuf = &bytes.Buffer{}
+				dst = io.MultiWriter(counter, chunkBuf)
+			}
+
+			var err error
+			if opusState != nil {
+				_, err = CopyOggOpusDataStream(dst, r, opusState)
+				// 最后一页延迟到 Flush 才写出，最后一个 chunk 需要在回调前刷出
+				if err == nil && idx == len(chunks)-1 {
+					_, err = opusState.Flush(dst)
+				}
+			} else {
+				_, err = io.CopyBuffer(dst, r, buf)
+			}
+			if err != nil {
+				return err
+			}
+
+			start := offset
+			offset += counter.n
+			if config.OnChunkProgress != nil {
+				if err := config.OnChunkProgress(ChunkProgress{
+					Index:  idx,
+					Total:  len(chunks),
+					Text:   chunks[idx],
+					Bytes:  counter.n,
+					Offset: start,
+				}); err != nil {
+					return err
+				}
+			}
+			if config.OnChunk != nil {
+				return config.OnChunk(ChunkResult{
+					Index:  idx,
+					Total:  len(chunks),
+					Text:   chunks[idx],
+					Data:   chunkBuf.Bytes(),
+					Offset: start,
+				})
+			}
+			return nil
+		}
+
 		// 写 chunk0（完整输出）
-		_, err := io.CopyBuffer(outWriter, firstResp.Body, buf)
+		err := copyChunk(firstBody, 0)
 		_ = firstResp.Close()
 		if err != nil {
 			fail(fmt.Errorf("chunk 0 write: %w", err))
@@ -625,7 +1293,7 @@ func (c *TTSClient) GenerateSpeechLongTextStreamConcurrent(
 				fail(fmt.Errorf("chunk %d pipe missing", i))
 				return
 			}
-			_, err := io.CopyBuffer(outWriter, pipes[i].r, buf)
+			err := copyChunk(pipes[i].r, i)
 			_ = pipes[i].r.Close()
 			if err != nil {
 				fail(fmt.Errorf("chunk %d write: %w", i, err))
@@ -647,6 +1315,54 @@ func (c *TTSClient) GenerateSpeechBatch(ctx context.Context, requests []*TTSRequ
 		return nil, nil
 	}
 
+	responses, errs := c.runBatch(ctx, requests, true)
+
+	// 优先报告真正失败的请求，而不是因其失败被连带取消的请求
+	failed := -1
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if failed < 0 || (errors.Is(errs[failed], context.Canceled) && !errors.Is(err, context.Canceled)) {
+			failed = i
+		}
+	}
+	if failed >= 0 {
+		return nil, fmt.Errorf("request %d failed: %w", failed, errs[failed])
+	}
+
+	return responses, nil
+}
+
+// GenerateSpeechBatchPartial 与 GenerateSpeechBatch 相同，但单个请求失败不会取消其他请求：
+// 返回的切片与 requests 按下标对齐，失败项为 nil；有失败时同时返回 *BatchError
+func (c *TTSClient) GenerateSpeechBatchPartial(ctx context.Context, requests []*TTSRequest) ([]*TTSResponse, error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+
+	responses, errs := c.runBatch(ctx, requests, false)
+	for _, err := range errs {
+		if err != nil {
+			return responses, &BatchError{Errors: errs}
+		}
+	}
+
+	return responses, nil
+}
+
+// GenerateSpeechBatchSettled 执行全部请求直至完成，单个失败不影响其他请求；
+// 返回与 requests 按下标对齐的两个切片：成功项的 errs[i] 为 nil，失败项的 responses[i] 为 nil
+func (c *TTSClient) GenerateSpeechBatchSettled(ctx context.Context, requests []*TTSRequest) ([]*TTSResponse, []error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	return c.runBatch(ctx, requests, false)
+}
+
+// runBatch 以固定 worker 数执行批量请求，结果与错误均按下标对齐；
+// failFast 为 true 时任一请求失败即取消其余请求
+func (c *TTSClient) runBatch(ctx context.Context, requests []*TTSRequest, failFast bool) ([]*TTSResponse, []error) {
 	workerCount := c.config.MaxConcurrent
 	if workerCount <= 0 {
 		workerCount = 1
@@ -655,7 +1371,7 @@ func (c *TTSClient) GenerateSpeechBatch(ctx context.Context, requests []*TTSRequ
 		workerCount = len(requests)
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
+	ctx, cancel := c.withDeadline(ctx)
 	defer cancel()
 
 	type job struct {
@@ -675,26 +1391,30 @@ func (c *TTSClient) GenerateSpeechBatch(ctx context.Context, requests []*TTSRequ
 			defer wg.Done()
 			for j := range jobs {
 				if ctx.Err() != nil {
-					return
+					errs[j.index] = ctx.Err()
+					continue
 				}
 				resp, err := c.GenerateSpeechFromRequest(ctx, j.request)
 				if err != nil {
 					errs[j.index] = err
-					cancel()
-					return
+					if failFast {
+						cancel()
+					}
+					continue
 				}
 				responses[j.index] = resp
 			}
 		}()
 	}
 
+	dispatched := 0
 	for i, req := range requests {
-		if ctx.Err() != nil {
-			break
-		}
 		select {
 		case jobs <- job{index: i, request: req}:
+			dispatched = i + 1
 		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
 			break
 		}
 	}
@@ -702,17 +1422,85 @@ func (c *TTSClient) GenerateSpeechBatch(ctx context.Context, requests []*TTSRequ
 
 	wg.Wait()
 
-	for i, err := range errs {
-		if err != nil {
-			return nil, fmt.Errorf("request %d failed: %w", i, err)
+	// 未派发的请求（上下文已取消）同样记录错误，保证下标对齐
+	for i := dispatched; i < len(requests); i++ {
+		if errs[i] == nil && responses[i] == nil {
+			errs[i] = ctx.Err()
 		}
 	}
 
-	return responses, nil
+	return responses, errs
+}
+
+// BatchResult GenerateSpeechBatchChan 的单个结果，Index 为请求在 requests 中的下标
+type BatchResult struct {
+	Index    int
+	Response *TTSResponse
+	Err      error
+}
+
+// GenerateSpeechBatchChan 以与 GenerateSpeechBatch 相同的 worker 数执行批量请求，
+// 每个请求完成后立即投递结果（按完成顺序，而非下标顺序），单个失败不会取消其他请求。
+// 全部完成或 ctx 取消后关闭 channel；取消时尚未派发的请求不会产生结果。
+// channel 带有足够缓冲，调用方提前停止读取也不会阻塞 worker
+func (c *TTSClient) GenerateSpeechBatchChan(ctx context.Context, requests []*TTSRequest) <-chan BatchResult {
+	out := make(chan BatchResult, len(requests))
+	if len(requests) == 0 {
+		close(out)
+		return out
+	}
+
+	workerCount := c.config.MaxConcurrent
+	if workerCount <= 0 {
+		workerCount = 1
+	}
+	if workerCount > len(requests) {
+		workerCount = len(requests)
+	}
+
+	ctx, cancel := c.withDeadline(ctx)
+
+	type job struct {
+		index   int
+		request *TTSRequest
+	}
+	jobs := make(chan job)
+
+	var wg sync.WaitGroup
+	wg.Add(workerCount)
+	for w := 0; w < workerCount; w++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				resp, err := c.GenerateSpeechFromRequest(ctx, j.request)
+				out <- BatchResult{Index: j.index, Response: resp, Err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(out)
+		defer cancel()
+	dispatch:
+		for i, req := range requests {
+			select {
+			case jobs <- job{index: i, request: req}:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		close(jobs)
+		wg.Wait()
+	}()
+
+	return out
 }
 
 // GenerateSpeechFromRequest 从请求对象生成语音
 func (c *TTSClient) GenerateSpeechFromRequest(ctx context.Context, request *TTSRequest) (*TTSResponse, error) {
+	ctx, cancel := c.withDeadline(ctx)
+	defer cancel()
+
 	streamResp, err := c.makeStreamRequest(ctx, request)
 	if err != nil {
 		return nil, err
@@ -724,25 +1512,112 @@ func (c *TTSClient) GenerateSpeechFromRequest(ctx context.Context, request *TTSR
 		return nil, fmt.Errorf("failed to read audio data: %w", err)
 	}
 
-	return &TTSResponse{
+	resp := &TTSResponse{
 		AudioData:   audioData,
 		ContentType: streamResp.ContentType,
 		Format:      streamResp.Format,
 		Size:        len(audioData),
 		Metadata:    streamResp.Metadata,
-	}, nil
+	}
+	if c.config.RecordSourceText {
+		resp.SourceText = request.Input
+	}
+	return resp, nil
 }
 
 // GenerateSpeechFromRequestStream 从请求对象生成语音流
 func (c *TTSClient) GenerateSpeechFromRequestStream(ctx context.Context, request *TTSRequest) (*TTSStreamResponse, error) {
-	return c.makeStreamRequest(ctx, request)
+	ctx, cancel := c.withDeadline(ctx)
+	streamResp, err := c.makeStreamRequest(ctx, request)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return deadlineStream(streamResp, cancel), nil
 }
 
 // makeStreamRequest 执行实际的 HTTP 请求并返回流式响应
 func (c *TTSClient) makeStreamRequest(ctx context.Context, request *TTSRequest) (*TTSStreamResponse, error) {
+	return c.makeStreamRequestWithAcquireTimeout(ctx, request, 0)
+}
+
+// makeStreamRequestWithAcquireTimeout 同 makeStreamRequest；acquireTimeout > 0 时，
+// 若在该时间内拿不到全局并发槽位则返回 BusyException
+func (c *TTSClient) makeStreamRequestWithAcquireTimeout(
+	ctx context.Context,
+	request *TTSRequest,
+	acquireTimeout time.Duration,
+) (*TTSStreamResponse, error) {
+	if c.cache == nil {
+		return c.fetchStreamRequest(ctx, request, acquireTimeout)
+	}
+
+	instructions, err := c.resolveInstructions(request)
+	if err != nil {
+		return nil, err
+	}
+	key := requestCacheKey(request, c.resolveVibe(request), instructions, c.strictFormat(request))
+	audio, hit, err := c.cache.do(ctx, key, func(fetchCtx context.Context) (*cachedAudio, error) {
+		sr, err := c.fetchStreamRequest(fetchCtx, request, acquireTimeout)
+		if err != nil {
+			return nil, err
+		}
+		defer sr.Close()
+
+		data, err := io.ReadAll(sr.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audio data: %w", err)
+		}
+		return &cachedAudio{
+			data:        data,
+			contentType: sr.ContentType,
+			format:      sr.Format,
+			metadata:    sr.Metadata,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hit {
+		c.loggerFor(ctx).Debug("Serving cached audio for text: '%s...'", TruncateString(request.Input, 50))
+	}
+	return audio.streamResponse(hit), nil
+}
+
+// fetchStreamRequest 不经过缓存，直接向上游发起请求
+func (c *TTSClient) fetchStreamRequest(
+	ctx context.Context,
+	request *TTSRequest,
+	acquireTimeout time.Duration,
+) (streamResp *TTSStreamResponse, err error) {
+	ctx, done, err := c.beginRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 成功时延迟到响应体关闭再登记结束，Shutdown 才能等到音频读完
+	defer func() {
+		if err != nil || streamResp == nil {
+			done()
+			return
+		}
+		streamResp.Body = &releasingBody{ReadCloser: streamResp.Body, release: done}
+	}()
+
+	var busy <-chan time.Time
+	if acquireTimeout > 0 {
+		timer := time.NewTimer(acquireTimeout)
+		defer timer.Stop()
+		busy = timer.C
+	}
+
 	select {
 	case c.semaphore <- struct{}{}:
 		defer func() { <-c.semaphore }()
+	case <-busy:
+		return nil, NewBusyException(
+			fmt.Sprintf("no concurrency slot available within %v (max concurrent %d)", acquireTimeout, cap(c.semaphore)),
+			acquireTimeout,
+		)
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
@@ -751,22 +1626,65 @@ func (c *TTSClient) makeStreamRequest(ctx context.Context, request *TTSRequest)
 
 	var body bytes.Buffer
 	writer := multipart.NewWriter(&body)
+	customBoundary := c.config.MultipartBoundary != nil
+	if customBoundary {
+		if err := writer.SetBoundary(c.config.MultipartBoundary()); err != nil {
+			return nil, NewValidationException(
+				fmt.Sprintf("Invalid multipart boundary: %v", err),
+				"multipart_boundary",
+				"",
+			)
+		}
+	}
 
+	instructions, err := c.resolveInstructions(request)
+	if err != nil {
+		return nil, err
+	}
 	formFields := map[string]string{
 		"input":           request.Input,
 		"voice":           string(request.Voice),
-		"generation":      uuid.New().String(),
-		"vibe":            "dramatic",
+		"generation":      generationID(request),
+		"vibe":            c.resolveVibe(request),
 		"response_format": string(request.ResponseFormat),
+		"prompt":          instructions,
+	}
+	if request.Speed != 0 {
+		formFields["speed"] = strconv.FormatFloat(request.Speed, 'f', -1, 64)
+	}
+	if request.Language != "" {
+		formFields["language"] = request.Language
+	}
+	if request.SampleRate != 0 {
+		formFields["sample_rate"] = strconv.Itoa(request.SampleRate)
+	}
+	if request.Bitrate != 0 {
+		formFields["bitrate"] = strconv.Itoa(request.Bitrate)
+	}
+	if request.Quality != "" {
+		formFields["quality"] = request.Quality
+	}
+	if request.Seed != nil {
+		formFields["seed"] = strconv.FormatInt(*request.Seed, 10)
 	}
 
-	if request.Instructions != "" {
-		formFields["prompt"] = request.Instructions
-	} else {
-		formFields["prompt"] = DefaultInstructions
+	for key, value := range request.ExtraFormFields {
+		if _, reserved := reservedFormFields[strings.ToLower(strings.TrimSpace(key))]; reserved {
+			c.loggerFor(ctx).Debug("Ignoring extra form field %q that would override a built-in field", key)
+			continue
+		}
+		formFields[key] = value
 	}
 
 	for key, value := range formFields {
+		// 自定义 boundary 可被预知，字段内容中出现它会截断表单（标准库的随机 boundary 无此问题）
+		if customBoundary && (strings.Contains(key, writer.Boundary()) || strings.Contains(value, writer.Boundary())) {
+			return nil, NewValidationException(
+				fmt.Sprintf("Form field %s contains the multipart boundary", key),
+				key,
+				TruncateString(value, 50),
+			)
+		}
 		if err := writer.WriteField(key, value); err != nil {
 			return nil, fmt.Errorf("failed to write form field %s: %w", key, err)
 		}
@@ -778,16 +1696,27 @@ func (c *TTSClient) makeStreamRequest(ctx context.Context, request *TTSRequest)
 
 	contentType := writer.FormDataContentType()
 
-	c.logger.Info("Generating speech for text: '%s...' with voice: %s",
-		truncateString(request.Input, 50), request.Voice)
+	c.loggerFor(ctx).Info("Generating speech for text: '%s...' with voice: %s",
+		TruncateString(request.Input, 50), request.Voice)
 
 	bodyBytes := body.Bytes()
 
+	attempts := 0
+	start := time.Now()
+	if observe := c.config.UpstreamObserver; observe != nil {
+		defer func() {
+			observe(UpstreamStats{Attempts: attempts, Duration: time.Since(start), Err: err})
+		}()
+	}
+
 	var lastErr error
 	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
 		if attempt > 0 {
-			delay := ExponentialBackoff(attempt-1, 1.0, 60.0)
-			c.logger.Info("Retrying request after %v (attempt %d)", delay, attempt+1)
+			delay := ExponentialBackoffWithJitter(attempt-1, c.config.RetryBackoffBase, c.config.RetryBackoffMax, c.config.RetryJitter)
+			c.loggerFor(ctx).Info("Retrying request after %v (attempt %d)", delay, attempt+1)
+			if c.config.RetryCallback != nil {
+				c.config.RetryCallback(attempt, lastErr, delay)
+			}
 
 			select {
 			case <-time.After(delay):
@@ -795,6 +1724,7 @@ func (c *TTSClient) makeStreamRequest(ctx context.Context, request *TTSRequest)
 				return nil, ctx.Err()
 			}
 		}
+		attempts++
 
 		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(bodyBytes))
 		if err != nil {
@@ -805,43 +1735,37 @@ func (c *TTSClient) makeStreamRequest(ctx context.Context, request *TTSRequest)
 			req = req.WithContext(ctx)
 		}
 
-		headers := GetRealisticHeaders()
-		for k, v := range headers {
-			req.Header.Set(k, v)
+		// Accept/Accept-Encoding 与请求头顺序都跟随所选 UA 的浏览器，避免指纹前后矛盾
+		ua := strings.TrimSpace(c.config.UserAgent)
+		if ua == "" {
+			ua = GetUserAgent()
 		}
-		if request.ResponseFormat == FormatMP3 {
-			req.Header.Set("Accept", "audio/mpeg")
-		} else {
-			req.Header.Set("Accept", "application/json, audio/*, */*;q=0.9")
+		for k, v := range GetRealisticHeadersWithUserAgent(ua) {
+			req.Header.Set(k, v)
 		}
-
 		req.Header.Set("Content-Type", contentType)
-		req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
 
 		if c.config.APIKey != "" {
 			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.APIKey))
 		}
 
-		req.Header[http.HeaderOrderKey] = []string{
-			"accept",
-			"accept-encoding",
-			"accept-language",
-			"cache-control",
-			"content-type",
-			"dnt",
-			"pragma",
-			"user-agent",
+		req.Header[http.HeaderOrderKey] = headerOrderFor(ua)
+
+		if c.config.RequestSigner != nil {
+			if err := c.config.RequestSigner(req, bodyBytes); err != nil {
+				return nil, fmt.Errorf("failed to sign request: %w", err)
+			}
 		}
 
-		resp, err := c.httpClient.Do(req)
+		resp, err := c.doHTTP(req)
 		if err != nil {
 			lastErr = NewNetworkException(fmt.Sprintf("Request error: %v", err), attempt)
-			c.logger.Warn("Request error, retrying...")
+			c.loggerFor(ctx).Warn("Request error, retrying...")
 			continue
 		}
 
 		if resp.StatusCode == http.StatusOK {
-			return c.processStreamResponse(resp, request)
+			return c.processStreamResponse(ctx, resp, request)
 		}
 
 		// 非成功状态码，需要读取响应体获取错误信息
@@ -857,13 +1781,12 @@ func (c *TTSClient) makeStreamRequest(ctx context.Context, request *TTSRequest)
 			fmt.Sprintf("TTS request failed with status %d", resp.StatusCode),
 		)
 
-		if resp.StatusCode == 400 || resp.StatusCode == 401 ||
-			resp.StatusCode == 403 || resp.StatusCode == 404 {
+		if !c.retryableStatus(resp.StatusCode) {
 			return nil, exception
 		}
 
 		lastErr = exception
-		c.logger.Warn("Request failed with status %d, retrying...", resp.StatusCode)
+		c.loggerFor(ctx).Warn("Request failed with status %d, retrying...", resp.StatusCode)
 	}
 
 	if lastErr != nil {
@@ -872,8 +1795,157 @@ func (c *TTSClient) makeStreamRequest(ctx context.Context, request *TTSRequest)
 	return nil, NewTTSException("Maximum retries exceeded")
 }
 
+// DefaultRetryableStatus 默认的重试判断：400/401/403/404 属于请求本身的问题，重试无意义，其余状态码都重试
+func DefaultRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return false
+	}
+	return true
+}
+
+// retryableStatus 按 ClientConfig.RetryableStatus（未设置时 DefaultRetryableStatus）判断是否重试
+func (c *TTSClient) retryableStatus(status int) bool {
+	if c.config.RetryableStatus != nil {
+		return c.config.RetryableStatus(status)
+	}
+	return DefaultRetryableStatus(status)
+}
+
+// strictFormat 请求级设置优先，其次客户端的 StrictFormat
+func (c *TTSClient) strictFormat(request *TTSRequest) bool {
+	if request != nil && request.StrictFormat != nil {
+		return *request.StrictFormat
+	}
+	return c.config.StrictFormat
+}
+
+// loggerFor 返回按 ctx 中请求级日志级别（ContextWithLogLevel）调整后的日志器
+func (c *TTSClient) loggerFor(ctx context.Context) Logger {
+	return LoggerForContext(ctx, c.logger)
+}
+
+// clampChunkLength 对长文本切分长度应用下限，并记录告警
+func (c *TTSClient) clampChunkLength(maxLength int) int {
+	clamped, changed := ClampChunkLength(maxLength)
+	if changed {
+		c.logger.Warn("maxLength %d is below minimum chunk length, using %d", maxLength, clamped)
+	}
+	return clamped
+}
+
+// reservedFormFields 上游表单的内置字段名；ExtraFormFields 中的同名字段一律忽略，
+// 即使本次请求没有设置该字段，也不能绕过内置字段的校验直接写入
+var reservedFormFields = map[string]struct{}{
+	"input":           {},
+	"voice":           {},
+	"generation":      {},
+	"vibe":            {},
+	"response_format": {},
+	"prompt":          {},
+	"speed":           {},
+	"language":        {},
+	"sample_rate":     {},
+	"bitrate":         {},
+	"quality":         {},
+	"seed":            {},
+}
+
+// generationID 上游表单的 generation：未设置 seed 时为随机 UUID；
+// 设置时由 seed 与文本派生（UUID v5），相同文本+seed 得到相同的值，不同分段仍互不相同
+func generationID(request *TTSRequest) string {
+	if request.Seed == nil {
+		return uuid.New().String()
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(strconv.FormatInt(*request.Seed, 10)+":"+request.Input)).String()
+}
+
+// resolveVibe 请求级 vibe 优先，其次客户端默认值
+func (c *TTSClient) resolveVibe(request *TTSRequest) string {
+	if v := strings.TrimSpace(request.Vibe); v != "" {
+		return v
+	}
+	if v := strings.TrimSpace(c.config.DefaultVibe); v != "" {
+		return v
+	}
+	return DefaultVibe
+}
+
+// resolveInstructions 按优先级返回指令：InstructionsTemplate 的渲染结果、Instructions、DefaultInstructions
+func (c *TTSClient) resolveInstructions(request *TTSRequest) (string, error) {
+	if request.InstructionsTemplate != nil {
+		var buf strings.Builder
+		err := request.InstructionsTemplate.Execute(&buf, InstructionsData{
+			Input:    request.Input,
+			Voice:    request.Voice,
+			Format:   request.ResponseFormat,
+			Vibe:     c.resolveVibe(request),
+			Speed:    request.Speed,
+			Language: request.Language,
+		})
+		if err != nil {
+			return "", NewValidationException(
+				fmt.Sprintf("Failed to render instructions template: %v", err),
+				"instructions_template",
+				"",
+			)
+		}
+		return buf.String(), nil
+	}
+	if request.Instructions != "" {
+		return request.Instructions, nil
+	}
+	return DefaultInstructions, nil
+}
+
+// decompressBody 按响应实际的 Content-Encoding 解压，不单纯信任 resp.Uncompressed：
+// 该标记有误时压缩字节会被当作音频输出。没有 Content-Encoding 时再按魔数识别 gzip/zstd
+// （裸 PCM 可能以任意字节开头，不做识别）
+func (c *TTSClient) decompressBody(ctx context.Context, resp *http.Response, format AudioFormat) {
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "gzip", "br", "deflate", "zstd":
+		if resp.Uncompressed {
+			c.loggerFor(ctx).Warn("Response marked uncompressed but has Content-Encoding %q, decompressing", encoding)
+		}
+		resp.Body = http.DecompressBody(resp)
+		return
+	case "", "identity":
+	default:
+		return
+	}
+	if format == FormatPCM {
+		return
+	}
+
+	br := bufio.NewReader(resp.Body)
+	magic, _ := br.Peek(4)
+	body := &peekedBody{Reader: br, Closer: resp.Body}
+	resp.Body = body
+
+	var encoding string
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b, 0x08}):
+		encoding = "gzip"
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		encoding = "zstd"
+	default:
+		return
+	}
+	c.loggerFor(ctx).Warn("Response body looks %s-compressed without Content-Encoding, decompressing", encoding)
+	resp.Body = http.DecompressBodyByType(body, encoding)
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// peekedBody 预读过的响应体：从缓冲读取，关闭原始响应体
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
 // processStreamResponse 处理成功的流式响应
 func (c *TTSClient) processStreamResponse(
+	ctx context.Context,
 	resp *http.Response,
 	request *TTSRequest,
 ) (*TTSStreamResponse, error) {
@@ -892,24 +1964,45 @@ func (c *TTSClient) processStreamResponse(
 		actualFormat = FormatAAC
 	case containsAny(contentTypeLower, "audio/flac"):
 		actualFormat = FormatFLAC
+	case containsAny(contentTypeLower, "audio/pcm", "audio/l16"):
+		actualFormat = FormatPCM
 	default:
 		actualFormat = FormatMP3
 	}
 
+	c.decompressBody(ctx, resp, actualFormat)
+
 	requestedFormat := request.ResponseFormat
+	upstreamFormat := actualFormat
+	body := resp.Body
 	if actualFormat != requestedFormat {
-		if MapsToWAV(string(requestedFormat)) && actualFormat == FormatWAV {
-			c.logger.Debug("Format '%s' requested, returning WAV format.", requestedFormat)
+		if transcoder := LookupTranscoder(actualFormat, requestedFormat); transcoder != nil {
+			c.loggerFor(ctx).Debug("Transcoding '%s' from service to requested format '%s'.", actualFormat, requestedFormat)
+			out, err := transcoder.Transcode(resp.Body, actualFormat, requestedFormat)
+			if err != nil {
+				_ = resp.Body.Close()
+				return nil, fmt.Errorf("failed to transcode %s to %s: %w", actualFormat, requestedFormat, err)
+			}
+			body = &transcodedBody{ReadCloser: out, upstream: resp.Body}
+			actualFormat = requestedFormat
+			contentType = GetContentType(requestedFormat)
+		} else if c.strictFormat(request) {
+			_ = resp.Body.Close()
+			return nil, NewValidationException(
+				fmt.Sprintf("Requested format '%s' but received '%s' from service", requestedFormat, actualFormat),
+				"response_format",
+				string(requestedFormat),
+			)
+		} else if MapsToWAV(string(requestedFormat)) && actualFormat == FormatWAV {
+			c.loggerFor(ctx).Debug("Format '%s' requested, returning WAV format.", requestedFormat)
 		} else {
-			c.logger.Warn("Requested format '%s' but received '%s' from service.",
+			c.loggerFor(ctx).Warn("Requested format '%s' but received '%s' from service.",
 				requestedFormat, actualFormat)
 		}
 	}
-	if !resp.Uncompressed {
-		resp.Body = http.DecompressBody(resp)
-	}
+
 	streamResp := &TTSStreamResponse{
-		Body:        resp.Body,
+		Body:        body,
 		ContentType: contentType,
 		Format:      actualFormat,
 		Metadata: map[string]string{
@@ -920,26 +2013,105 @@ func (c *TTSClient) processStreamResponse(
 			"actual_format":    string(actualFormat),
 		},
 	}
+	if upstreamFormat != actualFormat {
+		streamResp.Metadata["upstream_format"] = string(upstreamFormat)
+	}
+	if request.Bitrate != 0 {
+		streamResp.Metadata["bitrate"] = strconv.Itoa(request.Bitrate)
+	}
+	if request.Quality != "" {
+		streamResp.Metadata["quality"] = request.Quality
+	}
 
-	c.logger.Info("Streaming %s audio from openai.fm using voice '%s'",
+	c.loggerFor(ctx).Info("Streaming %s audio from openai.fm using voice '%s'",
 		string(actualFormat), request.Voice)
 
 	return streamResp, nil
 }
 
+// withDeadline 为一次公开调用附加整体截止时间（ClientConfig.Timeout，<=0 时不附加）；
+// 调用方 ctx 的截止时间更早时以其为准，嵌套调用也只会取最早的截止时间
+func (c *TTSClient) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.config.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.config.Timeout)
+}
+
+// deadlineStream 让整体截止时间覆盖到调用方读完并关闭流式响应体为止
+func deadlineStream(streamResp *TTSStreamResponse, cancel context.CancelFunc) *TTSStreamResponse {
+	streamResp.Body = &releasingBody{ReadCloser: streamResp.Body, release: cancel}
+	return streamResp
+}
+
+// beginRequest 登记一个进行中的上游请求，返回同时受 ctx 与客户端根上下文控制的上下文；
+// 返回的 done 必须恰好调用一次（可重复调用，只生效一次）
+func (c *TTSClient) beginRequest(ctx context.Context) (context.Context, func(), error) {
+	c.inflightMu.Lock()
+	if c.shuttingDown {
+		c.inflightMu.Unlock()
+		return nil, nil, ErrClientShutdown
+	}
+	c.inflight.Add(1)
+	c.inflightMu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(c.rootCtx, cancel)
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			stop()
+			cancel()
+			c.inflight.Done()
+		})
+	}, nil
+}
+
+// releasingBody 关闭响应体时登记请求结束
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// Shutdown 停止接受新请求，取消客户端根上下文以中止进行中的上游请求，
+// 并等待它们结束（包括调用方关闭已返回的流式响应体），最长等到 ctx 结束，最后关闭空闲连接。
+// 与立即返回的 Close 不同；ctx 先结束时返回 ctx.Err()。之后的请求返回 ErrClientShutdown
+func (c *TTSClient) Shutdown(ctx context.Context) error {
+	c.inflightMu.Lock()
+	c.shuttingDown = true
+	c.inflightMu.Unlock()
+	c.rootCancel()
+
+	drained := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return c.Close()
+}
+
 // Close 关闭客户端
 func (c *TTSClient) Close() error {
+	c.proxyMu.RLock()
 	c.httpClient.CloseIdleConnections()
+	c.proxyMu.RUnlock()
 	return nil
 }
 
-func truncateString(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
-	}
-	return s[:maxLen] + "..."
-}
-
 func containsAny(s string, substrs ...string) bool {
 	for _, substr := range substrs {
 		if strings.Contains(s, substr) {
@@ -1,12 +1,28 @@
 package ttsfm
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
+
+	fhttp "github.com/bogdanfinn/fhttp"
 )
 
 func TestNewTTSClient(t *testing.T) {
@@ -153,6 +169,16 @@ func TestSanitizeText(t *testing.T) {
 		{"Test & test", "Test test"},
 		{"Multiple   spaces", "Multiple spaces"},
 		{"", ""},
+		{"Hello \xe4\xbd world", "Hello world"},
+		{"\xff\xfe你好", "你好"},
+		{"end\xf0\x9f\x98", "end"},
+		{"Tom &amp; Jerry", "Tom & Jerry"},
+		{"1 &lt; 2 &gt; 0", "1 < 2 > 0"},
+		{"&quot;Hi&quot; and &apos;bye&apos;", "\"Hi\" and 'bye'"},
+		{"It&#39;s &#8364;5", "It's €5"},
+		{"&#x4F60;&#X597D; &#x1F600;", "你好 😀"},
+		{"&lt;b&gt;not a tag&lt;/b&gt;", "<b>not a tag</b>"},
+		{"bad &#0; &#xD800; &#xZZ; &nbsp;end", "bad end"},
 	}
 
 	for _, tt := range tests {
@@ -163,6 +189,9 @@ func TestSanitizeText(t *testing.T) {
 		if result != tt.expected {
 			t.Errorf("SanitizeText(%q) = %q, want %q", tt.input, result, tt.expected)
 		}
+		if !utf8.ValidString(result) {
+			t.Errorf("SanitizeText(%q) returned invalid UTF-8 %q", tt.input, result)
+		}
 	}
 }
 
@@ -181,6 +210,138 @@ func TestSplitTextByLength(t *testing.T) {
 	}
 }
 
+func TestSplitBySentences(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"Dr. Smith paid $3.50. He left.", []string{"Dr. Smith paid $3.50.", "He left."}},
+		{"Use tools, e.g. hammers. Then rest.", []string{"Use tools, e.g. hammers.", "Then rest."}},
+		{"Mr. and Mrs. Lee met at 9 a.m. today.", []string{"Mr. and Mrs. Lee met at 9 a.m. today."}},
+		{"Wait... what? Yes!", []string{"Wait... what?", "Yes!"}},
+		{"J. R. R. Tolkien wrote it. Version 2.0 shipped", []string{"J. R. R. Tolkien wrote it.", "Version 2.0 shipped"}},
+		{"Really?! No way.", []string{"Really?!", "No way."}},
+	}
+
+	for _, tt := range tests {
+		got := splitBySentences(tt.input)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitBySentences(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTextByLength_KeepsAbbreviationsAndDecimals(t *testing.T) {
+	chunks := SplitTextByLength("Dr. Smith paid $3.50. He left the shop.", 25, true)
+	want := []string{"Dr. Smith paid $3.50.", "He left the shop."}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected %q, got %q", want, chunks)
+	}
+}
+
+func TestSplitTextByLength_RuneBoundaries(t *testing.T) {
+	// 19 个字符（57+ 字节），maxLength 按字符计数：第一段恰好 16 个字符，emoji 不被切断
+	text := "你好世界你😀再见朋友们今天天气很好🎉结束"
+	chunks := SplitTextByLength(text, 16, false)
+
+	if len(chunks) != 2 {
+		t.Fatalf("expected text to be split into 2 chunks, got %q", chunks)
+	}
+	if chunks[0] != "你好世界你😀再见朋友们今天天气很" {
+		t.Fatalf("expected first chunk to hold 16 characters, got %q", chunks[0])
+	}
+	for i, chunk := range chunks {
+		if !utf8.ValidString(chunk) {
+			t.Fatalf("chunk %d severs a rune: %q", i, chunk)
+		}
+		if n := utf8.RuneCountInString(chunk); n > 16 {
+			t.Fatalf("chunk %d exceeds character budget: %d characters", i, n)
+		}
+	}
+	if joined := strings.Join(chunks, ""); joined != text {
+		t.Fatalf("chunks do not reassemble the input: %q", joined)
+	}
+
+	// 不超过 maxLength 个字符的多字节文本不切分，即使字节数远超 maxLength
+	short := strings.Repeat("汉", 16)
+	if got := SplitTextByLength(short, 16, true); len(got) != 1 || got[0] != short {
+		t.Fatalf("expected 16 CJK characters to fit in one chunk, got %q", got)
+	}
+}
+
+func TestLengthLimits_CountCharactersNotBytes(t *testing.T) {
+	// 2000 个汉字约 6000 字节，但只有 2000 个字符
+	text := strings.Repeat("语", 2000)
+
+	if _, err := NewTTSRequest(text, WithMaxLength(2000)); err != nil {
+		t.Fatalf("expected 2000 characters to fit max_length=2000, got %v", err)
+	}
+	if _, err := NewTTSRequest(text, WithMaxLength(1999)); err == nil || !strings.Contains(err.Error(), "2000 characters") {
+		t.Fatalf("expected too-long error reporting 2000 characters, got %v", err)
+	}
+
+	if err := ValidateTextLength(text, 2000); err != nil {
+		t.Fatalf("expected ValidateTextLength to count characters, got %v", err)
+	}
+	if err := ValidateTextLength(text, 1999); err == nil {
+		t.Fatal("expected ValidateTextLength to reject 2000 characters over 1999")
+	}
+	// SanitizeText 的 50000 上限同样按字符计：20000 个汉字约 60000 字节
+	if _, err := SanitizeText(strings.Repeat("语", 20000)); err != nil {
+		t.Fatalf("expected 20000 characters to pass sanitization, got %v", err)
+	}
+	if _, err := SanitizeText(strings.Repeat("语", 50001)); err == nil || !strings.Contains(err.Error(), "50000 characters") {
+		t.Fatalf("expected sanitization to reject 50001 characters, got %v", err)
+	}
+}
+
+func TestSplitBySentences_CJK(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"今天天气很好。我们去公园吧！好吗？", []string{"今天天气很好。", "我们去公园吧！", "好吗？"}},
+		{"他说：“走吧。”然后离开了；没有回头。", []string{"他说：“走吧。”", "然后离开了；", "没有回头。"}},
+		{"雨が降っています。傘を持っていきましょう。", []string{"雨が降っています。", "傘を持っていきましょう。"}},
+		{"Hello there. 你好。Bye", []string{"Hello there.", "你好。", "Bye"}},
+	}
+
+	for _, tt := range tests {
+		got := splitBySentences(tt.input)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitBySentences(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTextByLength_ChineseParagraph(t *testing.T) {
+	paragraph := strings.Repeat("人工智能正在改变我们的生活方式。", 6) +
+		"这是一个没有任何标点而且非常非常长的句子它会超过分段长度上限所以必须在字符边界处被切开" +
+		strings.Repeat("语音合成让机器能够开口说话！", 4)
+	const maxLength = 20
+
+	chunks := SplitTextByLength(paragraph, maxLength, true)
+	if len(chunks) < 3 {
+		t.Fatalf("expected the paragraph to be split, got %q", chunks)
+	}
+	for i, chunk := range chunks {
+		if !utf8.ValidString(chunk) {
+			t.Fatalf("chunk %d severs a rune: %q", i, chunk)
+		}
+		if n := utf8.RuneCountInString(chunk); n > maxLength {
+			t.Fatalf("chunk %d has %d runes, over maxLength %d", i, n, maxLength)
+		}
+	}
+
+	// 以句末标点结尾的分段必须在标点处切开，而不是句子中间
+	if chunks[0] != "人工智能正在改变我们的生活方式。" {
+		t.Fatalf("expected first chunk to end at ideographic full stop, got %q", chunks[0])
+	}
+	if last := chunks[len(chunks)-1]; !strings.HasSuffix(last, "！") {
+		t.Fatalf("expected last chunk to end at ideographic exclamation mark, got %q", last)
+	}
+}
+
 func TestBuildURL(t *testing.T) {
 	tests := []struct {
 		baseURL  string
@@ -236,6 +397,24 @@ func TestFormatFileSize(t *testing.T) {
 	}
 }
 
+func TestTruncateString(t *testing.T) {
+	// "你好世界" 每个字 3 字节，5 字节处落在第二个字中间
+	got := TruncateString("你好世界", 5)
+	if !utf8.ValidString(got) {
+		t.Fatalf("TruncateString produced invalid UTF-8: %q", got)
+	}
+	if got != "你..." {
+		t.Fatalf("TruncateString = %q, want %q", got, "你...")
+	}
+
+	if got := TruncateString("hello", 10); got != "hello" {
+		t.Errorf("short string should be unchanged, got %q", got)
+	}
+	if got := TruncateString("hello world", 5); got != "hello..." {
+		t.Errorf("ASCII truncation = %q", got)
+	}
+}
+
 func TestTTSResponseSaveToFile(t *testing.T) {
 	response := &TTSResponse{
 		AudioData: []byte("test audio data"),
@@ -293,4 +472,1967 @@ func TestIntegrationGenerateSpeech(t *testing.T) {
 
 	t.Logf("Generated audio: %s, duration: %.2fs",
 		FormatFileSize(response.Size), response.Duration)
-}
\ No newline at end of file
+}
+
+// recordedForm 记录 stub 上游收到的表单
+type recordedForm struct {
+	mu         sync.Mutex
+	forms      []map[string]string
+	userAgents []string
+}
+
+func (r *recordedForm) all() []map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]map[string]string(nil), r.forms...)
+}
+
+func (r *recordedForm) allUserAgents() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.userAgents...)
+}
+
+// newStubUpstream 启动一个记录表单字段并返回固定音频的上游
+func newStubUpstream(t *testing.T, contentType string, body func(input string) []byte) (*httptest.Server, *recordedForm) {
+	t.Helper()
+
+	rec := &recordedForm{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, "bad multipart", http.StatusBadRequest)
+			return
+		}
+		form := map[string]string{}
+		for k, v := range r.MultipartForm.Value {
+			if len(v) > 0 {
+				form[k] = v[0]
+			}
+		}
+		rec.mu.Lock()
+		rec.forms = append(rec.forms, form)
+		rec.userAgents = append(rec.userAgents, r.UserAgent())
+		rec.mu.Unlock()
+
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body(form["input"]))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, rec
+}
+
+func newStubClient(t *testing.T, baseURL string, opts ...ClientOption) *TTSClient {
+	t.Helper()
+
+	client, err := NewTTSClient(append([]ClientOption{
+		WithBaseURL(baseURL),
+		WithTimeout(2 * time.Second),
+		WithMaxRetries(0),
+	}, opts...)...)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestLongTextStream_AllChunksCarrySameVibe(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	client := newStubClient(t, upstream.URL, WithDefaultVibe("calm"))
+
+	text := "First sentence here. Second sentence here. Third sentence here."
+
+	cases := []struct {
+		name string
+		opts []RequestOption
+		want string
+	}{
+		{name: "client default", want: "calm"},
+		{name: "request override", opts: []RequestOption{WithVibe("energetic")}, want: "energetic"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := len(rec.all())
+
+			resp, err := client.GenerateSpeechLongTextStreamConcurrent(context.Background(), text, 25, true, nil, tc.opts...)
+			if err != nil {
+				t.Fatalf("stream: %v", err)
+			}
+			if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			_ = resp.Close()
+
+			forms := rec.all()[before:]
+			if len(forms) != 3 {
+				t.Fatalf("expected 3 chunk requests, got %d", len(forms))
+			}
+			for i, f := range forms {
+				if f["vibe"] != tc.want {
+					t.Fatalf("chunk %d: expected vibe %q, got %q", i, tc.want, f["vibe"])
+				}
+			}
+		})
+	}
+}
+
+func TestLongText_SourceTextMatchesChunks(t *testing.T) {
+	upstream, _ := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte("audio:" + input) })
+
+	text := "Alpha beta gamma. Delta epsilon zeta! Eta theta iota?"
+	want := SplitTextByLength(text, 20, true)
+
+	client := newStubClient(t, upstream.URL, WithSourceText(true))
+	responses, err := client.GenerateSpeechLongText(context.Background(), text, 20, true)
+	if err != nil {
+		t.Fatalf("long text: %v", err)
+	}
+	if len(responses) != len(want) {
+		t.Fatalf("expected %d responses, got %d", len(want), len(responses))
+	}
+	for i, resp := range responses {
+		if resp.SourceText != want[i] {
+			t.Fatalf("chunk %d: expected SourceText %q, got %q", i, want[i], resp.SourceText)
+		}
+	}
+
+	// 默认不保留原文
+	client = newStubClient(t, upstream.URL)
+	responses, err = client.GenerateSpeechLongText(context.Background(), text, 20, true)
+	if err != nil {
+		t.Fatalf("long text: %v", err)
+	}
+	for i, resp := range responses {
+		if resp.SourceText != "" {
+			t.Fatalf("chunk %d: expected empty SourceText without opt-in, got %q", i, resp.SourceText)
+		}
+	}
+}
+
+func TestSplitTextByLength_MinimumChunkLength(t *testing.T) {
+	text := "Supercalifragilistic words. Another sentence follows here."
+
+	chunks := SplitTextByLength(text, 1, true)
+	floor := SplitTextByLength(text, MinChunkLength, true)
+
+	if len(chunks) != len(floor) {
+		t.Fatalf("expected maxLength=1 to behave like %d (%d chunks), got %d chunks", MinChunkLength, len(floor), len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > MinChunkLength {
+			t.Fatalf("chunk %d too long: %q", i, chunk)
+		}
+		if len(chunk) <= 1 {
+			t.Fatalf("chunk %d was split to a single character: %q", i, chunk)
+		}
+	}
+
+	if got, changed := ClampChunkLength(1); got != MinChunkLength || !changed {
+		t.Fatalf("ClampChunkLength(1) = %d, %v", got, changed)
+	}
+	if got, changed := ClampChunkLength(100); got != 100 || changed {
+		t.Fatalf("ClampChunkLength(100) = %d, %v", got, changed)
+	}
+}
+
+func TestWithUserAgent_ConstantAcrossRequests(t *testing.T) {
+	const ua = "ttsfm-debug/1.0"
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	client := newStubClient(t, upstream.URL, WithUserAgent(ua), WithClientProfile("chrome_133"))
+
+	for i := 0; i < 5; i++ {
+		if _, err := client.GenerateSpeech(context.Background(), "Hello there.", WithFormat(FormatMP3)); err != nil {
+			t.Fatalf("generate %d: %v", i, err)
+		}
+	}
+
+	agents := rec.allUserAgents()
+	if len(agents) != 5 {
+		t.Fatalf("expected 5 upstream requests, got %d", len(agents))
+	}
+	for i, got := range agents {
+		if got != ua {
+			t.Fatalf("request %d: expected User-Agent %q, got %q", i, ua, got)
+		}
+	}
+}
+
+func TestGetRealisticHeadersWithUserAgent_ConsistentPerBrowser(t *testing.T) {
+	const (
+		chromeUA  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36"
+		firefoxUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:122.0) Gecko/20100101 Firefox/122.0"
+		safariUA  = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15"
+	)
+
+	for i := 0; i < 20; i++ {
+		chrome := GetRealisticHeadersWithUserAgent(chromeUA)
+		if !strings.Contains(chrome["Sec-Ch-Ua"], `v="121"`) || chrome["Sec-Ch-Ua-Mobile"] == "" || chrome["Sec-Ch-Ua-Platform"] == "" {
+			t.Fatalf("expected Chrome client hints matching the UA version, got %v", chrome)
+		}
+		if chrome["Accept-Encoding"] != "gzip, deflate, br" {
+			t.Fatalf("expected Chrome 121 Accept-Encoding without zstd, got %q", chrome["Accept-Encoding"])
+		}
+
+		for name, ua := range map[string]string{"firefox": firefoxUA, "safari": safariUA} {
+			headers := GetRealisticHeadersWithUserAgent(ua)
+			for k := range headers {
+				if strings.HasPrefix(k, "Sec-Ch-Ua") {
+					t.Fatalf("%s: unexpected Chrome client hint %s", name, k)
+				}
+			}
+			if headers["Accept"] != "*/*" || headers["Sec-Fetch-Mode"] != "cors" {
+				t.Fatalf("%s: expected browser fetch headers, got %v", name, headers)
+			}
+			if _, ok := headers["Upgrade-Insecure-Requests"]; ok {
+				t.Fatalf("%s: navigation-only header on a fetch request", name)
+			}
+		}
+		if got := GetRealisticHeadersWithUserAgent(firefoxUA)["Accept-Language"]; got != "en-US,en;q=0.5" {
+			t.Fatalf("expected Firefox-style Accept-Language, got %q", got)
+		}
+	}
+
+	// 上游实际收到的请求头也跟随 UA，而不是被统一覆盖
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Sec-Ch-Ua") != "" || r.Header.Get("Accept") != "*/*" ||
+			r.Header.Get("Accept-Encoding") != "gzip, deflate, br" || r.Header.Get("Accept-Language") != "en-US,en;q=0.5" {
+			http.Error(w, "inconsistent headers", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+
+	client := newStubClient(t, upstream.URL, WithUserAgent(firefoxUA))
+	if _, err := client.GenerateSpeech(context.Background(), "Hello there.", WithFormat(FormatMP3)); err != nil {
+		t.Fatalf("generate with Firefox UA: %v", err)
+	}
+}
+
+func TestWithClientProfile_Unknown(t *testing.T) {
+	_, err := NewTTSClient(WithClientProfile("netscape_4"))
+	if err == nil {
+		t.Fatal("expected error for unknown client profile")
+	}
+	if _, ok := err.(*ValidationException); !ok {
+		t.Fatalf("expected ValidationException, got %T", err)
+	}
+}
+
+func TestLongTextStreamConcurrent_FailsFastWhenSemaphoreSaturated(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	client := newStubClient(t, upstream.URL, WithMaxConcurrent(1))
+
+	// 模拟其他请求占满全局并发槽位
+	client.semaphore <- struct{}{}
+	defer func() { <-client.semaphore }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GenerateSpeechLongTextStreamConcurrent(
+		ctx,
+		"First sentence here. Second sentence here. Third sentence here.",
+		25,
+		true,
+		&LongTextStreamConfig{MaxConcurrent: 1, AcquireTimeout: 50 * time.Millisecond},
+	)
+	elapsed := time.Since(start)
+
+	var busy *BusyException
+	if !errors.As(err, &busy) {
+		t.Fatalf("expected BusyException, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("expected to fail fast, took %v", elapsed)
+	}
+	if n := len(rec.all()); n != 0 {
+		t.Fatalf("expected no upstream requests, got %d", n)
+	}
+}
+
+func TestLongTextStreamConcurrent_TrimLeadingFrames(t *testing.T) {
+	priming := mp3Frame(0x11)
+	content := func(input string) []byte {
+		if strings.HasPrefix(input, "First") {
+			return mp3Frame(0x20)
+		}
+		return mp3Frame(0x21)
+	}
+	upstream, _ := newStubUpstream(t, "audio/mpeg", func(input string) []byte {
+		return append(append([]byte{}, priming...), content(input)...)
+	})
+	client := newStubClient(t, upstream.URL)
+
+	text := "First sentence here. Second sentence here."
+	read := func(config *LongTextStreamConfig) []byte {
+		t.Helper()
+		resp, err := client.GenerateSpeechLongTextStreamConcurrent(context.Background(), text, 25, true, config)
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		defer resp.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return data
+	}
+
+	// 首段保持完整，后续分段丢弃开头的填充帧
+	trimmed := read(&LongTextStreamConfig{TrimLeadingFrames: 1})
+	want := bytes.Join([][]byte{priming, mp3Frame(0x20), mp3Frame(0x21)}, nil)
+	if !bytes.Equal(trimmed, want) {
+		t.Fatalf("TrimLeadingFrames=1: expected %d bytes, got %d", len(want), len(trimmed))
+	}
+
+	if untouched := read(nil); len(untouched) != 4*len(priming) {
+		t.Fatalf("default: expected no trimming (%d bytes), got %d", 4*len(priming), len(untouched))
+	}
+}
+
+func TestLongTextStreamConcurrent_RawConcatKeepsID3(t *testing.T) {
+	id3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x04"), []byte("TAG!")...)
+	upstream, _ := newStubUpstream(t, "audio/mpeg", func(input string) []byte {
+		return append(append([]byte{}, id3...), []byte(input)...)
+	})
+	client := newStubClient(t, upstream.URL)
+
+	text := "First sentence here. Second sentence here."
+	chunks := SplitTextByLength(text, 25, true)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(chunks))
+	}
+
+	read := func(config *LongTextStreamConfig) []byte {
+		t.Helper()
+		resp, err := client.GenerateSpeechLongTextStreamConcurrent(context.Background(), text, 25, true, config)
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		defer resp.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return data
+	}
+
+	raw := read(&LongTextStreamConfig{RawConcat: true})
+	wantRaw := string(id3) + chunks[0] + string(id3) + chunks[1]
+	if string(raw) != wantRaw {
+		t.Fatalf("RawConcat: expected %q, got %q", wantRaw, raw)
+	}
+
+	stripped := read(nil)
+	wantStripped := string(id3) + chunks[0] + chunks[1]
+	if string(stripped) != wantStripped {
+		t.Fatalf("default: expected %q, got %q", wantStripped, stripped)
+	}
+}
+
+func TestLongTextStreamConcurrent_SingleChunkReportsChunksTotal(t *testing.T) {
+	upstream, _ := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	client := newStubClient(t, upstream.URL)
+
+	resp, err := client.GenerateSpeechLongTextStreamConcurrent(context.Background(), "Short enough.", 100, true, nil)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer resp.Close()
+	if got := resp.Metadata["chunks_total"]; got != "1" {
+		t.Fatalf("expected chunks_total=1, got %q", got)
+	}
+}
+
+func TestLongTextStreamConcurrent_OnChunkProgress(t *testing.T) {
+	upstream, _ := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	client := newStubClient(t, upstream.URL)
+
+	var progress []ChunkProgress
+	config := &LongTextStreamConfig{OnChunkProgress: func(p ChunkProgress) error {
+		progress = append(progress, p)
+		return nil
+	}}
+	resp, err := client.GenerateSpeechLongTextStreamConcurrent(context.Background(),
+		"First sentence here. Second sentence here.", 25, true, config)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	_ = resp.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(progress) != 2 {
+		t.Fatalf("expected 2 progress callbacks, got %+v", progress)
+	}
+	var offset int64
+	for i, p := range progress {
+		if p.Index != i || p.Total != 2 || p.Offset != offset || p.Bytes != int64(len(p.Text)) {
+			t.Fatalf("progress %d: unexpected %+v", i, p)
+		}
+		offset += p.Bytes
+	}
+	if offset != int64(len(data)) {
+		t.Fatalf("progress covers %d bytes, stream has %d", offset, len(data))
+	}
+
+	// 单个 chunk 仍直接透传上游流，读到结尾时回调一次；回调错误会代替 io.EOF 返回
+	progress = nil
+	stop := errors.New("stop")
+	config.OnChunkProgress = func(p ChunkProgress) error {
+		progress = append(progress, p)
+		return stop
+	}
+	resp, err = client.GenerateSpeechLongTextStreamConcurrent(context.Background(), "Short enough.", 100, true, config)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer resp.Close()
+	if _, err := io.ReadAll(resp.Body); !errors.Is(err, stop) {
+		t.Fatalf("expected callback error at end of stream, got %v", err)
+	}
+	if len(progress) != 1 || progress[0].Total != 1 || progress[0].Bytes != int64(len("Short enough.")) {
+		t.Fatalf("expected one progress callback for the single chunk, got %+v", progress)
+	}
+}
+
+func TestWithStaticHostMapping_RoutesToPinnedIP(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(upstream.URL, "http://"))
+	if err != nil {
+		t.Fatalf("split upstream address: %v", err)
+	}
+
+	// tts.invalid 无法通过 DNS 解析，只有映射生效时请求才能成功
+	client := newStubClient(t, "http://tts.invalid:"+port, WithStaticHostMapping("TTS.invalid", "127.0.0.1"))
+	if _, err := client.GenerateSpeech(context.Background(), "Hello there."); err != nil {
+		t.Fatalf("generate via static mapping: %v", err)
+	}
+	if n := len(rec.all()); n != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", n)
+	}
+}
+
+func TestWithStaticHostMapping_Validation(t *testing.T) {
+	if _, err := NewTTSClient(WithStaticHostMapping("www.openai.fm", "not-an-ip")); err == nil {
+		t.Fatal("expected error for invalid IP")
+	}
+	if _, err := NewTTSClient(
+		WithStaticHostMapping("www.openai.fm", "127.0.0.1"),
+		WithProxyURL("http://127.0.0.1:8888"),
+	); err == nil {
+		t.Fatal("expected error when combining static host mapping with a proxy")
+	}
+}
+
+func TestWithRetryCallback_FiresPerRetry(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 前两次失败，第三次成功
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+
+	type retry struct {
+		attempt int
+		err     error
+		delay   time.Duration
+	}
+	var mu sync.Mutex
+	var retries []retry
+
+	client := newStubClient(t, upstream.URL,
+		WithMaxRetries(3),
+		WithTimeout(10*time.Second),
+		WithRetryCallback(func(attempt int, err error, delay time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			retries = append(retries, retry{attempt: attempt, err: err, delay: delay})
+		}),
+	)
+
+	if _, err := client.GenerateSpeech(context.Background(), "Hello there."); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(retries) != 2 {
+		t.Fatalf("expected 2 retry callbacks, got %d", len(retries))
+	}
+	for i, r := range retries {
+		if r.attempt != i+1 {
+			t.Fatalf("retry %d: expected attempt %d, got %d", i, i+1, r.attempt)
+		}
+		var apiErr *APIException
+		if !errors.As(r.err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("retry %d: expected 503 APIException, got %v", i, r.err)
+		}
+		// ExponentialBackoff：base * 2^(attempt-1) 加 10%~30% 抖动
+		base := time.Duration(1<<i) * time.Second
+		if r.delay < base || r.delay > base*13/10 {
+			t.Fatalf("retry %d: delay %v outside [%v, %v]", i, r.delay, base, base*13/10)
+		}
+	}
+}
+
+func TestExponentialBackoffWithJitter_NoJitterIsExact(t *testing.T) {
+	const base, max = 0.5, 3.0
+	for attempt, want := range []float64{0.5, 1, 2, 3, 3} {
+		got := ExponentialBackoffWithJitter(attempt, base, max, 0)
+		if got != time.Duration(want*float64(time.Second)) {
+			t.Fatalf("attempt %d: expected %vs, got %v", attempt, want, got)
+		}
+	}
+
+	// 默认抖动在 base*2^attempt 之上附加 10%~30%
+	for i := 0; i < 50; i++ {
+		got := ExponentialBackoff(1, 1, 60)
+		if got < 2200*time.Millisecond || got > 2600*time.Millisecond {
+			t.Fatalf("default jitter out of range: %v", got)
+		}
+	}
+}
+
+func TestWithRetryBackoff_DeterministicDelays(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+
+	var mu sync.Mutex
+	var delays []time.Duration
+	client := newStubClient(t, upstream.URL,
+		WithMaxRetries(3),
+		WithRetryBackoff(0.01, 0.03),
+		WithRetryJitter(0),
+		WithRetryCallback(func(_ int, _ error, delay time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			delays = append(delays, delay)
+		}),
+	)
+
+	if _, err := client.GenerateSpeech(context.Background(), "Hello there."); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond}
+	if len(delays) != len(want) {
+		t.Fatalf("expected %d retries, got %v", len(want), delays)
+	}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Fatalf("retry %d: expected %v, got %v", i+1, want[i], delays[i])
+		}
+	}
+}
+
+func TestWithRetryableStatus(t *testing.T) {
+	var status, calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		_, _ = w.Write([]byte(`{"error":{"message":"upstream says no"}}`))
+	}))
+	defer upstream.Close()
+
+	run := func(code int, opts ...ClientOption) (int32, error) {
+		t.Helper()
+		atomic.StoreInt32(&status, int32(code))
+		atomic.StoreInt32(&calls, 0)
+		client := newStubClient(t, upstream.URL, append([]ClientOption{
+			WithMaxRetries(2), WithRetryBackoff(0.001, 0.001), WithRetryJitter(0),
+		}, opts...)...)
+		_, err := client.GenerateSpeech(context.Background(), "Hello there.")
+		return atomic.LoadInt32(&calls), err
+	}
+
+	// 默认：404 快速失败，409 重试
+	if n, err := run(http.StatusNotFound); n != 1 || err == nil {
+		t.Fatalf("default 404: expected 1 attempt and an error, got %d, %v", n, err)
+	}
+	if n, _ := run(http.StatusConflict); n != 3 {
+		t.Fatalf("default 409: expected 3 attempts, got %d", n)
+	}
+
+	failFastOn409 := WithRetryableStatus(func(status int) bool {
+		return status != http.StatusConflict && DefaultRetryableStatus(status)
+	})
+	n, err := run(http.StatusConflict, failFastOn409)
+	var apiErr *APIException
+	if n != 1 || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict || !strings.Contains(err.Error(), "upstream says no") {
+		t.Fatalf("custom 409: expected a single attempt returning the typed exception, got %d, %v", n, err)
+	}
+	if n, _ := run(http.StatusRequestTimeout, failFastOn409); n != 3 {
+		t.Fatalf("custom 408: expected 3 attempts, got %d", n)
+	}
+
+	// 自定义函数也可以让默认不重试的状态码重试
+	retryAll := WithRetryableStatus(func(int) bool { return true })
+	n, err = run(http.StatusBadRequest, retryAll)
+	var validationErr *ValidationException
+	if n != 3 || !errors.As(err, &validationErr) {
+		t.Fatalf("custom 400: expected 3 attempts ending in ValidationException, got %d, %v", n, err)
+	}
+}
+
+func TestGenerateSpeechStreamFunc_DeliversFullAudio(t *testing.T) {
+	audio := bytes.Repeat([]byte("0123456789abcdef"), 8*1024) // 128KB，超过单个缓冲区
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		for off := 0; off < len(audio); off += 16 * 1024 {
+			_, _ = w.Write(audio[off : off+16*1024])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	client := newStubClient(t, upstream.URL)
+
+	var got []byte
+	calls := 0
+	err := client.GenerateSpeechStreamFunc(context.Background(), "Hello there.", func(chunk []byte) error {
+		calls++
+		got = append(got, chunk...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream func: %v", err)
+	}
+	if !bytes.Equal(got, audio) {
+		t.Fatalf("expected %d bytes of audio, got %d", len(audio), len(got))
+	}
+	if calls < 2 {
+		t.Fatalf("expected multiple callback invocations, got %d", calls)
+	}
+}
+
+func TestGenerateSpeechStreamFunc_CallbackErrorAborts(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		// 不再结束响应，直到客户端断开
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	client := newStubClient(t, upstream.URL, WithTimeout(10*time.Second))
+
+	stop := errors.New("stop")
+	start := time.Now()
+	err := client.GenerateSpeechStreamFunc(context.Background(), "Hello there.", func(chunk []byte) error {
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected prompt abort, took %v", elapsed)
+	}
+}
+
+func TestGenerateSpeechToFile_NormalizesExtension(t *testing.T) {
+	upstream, _ := newStubUpstream(t, "audio/wav", func(input string) []byte { return []byte("RIFF" + input) })
+	client := newStubClient(t, upstream.URL)
+
+	target := filepath.Join(t.TempDir(), "nested", "out.mp3")
+	path, err := client.GenerateSpeechToFile(context.Background(), "Hello there.", target, WithFormat(FormatWAV))
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if want := strings.TrimSuffix(target, ".mp3") + ".wav"; path != want {
+		t.Fatalf("expected path %s, got %s", want, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "RIFFHello there." {
+		t.Fatalf("unexpected file contents %q", data)
+	}
+}
+
+func TestGenerateSpeechToFile_RemovesPartialFileOnError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("Content-Length", "1024")
+		_, _ = w.Write([]byte("partial"))
+		// 提前断开，触发 unexpected EOF
+		if hj, ok := w.(http.Hijacker); ok {
+			conn, _, _ := hj.Hijack()
+			_ = conn.Close()
+		}
+	}))
+	defer upstream.Close()
+	client := newStubClient(t, upstream.URL)
+
+	target := filepath.Join(t.TempDir(), "out.mp3")
+	if _, err := client.GenerateSpeechToFile(context.Background(), "Hello there.", target); err == nil {
+		t.Fatal("expected truncated body to fail")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("expected partial file to be removed, stat err=%v", err)
+	}
+}
+
+func TestTTSStreamResponse_SaveToFile(t *testing.T) {
+	resp := &TTSStreamResponse{
+		Body:   io.NopCloser(strings.NewReader("audio-bytes")),
+		Format: FormatOPUS,
+	}
+
+	target := filepath.Join(t.TempDir(), "speech.mp3")
+	path, n, err := resp.SaveToFile(context.Background(), target)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if want := strings.TrimSuffix(target, ".mp3") + ".opus"; path != want {
+		t.Fatalf("expected path %s, got %s", want, path)
+	}
+	if n != int64(len("audio-bytes")) {
+		t.Fatalf("expected %d bytes written, got %d", len("audio-bytes"), n)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "audio-bytes" {
+		t.Fatalf("unexpected file contents %q", data)
+	}
+}
+
+func TestTTSStreamResponse_SaveToFile_ContextCancel(t *testing.T) {
+	pr, pw := io.Pipe()
+	resp := &TTSStreamResponse{Body: pr, Format: FormatMP3}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, _ = pw.Write([]byte("partial"))
+		cancel()
+	}()
+
+	target := filepath.Join(t.TempDir(), "speech.mp3")
+	_, _, err := resp.SaveToFile(ctx, target)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("expected partial file to be removed, stat err=%v", err)
+	}
+}
+
+func TestLongText_EveryChunkCarriesSpeed(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	client := newStubClient(t, upstream.URL)
+
+	text := "First sentence here. Second sentence here. Third sentence here."
+
+	run := map[string]func() error{
+		"buffered": func() error {
+			_, err := client.GenerateSpeechLongText(context.Background(), text, 25, true, WithSpeed(1.5))
+			return err
+		},
+		"sequential stream": func() error {
+			resp, err := client.GenerateSpeechLongTextStream(context.Background(), text, 25, true, WithSpeed(1.5))
+			if err != nil {
+				return err
+			}
+			_ = readStream(t, resp)
+			return nil
+		},
+		"concurrent stream": func() error {
+			resp, err := client.GenerateSpeechLongTextStreamConcurrent(context.Background(), text, 25, true, nil, WithSpeed(1.5))
+			if err != nil {
+				return err
+			}
+			_ = readStream(t, resp)
+			return nil
+		},
+	}
+
+	for name, fn := range run {
+		t.Run(name, func(t *testing.T) {
+			before := len(rec.all())
+			if err := fn(); err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+
+			forms := rec.all()[before:]
+			if len(forms) != 3 {
+				t.Fatalf("expected 3 chunk requests, got %d", len(forms))
+			}
+			for i, f := range forms {
+				if f["speed"] != "1.5" {
+					t.Fatalf("chunk %d: expected speed 1.5, got %q", i, f["speed"])
+				}
+			}
+		})
+	}
+
+	// 未设置语速时不发送该字段，由上游使用默认值
+	before := len(rec.all())
+	if _, err := client.GenerateSpeech(context.Background(), "Hello there."); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if f := rec.all()[before]; f["speed"] != "" {
+		t.Fatalf("expected no speed field by default, got %q", f["speed"])
+	}
+}
+
+func TestWithLanguage(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte("audio") })
+	client := newStubClient(t, upstream.URL)
+
+	if _, err := client.GenerateSpeech(context.Background(), "Guten Tag.", WithLanguage("de-DE")); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if _, err := client.GenerateSpeech(context.Background(), "Hello."); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	forms := rec.all()
+	if forms[0]["language"] != "de-DE" {
+		t.Fatalf("expected language=de-DE, got %q", forms[0]["language"])
+	}
+	if _, ok := forms[1]["language"]; ok {
+		t.Fatalf("expected language to be omitted when unset, got %q", forms[1]["language"])
+	}
+
+	for _, lang := range []string{"de", "zh-CN"} {
+		if _, err := NewTTSRequest("Hi", WithLanguage(lang)); err != nil {
+			t.Fatalf("language=%q: unexpected error %v", lang, err)
+		}
+	}
+	for _, lang := range []string{"german", "de_DE", "DE", "de-de"} {
+		_, err := NewTTSRequest("Hi", WithLanguage(lang))
+		var ve *ValidationException
+		if !errors.As(err, &ve) {
+			t.Fatalf("language=%q: expected ValidationException, got %v", lang, err)
+		}
+	}
+}
+
+func TestWithSampleRate(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/wav", func(input string) []byte { return []byte("audio") })
+	client := newStubClient(t, upstream.URL)
+
+	if _, err := client.GenerateSpeech(context.Background(), "Hello.", WithFormat(FormatWAV), WithSampleRate(16000)); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if _, err := client.GenerateSpeech(context.Background(), "Hello.", WithFormat(FormatWAV)); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	forms := rec.all()
+	if forms[0]["sample_rate"] != "16000" {
+		t.Fatalf("expected sample_rate=16000, got %q", forms[0]["sample_rate"])
+	}
+	if _, ok := forms[1]["sample_rate"]; ok {
+		t.Fatalf("expected sample_rate to be omitted when unset, got %q", forms[1]["sample_rate"])
+	}
+
+	if _, err := NewTTSRequest("Hi", WithSampleRate(22050)); err != nil {
+		t.Fatalf("sample_rate=22050 should be accepted, got %v", err)
+	}
+	for _, hz := range []int{12345, -1} {
+		_, err := NewTTSRequest("Hi", WithSampleRate(hz))
+		var ve *ValidationException
+		if !errors.As(err, &ve) {
+			t.Fatalf("sample_rate=%d: expected ValidationException, got %v", hz, err)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	var status int32 = http.StatusOK
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected HEAD, got %s", r.Method)
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	client := newStubClient(t, upstream.URL)
+
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("expected reachable upstream, got %v", err)
+	}
+
+	// 4xx 说明上游可达
+	atomic.StoreInt32(&status, http.StatusMethodNotAllowed)
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("expected 405 to count as reachable, got %v", err)
+	}
+
+	var netErr *NetworkException
+	atomic.StoreInt32(&status, http.StatusBadGateway)
+	if err := client.Ping(context.Background()); !errors.As(err, &netErr) {
+		t.Fatalf("expected NetworkException for 502, got %v", err)
+	}
+
+	upstream.Close()
+	if err := client.Ping(context.Background()); !errors.As(err, &netErr) {
+		t.Fatalf("expected NetworkException for closed upstream, got %v", err)
+	}
+}
+
+func TestWithRequestSigner_SignsEveryAttempt(t *testing.T) {
+	key := []byte("secret")
+	sign := func(body []byte) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("X-Signature"); got != sign(body) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		// 首次失败，确认重试时重新签名
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+
+	var signed int32
+	client := newStubClient(t, upstream.URL,
+		WithMaxRetries(1),
+		WithTimeout(10*time.Second),
+		WithRequestSigner(func(req *fhttp.Request, body []byte) error {
+			atomic.AddInt32(&signed, 1)
+			if req.Header.Get("User-Agent") == "" {
+				t.Error("expected headers to be set before signing")
+			}
+			req.Header.Set("X-Signature", sign(body))
+			return nil
+		}),
+	)
+
+	resp, err := client.GenerateSpeech(context.Background(), "Hello there.")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if string(resp.AudioData) != "audio" {
+		t.Fatalf("unexpected audio %q", resp.AudioData)
+	}
+	if got := atomic.LoadInt32(&signed); got != 2 {
+		t.Fatalf("expected signer to run once per attempt (2), got %d", got)
+	}
+
+	// 签名失败时不发出请求
+	failing := newStubClient(t, upstream.URL, WithRequestSigner(func(*fhttp.Request, []byte) error {
+		return errors.New("no key")
+	}))
+	before := atomic.LoadInt32(&calls)
+	if _, err := failing.GenerateSpeech(context.Background(), "Hello there."); err == nil || !strings.Contains(err.Error(), "no key") {
+		t.Fatalf("expected signer error, got %v", err)
+	}
+	if atomic.LoadInt32(&calls) != before {
+		t.Fatal("request must not be sent when signing fails")
+	}
+}
+
+func TestGenerateSpeechBatchPartial_KeepsSuccessfulResults(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseMultipartForm(1 << 20)
+		input := r.FormValue("input")
+		if strings.Contains(input, "bad") {
+			http.Error(w, "rejected", http.StatusBadRequest)
+			return
+		}
+		// 让成功项慢一些，确认失败不会取消它们
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio:" + input))
+	}))
+	defer upstream.Close()
+	client := newStubClient(t, upstream.URL, WithMaxConcurrent(4))
+
+	var requests []*TTSRequest
+	for _, text := range []string{"one", "bad two", "three", "bad four"} {
+		req, err := NewTTSRequest(text)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		requests = append(requests, req)
+	}
+
+	responses, err := client.GenerateSpeechBatchPartial(context.Background(), requests)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected BatchError, got %v", err)
+	}
+	if got := batchErr.Failed(); len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Fatalf("expected failures at [1 3], got %v", got)
+	}
+	var validationErr *ValidationException
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected underlying exception to be reachable, got %v", err)
+	}
+
+	if len(responses) != len(requests) {
+		t.Fatalf("expected index-aligned responses, got %d", len(responses))
+	}
+	for i, want := range []string{"audio:one", "", "audio:three", ""} {
+		if want == "" {
+			if responses[i] != nil {
+				t.Fatalf("request %d: expected nil response for failure", i)
+			}
+			continue
+		}
+		if responses[i] == nil || string(responses[i].AudioData) != want {
+			t.Fatalf("request %d: expected %q, got %+v", i, want, responses[i])
+		}
+	}
+
+	// 原方法仍然快速失败
+	if _, err := client.GenerateSpeechBatch(context.Background(), requests); err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("expected fail-fast batch to report the real failure, got %v", err)
+	}
+}
+
+func TestGenerateSpeechBatchSettled_ReturnsParallelSlices(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseMultipartForm(1 << 20)
+		input := r.FormValue("input")
+		if strings.Contains(input, "bad") {
+			http.Error(w, "rejected", http.StatusBadRequest)
+			return
+		}
+		// 让成功项慢一些，确认失败不会取消它们
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio:" + input))
+	}))
+	defer upstream.Close()
+	client := newStubClient(t, upstream.URL, WithMaxConcurrent(3))
+
+	var requests []*TTSRequest
+	for _, text := range []string{"zero", "bad one", "two"} {
+		req, err := NewTTSRequest(text)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		requests = append(requests, req)
+	}
+
+	responses, errs := client.GenerateSpeechBatchSettled(context.Background(), requests)
+	if len(responses) != len(requests) || len(errs) != len(requests) {
+		t.Fatalf("expected parallel slices of %d, got %d responses and %d errors", len(requests), len(responses), len(errs))
+	}
+	for _, i := range []int{0, 2} {
+		if errs[i] != nil {
+			t.Fatalf("request %d: unexpected error %v", i, errs[i])
+		}
+	}
+	if responses[0] == nil || string(responses[0].AudioData) != "audio:zero" {
+		t.Fatalf("request 0: unexpected response %+v", responses[0])
+	}
+	if responses[2] == nil || string(responses[2].AudioData) != "audio:two" {
+		t.Fatalf("request 2: unexpected response %+v", responses[2])
+	}
+	var validationErr *ValidationException
+	if !errors.As(errs[1], &validationErr) {
+		t.Fatalf("request 1: expected ValidationException, got %v", errs[1])
+	}
+	if responses[1] != nil {
+		t.Fatal("request 1: expected nil response for failure")
+	}
+
+	if responses, errs := client.GenerateSpeechBatchSettled(context.Background(), nil); responses != nil || errs != nil {
+		t.Fatalf("expected nil slices for empty batch, got %v %v", responses, errs)
+	}
+}
+
+func TestGenerateSpeechBatchChan_DeliversAsCompleted(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseMultipartForm(1 << 20)
+		input := r.FormValue("input")
+		switch {
+		case strings.Contains(input, "bad"):
+			http.Error(w, "rejected", http.StatusBadRequest)
+			return
+		case strings.Contains(input, "slow"):
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio:" + input))
+	}))
+	defer upstream.Close()
+	client := newStubClient(t, upstream.URL, WithMaxConcurrent(3))
+
+	texts := []string{"slow one", "two", "bad three"}
+	var requests []*TTSRequest
+	for _, text := range texts {
+		req, err := NewTTSRequest(text)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		requests = append(requests, req)
+	}
+
+	var order []int
+	for res := range client.GenerateSpeechBatchChan(context.Background(), requests) {
+		order = append(order, res.Index)
+		if res.Index == 2 {
+			var validationErr *ValidationException
+			if !errors.As(res.Err, &validationErr) || res.Response != nil {
+				t.Fatalf("expected validation error for index 2, got %+v", res)
+			}
+			continue
+		}
+		if res.Err != nil {
+			t.Fatalf("request %d: unexpected error %v", res.Index, res.Err)
+		}
+		if want := "audio:" + texts[res.Index]; string(res.Response.AudioData) != want {
+			t.Fatalf("request %d: expected %q, got %q", res.Index, want, res.Response.AudioData)
+		}
+	}
+
+	if len(order) != len(requests) {
+		t.Fatalf("expected %d results, got %v", len(requests), order)
+	}
+	if order[len(order)-1] != 0 {
+		t.Fatalf("expected the slow request to be delivered last, got order %v", order)
+	}
+}
+
+func TestGenerateSpeechBatchChan_ClosesOnCancel(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+	client := newStubClient(t, upstream.URL, WithMaxConcurrent(1))
+
+	var requests []*TTSRequest
+	for i := 0; i < 5; i++ {
+		req, err := NewTTSRequest("text " + strconv.Itoa(i))
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		requests = append(requests, req)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan int)
+	go func() {
+		n := 0
+		for res := range client.GenerateSpeechBatchChan(ctx, requests) {
+			if res.Err == nil {
+				t.Errorf("request %d: expected error after cancel", res.Index)
+			}
+			n++
+		}
+		done <- n
+	}()
+
+	select {
+	case n := <-done:
+		if n >= len(requests) {
+			t.Fatalf("expected undispatched requests to be dropped, got %d results", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel was not closed after cancel")
+	}
+}
+
+// 在 -race 下运行：大量并发请求共享同一个 cookie jar（每个响应都会写入 cookie）
+func TestConcurrentRequests_SharedCookieJar(t *testing.T) {
+	var withCookie int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err == nil {
+			atomic.AddInt32(&withCookie, 1)
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: strconv.FormatInt(time.Now().UnixNano(), 10), Path: "/"})
+		http.SetCookie(w, &http.Cookie{Name: "seen", Value: "1", Path: "/"})
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+	client := newStubClient(t, upstream.URL, WithMaxConcurrent(16))
+
+	// 先发一个请求让 jar 里有 cookie，之后的并发请求同时读写它
+	if _, err := client.GenerateSpeech(context.Background(), "warm up"); err != nil {
+		t.Fatalf("warm up: %v", err)
+	}
+
+	const workers, perWorker = 32, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				if _, err := client.GenerateSpeech(context.Background(), "text "+strconv.Itoa(w*perWorker+i)); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent request failed: %v", err)
+	}
+
+	if got := atomic.LoadInt32(&withCookie); got != workers*perWorker {
+		t.Fatalf("expected every request after warm up to carry the session cookie, got %d/%d", got, workers*perWorker)
+	}
+}
+
+func TestShutdown_AbortsAndDrainsInflight(t *testing.T) {
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+	client := newStubClient(t, upstream.URL, WithMaxConcurrent(4))
+
+	const inflight = 3
+	errs := make(chan error, inflight)
+	for i := 0; i < inflight; i++ {
+		go func(i int) {
+			_, err := client.GenerateSpeech(context.Background(), "text "+strconv.Itoa(i))
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < inflight; i++ {
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+			t.Fatal("requests did not reach upstream")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	// Shutdown 返回时所有进行中的请求都已结束
+	for i := 0; i < inflight; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Fatal("expected in-flight request to be aborted")
+			}
+		default:
+			t.Fatal("Shutdown returned before in-flight requests finished")
+		}
+	}
+
+	if _, err := client.GenerateSpeech(context.Background(), "after shutdown"); !errors.Is(err, ErrClientShutdown) {
+		t.Fatalf("expected ErrClientShutdown after shutdown, got %v", err)
+	}
+}
+
+func TestShutdown_WaitsForOpenStreamBody(t *testing.T) {
+	upstream, _ := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte("audio") })
+	client := newStubClient(t, upstream.URL)
+
+	resp, err := client.GenerateSpeechStream(context.Background(), "Hello.")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected Shutdown to wait for the open body, got %v", err)
+	}
+
+	_ = resp.Close()
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown after closing body: %v", err)
+	}
+}
+
+func TestLongTextStreamConcurrent_RejectsWAVFormatMismatch(t *testing.T) {
+	wavAt := func(t *testing.T, sampleRate uint32, pcm []byte) []byte {
+		t.Helper()
+		data, err := buildWAVFile(&WAVHeader{
+			AudioFormat:   1,
+			NumChannels:   1,
+			SampleRate:    sampleRate,
+			ByteRate:      sampleRate * 2,
+			BlockAlign:    2,
+			BitsPerSample: 16,
+		}, pcm)
+		if err != nil {
+			t.Fatalf("build wav: %v", err)
+		}
+		return data
+	}
+	text := "This is chunk one. This is chunk two. This is chunk three."
+
+	cases := map[string]struct {
+		secondRate uint32
+		wantErr    bool
+	}{
+		"matching":   {secondRate: 24000},
+		"mismatched": {secondRate: 16000, wantErr: true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			upstream, _ := newStubUpstream(t, "audio/wav", func(input string) []byte {
+				if strings.Contains(input, "two") {
+					return wavAt(t, tc.secondRate, []byte{3, 4})
+				}
+				return wavAt(t, 24000, []byte{1, 2})
+			})
+			client := newStubClient(t, upstream.URL)
+
+			resp, err := client.GenerateSpeechLongTextStreamConcurrent(context.Background(), text, 25, true, nil, WithFormat(FormatWAV))
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+			defer resp.Close()
+			data, err := io.ReadAll(resp.Body)
+
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "wav format mismatch") {
+					t.Fatalf("expected wav format mismatch error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			// 流式输出沿用 chunk0 的 44 字节头，其后依次是各 chunk 的 PCM
+			if len(data) < 44 || !bytes.Equal(data[44:], []byte{1, 2, 3, 4, 1, 2}) {
+				t.Fatalf("unexpected combined audio: %v", data)
+			}
+		})
+	}
+}
+
+func TestWithMultipartBoundary(t *testing.T) {
+	var mu sync.Mutex
+	var contentTypes, bodies []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "read body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(raw))
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, "bad multipart", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		bodies = append(bodies, string(raw))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+
+	const boundary = "----WebKitFormBoundaryAbCdEfGh12345678"
+	client := newStubClient(t, upstream.URL, WithMultipartBoundary(boundary))
+	if _, err := client.GenerateSpeech(context.Background(), "Hello."); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	generated := newStubClient(t, upstream.URL, WithMultipartBoundaryGenerator(WebKitFormBoundary))
+	if _, err := generated.GenerateSpeech(context.Background(), "Hello."); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	// 默认仍为标准库的随机 boundary
+	random := newStubClient(t, upstream.URL)
+	for i := 0; i < 2; i++ {
+		if _, err := random.GenerateSpeech(context.Background(), "Hello."); err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := "multipart/form-data; boundary=" + boundary; contentTypes[0] != want {
+		t.Fatalf("expected Content-Type %q, got %q", want, contentTypes[0])
+	}
+	if !strings.HasPrefix(bodies[0], "--"+boundary+"\r\n") || !strings.HasSuffix(bodies[0], "--"+boundary+"--\r\n") {
+		t.Fatalf("expected body delimited by the fixed boundary, got %q", bodies[0])
+	}
+	if !strings.HasPrefix(contentTypes[1], "multipart/form-data; boundary=----WebKitFormBoundary") {
+		t.Fatalf("expected WebKit-style boundary, got %q", contentTypes[1])
+	}
+	if contentTypes[2] == contentTypes[3] || strings.Contains(contentTypes[2], boundary) {
+		t.Fatalf("expected a fresh random boundary per request by default, got %q and %q", contentTypes[2], contentTypes[3])
+	}
+
+	for _, bad := range []string{"", "has\nnewline", strings.Repeat("x", 71), "ends with space "} {
+		_, err := NewTTSClient(WithBaseURL(upstream.URL), WithMultipartBoundary(bad))
+		var ve *ValidationException
+		if !errors.As(err, &ve) {
+			t.Fatalf("boundary %q: expected ValidationException, got %v", bad, err)
+		}
+	}
+}
+
+func TestWithMultipartBoundary_RejectsFieldContainingBoundary(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte("audio") })
+
+	const boundary = "fixed-test-boundary"
+	client := newStubClient(t, upstream.URL, WithMultipartBoundary(boundary))
+	_, err := client.GenerateSpeech(context.Background(), "Hello --"+boundary+" injected.")
+	var ve *ValidationException
+	if !errors.As(err, &ve) || ve.Field != "input" {
+		t.Fatalf("expected ValidationException for input, got %v", err)
+	}
+	if n := len(rec.all()); n != 0 {
+		t.Fatalf("expected no upstream requests, got %d", n)
+	}
+}
+
+func TestWithBitrateAndQuality(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte("audio") })
+	client := newStubClient(t, upstream.URL)
+
+	resp, err := client.GenerateSpeech(context.Background(), "Hello.", WithBitrate(64), WithQuality("High"))
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if _, err := client.GenerateSpeech(context.Background(), "Hello."); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	forms := rec.all()
+	if forms[0]["bitrate"] != "64" || forms[0]["quality"] != "high" {
+		t.Fatalf("expected bitrate=64 quality=high, got %q %q", forms[0]["bitrate"], forms[0]["quality"])
+	}
+	if _, ok := forms[1]["bitrate"]; ok {
+		t.Fatalf("expected bitrate to be omitted when unset, got %q", forms[1]["bitrate"])
+	}
+	if resp.Metadata["bitrate"] != "64" || resp.Metadata["quality"] != "high" {
+		t.Fatalf("expected bitrate/quality in metadata, got %v", resp.Metadata)
+	}
+
+	// 无损/未压缩格式不重新编码，设置码率属于调用错误
+	for _, format := range []AudioFormat{FormatWAV, FormatPCM} {
+		_, err := NewTTSRequest("Hi", WithFormat(format), WithBitrate(128))
+		var ve *ValidationException
+		if !errors.As(err, &ve) || !strings.Contains(ve.Message, "only apply to mp3, aac and opus") {
+			t.Fatalf("format=%s: expected ValidationException, got %v", format, err)
+		}
+	}
+	for _, opt := range []RequestOption{WithBitrate(16), WithBitrate(512), WithQuality("ultra")} {
+		_, err := NewTTSRequest("Hi", WithFormat(FormatOPUS), opt)
+		var ve *ValidationException
+		if !errors.As(err, &ve) {
+			t.Fatalf("expected ValidationException, got %v", err)
+		}
+	}
+}
+
+func TestWithTimeout_AppliesOverallDeadline(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	client := newStubClient(t, upstream.URL, WithTimeout(100*time.Millisecond), WithConnectTimeout(5*time.Second))
+
+	start := time.Now()
+	_, err := client.GenerateSpeech(context.Background(), "hello")
+	if err == nil {
+		t.Fatal("expected the overall deadline to abort the request")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the request to stop near the 100ms deadline, took %v", elapsed)
+	}
+}
+
+func TestWithTimeout_CoversStreamBody(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte("ID3 partial"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	client := newStubClient(t, upstream.URL, WithTimeout(150*time.Millisecond))
+
+	resp, err := client.GenerateSpeechStream(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer resp.Close()
+
+	start := time.Now()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatal("expected reading a stalled body to fail once the deadline passes")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the body read to stop near the deadline, took %v", elapsed)
+	}
+}
+
+func TestWithConnectTimeout_IsPerUpstreamCall(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, "bad multipart", http.StatusBadRequest)
+			return
+		}
+		time.Sleep(150 * time.Millisecond)
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte(r.FormValue("input")))
+	}))
+	defer upstream.Close()
+
+	// 单次调用超过套接字超时即失败
+	strict := newStubClient(t, upstream.URL, WithTimeout(5*time.Second), WithConnectTimeout(50*time.Millisecond))
+	if _, err := strict.GenerateSpeech(context.Background(), "hello"); err == nil {
+		t.Fatal("expected the connect/socket timeout to abort a slow upstream call")
+	}
+
+	// 三个串行分段合计超过套接字超时，但每段都在其内，整体截止时间足够时成功
+	relaxed := newStubClient(t, upstream.URL, WithTimeout(5*time.Second), WithConnectTimeout(400*time.Millisecond))
+	resp, err := relaxed.GenerateSpeechLongTextStream(context.Background(),
+		"First sentence here. Second sentence here. Third sentence here.", 25, true)
+	if err != nil {
+		t.Fatalf("long text: %v", err)
+	}
+	defer resp.Close()
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("read long text: %v", err)
+	}
+	if relaxed.config.ConnectTimeout != 400*time.Millisecond || DefaultClientConfig().ConnectTimeout != 30*time.Second {
+		t.Fatalf("unexpected connect timeout config")
+	}
+}
+
+func TestWithTextPreprocessor(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	expand := func(text string) (string, error) {
+		return strings.ReplaceAll(text, "Dr.", "Doctor"), nil
+	}
+	client := newStubClient(t, upstream.URL, WithTextPreprocessor(expand), WithMarkdownStripping(true))
+
+	if _, err := client.GenerateSpeech(context.Background(), "**Dr. Smith** is in"); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if got := rec.all()[0]["input"]; got != "Doctor Smith is in." {
+		t.Fatalf("expected preprocessed input, got %q", got)
+	}
+
+	// 长文本按预处理后的文本分段
+	if _, err := client.GenerateSpeechLongText(context.Background(), "Dr. Who arrives. Dr. No leaves.", 20, true); err != nil {
+		t.Fatalf("long text: %v", err)
+	}
+	forms := rec.all()
+	if len(forms) != 3 || forms[1]["input"] != "Doctor Who arrives." || forms[2]["input"] != "Doctor No leaves." {
+		t.Fatalf("unexpected long-text chunks: %v", forms[1:])
+	}
+}
+
+func TestWithTextPreprocessor_ErrorIsValidationException(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	errUnsupported := errors.New("unsupported numeral")
+	client := newStubClient(t, upstream.URL, WithTextPreprocessor(func(string) (string, error) {
+		return "", errUnsupported
+	}))
+
+	_, err := client.GenerateSpeechStream(context.Background(), "hello")
+	var validationErr *ValidationException
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected ValidationException, got %T %v", err, err)
+	}
+	if !errors.Is(err, errUnsupported) {
+		t.Fatalf("expected the preprocessor error to be wrapped, got %v", err)
+	}
+	if _, err := client.GenerateSpeechLongTextStreamConcurrent(context.Background(), "hello", 100, true, nil); !errors.As(err, &validationErr) {
+		t.Fatalf("expected ValidationException from long-text stream, got %v", err)
+	}
+	if n := len(rec.all()); n != 0 {
+		t.Fatalf("expected no upstream calls, got %d", n)
+	}
+}
+
+func TestLongTextStream_StrictFormatRejectsNonWAVChunk(t *testing.T) {
+	text := "This is chunk one. This is chunk two. This is chunk three."
+	upstream, _ := newStubUpstream(t, "audio/wav", func(input string) []byte {
+		if strings.Contains(input, "two") {
+			return append([]byte{0xFF, 0xFB, 0x90, 0x64}, bytes.Repeat([]byte{0}, 40)...)
+		}
+		return testWAV(t, []byte{1, 2, 3, 4})
+	})
+
+	streams := map[string]func(c *TTSClient) (*TTSStreamResponse, error){
+		"sequential": func(c *TTSClient) (*TTSStreamResponse, error) {
+			return c.GenerateSpeechLongTextStream(context.Background(), text, 25, true, WithFormat(FormatWAV))
+		},
+		"concurrent": func(c *TTSClient) (*TTSStreamResponse, error) {
+			return c.GenerateSpeechLongTextStreamConcurrent(context.Background(), text, 25, true, nil, WithFormat(FormatWAV))
+		},
+	}
+	for name, stream := range streams {
+		t.Run(name, func(t *testing.T) {
+			read := func(strict bool) error {
+				resp, err := stream(newStubClient(t, upstream.URL, WithStrictFormat(strict)))
+				if err != nil {
+					return err
+				}
+				defer resp.Close()
+				_, err = io.ReadAll(resp.Body)
+				return err
+			}
+
+			if err := read(false); err != nil {
+				t.Fatalf("lenient mode should keep concatenating, got %v", err)
+			}
+			if err := read(true); !errors.Is(err, ErrNotWAV) {
+				t.Fatalf("expected ErrNotWAV in strict mode, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLongTextStream_RejectsMismatchedWAVFormat(t *testing.T) {
+	text := "This is chunk one. This is chunk two. This is chunk three."
+	formats := map[string]*WAVHeader{
+		"sample rate": {AudioFormat: 1, NumChannels: 1, SampleRate: 22050, ByteRate: 44100, BlockAlign: 2, BitsPerSample: 16},
+		"stereo":      {AudioFormat: 1, NumChannels: 2, SampleRate: 8000, ByteRate: 32000, BlockAlign: 4, BitsPerSample: 16},
+	}
+	streams := map[string]func(c *TTSClient) (*TTSStreamResponse, error){
+		"sequential": func(c *TTSClient) (*TTSStreamResponse, error) {
+			return c.GenerateSpeechLongTextStream(context.Background(), text, 25, true, WithFormat(FormatWAV))
+		},
+		"concurrent": func(c *TTSClient) (*TTSStreamResponse, error) {
+			return c.GenerateSpeechLongTextStreamConcurrent(context.Background(), text, 25, true, nil, WithFormat(FormatWAV))
+		},
+	}
+	for formatName, header := range formats {
+		upstream, _ := newStubUpstream(t, "audio/wav", func(input string) []byte {
+			// chunk 0 为 8000 Hz 单声道（testWAV），chunk 1 换成其他格式
+			if strings.Contains(input, "two") {
+				data, err := buildWAVFile(header, []byte{1, 2, 3, 4})
+				if err != nil {
+					t.Errorf("build wav: %v", err)
+				}
+				return data
+			}
+			return testWAV(t, []byte{1, 2, 3, 4})
+		})
+		for streamName, stream := range streams {
+			t.Run(formatName+"/"+streamName, func(t *testing.T) {
+				resp, err := stream(newStubClient(t, upstream.URL))
+				if err != nil {
+					t.Fatalf("stream: %v", err)
+				}
+				defer resp.Close()
+				if _, err := io.ReadAll(resp.Body); err == nil || !strings.Contains(err.Error(), "wav format mismatch") {
+					t.Fatalf("expected wav format mismatch error, got %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestLongTextStreamConcurrent_StrictFormatRejectsNonWAVFirstChunk(t *testing.T) {
+	upstream, _ := newStubUpstream(t, "audio/wav", func(input string) []byte {
+		return []byte("definitely not a riff header, just bytes")
+	})
+	client := newStubClient(t, upstream.URL, WithStrictFormat(true))
+
+	_, err := client.GenerateSpeechLongTextStreamConcurrent(context.Background(),
+		"This is chunk one. This is chunk two.", 20, true, nil, WithFormat(FormatWAV))
+	if !errors.Is(err, ErrNotWAV) {
+		t.Fatalf("expected ErrNotWAV for a non-WAV first chunk, got %v", err)
+	}
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressBody_IgnoresWrongUncompressedFlag(t *testing.T) {
+	audio := append([]byte("ID3"), bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x64}, 8)...)
+	client := newStubClient(t, "http://127.0.0.1:1")
+
+	resp := &fhttp.Response{
+		Header:       fhttp.Header{"Content-Encoding": {"gzip"}},
+		Body:         io.NopCloser(bytes.NewReader(gzipBytes(t, audio))),
+		Uncompressed: true,
+	}
+	client.decompressBody(context.Background(), resp, FormatMP3)
+	got, err := io.ReadAll(resp.Body)
+	if err != nil || !bytes.Equal(got, audio) {
+		t.Fatalf("expected decompressed audio, got %q (err %v)", got, err)
+	}
+
+	// 已解压的响应（无 Content-Encoding、非压缩魔数）原样透传
+	resp = &fhttp.Response{
+		Header:       fhttp.Header{},
+		Body:         io.NopCloser(bytes.NewReader(audio)),
+		Uncompressed: true,
+	}
+	client.decompressBody(context.Background(), resp, FormatMP3)
+	if got, _ := io.ReadAll(resp.Body); !bytes.Equal(got, audio) {
+		t.Fatalf("expected audio to pass through, got %q", got)
+	}
+
+	// 裸 PCM 不按魔数识别
+	pcm := gzipBytes(t, []byte{1, 2, 3, 4})
+	resp = &fhttp.Response{Header: fhttp.Header{}, Body: io.NopCloser(bytes.NewReader(pcm))}
+	client.decompressBody(context.Background(), resp, FormatPCM)
+	if got, _ := io.ReadAll(resp.Body); !bytes.Equal(got, pcm) {
+		t.Fatalf("expected pcm bytes untouched, got %q", got)
+	}
+}
+
+func TestGenerateSpeech_DecompressesGzipBodyWithoutContentEncoding(t *testing.T) {
+	audio := append([]byte("ID3"), bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x64}, 8)...)
+	compressed := gzipBytes(t, audio)
+	upstream, _ := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return compressed })
+	client := newStubClient(t, upstream.URL)
+
+	resp, err := client.GenerateSpeech(context.Background(), "Hello.")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !bytes.Equal(resp.AudioData, audio) {
+		t.Fatalf("expected decompressed audio, got %q", resp.AudioData)
+	}
+}
+
+func TestStrictFormat_RejectsFormatFallback(t *testing.T) {
+	upstream, _ := newStubUpstream(t, "audio/wav", func(input string) []byte { return testWAV(t, []byte{1, 2, 3, 4}) })
+
+	cases := map[string]struct {
+		clientStrict bool
+		opts         []RequestOption
+		wantErr      bool
+	}{
+		"lenient":                 {},
+		"strict client":           {clientStrict: true, wantErr: true},
+		"request enables strict":  {opts: []RequestOption{WithStrictResponseFormat(true)}, wantErr: true},
+		"request disables strict": {clientStrict: true, opts: []RequestOption{WithStrictResponseFormat(false)}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			client := newStubClient(t, upstream.URL, WithStrictFormat(tc.clientStrict))
+			opts := append([]RequestOption{WithFormat(FormatOPUS)}, tc.opts...)
+			resp, err := client.GenerateSpeech(context.Background(), "Hello.", opts...)
+
+			if tc.wantErr {
+				var ve *ValidationException
+				if !errors.As(err, &ve) || ve.Field != "response_format" {
+					t.Fatalf("expected response_format ValidationException, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+			if resp.Format != FormatWAV {
+				t.Fatalf("expected lenient fallback to wav, got %s", resp.Format)
+			}
+		})
+	}
+}
+
+func TestWithSeed(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte("audio") })
+	client := newStubClient(t, upstream.URL)
+
+	for _, step := range []struct {
+		text string
+		opts []RequestOption
+	}{
+		{"Hello.", []RequestOption{WithSeed(42)}},
+		{"Hello.", []RequestOption{WithSeed(42)}},
+		{"Goodbye.", []RequestOption{WithSeed(42)}},
+		{"Hello.", []RequestOption{WithSeed(7)}},
+		{"Hello.", nil},
+		{"Hello.", nil},
+	} {
+		if _, err := client.GenerateSpeech(context.Background(), step.text, step.opts...); err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+	}
+
+	forms := rec.all()
+	if forms[0]["seed"] != "42" || forms[3]["seed"] != "7" {
+		t.Fatalf("expected seed form field, got %q and %q", forms[0]["seed"], forms[3]["seed"])
+	}
+	if forms[0]["generation"] != forms[1]["generation"] {
+		t.Fatalf("same text+seed should reuse the generation, got %q and %q", forms[0]["generation"], forms[1]["generation"])
+	}
+	if forms[0]["generation"] == forms[2]["generation"] || forms[0]["generation"] == forms[3]["generation"] {
+		t.Fatal("different text or seed should derive a different generation")
+	}
+	if _, ok := forms[4]["seed"]; ok {
+		t.Fatalf("expected seed to be omitted when unset, got %q", forms[4]["seed"])
+	}
+	if forms[4]["generation"] == forms[5]["generation"] {
+		t.Fatal("expected a random generation per request without a seed")
+	}
+}
+
+func TestSetProxy_ConcurrentWithRequests(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	client := newStubClient(t, upstream.URL)
+
+	const requests = 20
+	stop := make(chan struct{})
+	var proxyWG sync.WaitGroup
+	proxyWG.Add(1)
+	go func() {
+		defer proxyWG.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			var err error
+			if i%2 == 0 {
+				err = client.SetProxy("")
+			} else {
+				err = client.ClearProxy()
+			}
+			if err != nil {
+				t.Errorf("SetProxy failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.GenerateSpeech(context.Background(), "Request "+strconv.Itoa(i)+".", WithFormat(FormatMP3))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !strings.HasPrefix(string(resp.AudioData), "Request ") {
+				errs <- errors.New("unexpected audio " + string(resp.AudioData))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	proxyWG.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("request failed while switching proxy: %v", err)
+	}
+	if got := len(rec.all()); got != requests {
+		t.Fatalf("expected %d upstream requests, got %d", requests, got)
+	}
+}
+
+func TestWithInstructionsTemplate(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	client := newStubClient(t, upstream.URL, WithDefaultVibe("calm"))
+
+	const tmpl = "Voice={{.Voice}} Tone={{.Vibe}} Format={{.Format}}"
+	requests := [][]RequestOption{
+		{WithVoice(VoiceNova), WithInstructionsTemplate(tmpl)},
+		{WithVoice(VoiceEcho), WithVibe("dramatic"), WithInstructions("ignored"), WithInstructionsTemplate(tmpl)},
+		{WithInstructions("Speak slowly.")},
+		{},
+	}
+	for i, opts := range requests {
+		if _, err := client.GenerateSpeech(context.Background(), "Hello there.", append(opts, WithFormat(FormatMP3))...); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+
+	forms := rec.all()
+	want := []string{
+		"Voice=nova Tone=calm Format=mp3",
+		"Voice=echo Tone=dramatic Format=mp3",
+		"Speak slowly.",
+		DefaultInstructions,
+	}
+	for i, w := range want {
+		if forms[i]["prompt"] != w {
+			t.Fatalf("request %d: expected prompt %q, got %q", i, w, forms[i]["prompt"])
+		}
+	}
+	if forms[1]["vibe"] != "dramatic" {
+		t.Fatalf("expected vibe form field to follow WithVibe, got %q", forms[1]["vibe"])
+	}
+
+	var validationErr *ValidationException
+	if _, err := NewTTSRequest("Hello.", WithInstructionsTemplate("{{.Voice")); !errors.As(err, &validationErr) || validationErr.Field != "instructions_template" {
+		t.Fatalf("expected ValidationException for a malformed template, got %v", err)
+	}
+	if _, err := client.GenerateSpeech(context.Background(), "Hello.", WithInstructionsTemplate("{{.Mood}}")); !errors.As(err, &validationErr) {
+		t.Fatalf("expected ValidationException for an unknown template field, got %v", err)
+	}
+	if n := len(rec.all()); n != len(requests) {
+		t.Fatalf("expected failed renders to skip the upstream, got %d requests", n)
+	}
+}
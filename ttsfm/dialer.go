@@ -0,0 +1,100 @@
+package ttsfm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	tls_client "github.com/bogdanfinn/tls-client"
+	"golang.org/x/net/proxy"
+)
+
+// normalizeStaticHostMapping 校验并规范化静态主机映射（主机名小写，IP 必须合法）
+func normalizeStaticHostMapping(mapping map[string][]string) (map[string][]string, error) {
+	if len(mapping) == 0 {
+		return nil, nil
+	}
+
+	normalized := make(map[string][]string, len(mapping))
+	for host, ips := range mapping {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" {
+			return nil, NewValidationException("Static host mapping has an empty host", "static_host_mapping", "")
+		}
+		if len(ips) == 0 {
+			return nil, NewValidationException(
+				fmt.Sprintf("Static host mapping for %s has no IPs", host),
+				"static_host_mapping",
+				host,
+			)
+		}
+		for _, ip := range ips {
+			if net.ParseIP(strings.TrimSpace(ip)) == nil {
+				return nil, NewValidationException(
+					fmt.Sprintf("Invalid IP %q in static host mapping for %s", ip, host),
+					"static_host_mapping",
+					ip,
+				)
+			}
+			normalized[host] = append(normalized[host], strings.TrimSpace(ip))
+		}
+	}
+	return normalized, nil
+}
+
+// staticHostDialer 将映射中的主机直接拨到固定 IP（按顺序尝试），其余主机走正常 DNS。
+// 只替换 TCP 拨号地址，TLS SNI 与 Host 头仍使用原始主机名。
+type staticHostDialer struct {
+	dialer  net.Dialer
+	mapping map[string][]string
+}
+
+func (d *staticHostDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *staticHostDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, ok := d.mapping[strings.ToLower(host)]
+	if !ok {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("dial %s via static mapping: %w", host, lastErr)
+}
+
+// staticHostDialerFactory 通过 tls_client 的自定义拨号器入口接入静态主机映射。
+// tls_client 的 CONNECT/SOCKS 代理拨号器未导出，无法在其上叠加映射，因此与代理互斥。
+func staticHostDialerFactory(mapping map[string][]string) tls_client.ProxyDialerFactory {
+	return func(proxyURL string, timeout time.Duration, localAddr *net.TCPAddr, _ http.Header, _ tls_client.Logger) (proxy.ContextDialer, error) {
+		if strings.TrimSpace(proxyURL) != "" {
+			return nil, fmt.Errorf("static host mapping cannot be combined with a proxy (%s)", proxyURL)
+		}
+		d := &staticHostDialer{
+			dialer:  net.Dialer{Timeout: timeout},
+			mapping: mapping,
+		}
+		if localAddr != nil {
+			d.dialer.LocalAddr = localAddr
+		}
+		return d, nil
+	}
+}
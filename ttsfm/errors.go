@@ -1,9 +1,18 @@
 package ttsfm
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
+// ErrClientShutdown 客户端已调用 Shutdown，不再接受新的上游请求
+var ErrClientShutdown = errors.New("ttsfm: client is shut down")
+
+// ErrNotWAV 严格格式模式下，按 WAV 拼接的分段不以合法的 RIFF/WAVE 头开头
+var ErrNotWAV = errors.New("ttsfm: chunk is not a RIFF/WAVE stream")
+
 // TTSException 基础 TTS 异常
 type TTSException struct {
 	Code    string
@@ -116,6 +125,23 @@ func NewAuthenticationException(message string) *AuthenticationException {
 	}
 }
 
+// BusyException 客户端并发槽位在限定时间内无法获取
+type BusyException struct {
+	*TTSException
+	Wait time.Duration
+}
+
+// NewBusyException 创建新的繁忙异常
+func NewBusyException(message string, wait time.Duration) *BusyException {
+	return &BusyException{
+		TTSException: &TTSException{
+			Code:    "BUSY",
+			Message: message,
+		},
+		Wait: wait,
+	}
+}
+
 // CreateExceptionFromResponse 根据响应创建对应的异常
 func CreateExceptionFromResponse(statusCode int, errorData map[string]interface{}, defaultMessage string) error {
 	message := defaultMessage
@@ -144,4 +170,41 @@ func CreateExceptionFromResponse(statusCode int, errorData map[string]interface{
 	default:
 		return NewAPIException(message, statusCode)
 	}
-}
\ No newline at end of file
+}
+
+// BatchError 批量请求的部分失败：Errors 与请求按下标对齐，成功项为 nil
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	var msgs []string
+	for i, err := range e.Errors {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("request %d failed: %v", i, err))
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap 返回所有非 nil 的错误，支持 errors.Is/errors.As
+func (e *BatchError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errors {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Failed 返回失败请求的下标
+func (e *BatchError) Failed() []int {
+	var indexes []int
+	for i, err := range e.Errors {
+		if err != nil {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,246 @@
+package ttsfm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel 日志级别
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String 返回级别名称（小写）
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// ParseLogLevel 解析级别名称（debug/info/warn/error，不区分大小写）
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level %q", s)
+	}
+}
+
+type logLevelContextKey struct{}
+
+// ContextWithLogLevel 为单个请求指定最低日志级别（如服务端收到 X-Log-Level: debug），
+// 客户端在该 ctx 下的日志（含重试日志）按此级别过滤，而不必重启换日志器
+func ContextWithLogLevel(ctx context.Context, level LogLevel) context.Context {
+	return context.WithValue(ctx, logLevelContextKey{}, level)
+}
+
+// LogLevelFromContext 读取 ContextWithLogLevel 写入的级别
+func LogLevelFromContext(ctx context.Context) (LogLevel, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	level, ok := ctx.Value(logLevelContextKey{}).(LogLevel)
+	return level, ok
+}
+
+// LevelOverrider 由支持按请求调整级别的 Logger 实现（LevelLogger、JSONLogger），
+// 返回的 Logger 与原日志器共享输出；最低级别取 level 与原级别中较低者，只能输出更多日志而不能屏蔽已配置的级别
+type LevelOverrider interface {
+	WithMinLevel(level LogLevel) Logger
+}
+
+// LoggerForContext 返回 ctx 中携带请求级别时对应的日志器；
+// ctx 未指定级别或 logger 不支持 LevelOverrider 时原样返回 logger
+func LoggerForContext(ctx context.Context, logger Logger) Logger {
+	level, ok := LogLevelFromContext(ctx)
+	if !ok {
+		return logger
+	}
+	if o, ok := logger.(LevelOverrider); ok {
+		return o.WithMinLevel(level)
+	}
+	return logger
+}
+
+// LevelLogger 按最低级别过滤日志后转发给内部 Logger（如为 DefaultLogger 屏蔽 Debug）
+type LevelLogger struct {
+	next     Logger
+	minLevel LogLevel
+}
+
+// NewLevelLogger 创建级别过滤日志；next 为 nil 时使用 DefaultLogger
+func NewLevelLogger(next Logger, minLevel LogLevel) *LevelLogger {
+	if next == nil {
+		next = &DefaultLogger{}
+	}
+	return &LevelLogger{next: next, minLevel: minLevel}
+}
+
+// WithMinLevel 返回转发到同一内部 Logger、最低级别为 min(level, 当前级别) 的副本
+func (l *LevelLogger) WithMinLevel(level LogLevel) Logger {
+	return &LevelLogger{next: l.next, minLevel: min(level, l.minLevel)}
+}
+
+func (l *LevelLogger) Info(msg string, args ...interface{}) {
+	if l.minLevel <= LevelInfo {
+		l.next.Info(msg, args...)
+	}
+}
+
+func (l *LevelLogger) Warn(msg string, args ...interface{}) {
+	if l.minLevel <= LevelWarn {
+		l.next.Warn(msg, args...)
+	}
+}
+
+func (l *LevelLogger) Error(msg string, args ...interface{}) {
+	if l.minLevel <= LevelError {
+		l.next.Error(msg, args...)
+	}
+}
+
+func (l *LevelLogger) Debug(msg string, args ...interface{}) {
+	if l.minLevel <= LevelDebug {
+		l.next.Debug(msg, args...)
+	}
+}
+
+// JSONLogger 结构化日志实现：每行输出一个 JSON 对象（time/level/msg），便于日志采集系统解析
+type JSONLogger struct {
+	mu       sync.Mutex
+	out      io.Writer
+	minLevel LogLevel
+}
+
+// NewJSONLogger 创建 JSON 日志；out 为 nil 时写到 stderr，低于 minLevel 的日志被丢弃
+func NewJSONLogger(out io.Writer, minLevel LogLevel) *JSONLogger {
+	if out == nil {
+		out = os.Stderr
+	}
+	return &JSONLogger{out: out, minLevel: minLevel}
+}
+
+type jsonLogEntry struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
+func (l *JSONLogger) Info(msg string, args ...interface{}) {
+	l.log(l.minLevel, LevelInfo, msg, args...)
+}
+func (l *JSONLogger) Warn(msg string, args ...interface{}) {
+	l.log(l.minLevel, LevelWarn, msg, args...)
+}
+func (l *JSONLogger) Error(msg string, args ...interface{}) {
+	l.log(l.minLevel, LevelError, msg, args...)
+}
+func (l *JSONLogger) Debug(msg string, args ...interface{}) {
+	l.log(l.minLevel, LevelDebug, msg, args...)
+}
+
+// WithMinLevel 返回写入同一输出（共用同一把锁）、最低级别为 min(level, 当前级别) 的视图
+func (l *JSONLogger) WithMinLevel(level LogLevel) Logger {
+	return &jsonLevelView{parent: l, minLevel: min(level, l.minLevel)}
+}
+
+func (l *JSONLogger) log(minLevel, level LogLevel, msg string, args ...interface{}) {
+	if level < minLevel {
+		return
+	}
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+
+	line, err := json.Marshal(jsonLogEntry{
+		Time:  time.Now().UTC().Format(time.RFC3339Nano),
+		Level: level.String(),
+		Msg:   msg,
+	})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(line)
+}
+
+type jsonLevelView struct {
+	parent   *JSONLogger
+	minLevel LogLevel
+}
+
+func (v *jsonLevelView) Info(msg string, args ...interface{}) {
+	v.parent.log(v.minLevel, LevelInfo, msg, args...)
+}
+
+func (v *jsonLevelView) Warn(msg string, args ...interface{}) {
+	v.parent.log(v.minLevel, LevelWarn, msg, args...)
+}
+
+func (v *jsonLevelView) Error(msg string, args ...interface{}) {
+	v.parent.log(v.minLevel, LevelError, msg, args...)
+}
+
+func (v *jsonLevelView) Debug(msg string, args ...interface{}) {
+	v.parent.log(v.minLevel, LevelDebug, msg, args...)
+}
+
+// SlogLogger 将 Logger 接口适配到 *slog.Logger：格式化后的文本作为 msg，级别一一对应
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger 创建 slog 适配器；logger 为 nil 时使用 slog.Default()
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogLogger{logger: logger}
+}
+
+func (l *SlogLogger) Info(msg string, args ...interface{})  { l.log(slog.LevelInfo, msg, args...) }
+func (l *SlogLogger) Warn(msg string, args ...interface{})  { l.log(slog.LevelWarn, msg, args...) }
+func (l *SlogLogger) Error(msg string, args ...interface{}) { l.log(slog.LevelError, msg, args...) }
+func (l *SlogLogger) Debug(msg string, args ...interface{}) { l.log(slog.LevelDebug, msg, args...) }
+
+func (l *SlogLogger) log(level slog.Level, msg string, args ...interface{}) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	l.logger.Log(ctx, level, msg)
+}
@@ -0,0 +1,147 @@
+package ttsfm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLogger_EmitsOneObjectPerLine(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, LevelInfo)
+
+	logger.Debug("hidden %d", 1)
+	logger.Info("streamed %d bytes of %s", 42, "mp3")
+	logger.Error("quote \" and newline\n")
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines (debug suppressed), got %d: %q", len(lines), buf.String())
+	}
+
+	var entry struct {
+		Time  string `json:"time"`
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("line is not JSON: %v", err)
+	}
+	if entry.Level != "info" || entry.Msg != "streamed 42 bytes of mp3" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, entry.Time); err != nil {
+		t.Fatalf("unexpected time %q: %v", entry.Time, err)
+	}
+
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("line is not JSON: %v", err)
+	}
+	if entry.Level != "error" || entry.Msg != "quote \" and newline\n" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for in, want := range map[string]LogLevel{"debug": LevelDebug, "": LevelInfo, "WARN": LevelWarn, "error": LevelError} {
+		got, err := ParseLogLevel(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseLogLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+}
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (r *recordingLogger) Info(msg string, args ...interface{})  { r.lines = append(r.lines, "info") }
+func (r *recordingLogger) Warn(msg string, args ...interface{})  { r.lines = append(r.lines, "warn") }
+func (r *recordingLogger) Error(msg string, args ...interface{}) { r.lines = append(r.lines, "error") }
+func (r *recordingLogger) Debug(msg string, args ...interface{}) { r.lines = append(r.lines, "debug") }
+
+func TestLevelLogger_DropsBelowMinimum(t *testing.T) {
+	for level, want := range map[LogLevel]string{
+		LevelDebug: "debug,info,warn,error",
+		LevelInfo:  "info,warn,error",
+		LevelWarn:  "warn,error",
+		LevelError: "error",
+	} {
+		rec := &recordingLogger{}
+		logger := NewLevelLogger(rec, level)
+		logger.Debug("d")
+		logger.Info("i")
+		logger.Warn("w")
+		logger.Error("e")
+		if got := strings.Join(rec.lines, ","); got != want {
+			t.Fatalf("min level %s: expected %q, got %q", level, want, got)
+		}
+	}
+}
+
+func TestSlogLogger_FormatsMessageAndMapsLevels(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+	logger := NewSlogLogger(slog.New(handler))
+
+	logger.Debug("hidden")
+	logger.Warn("retrying after %v (attempt %d)", time.Second, 2)
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected debug to be filtered by the handler, got %q", buf.String())
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("line is not JSON: %v", err)
+	}
+	if entry["level"] != "WARN" || entry["msg"] != "retrying after 1s (attempt 2)" {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+}
+
+func TestLoggerForContext_OverridesMinimumLevel(t *testing.T) {
+	rec := &recordingLogger{}
+	base := NewLevelLogger(rec, LevelWarn)
+
+	LoggerForContext(context.Background(), base).Debug("d")
+	LoggerForContext(ContextWithLogLevel(context.Background(), LevelDebug), base).Debug("d")
+	base.Debug("d")
+	if got := strings.Join(rec.lines, ","); got != "debug" {
+		t.Fatalf("expected only the request-scoped debug line, got %q", got)
+	}
+
+	var buf bytes.Buffer
+	jsonLogger := NewJSONLogger(&buf, LevelInfo)
+	LoggerForContext(ContextWithLogLevel(context.Background(), LevelDebug), jsonLogger).Debug("visible")
+	jsonLogger.Debug("hidden")
+	if strings.Count(buf.String(), "\n") != 1 || !strings.Contains(buf.String(), `"visible"`) {
+		t.Fatalf("unexpected json output: %q", buf.String())
+	}
+
+	// 请求级别只能放宽，不能屏蔽已配置的级别
+	rec.lines = nil
+	LoggerForContext(ContextWithLogLevel(context.Background(), LevelError), base).Warn("w")
+	if got := strings.Join(rec.lines, ","); got != "warn" {
+		t.Fatalf("expected a higher request level to keep the configured warn level, got %q", got)
+	}
+	buf.Reset()
+	LoggerForContext(ContextWithLogLevel(context.Background(), LevelError), jsonLogger).Info("kept")
+	if !strings.Contains(buf.String(), `"kept"`) {
+		t.Fatalf("expected a higher request level to keep the configured info level, got %q", buf.String())
+	}
+
+	// 不支持 LevelOverrider 的日志器原样返回
+	plain := &recordingLogger{}
+	if LoggerForContext(ContextWithLogLevel(context.Background(), LevelDebug), plain) != Logger(plain) {
+		t.Fatal("expected logger without LevelOverrider to be returned unchanged")
+	}
+}
@@ -0,0 +1,99 @@
+package ttsfm
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	mdHeadingPattern    = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*\s*$`)
+	mdListItemPattern   = regexp.MustCompile(`^(?:[-*+]|\d+[.)])\s+(.*)$`)
+	mdRulePattern       = regexp.MustCompile(`^(?:(?:\*\s*){3,}|(?:-\s*){3,}|(?:_\s*){3,})$`)
+	mdFencePattern      = regexp.MustCompile("^(?:```|~~~)")
+	mdImagePattern      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLinkPattern       = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	mdInlineCodePattern = regexp.MustCompile("`+([^`]+)`+")
+	mdStarBoldPattern   = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*`)
+	mdUnderBoldPattern  = regexp.MustCompile(`__(\S(?:.*?\S)?)__`)
+	mdStarItalicPattern = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*`)
+	// 下划线斜体要求两侧不是单词字符，避免误伤 snake_case
+	mdUnderscoreItalicPattern = regexp.MustCompile(`(^|[^\w])_(\S(?:[^_]*?\S)?)_($|[^\w])`)
+	mdStrikePattern           = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+)
+
+// StripMarkdown 去除 Markdown 格式标记，只保留需要朗读的文本：
+// 标题、强调、删除线、行内代码与代码块围栏标记被去掉，[text](url) 只保留 text，图片保留替代文本。
+// 标题、列表项、代码块和段落各自成为独立的句子（缺少句末标点时补上句号），
+// 这样 SanitizeText 合并空白后分句仍然落在原来的块边界上。
+func StripMarkdown(text string) string {
+	var blocks []string
+	var current []string
+	flush := func() {
+		if block := strings.TrimSpace(strings.Join(current, " ")); block != "" {
+			blocks = append(blocks, terminateSentence(block))
+		}
+		current = current[:0]
+	}
+
+	inFence := false
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if mdFencePattern.MatchString(trimmed) {
+			flush()
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			if trimmed != "" {
+				current = append(current, trimmed)
+			}
+			continue
+		}
+
+		for strings.HasPrefix(trimmed, ">") {
+			trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))
+		}
+
+		switch {
+		case trimmed == "" || mdRulePattern.MatchString(trimmed):
+			flush()
+		case mdHeadingPattern.MatchString(trimmed):
+			flush()
+			current = append(current, stripInlineMarkdown(mdHeadingPattern.FindStringSubmatch(trimmed)[1]))
+			flush()
+		case mdListItemPattern.MatchString(trimmed):
+			flush()
+			current = append(current, stripInlineMarkdown(mdListItemPattern.FindStringSubmatch(trimmed)[1]))
+		default:
+			current = append(current, stripInlineMarkdown(trimmed))
+		}
+	}
+	flush()
+
+	return strings.Join(blocks, "\n\n")
+}
+
+// stripInlineMarkdown 去除单行内的链接、代码与强调标记
+func stripInlineMarkdown(line string) string {
+	line = mdImagePattern.ReplaceAllString(line, "$1")
+	line = mdLinkPattern.ReplaceAllString(line, "$1")
+	line = mdInlineCodePattern.ReplaceAllString(line, "$1")
+	line = mdStarBoldPattern.ReplaceAllString(line, "$1")
+	line = mdUnderBoldPattern.ReplaceAllString(line, "$1")
+	line = mdStrikePattern.ReplaceAllString(line, "$1")
+	line = mdStarItalicPattern.ReplaceAllString(line, "$1")
+	line = mdUnderscoreItalicPattern.ReplaceAllString(line, "$1$2$3")
+	return strings.TrimSpace(line)
+}
+
+// terminateSentence 块末尾没有句末标点时补上句号，使块边界成为分句边界
+func terminateSentence(block string) string {
+	last, _ := utf8.DecodeLastRuneInString(block)
+	switch last {
+	case '.', '!', '?', ':', ';', '。', '！', '？', '：', '；':
+		return block
+	}
+	return block + "."
+}
@@ -0,0 +1,75 @@
+package ttsfm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStripMarkdown(t *testing.T) {
+	cases := map[string]struct {
+		in   string
+		want string
+	}{
+		"headings": {
+			in:   "# Getting Started\n\nInstall the tool.\n\n## Usage ##",
+			want: "Getting Started.\n\nInstall the tool.\n\nUsage.",
+		},
+		"links and images": {
+			in:   "See [the docs](https://example.com/docs) and ![a diagram](img.png) for details.",
+			want: "See the docs and a diagram for details.",
+		},
+		"emphasis": {
+			in:   "This is **bold**, *italic*, __strong__, _em_ and ~~gone~~ but snake_case_name stays.",
+			want: "This is bold, italic, strong, em and gone but snake_case_name stays.",
+		},
+		"inline and fenced code": {
+			in:   "Run `make build` first.\n\n```go\nfmt.Println(1)\n```\nDone",
+			want: "Run make build first.\n\nfmt.Println(1).\n\nDone.",
+		},
+		"lists and quotes": {
+			in:   "Steps:\n- first item\n- second item!\n1. numbered\n\n> quoted line",
+			want: "Steps:\n\nfirst item.\n\nsecond item!\n\nnumbered.\n\nquoted line.",
+		},
+		"paragraph lines join": {
+			in:   "A paragraph that\nwraps lines\n\n---\n\nNext",
+			want: "A paragraph that wraps lines.\n\nNext.",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := StripMarkdown(tc.in); got != tc.want {
+				t.Fatalf("StripMarkdown(%q)\n got: %q\nwant: %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStripMarkdown_BlocksBecomeSentences(t *testing.T) {
+	clean, err := SanitizeText(StripMarkdown("# Title\n\n- one\n- two"))
+	if err != nil {
+		t.Fatalf("sanitize: %v", err)
+	}
+	got := splitBySentences(clean)
+	want := []string{"Title.", "one.", "two."}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestWithMarkdownStripping(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte("audio") })
+	client := newStubClient(t, upstream.URL, WithMarkdownStripping(true))
+
+	if _, err := client.GenerateSpeech(context.Background(), "## **Hello** [world](https://example.com)"); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if got := rec.all()[0]["input"]; got != "Hello world." {
+		t.Fatalf("expected markdown to be stripped, got %q", got)
+	}
+}
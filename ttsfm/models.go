@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
 // Voice 可用的语音选项
@@ -41,6 +46,13 @@ func (v Voice) IsValid() bool {
 	return false
 }
 
+// SupportedModels OpenAI 兼容的 TTS 模型 ID（上游不区分模型，仅用于客户端兼容）
+var SupportedModels = []string{
+	"gpt-4o-mini-tts",
+	"tts-1",
+	"tts-1-hd",
+}
+
 // AudioFormat 支持的音频输出格式
 type AudioFormat string
 
@@ -122,6 +134,52 @@ func MapsToWAV(format string) bool {
 	return f == "wav" || f == "opus" || f == "aac" || f == "flac" || f == "pcm"
 }
 
+// 语速范围（0 表示未设置，使用上游默认值）
+const (
+	MinSpeed = 0.25
+	MaxSpeed = 4.0
+)
+
+// IsValidSpeed 检查语速是否在允许范围内
+func IsValidSpeed(speed float64) bool {
+	return speed >= MinSpeed && speed <= MaxSpeed
+}
+
+// languagePattern 宽松的 BCP-47 语言标签校验：语言码 + 可选地区码（如 zh、zh-CN、de-DE）
+var languagePattern = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+
+// IsValidLanguage 检查语言标签格式是否合法
+func IsValidLanguage(lang string) bool {
+	return languagePattern.MatchString(lang)
+}
+
+// ValidSampleRates 允许请求的输出采样率（Hz）
+var ValidSampleRates = []int{8000, 16000, 22050, 24000, 44100, 48000}
+
+// IsValidSampleRate 检查采样率是否为支持的常用值
+func IsValidSampleRate(hz int) bool {
+	for _, rate := range ValidSampleRates {
+		if hz == rate {
+			return true
+		}
+	}
+	return false
+}
+
+// 码率范围（kbps，0 表示未设置，使用上游默认值）
+const (
+	MinBitrate = 32
+	MaxBitrate = 320
+)
+
+// ValidQualities 允许的编码质量档位
+var ValidQualities = []string{"low", "medium", "high"}
+
+// SupportsBitrate 检查格式是否为有损压缩格式（码率/质量参数仅对其生效）
+func (f AudioFormat) SupportsBitrate() bool {
+	return f == FormatMP3 || f == FormatAAC || f == FormatOPUS
+}
+
 // TTSRequest TTS 生成请求模型
 type TTSRequest struct {
 	Input          string      `json:"input"`
@@ -130,8 +188,38 @@ type TTSRequest struct {
 	Instructions   string      `json:"instructions,omitempty"`
 	Model          string      `json:"model,omitempty"`
 	Speed          float64     `json:"speed,omitempty"`
-	MaxLength      int         `json:"-"`
-	ValidateLength bool        `json:"-"`
+	Vibe           string      `json:"vibe,omitempty"`
+	// Language 语言/地区提示（BCP-47，如 zh-CN），为空时不发送给上游
+	Language string `json:"language,omitempty"`
+	// SampleRate 输出采样率（Hz，仅对 WAV/PCM 有意义），0 表示使用上游默认值
+	SampleRate int `json:"sample_rate,omitempty"`
+	// Bitrate 编码码率（kbps，仅 mp3/aac/opus），0 表示使用上游默认值
+	Bitrate int `json:"bitrate,omitempty"`
+	// Quality 编码质量档位（low/medium/high，仅 mp3/aac/opus），为空时使用上游默认值
+	Quality        string `json:"quality,omitempty"`
+	MaxLength      int    `json:"-"`
+	ValidateLength bool   `json:"-"`
+	// ExtraFormFields 额外透传给上游表单的字段（不会写入 input/voice/speed 等内置字段，无论本次是否设置）
+	ExtraFormFields map[string]string `json:"-"`
+	// StrictFormat 非 nil 时覆盖客户端的 StrictFormat 配置（见 ClientConfig.StrictFormat）
+	StrictFormat *bool `json:"-"`
+	// Seed 非 nil 时作为 seed 表单字段发送，并由 seed 与文本派生 generation（见 WithSeed）
+	Seed *int64 `json:"seed,omitempty"`
+	// InstructionsTemplate 非 nil 时发送前以 InstructionsData 渲染作为指令，优先于 Instructions（见 WithInstructionsTemplate）
+	InstructionsTemplate *template.Template `json:"-"`
+
+	// instructionsTemplateErr WithInstructionsTemplate 的解析错误，由 Validate 报告
+	instructionsTemplateErr error
+}
+
+// InstructionsData 渲染 InstructionsTemplate 时可用的字段，Vibe 为实际发送的值（已应用客户端默认 vibe）
+type InstructionsData struct {
+	Input    string
+	Voice    Voice
+	Format   AudioFormat
+	Vibe     string
+	Speed    float64
+	Language string
 }
 
 // NewTTSRequest 创建新的 TTS 请求
@@ -193,6 +281,64 @@ func WithSpeed(speed float64) RequestOption {
 	}
 }
 
+// WithInstructionsTemplate 使用 text/template 按请求动态生成指令，可引用 InstructionsData 的字段，
+// 如 "Voice: {{.Voice}}\nTone: {{.Vibe}}"；模板语法错误时请求校验失败
+func WithInstructionsTemplate(tmpl string) RequestOption {
+	return func(r *TTSRequest) {
+		r.InstructionsTemplate, r.instructionsTemplateErr = template.New("instructions").Option("missingkey=error").Parse(tmpl)
+	}
+}
+
+// WithVibe 设置 vibe（为空时使用客户端的默认 vibe）
+func WithVibe(vibe string) RequestOption {
+	return func(r *TTSRequest) {
+		r.Vibe = vibe
+	}
+}
+
+// WithLanguage 设置语言/地区提示（如 zh-CN、de-DE），帮助上游选择正确的发音
+func WithLanguage(lang string) RequestOption {
+	return func(r *TTSRequest) {
+		r.Language = strings.TrimSpace(lang)
+	}
+}
+
+// WithSampleRate 设置输出采样率（8000/16000/22050/24000/44100/48000），主要用于 WAV/PCM
+func WithSampleRate(hz int) RequestOption {
+	return func(r *TTSRequest) {
+		r.SampleRate = hz
+	}
+}
+
+// WithBitrate 设置编码码率（kbps，32-320），仅对 mp3/aac/opus 有效
+func WithBitrate(kbps int) RequestOption {
+	return func(r *TTSRequest) {
+		r.Bitrate = kbps
+	}
+}
+
+// WithQuality 设置编码质量档位（low/medium/high），仅对 mp3/aac/opus 有效
+func WithQuality(quality string) RequestOption {
+	return func(r *TTSRequest) {
+		r.Quality = strings.ToLower(strings.TrimSpace(quality))
+	}
+}
+
+// WithExtraFormFields 追加透传给上游表单的字段；与内置字段同名的会被忽略（即使本次未设置该内置字段）
+func WithExtraFormFields(fields map[string]string) RequestOption {
+	return func(r *TTSRequest) {
+		if len(fields) == 0 {
+			return
+		}
+		if r.ExtraFormFields == nil {
+			r.ExtraFormFields = make(map[string]string, len(fields))
+		}
+		for k, v := range fields {
+			r.ExtraFormFields[k] = v
+		}
+	}
+}
+
 // WithMaxLength 设置最大长度
 func WithMaxLength(maxLength int) RequestOption {
 	return func(r *TTSRequest) {
@@ -200,6 +346,21 @@ func WithMaxLength(maxLength int) RequestOption {
 	}
 }
 
+// WithSeed 设置随机种子：发送 seed 字段，并用 seed+文本确定性地生成 generation（替代随机 UUID），
+// 相同文本与 seed 的请求完全一致。能否因此得到相同音频取决于上游是否支持
+func WithSeed(seed int64) RequestOption {
+	return func(r *TTSRequest) {
+		r.Seed = &seed
+	}
+}
+
+// WithStrictResponseFormat 为单个请求开启/关闭严格格式模式，覆盖客户端的 WithStrictFormat
+func WithStrictResponseFormat(enabled bool) RequestOption {
+	return func(r *TTSRequest) {
+		r.StrictFormat = &enabled
+	}
+}
+
 // WithoutLengthValidation 禁用长度验证
 func WithoutLengthValidation() RequestOption {
 	return func(r *TTSRequest) {
@@ -229,8 +390,16 @@ func (r *TTSRequest) Validate() error {
 		return NewValidationError("Input text cannot be empty", "input", "")
 	}
 
+	if r.instructionsTemplateErr != nil {
+		return NewValidationError(
+			fmt.Sprintf("Invalid instructions template: %v", r.instructionsTemplateErr),
+			"instructions_template",
+			"",
+		)
+	}
+
 	if r.ValidateLength {
-		textLength := len(r.Input)
+		textLength := utf8.RuneCountInString(r.Input)
 		if textLength > r.MaxLength {
 			return NewValidationError(
 				fmt.Sprintf(
@@ -248,8 +417,52 @@ func (r *TTSRequest) Validate() error {
 		return NewValidationError("max_length must be a positive integer", "max_length", fmt.Sprintf("%d", r.MaxLength))
 	}
 
-	if r.Speed != 0 && (r.Speed < 0.25 || r.Speed > 4.0) {
-		return NewValidationError("Speed must be between 0.25 and 4.0", "speed", fmt.Sprintf("%f", r.Speed))
+	if r.Speed != 0 && !IsValidSpeed(r.Speed) {
+		return NewValidationError(
+			fmt.Sprintf("Speed must be between %v and %v", MinSpeed, MaxSpeed),
+			"speed",
+			fmt.Sprintf("%f", r.Speed),
+		)
+	}
+
+	if r.Language != "" && !IsValidLanguage(r.Language) {
+		return NewValidationError(
+			fmt.Sprintf("Invalid language: %s. Expected a tag like 'en' or 'zh-CN'", r.Language),
+			"language",
+			r.Language,
+		)
+	}
+
+	if r.SampleRate != 0 && !IsValidSampleRate(r.SampleRate) {
+		return NewValidationError(
+			fmt.Sprintf("Invalid sample rate: %d. Must be one of %v", r.SampleRate, ValidSampleRates),
+			"sample_rate",
+			strconv.Itoa(r.SampleRate),
+		)
+	}
+
+	if r.Bitrate != 0 || r.Quality != "" {
+		if !r.ResponseFormat.SupportsBitrate() {
+			return NewValidationError(
+				fmt.Sprintf("bitrate/quality only apply to mp3, aac and opus; %s output is not re-encoded", r.ResponseFormat),
+				"response_format",
+				string(r.ResponseFormat),
+			)
+		}
+		if r.Bitrate != 0 && (r.Bitrate < MinBitrate || r.Bitrate > MaxBitrate) {
+			return NewValidationError(
+				fmt.Sprintf("Bitrate must be between %d and %d kbps", MinBitrate, MaxBitrate),
+				"bitrate",
+				strconv.Itoa(r.Bitrate),
+			)
+		}
+		if r.Quality != "" && !slices.Contains(ValidQualities, r.Quality) {
+			return NewValidationError(
+				fmt.Sprintf("Invalid quality: %s. Must be one of %v", r.Quality, ValidQualities),
+				"quality",
+				r.Quality,
+			)
+		}
 	}
 
 	return nil
@@ -275,6 +488,26 @@ func (r *TTSRequest) ToMap() map[string]string {
 		data["speed"] = fmt.Sprintf("%f", r.Speed)
 	}
 
+	if r.Vibe != "" {
+		data["vibe"] = r.Vibe
+	}
+
+	if r.Language != "" {
+		data["language"] = r.Language
+	}
+
+	if r.SampleRate != 0 {
+		data["sample_rate"] = strconv.Itoa(r.SampleRate)
+	}
+
+	if r.Bitrate != 0 {
+		data["bitrate"] = strconv.Itoa(r.Bitrate)
+	}
+
+	if r.Quality != "" {
+		data["quality"] = r.Quality
+	}
+
 	return data
 }
 
@@ -286,11 +519,13 @@ type TTSResponse struct {
 	Size        int               `json:"size"`
 	Duration    float64           `json:"duration,omitempty"`
 	Metadata    map[string]string `json:"metadata,omitempty"`
+	// SourceText 生成该段音频的原始文本（需启用 WithSourceText）
+	SourceText string `json:"source_text,omitempty"`
 }
 
-// SaveToFile 将音频数据保存到文件
-func (r *TTSResponse) SaveToFile(filename string) (string, error) {
-	expectedExt := "." + string(r.Format)
+// resolveAudioFilename 按实际音频格式修正文件扩展名（替换已有的音频扩展名），并确保目录存在
+func resolveAudioFilename(filename string, format AudioFormat) (string, error) {
+	expectedExt := "." + string(format)
 
 	var finalFilename string
 	if strings.HasSuffix(filename, expectedExt) {
@@ -314,6 +549,16 @@ func (r *TTSResponse) SaveToFile(filename string) (string, error) {
 		}
 	}
 
+	return finalFilename, nil
+}
+
+// SaveToFile 将音频数据保存到文件
+func (r *TTSResponse) SaveToFile(filename string) (string, error) {
+	finalFilename, err := resolveAudioFilename(filename, r.Format)
+	if err != nil {
+		return "", err
+	}
+
 	if err := os.WriteFile(finalFilename, r.AudioData, 0o644); err != nil {
 		return "", fmt.Errorf("failed to write file: %w", err)
 	}
@@ -0,0 +1,128 @@
+package ttsfm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// WithContextOverlap 长文本分段时，将上一段末尾的 sentences 句作为上下文一并发给下一段，
+// 再按单独合成这些句子得到的时长把对应音频从下一段开头裁掉，以减轻分段处的语调断层。
+// 每个上下文会多一次上游请求；目前仅 GenerateSpeechLongText（preserveWords=true）支持，
+// 且输出须为 WAV（用于按采样裁剪）。
+func WithContextOverlap(sentences int) ClientOption {
+	return func(c *ClientConfig) {
+		c.ContextOverlap = sentences
+	}
+}
+
+// overlapSplitLength 开启上下文重叠时的切分长度：预留一半 maxLength 给上一段的上下文句子
+func overlapSplitLength(maxLength int) int {
+	return maxLength - maxLength/2
+}
+
+// lastSentences 返回 text 的最后至多 n 句，总长度（按 rune 计）不超过 budget；一句都放不下时返回空串
+func lastSentences(text string, n int, budget int) string {
+	sentences := splitBySentences(text)
+	if len(sentences) > n {
+		sentences = sentences[len(sentences)-n:]
+	}
+	for len(sentences) > 0 {
+		joined := strings.Join(sentences, " ")
+		if utf8.RuneCountInString(joined) <= budget {
+			return joined
+		}
+		sentences = sentences[1:]
+	}
+	return ""
+}
+
+// generateLongTextWithOverlap 带上下文重叠的非流式长文本合成：
+// 第 i>0 段发送 "上一段末尾句子 + 本段"，同时单独合成上下文句子以得知其音频长度，
+// 最后从第 i 段开头裁掉这段长度的 PCM。chunks 应按 overlapSplitLength 切分，
+// 上下文放不进 maxLength 时会减少句数或省略，每个请求仍按 maxLength 做长度校验。
+func (c *TTSClient) generateLongTextWithOverlap(
+	ctx context.Context,
+	chunks []string,
+	maxLength int,
+	overlap int,
+	opts []RequestOption,
+) ([]*TTSResponse, error) {
+	opts = append(append([]RequestOption(nil), opts...), WithMaxLength(maxLength))
+
+	requests := make([]*TTSRequest, 0, 2*len(chunks)-1)
+	contexts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		text := chunk
+		if i > 0 {
+			contexts[i] = lastSentences(chunks[i-1], overlap, maxLength-utf8.RuneCountInString(chunk)-1)
+			if contexts[i] != "" {
+				text = contexts[i] + " " + chunk
+			}
+		}
+		req, err := NewTTSRequest(text, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request for chunk %d: %w", i, err)
+		}
+		if !MapsToWAV(string(req.ResponseFormat)) {
+			return nil, NewValidationException(
+				fmt.Sprintf("Context overlap requires WAV output, got %s", req.ResponseFormat),
+				"response_format",
+				string(req.ResponseFormat),
+			)
+		}
+		requests = append(requests, req)
+	}
+
+	// 上下文句子单独合成，用于得知需要裁掉的音频长度
+	contextIndex := make([]int, len(chunks))
+	for i := 1; i < len(chunks); i++ {
+		if contexts[i] == "" {
+			continue
+		}
+		contextIndex[i] = len(requests)
+		req, err := NewTTSRequest(contexts[i], opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create context request for chunk %d: %w", i, err)
+		}
+		requests = append(requests, req)
+	}
+
+	responses, err := c.GenerateSpeechBatch(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+
+	results := responses[:len(chunks)]
+	for i := 1; i < len(chunks); i++ {
+		if contexts[i] == "" {
+			continue
+		}
+		resp, contextResp := results[i], responses[contextIndex[i]]
+		if resp.Format != FormatWAV || contextResp.Format != FormatWAV {
+			return nil, fmt.Errorf("context overlap trimming requires WAV audio, got %s", resp.Format)
+		}
+
+		contextPCM, err := extractWAVData(contextResp.AudioData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read context audio for chunk %d: %w", i, err)
+		}
+		trimmed, err := trimWAVLeading(resp.AudioData, len(contextPCM))
+		if err != nil {
+			return nil, fmt.Errorf("failed to trim context audio for chunk %d: %w", i, err)
+		}
+
+		resp.AudioData = trimmed
+		resp.Size = len(trimmed)
+		if resp.Metadata != nil {
+			resp.Metadata["context_trimmed_bytes"] = strconv.Itoa(len(contextPCM))
+		}
+		if c.config.RecordSourceText {
+			resp.SourceText = chunks[i]
+		}
+	}
+
+	return results, nil
+}
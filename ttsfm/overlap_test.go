@@ -0,0 +1,115 @@
+package ttsfm
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// overlapTestPCM 每个非空格字节对应一个 16-bit 采样，使音频长度只取决于文本内容
+func overlapTestPCM(input string) []byte {
+	var pcm []byte
+	for _, b := range []byte(strings.ReplaceAll(input, " ", "")) {
+		pcm = append(pcm, b, 0)
+	}
+	return pcm
+}
+
+func TestWithContextOverlap_SendsContextAndTrimsItsAudio(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/wav", func(input string) []byte {
+		wav, _ := WrapPCMAsWAV(overlapTestPCM(input), nil)
+		return wav
+	})
+	client := newStubClient(t, upstream.URL, WithContextOverlap(1))
+
+	text := "First sentence here. Second sentence here. Third sentence here."
+	responses, err := client.GenerateSpeechLongText(context.Background(), text, 50, true, WithFormat(FormatWAV))
+	if err != nil {
+		t.Fatalf("long text: %v", err)
+	}
+
+	chunks := SplitTextByLength(text, overlapSplitLength(50), true)
+	if len(responses) != len(chunks) {
+		t.Fatalf("expected %d responses, got %d", len(chunks), len(responses))
+	}
+
+	var inputs []string
+	for _, f := range rec.all() {
+		inputs = append(inputs, f["input"])
+	}
+	sort.Strings(inputs)
+	want := []string{
+		"First sentence here.",
+		"First sentence here.",
+		"First sentence here. Second sentence here.",
+		"Second sentence here.",
+		"Second sentence here. Third sentence here.",
+	}
+	if strings.Join(inputs, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected upstream inputs:\n got %q\nwant %q", inputs, want)
+	}
+
+	for i, resp := range responses {
+		pcm, err := extractWAVData(resp.AudioData)
+		if err != nil {
+			t.Fatalf("chunk %d: invalid WAV: %v", i, err)
+		}
+		if !bytes.Equal(pcm, overlapTestPCM(chunks[i])) {
+			t.Fatalf("chunk %d: expected only its own audio after trimming, got %q", i, pcm)
+		}
+		if resp.Size != len(resp.AudioData) {
+			t.Fatalf("chunk %d: size %d does not match audio length %d", i, resp.Size, len(resp.AudioData))
+		}
+	}
+}
+
+func TestWithContextOverlap_KeepsRequestsWithinMaxLength(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/wav", func(input string) []byte {
+		wav, _ := WrapPCMAsWAV(overlapTestPCM(input), nil)
+		return wav
+	})
+	client := newStubClient(t, upstream.URL, WithContextOverlap(2))
+
+	const maxLength = 40
+	text := "Alpha beta gamma delta. Epsilon zeta eta theta. Iota kappa lambda mu. Nu xi omicron pi rho."
+	responses, err := client.GenerateSpeechLongText(context.Background(), text, maxLength, true, WithFormat(FormatWAV))
+	if err != nil {
+		t.Fatalf("long text: %v", err)
+	}
+
+	forms := rec.all()
+	if len(forms) <= len(responses) {
+		t.Fatalf("expected context requests in addition to %d chunks, got %d requests", len(responses), len(forms))
+	}
+	for _, f := range forms {
+		if n := len([]rune(f["input"])); n > maxLength {
+			t.Fatalf("upstream input %q has %d chars, exceeds max length %d", f["input"], n, maxLength)
+		}
+	}
+
+	chunks := SplitTextByLength(text, overlapSplitLength(maxLength), true)
+	for i, resp := range responses {
+		pcm, err := extractWAVData(resp.AudioData)
+		if err != nil {
+			t.Fatalf("chunk %d: invalid WAV: %v", i, err)
+		}
+		if !bytes.Equal(pcm, overlapTestPCM(chunks[i])) {
+			t.Fatalf("chunk %d: expected only its own audio after trimming, got %q", i, pcm)
+		}
+	}
+}
+
+func TestWithContextOverlap_RequiresWAV(t *testing.T) {
+	upstream, rec := newStubUpstream(t, "audio/mpeg", func(input string) []byte { return []byte(input) })
+	client := newStubClient(t, upstream.URL, WithContextOverlap(1))
+
+	_, err := client.GenerateSpeechLongText(context.Background(), "First sentence here. Second sentence here.", 25, true)
+	if _, ok := err.(*ValidationException); !ok {
+		t.Fatalf("expected ValidationException for mp3 output, got %v", err)
+	}
+	if n := len(rec.all()); n != 0 {
+		t.Fatalf("expected no upstream requests, got %d", n)
+	}
+}
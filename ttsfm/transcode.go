@@ -0,0 +1,58 @@
+package ttsfm
+
+import (
+	"io"
+	"sync"
+)
+
+// AudioTranscoder 音频格式转换器（例如基于 ffmpeg 或纯 Go 编码器的实现）
+type AudioTranscoder interface {
+	// Transcode 读取 from 格式的音频 in，返回 to 格式的音频流；
+	// 返回的 ReadCloser 关闭时应释放转换器自身持有的资源（上游 body 由调用方关闭）
+	Transcode(in io.Reader, from, to AudioFormat) (io.ReadCloser, error)
+}
+
+type transcoderKey struct {
+	from AudioFormat
+	to   AudioFormat
+}
+
+var (
+	transcodersMu sync.RWMutex
+	transcoders   = map[transcoderKey]AudioTranscoder{}
+)
+
+// RegisterTranscoder 注册 from→to 的转换器（进程级，对所有客户端生效）；t 为 nil 时取消注册。
+// 上游返回的格式与请求的格式不一致且存在对应转换器时，客户端会自动转换并改写 Content-Type。
+func RegisterTranscoder(from, to AudioFormat, t AudioTranscoder) {
+	transcodersMu.Lock()
+	defer transcodersMu.Unlock()
+
+	key := transcoderKey{from: from, to: to}
+	if t == nil {
+		delete(transcoders, key)
+		return
+	}
+	transcoders[key] = t
+}
+
+// LookupTranscoder 查找 from→to 的转换器，未注册时返回 nil
+func LookupTranscoder(from, to AudioFormat) AudioTranscoder {
+	transcodersMu.RLock()
+	defer transcodersMu.RUnlock()
+	return transcoders[transcoderKey{from: from, to: to}]
+}
+
+// transcodedBody 关闭时同时关闭转换输出与上游响应体
+type transcodedBody struct {
+	io.ReadCloser
+	upstream io.Closer
+}
+
+func (b *transcodedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if upErr := b.upstream.Close(); err == nil {
+		err = upErr
+	}
+	return err
+}
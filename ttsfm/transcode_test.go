@@ -0,0 +1,94 @@
+package ttsfm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// prefixTranscoder 测试用转换器：在输出前加上目标格式前缀
+type prefixTranscoder struct {
+	closed bool
+}
+
+func (p *prefixTranscoder) Transcode(in io.Reader, from, to AudioFormat) (io.ReadCloser, error) {
+	return &trackingCloser{
+		Reader:  io.MultiReader(strings.NewReader(string(to)+":"), in),
+		onClose: func() { p.closed = true },
+	}, nil
+}
+
+type trackingCloser struct {
+	io.Reader
+	onClose func()
+}
+
+func (t *trackingCloser) Close() error {
+	t.onClose()
+	return nil
+}
+
+type failingTranscoder struct{}
+
+func (failingTranscoder) Transcode(io.Reader, AudioFormat, AudioFormat) (io.ReadCloser, error) {
+	return nil, errors.New("encoder unavailable")
+}
+
+func TestTranscoder_AppliedOnFormatMismatch(t *testing.T) {
+	upstream, _ := newStubUpstream(t, "audio/wav", func(input string) []byte { return []byte("RIFF") })
+	client := newStubClient(t, upstream.URL)
+
+	tc := &prefixTranscoder{}
+	RegisterTranscoder(FormatWAV, FormatFLAC, tc)
+	t.Cleanup(func() { RegisterTranscoder(FormatWAV, FormatFLAC, nil) })
+
+	resp, err := client.GenerateSpeechStream(context.Background(), "Hello there.", WithFormat(FormatFLAC))
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	_ = resp.Close()
+
+	if string(data) != "flac:RIFF" {
+		t.Fatalf("expected transcoded body, got %q", data)
+	}
+	if resp.Format != FormatFLAC || resp.ContentType != "audio/flac" {
+		t.Fatalf("expected flac output, got format=%s content-type=%s", resp.Format, resp.ContentType)
+	}
+	if resp.Metadata["upstream_format"] != "wav" {
+		t.Fatalf("expected upstream_format=wav, got %q", resp.Metadata["upstream_format"])
+	}
+	if !tc.closed {
+		t.Fatal("expected transcoder output to be closed")
+	}
+}
+
+func TestTranscoder_NotRegisteredPassesThrough(t *testing.T) {
+	upstream, _ := newStubUpstream(t, "audio/wav", func(input string) []byte { return []byte("RIFF") })
+	client := newStubClient(t, upstream.URL)
+
+	resp, err := client.GenerateSpeech(context.Background(), "Hello there.", WithFormat(FormatOPUS))
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if resp.Format != FormatWAV || string(resp.AudioData) != "RIFF" {
+		t.Fatalf("expected untouched WAV passthrough, got format=%s data=%q", resp.Format, resp.AudioData)
+	}
+}
+
+func TestTranscoder_ErrorIsReturned(t *testing.T) {
+	upstream, _ := newStubUpstream(t, "audio/wav", func(input string) []byte { return []byte("RIFF") })
+	client := newStubClient(t, upstream.URL)
+
+	RegisterTranscoder(FormatWAV, FormatAAC, failingTranscoder{})
+	t.Cleanup(func() { RegisterTranscoder(FormatWAV, FormatAAC, nil) })
+
+	if _, err := client.GenerateSpeechStream(context.Background(), "Hello there.", WithFormat(FormatAAC)); err == nil {
+		t.Fatal("expected transcoder error")
+	}
+}
@@ -2,13 +2,18 @@ package ttsfm
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"mime/multipart"
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 func init() {
@@ -39,50 +44,137 @@ var AcceptLanguages = []string{
 
 // GetRealisticHeaders 生成真实的 HTTP 请求头
 func GetRealisticHeaders() map[string]string {
-	userAgent := GetUserAgent()
+	return GetRealisticHeadersWithUserAgent(GetUserAgent())
+}
+
+// browserFamily User-Agent 所属的浏览器家族，决定与之配套的请求头
+type browserFamily int
+
+const (
+	browserOther browserFamily = iota
+	browserChrome
+	browserFirefox
+	browserSafari
+)
+
+var (
+	chromeVersionRe  = regexp.MustCompile(`(?:Chrome|Chromium)/(\d+)`)
+	firefoxVersionRe = regexp.MustCompile(`Firefox/(\d+)`)
+)
+
+// detectBrowserFamily 按 User-Agent 判断浏览器家族（Edge 等 Chromium 内核归为 Chrome）
+func detectBrowserFamily(userAgent string) browserFamily {
+	switch {
+	case chromeVersionRe.MatchString(userAgent):
+		return browserChrome
+	case firefoxVersionRe.MatchString(userAgent):
+		return browserFirefox
+	case strings.Contains(userAgent, "Safari/") && strings.Contains(userAgent, "Version/"):
+		return browserSafari
+	default:
+		return browserOther
+	}
+}
 
+// majorVersion 从 User-Agent 中提取主版本号，匹配失败时返回 fallback
+func majorVersion(re *regexp.Regexp, userAgent string, fallback int) int {
+	if m := re.FindStringSubmatch(userAgent); len(m) > 1 {
+		if v, err := strconv.Atoi(m[1]); err == nil {
+			return v
+		}
+	}
+	return fallback
+}
+
+// GetRealisticHeadersWithUserAgent 使用指定的 User-Agent 生成请求头。
+// 请求头与 UA 所属浏览器保持一致：只有 Chrome 系发送 Sec-Ch-Ua 客户端提示，
+// Accept / Accept-Encoding / Accept-Language 取该浏览器 fetch 请求的典型值
+func GetRealisticHeadersWithUserAgent(userAgent string) map[string]string {
 	headers := map[string]string{
-		"Accept":          "application/json, audio/*",
-		"Accept-Encoding": "gzip, deflate, br",
 		"Accept-Language": AcceptLanguages[rand.Intn(len(AcceptLanguages))],
 		"Cache-Control":   "no-cache",
-		"DNT":             "1",
 		"Pragma":          "no-cache",
 		"User-Agent":      userAgent,
 	}
 
-	if strings.Contains(strings.ToLower(userAgent), "chrome") {
-		re := regexp.MustCompile(`Chrome/(\d+)`)
-		matches := re.FindStringSubmatch(userAgent)
-		version := "121"
-		if len(matches) > 1 {
-			version = matches[1]
-		}
-
+	switch detectBrowserFamily(userAgent) {
+	case browserChrome:
+		version := majorVersion(chromeVersionRe, userAgent, 121)
 		platforms := []string{`"Windows"`, `"macOS"`, `"Linux"`}
 
-		headers["Sec-Ch-Ua"] = fmt.Sprintf(`"Google Chrome";v="%s", "Chromium";v="%s", "Not A(Brand";v="99"`, version, version)
+		headers["Accept"] = "*/*"
+		headers["Accept-Encoding"] = "gzip, deflate, br"
+		if version >= 123 {
+			headers["Accept-Encoding"] = "gzip, deflate, br, zstd"
+		}
+		headers["DNT"] = "1"
+		headers["Sec-Ch-Ua"] = fmt.Sprintf(`"Google Chrome";v="%d", "Chromium";v="%d", "Not A(Brand";v="99"`, version, version)
 		headers["Sec-Ch-Ua-Mobile"] = "?0"
 		headers["Sec-Ch-Ua-Platform"] = platforms[rand.Intn(len(platforms))]
-		headers["Sec-Fetch-Dest"] = "empty"
-		headers["Sec-Fetch-Mode"] = "cors"
-		headers["Sec-Fetch-Site"] = "same-origin"
-	}
-
-	if rand.Float32() < 0.5 {
-		headers["Upgrade-Insecure-Requests"] = "1"
+		setSecFetchHeaders(headers)
+	case browserFirefox:
+		headers["Accept"] = "*/*"
+		headers["Accept-Encoding"] = "gzip, deflate, br"
+		if majorVersion(firefoxVersionRe, userAgent, 0) >= 126 {
+			headers["Accept-Encoding"] = "gzip, deflate, br, zstd"
+		}
+		headers["Accept-Language"] = "en-US,en;q=0.5"
+		headers["DNT"] = "1"
+		setSecFetchHeaders(headers)
+	case browserSafari:
+		headers["Accept"] = "*/*"
+		headers["Accept-Encoding"] = "gzip, deflate, br"
+		setSecFetchHeaders(headers)
+	default:
+		headers["Accept"] = "application/json, audio/*"
+		headers["Accept-Encoding"] = "gzip, deflate, br"
+		headers["DNT"] = "1"
 	}
 
 	return headers
 }
 
-// ValidateTextLength 验证文本长度
+// setSecFetchHeaders 同源 fetch 请求的 Fetch Metadata 头（Chrome/Firefox/Safari 均会发送）
+func setSecFetchHeaders(headers map[string]string) {
+	headers["Sec-Fetch-Dest"] = "empty"
+	headers["Sec-Fetch-Mode"] = "cors"
+	headers["Sec-Fetch-Site"] = "same-origin"
+}
+
+// headerOrderFor 返回 UA 所属浏览器发送请求头的典型顺序（小写），未列出的头由 fhttp 追加在后面
+func headerOrderFor(userAgent string) []string {
+	switch detectBrowserFamily(userAgent) {
+	case browserChrome:
+		return []string{
+			"content-length", "pragma", "cache-control", "sec-ch-ua-platform", "authorization",
+			"user-agent", "sec-ch-ua", "content-type", "dnt", "sec-ch-ua-mobile", "accept",
+			"sec-fetch-site", "sec-fetch-mode", "sec-fetch-dest", "accept-encoding", "accept-language",
+		}
+	case browserFirefox:
+		return []string{
+			"user-agent", "accept", "accept-language", "accept-encoding", "content-type", "authorization",
+			"content-length", "dnt", "sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site", "pragma", "cache-control",
+		}
+	case browserSafari:
+		return []string{
+			"content-type", "pragma", "accept", "authorization", "sec-fetch-site", "accept-language",
+			"cache-control", "sec-fetch-mode", "accept-encoding", "user-agent", "content-length", "sec-fetch-dest",
+		}
+	default:
+		return []string{
+			"accept", "accept-encoding", "accept-language", "authorization", "cache-control",
+			"content-type", "dnt", "pragma", "user-agent",
+		}
+	}
+}
+
+// ValidateTextLength 验证文本长度（按字符即 rune 计数，而非字节）
 func ValidateTextLength(text string, maxLength int) error {
 	if text == "" {
 		return nil
 	}
 
-	textLength := len(text)
+	textLength := utf8.RuneCountInString(text)
 	if textLength > maxLength {
 		return fmt.Errorf(
 			"text is too long (%d characters). Maximum allowed length is %d characters. "+
@@ -94,13 +186,28 @@ func ValidateTextLength(text string, maxLength int) error {
 	return nil
 }
 
-// SplitTextByLength 按长度分割文本
+// MinChunkLength 长文本切分允许的最小 maxLength。
+// 过小的值会把文本切成大量单字符 chunk，每个 chunk 都是一次上游请求。
+const MinChunkLength = 16
+
+// ClampChunkLength 将 maxLength 提升到 MinChunkLength；第二个返回值表示是否发生了调整
+func ClampChunkLength(maxLength int) (int, bool) {
+	if maxLength < MinChunkLength {
+		return MinChunkLength, true
+	}
+	return maxLength, false
+}
+
+// SplitTextByLength 按长度分割文本，maxLength 按字符（rune）计数（小于 MinChunkLength 时按 MinChunkLength 处理）。
+// 这里不记录调整，需要告警的调用方应先调用 ClampChunkLength 并通过自己的 Logger 输出
 func SplitTextByLength(text string, maxLength int, preserveWords bool) []string {
 	if text == "" {
 		return nil
 	}
 
-	if len(text) <= maxLength {
+	maxLength, _ = ClampChunkLength(maxLength)
+
+	if utf8.RuneCountInString(text) <= maxLength {
 		return []string{text}
 	}
 
@@ -116,24 +223,25 @@ func SplitTextByLength(text string, maxLength int, preserveWords bool) []string
 				continue
 			}
 
-			if !strings.HasSuffix(sentence, ".") && !strings.HasSuffix(sentence, "!") && !strings.HasSuffix(sentence, "?") {
+			if !endsWithSentenceTerminator(sentence) {
 				sentence += "."
 			}
 
+			// 中日文句子之间不加空格
 			testChunk := currentChunk
-			if testChunk != "" {
+			if testChunk != "" && !endsWithCJKTerminator(testChunk) {
 				testChunk += " "
 			}
 			testChunk += sentence
 
-			if len(testChunk) <= maxLength {
+			if utf8.RuneCountInString(testChunk) <= maxLength {
 				currentChunk = testChunk
 			} else {
 				if currentChunk != "" {
 					chunks = append(chunks, strings.TrimSpace(currentChunk))
 				}
 
-				if len(sentence) > maxLength {
+				if utf8.RuneCountInString(sentence) > maxLength {
 					wordChunks := splitByWords(sentence, maxLength)
 					chunks = append(chunks, wordChunks...)
 					currentChunk = ""
@@ -147,13 +255,7 @@ func SplitTextByLength(text string, maxLength int, preserveWords bool) []string
 			chunks = append(chunks, strings.TrimSpace(currentChunk))
 		}
 	} else {
-		for i := 0; i < len(text); i += maxLength {
-			end := i + maxLength
-			if end > len(text) {
-				end = len(text)
-			}
-			chunks = append(chunks, text[i:end])
-		}
+		chunks = splitAtRuneBoundaries(text, maxLength)
 	}
 
 	result := make([]string, 0, len(chunks))
@@ -166,21 +268,144 @@ func SplitTextByLength(text string, maxLength int, preserveWords bool) []string
 	return result
 }
 
+// splitAtRuneBoundaries 每 maxLength 个字符（rune）切一段，不会切断多字节字符
+func splitAtRuneBoundaries(text string, maxLength int) []string {
+	if maxLength <= 0 {
+		maxLength = 1
+	}
+	var chunks []string
+	for i := 0; i < len(text); {
+		end := i
+		for n := 0; n < maxLength && end < len(text); n++ {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+		}
+		chunks = append(chunks, text[i:end])
+		i = end
+	}
+	return chunks
+}
+
+// sentenceAbbreviations 以 "." 结尾但通常不表示句末的缩写（小写，不含末尾的点）
+var sentenceAbbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true, "jr": true,
+	"st": true, "mt": true, "vs": true, "etc": true, "e.g": true, "i.e": true, "cf": true,
+	"inc": true, "ltd": true, "co": true, "corp": true, "no": true, "fig": true, "approx": true,
+	"a.m": true, "p.m": true, "u.s": true,
+}
+
+// splitBySentences 按句末标点分句，句末标点保留在句子中。
+// 不在小数（3.50）、常见缩写（Dr.、e.g.）、姓名首字母（J.）和省略号（...）处断句；
+// 中日文标点（。！？；）后不需要空白即断句，紧随其后的右引号/括号归入前一句。
 func splitBySentences(text string) []string {
-	re := regexp.MustCompile(`[.!?]+`)
-	parts := re.Split(text, -1)
-
-	result := make([]string, 0, len(parts))
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-		if part != "" {
-			result = append(result, part)
+	var result []string
+	start := 0
+
+	for i := 0; i < len(text); {
+		if r, size := utf8.DecodeRuneInString(text[i:]); isCJKTerminator(r) {
+			j := i + size
+			for j < len(text) {
+				next, n := utf8.DecodeRuneInString(text[j:])
+				if !isCJKTerminator(next) && !isClosingPunct(next) {
+					break
+				}
+				j += n
+			}
+			if part := strings.TrimSpace(text[start:j]); part != "" {
+				result = append(result, part)
+			}
+			start = j
+			i = j
+			continue
+		}
+		if !isSentenceTerminator(text[i]) {
+			i++
+			continue
 		}
+		j := i
+		for j < len(text) && isSentenceTerminator(text[j]) {
+			j++
+		}
+		if isSentenceEnd(text, i, j) {
+			if part := strings.TrimSpace(text[start:j]); part != "" {
+				result = append(result, part)
+			}
+			start = j
+		}
+		i = j
+	}
+
+	if part := strings.TrimSpace(text[start:]); part != "" {
+		result = append(result, part)
 	}
 
 	return result
 }
 
+func isSentenceTerminator(c byte) bool {
+	return c == '.' || c == '!' || c == '?'
+}
+
+// isCJKTerminator 中日文句末（及分号）标点
+func isCJKTerminator(r rune) bool {
+	return r == '。' || r == '！' || r == '？' || r == '；'
+}
+
+// isClosingPunct 句末标点之后仍属于同一句的右引号/括号
+func isClosingPunct(r rune) bool {
+	switch r {
+	case '”', '’', '」', '』', '）', '》', '】':
+		return true
+	}
+	return false
+}
+
+// endsWithCJKTerminator 文本是否以中日文句末标点（可带右引号/括号）结尾
+func endsWithCJKTerminator(s string) bool {
+	s = strings.TrimRightFunc(s, isClosingPunct)
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return isCJKTerminator(r)
+}
+
+// endsWithSentenceTerminator 文本是否已以句末标点结尾（无需补句号）
+func endsWithSentenceTerminator(s string) bool {
+	return strings.HasSuffix(s, ".") || strings.HasSuffix(s, "!") || strings.HasSuffix(s, "?") ||
+		endsWithCJKTerminator(s)
+}
+
+// isSentenceEnd 判断 text[i:j] 这一串标点是否为句末
+func isSentenceEnd(text string, i, j int) bool {
+	// 标点后必须是空白或文本结尾，排除 3.50、e.g 中间的点等
+	if j < len(text) {
+		r, _ := utf8.DecodeRuneInString(text[j:])
+		if !unicode.IsSpace(r) {
+			return false
+		}
+	}
+
+	run := text[i:j]
+	if strings.Trim(run, ".") != "" {
+		// 含 ! 或 ? 的一律视为句末
+		return true
+	}
+	if len(run) > 1 {
+		// 省略号
+		return false
+	}
+
+	wordStart := strings.LastIndexFunc(text[:i], unicode.IsSpace) + 1
+	word := strings.TrimLeft(text[wordStart:i], "(\"'")
+	if sentenceAbbreviations[strings.ToLower(word)] {
+		return false
+	}
+	// 单个大写字母视为姓名首字母
+	if r, size := utf8.DecodeRuneInString(word); size == len(word) && unicode.IsUpper(r) {
+		return false
+	}
+
+	return true
+}
+
 func splitByWords(text string, maxLength int) []string {
 	words := strings.Fields(text)
 	var chunks []string
@@ -193,21 +418,16 @@ func splitByWords(text string, maxLength int) []string {
 		}
 		testChunk += word
 
-		if len(testChunk) <= maxLength {
+		if utf8.RuneCountInString(testChunk) <= maxLength {
 			currentChunk = testChunk
 		} else {
 			if currentChunk != "" {
 				chunks = append(chunks, currentChunk)
 			}
 
-			if len(word) > maxLength {
-				for i := 0; i < len(word); i += maxLength {
-					end := i + maxLength
-					if end > len(word) {
-						end = len(word)
-					}
-					chunks = append(chunks, word[i:end])
-				}
+			if utf8.RuneCountInString(word) > maxLength {
+				// 超长的单个“词”（如没有空格的中日文）按字符边界切分
+				chunks = append(chunks, splitAtRuneBoundaries(word, maxLength)...)
 				currentChunk = ""
 			} else {
 				currentChunk = word
@@ -222,16 +442,70 @@ func splitByWords(text string, maxLength int) []string {
 	return chunks
 }
 
-// SanitizeText 清理文本
+// IsValidMultipartBoundary 检查 boundary 是否符合 RFC 2046（与 multipart.Writer.SetBoundary 规则一致）
+func IsValidMultipartBoundary(boundary string) bool {
+	return multipart.NewWriter(io.Discard).SetBoundary(boundary) == nil
+}
+
+const webKitBoundaryAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// WebKitFormBoundary 生成与 Chrome/Safari 表单提交相同格式的 boundary（----WebKitFormBoundary + 16 位字母数字）
+func WebKitFormBoundary() string {
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = webKitBoundaryAlphabet[rand.Intn(len(webKitBoundaryAlphabet))]
+	}
+	return "----WebKitFormBoundary" + string(b)
+}
+
+// htmlNamedEntities SanitizeText 解码的命名实体
+var htmlNamedEntities = map[string]rune{
+	"amp":  '&',
+	"lt":   '<',
+	"gt":   '>',
+	"quot": '"',
+	"apos": '\'',
+}
+
+// decodeHTMLEntity 解码 & 与 ; 之间的实体名：常见命名实体以及 &#NN; / &#xNN; 数字实体
+func decodeHTMLEntity(name string) (rune, bool) {
+	if r, ok := htmlNamedEntities[name]; ok {
+		return r, true
+	}
+	if !strings.HasPrefix(name, "#") {
+		return 0, false
+	}
+
+	digits, base := name[1:], 10
+	if strings.HasPrefix(digits, "x") || strings.HasPrefix(digits, "X") {
+		digits, base = digits[1:], 16
+	}
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	r := rune(n)
+	if !utf8.ValidRune(r) {
+		return 0, false
+	}
+	return r, true
+}
+
+// SanitizeText 清理文本（去除 HTML 标签与非法 UTF-8 字节；常见实体解码为字面字符，其他实体去除）
 func SanitizeText(text string) (string, error) {
 	if text == "" {
 		return "", nil
 	}
 
-	if len(text) > 50000 {
+	if utf8.RuneCountInString(text) > 50000 {
 		return "", fmt.Errorf("input text too long for sanitization (max 50000 characters)")
 	}
 
+	// 截断的多字节序列等非法 UTF-8 直接去掉，避免发往上游的文本本身不合法
+	if !utf8.ValidString(text) {
+		text = strings.ToValidUTF8(text, "")
+	}
+
 	var result strings.Builder
 	result.Grow(len(text))
 
@@ -250,10 +524,14 @@ func SanitizeText(text string) (string, error) {
 			}
 		} else if text[i] == '&' {
 			j := i + 1
-			for j < len(text) && j < i+10 && !strings.ContainsAny(string(text[j]), " \t\n\r<>&") {
+			for j < len(text) && j < i+10 && !strings.ContainsAny(string(text[j]), " \t\n\r<>&;") {
 				j++
 			}
 			if j < len(text) && text[j] == ';' {
+				// 常见实体解码为字面字符（不再参与标签/引号处理），其他实体直接去掉
+				if r, ok := decodeHTMLEntity(text[i+1 : j]); ok {
+					result.WriteRune(r)
+				}
 				i = j + 1
 			} else {
 				result.WriteByte(' ')
@@ -302,11 +580,22 @@ func GetRandomDelay(minDelay, maxDelay float64) time.Duration {
 	return time.Duration((baseDelay + jitter) * float64(time.Second))
 }
 
+// DefaultRetryJitter ExponentialBackoff 使用的抖动比例（附加 10%~30% 的随机延迟）
+const DefaultRetryJitter = 0.3
+
 // ExponentialBackoff 计算指数退避延迟
 func ExponentialBackoff(attempt int, baseDelay, maxDelay float64) time.Duration {
+	return ExponentialBackoffWithJitter(attempt, baseDelay, maxDelay, DefaultRetryJitter)
+}
+
+// ExponentialBackoffWithJitter 计算 baseDelay * 2^attempt（秒）并附加抖动，总延迟不超过 maxDelay。
+// jitter 为附加延迟占基础延迟的最大比例，实际在 [jitter/3, jitter] 间均匀取值；jitter<=0 时不加抖动
+func ExponentialBackoffWithJitter(attempt int, baseDelay, maxDelay, jitter float64) time.Duration {
 	delay := baseDelay * math.Pow(2, float64(attempt))
-	jitter := (0.1 + rand.Float64()*0.2) * delay
-	total := delay + jitter
+	total := delay
+	if jitter > 0 {
+		total += (jitter/3 + rand.Float64()*jitter*2/3) * delay
+	}
 	if total > maxDelay {
 		total = maxDelay
 	}
@@ -369,6 +658,25 @@ func FormatFileSize(sizeBytes int) string {
 	return fmt.Sprintf("%.1f %s", size, sizeNames[i])
 }
 
+// TruncateString 截断到最多 maxLen 字节并追加 "..."（用于日志）；
+// 截断点会回退到 rune 边界，不会切开多字节字符
+func TruncateString(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	if maxLen < 0 {
+		maxLen = 0
+	}
+	cut := maxLen
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+// DefaultVibe 默认的 vibe
+const DefaultVibe = "dramatic"
+
 // DefaultInstructions 默认的语音指令
 const DefaultInstructions = `Affect/personality: Natural and clear
 